@@ -9,6 +9,7 @@ import (
 	"time" // time パッケージをインポート
 	"wasabi/config"
 	"wasabi/db"
+	"wasabi/forecast"
 	"wasabi/model"
 )
 
@@ -58,6 +59,7 @@ func GetAggregationHandler(conn *sql.DB) http.HandlerFunc {
 			DosageForm:   q.Get("dosageForm"),
 			Coefficient:  coefficient,
 			MovementOnly: q.Get("movementOnly") == "true",
+			Forecast:     forecast.ParamsFromConfig(cfg, coefficient),
 		}
 		// ▲▲▲【修正ここまで】▲▲▲
 
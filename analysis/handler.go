@@ -0,0 +1,163 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\analysis\handler.go
+
+package analysis
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// defaultPeriodWeeks は期間の指定が無い場合の分析週数です (終了日は今日)。
+const defaultPeriodWeeks = 26
+
+// parseFilters はクエリパラメータから分析期間を作成します。日付は YYYY-MM-DD と YYYYMMDD のどちらも受け付けます。
+func parseFilters(r *http.Request) model.AbcXyzFilters {
+	q := r.URL.Query()
+	filters := model.AbcXyzFilters{
+		StartDate: strings.ReplaceAll(q.Get("startDate"), "-", ""),
+		EndDate:   strings.ReplaceAll(q.Get("endDate"), "-", ""),
+	}
+	if filters.EndDate == "" {
+		filters.EndDate = time.Now().Format("20060102")
+	}
+	if filters.StartDate == "" {
+		if end, err := time.Parse("20060102", filters.EndDate); err == nil {
+			filters.StartDate = end.AddDate(0, 0, -(defaultPeriodWeeks*7 - 1)).Format("20060102")
+		}
+	}
+	return filters
+}
+
+// GetAbcXyzHandler はYJコード・包装ごとのABC/XYZ分類とマトリクスを返します。
+func GetAbcXyzHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := db.GetAbcXyzAnalysis(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "ABC/XYZ分析に失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// SaveAbcXyzHandler は指定期間のABC/XYZ分析を行い、包装単位の分類を各製品に保存します。
+func SaveAbcXyzHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := db.GetAbcXyzAnalysis(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "ABC/XYZ分析に失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		count, err := db.SaveAbcXyzClassifications(conn, result)
+		if err != nil {
+			http.Error(w, "分類の保存に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("%d件の製品に分類を保存しました。", count)})
+	}
+}
+
+// ExportAbcXyzHandler はABC/XYZ分析を、マトリクス・YJコード別・包装別の3シートのExcelファイルとしてエクスポートします。
+func ExportAbcXyzHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := db.GetAbcXyzAnalysis(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "Failed to get ABC/XYZ analysis for export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f := excelize.NewFile()
+		headerStyle, _ := f.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true},
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center"},
+		})
+		currencyStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 2})
+		percentStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 10})
+		writeHeaders := func(sheet string, row int, headers []string) {
+			for i, h := range headers {
+				cell, _ := excelize.CoordinatesToCellName(i+1, row)
+				f.SetCellValue(sheet, cell, h)
+				f.SetCellStyle(sheet, cell, cell, headerStyle)
+			}
+		}
+
+		matrixSheet := "マトリクス"
+		index, _ := f.NewSheet(matrixSheet)
+		f.SetActiveSheet(index)
+		f.DeleteSheet("Sheet1")
+		f.SetCellValue(matrixSheet, "A1", fmt.Sprintf("ABC/XYZ分析 (%s ～ %s, %d週)", formatDate(result.StartDate), formatDate(result.EndDate), result.Weeks))
+		row := 3
+		for _, level := range []struct {
+			title  string
+			result model.AbcXyzResult
+		}{{"YJコード別", result.YjGroups}, {"包装別", result.Packages}} {
+			f.SetCellValue(matrixSheet, fmt.Sprintf("A%d", row), level.title)
+			writeHeaders(matrixSheet, row+1, []string{"ABC", "XYZ", "品目数", "処方金額", "構成比"})
+			for i, cell := range level.result.Matrix {
+				r := row + 2 + i
+				values := []interface{}{cell.AbcClass, cell.XyzClass, cell.Count, cell.UsageValue, cell.ValueShare}
+				start, _ := excelize.CoordinatesToCellName(1, r)
+				f.SetSheetRow(matrixSheet, start, &values)
+				f.SetCellStyle(matrixSheet, fmt.Sprintf("D%d", r), fmt.Sprintf("D%d", r), currencyStyle)
+				f.SetCellStyle(matrixSheet, fmt.Sprintf("E%d", r), fmt.Sprintf("E%d", r), percentStyle)
+			}
+			row += len(level.result.Matrix) + 3
+		}
+		f.SetColWidth(matrixSheet, "D", "E", 16)
+
+		for _, level := range []struct {
+			sheet  string
+			result model.AbcXyzResult
+		}{{"YJコード別", result.YjGroups}, {"包装別", result.Packages}} {
+			f.NewSheet(level.sheet)
+			writeHeaders(level.sheet, 1, []string{
+				"ABC", "XYZ", "YJコード", "製品名", "包装", "処方数量", "YJ単位", "処方金額", "累積構成比", "週平均", "週標準偏差", "変動係数",
+			})
+			for i, item := range level.result.Items {
+				r := i + 2
+				values := []interface{}{
+					item.AbcClass, item.XyzClass, item.YjCode, item.ProductName, item.PackageSpec, item.UsageQuantity, item.YjUnitName,
+					item.UsageValue, item.CumulativeShare, item.WeeklyMean, item.WeeklyStdDev, item.CV,
+				}
+				start, _ := excelize.CoordinatesToCellName(1, r)
+				f.SetSheetRow(level.sheet, start, &values)
+				f.SetCellStyle(level.sheet, fmt.Sprintf("H%d", r), fmt.Sprintf("H%d", r), currencyStyle)
+				f.SetCellStyle(level.sheet, fmt.Sprintf("I%d", r), fmt.Sprintf("I%d", r), percentStyle)
+			}
+			f.SetColWidth(level.sheet, "C", "C", 14)
+			f.SetColWidth(level.sheet, "D", "D", 40)
+			f.SetColWidth(level.sheet, "E", "E", 25)
+			f.SetColWidth(level.sheet, "H", "H", 16)
+		}
+
+		fileName := fmt.Sprintf("ABCXYZ分析_%s.xlsx", time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+		if err := f.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// formatDate は YYYYMMDD 形式の日付を YYYY-MM-DD 形式に変換します。
+func formatDate(s string) string {
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:6] + "-" + s[6:]
+}
@@ -0,0 +1,154 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\audit\handler.go
+
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// UserHeader は画面で設定した担当者名を送るリクエストヘッダです (URLエンコード済み)。
+const UserHeader = "X-Wasabi-User"
+
+// exportLimit はExcelエクスポートで件数の指定が無い場合の上限です。
+const exportLimit = 50000
+
+// RequestUser はリクエストの操作者名を返します。
+// 担当者名が設定されていない端末からの操作は、接続元のアドレスで記録します。
+func RequestUser(r *http.Request) string {
+	if v := r.Header.Get(UserHeader); v != "" {
+		if name, err := url.QueryUnescape(v); err == nil && strings.TrimSpace(name) != "" {
+			return strings.TrimSpace(name)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "端末 " + host
+}
+
+// parseFilters はクエリパラメータから監査ログの検索条件を作成します。
+func parseFilters(r *http.Request) model.AuditLogFilters {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	return model.AuditLogFilters{
+		Entity:    q.Get("entity"),
+		EntityKey: q.Get("key"),
+		Action:    q.Get("action"),
+		UserName:  q.Get("user"),
+		Keyword:   q.Get("keyword"),
+		DateFrom:  q.Get("from"),
+		DateTo:    q.Get("to"),
+		Limit:     limit,
+	}
+}
+
+// GetAuditLogsHandler は条件に一致する監査ログを返します。
+func GetAuditLogsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := db.GetAuditLogs(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "監査ログの取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// ExportAuditLogsHandler は条件に一致する監査ログをExcelファイルとしてエクスポートします。
+func ExportAuditLogsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters := parseFilters(r)
+		if filters.Limit <= 0 {
+			filters.Limit = exportLimit
+		}
+		entries, err := db.GetAuditLogs(conn, filters)
+		if err != nil {
+			http.Error(w, "Failed to get audit logs for export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		f := excelize.NewFile()
+		sheetName := "監査ログ"
+		index, _ := f.NewSheet(sheetName)
+		f.SetActiveSheet(index)
+		f.DeleteSheet("Sheet1")
+
+		headers := []string{"ID", "日時", "対象", "キー", "操作", "操作者", "変更内容", "変更前", "変更後"}
+		headerStyle, _ := f.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true},
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center"},
+		})
+		for i, h := range headers {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+			f.SetCellValue(sheetName, cell, h)
+			f.SetCellStyle(sheetName, cell, cell, headerStyle)
+		}
+
+		for i, e := range entries {
+			row := strconv.Itoa(i + 2)
+			f.SetCellValue(sheetName, "A"+row, e.ID)
+			f.SetCellValue(sheetName, "B"+row, e.CreatedAt)
+			f.SetCellValue(sheetName, "C"+row, e.Entity)
+			f.SetCellValue(sheetName, "D"+row, e.EntityKey)
+			f.SetCellValue(sheetName, "E"+row, e.Action)
+			f.SetCellValue(sheetName, "F"+row, e.UserName)
+			f.SetCellValue(sheetName, "G"+row, describeChanges(e))
+			f.SetCellValue(sheetName, "H"+row, e.BeforeJSON)
+			f.SetCellValue(sheetName, "I"+row, e.AfterJSON)
+		}
+		f.SetColWidth(sheetName, "B", "B", 20)
+		f.SetColWidth(sheetName, "C", "D", 18)
+		f.SetColWidth(sheetName, "F", "F", 16)
+		f.SetColWidth(sheetName, "G", "G", 50)
+		f.SetColWidth(sheetName, "H", "I", 60)
+
+		fileName := fmt.Sprintf("監査ログ_%s.xlsx", time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+		if err := f.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// describeChanges は UPDATE で値が変わった列を「列名: 変更前 → 変更後」の形式で返します。
+func describeChanges(e model.AuditLogEntry) string {
+	if e.Action != "UPDATE" {
+		return ""
+	}
+	var before, after map[string]interface{}
+	if json.Unmarshal([]byte(e.BeforeJSON), &before) != nil || json.Unmarshal([]byte(e.AfterJSON), &after) != nil {
+		return ""
+	}
+	var changes []string
+	for key, newValue := range after {
+		if oldValue := before[key]; fmt.Sprint(oldValue) != fmt.Sprint(newValue) {
+			changes = append(changes, fmt.Sprintf("%s: %v → %v", key, formatValue(oldValue), formatValue(newValue)))
+		}
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, "\n")
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "(なし)"
+	}
+	return fmt.Sprint(v)
+}
@@ -5,32 +5,61 @@ package backorder
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/model"
 	"wasabi/units"
 )
 
+// DefaultAgingDays は発注残の経過日数レポートの既定の日数です。
+const DefaultAgingDays = 7
+
+// writeBackorderError は発注残の更新で発生したエラーを、原因に応じたステータスコードで返します。
+// 存在しない発注残は 404、状態により変更できない場合は 400、それ以外は fallback を使用します。
+func writeBackorderError(w http.ResponseWriter, message string, err error, fallback int) {
+	status := fallback
+	switch {
+	case errors.Is(err, db.ErrBackorderNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, db.ErrBackorderStatus):
+		status = http.StatusBadRequest
+	}
+	http.Error(w, message+err.Error(), status)
+}
+
 // BackorderView は発注残データを画面表示用に整形するための構造体です。
 // model.Backorder の全フィールドに加え、画面表示用の包装仕様文字列を持ちます。
 type BackorderView struct {
 	model.Backorder
-	FormattedPackageSpec string `json:"formattedPackageSpec"`
+	FormattedPackageSpec string                       `json:"formattedPackageSpec"`
+	Fulfillments         []model.BackorderFulfillment `json:"fulfillments"` // 消し込んだ納品データ
 }
 
 /**
- * @brief 全ての発注残リストを取得し、画面表示用に整形して返すためのHTTPハンドラです。
+ * @brief 発注残リストを取得し、画面表示用に整形して返すためのHTTPハンドラです。
  * @param conn データベース接続
  * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
+ * @details
+ * ?status= で open (未完了、既定)、closed (納品完了・取消・欠品)、all を指定できます。
  */
 func GetBackordersHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		backorders, err := db.GetAllBackordersList(conn)
+		backorders, err := db.GetBackordersList(conn, r.URL.Query().Get("status"))
 		if err != nil {
 			http.Error(w, "Failed to get backorder list", http.StatusInternalServerError)
 			return
 		}
+		fulfillments, err := db.GetBackorderFulfillmentsMap(conn)
+		if err != nil {
+			http.Error(w, "Failed to get backorder fulfillments", http.StatusInternalServerError)
+			return
+		}
 
 		backorderViews := make([]BackorderView, 0, len(backorders))
 		for _, bo := range backorders {
@@ -49,6 +78,7 @@ func GetBackordersHandler(conn *sql.DB) http.HandlerFunc {
 			backorderViews = append(backorderViews, BackorderView{
 				Backorder:            bo,
 				FormattedPackageSpec: formattedSpec,
+				Fulfillments:         fulfillments[bo.ID],
 			})
 		}
 
@@ -59,31 +89,33 @@ func GetBackordersHandler(conn *sql.DB) http.HandlerFunc {
 
 // ▼▼▼【ここから修正】▼▼▼
 /**
- * @brief 単一の発注残レコードを削除するためのHTTPハンドラです。
+ * @brief 単一の発注残レコードを取り消すためのHTTPハンドラです。
  * @param conn データベース接続
  * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
  * @details
- * HTTPリクエストのボディから削除対象のIDを受け取り、DBから削除します。
+ * HTTPリクエストのボディから取消対象のIDと取消理由を受け取ります。
+ * 発注残は削除せず、理由とともに取消の状態で残します。
  */
 func DeleteBackorderHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload struct {
-			ID int `json:"id"`
+			ID     int    `json:"id"`
+			Reason string `json:"reason"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
 		}
 		defer tx.Rollback()
 
-		if err := db.DeleteBackorderInTx(tx, payload.ID); err != nil {
-			http.Error(w, "Failed to delete backorder: "+err.Error(), http.StatusInternalServerError)
+		if err := db.SetBackorderStatusInTx(tx, payload.ID, model.BackorderStatusCancelled, payload.Reason); err != nil {
+			writeBackorderError(w, "Failed to cancel backorder: ", err, http.StatusInternalServerError)
 			return
 		}
 
@@ -93,22 +125,23 @@ func DeleteBackorderHandler(conn *sql.DB) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "発注残を削除しました。"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "発注残を取り消しました。"})
 	}
 }
 
 /**
- * @brief 複数の発注残レコードを一括で削除するためのHTTPハンドラです。
+ * @brief 複数の発注残レコードを一括で取り消すためのHTTPハンドラです。
  * @param conn データベース接続
  * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
  * @details
- * HTTPリクエストのボディから削除対象のIDの配列を受け取り、ループ処理でDBから削除します。
+ * HTTPリクエストのボディから取消対象のIDと取消理由の配列を受け取り、ループ処理で取消の状態にします。
  * 処理は単一のトランザクション内で行われ、一件でも失敗した場合は全てロールバックされます。
  */
 func BulkDeleteBackordersHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload []struct {
-			ID int `json:"id"`
+			ID     int    `json:"id"`
+			Reason string `json:"reason"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -117,11 +150,11 @@ func BulkDeleteBackordersHandler(conn *sql.DB) http.HandlerFunc {
 
 		if len(payload) == 0 {
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{"message": "削除する項目がありません。"})
+			json.NewEncoder(w).Encode(map[string]string{"message": "取り消す項目がありません。"})
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -129,8 +162,81 @@ func BulkDeleteBackordersHandler(conn *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		for _, bo := range payload {
-			if err := db.DeleteBackorderInTx(tx, bo.ID); err != nil {
-				http.Error(w, "Failed to delete backorder: "+err.Error(), http.StatusInternalServerError)
+			if bo.ID <= 0 {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if err := db.SetBackorderStatusInTx(tx, bo.ID, model.BackorderStatusCancelled, bo.Reason); err != nil {
+				writeBackorderError(w, "Failed to cancel backorder: ", err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "選択された発注残を取り消しました。"})
+	}
+}
+
+/**
+ * @brief 発注残の状態 (欠品・取消・再開) と納品予定日を更新するためのHTTPハンドラです。
+ * @param conn データベース接続
+ * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
+ * @details
+ * リクエストボディ: {"id": 1, "status": "out_of_stock", "reason": "...", "expectedDate": "2025-01-31"}
+ * status を省略した場合は状態を変更しません。expectedDate は指定した場合のみ更新し、空文字で未定に戻します。
+ */
+func UpdateBackorderHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var payload struct {
+			ID           int     `json:"id"`
+			Status       string  `json:"status"`
+			Reason       string  `json:"reason"`
+			ExpectedDate *string `json:"expectedDate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID <= 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		switch payload.Status {
+		case "", model.BackorderStatusCancelled, model.BackorderStatusOutOfStock, model.BackorderStatusOrdered:
+		default:
+			http.Error(w, "発注残の状態が不正です: "+payload.Status, http.StatusBadRequest)
+			return
+		}
+		var expectedDate string
+		if payload.ExpectedDate != nil {
+			expectedDate = strings.ReplaceAll(*payload.ExpectedDate, "-", "")
+			if _, err := time.Parse("20060102", expectedDate); expectedDate != "" && err != nil {
+				http.Error(w, "納品予定日が不正です: "+*payload.ExpectedDate, http.StatusBadRequest)
+				return
+			}
+		}
+
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if payload.Status != "" {
+			if err := db.SetBackorderStatusInTx(tx, payload.ID, payload.Status, payload.Reason); err != nil {
+				writeBackorderError(w, "", err, http.StatusInternalServerError)
+				return
+			}
+		}
+		if payload.ExpectedDate != nil {
+			if err := db.SetBackorderExpectedDateInTx(tx, payload.ID, expectedDate); err != nil {
+				writeBackorderError(w, "", err, http.StatusInternalServerError)
 				return
 			}
 		}
@@ -141,7 +247,35 @@ func BulkDeleteBackordersHandler(conn *sql.DB) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "選択された発注残を削除しました。"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "発注残を更新しました。"})
+	}
+}
+
+/**
+ * @brief 発注から指定日数を超えて未完了の発注残を、卸ごとに返すHTTPハンドラです。
+ * @param conn データベース接続
+ * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
+ * @details
+ * ?days= で経過日数を指定します (省略時は DefaultAgingDays)。
+ */
+func AgingReportHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := DefaultAgingDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			d, err := strconv.Atoi(v)
+			if err != nil || d < 0 {
+				http.Error(w, "days の指定が不正です: "+v, http.StatusBadRequest)
+				return
+			}
+			days = d
+		}
+		report, err := db.GetBackorderAgingReport(conn, time.Now(), days)
+		if err != nil {
+			http.Error(w, "発注残の経過日数レポートの作成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
 	}
 }
 
@@ -0,0 +1,69 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\backup\database.go
+
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDatabaseBackupDir はデータベースのバックアップの保存先です。
+var DefaultDatabaseBackupDir = filepath.Join(".", "download", "backup")
+
+const databaseBackupPrefix = "wasabi_"
+
+/**
+ * @brief データベース全体を1つのファイルにバックアップします。
+ * @param conn データベース接続
+ * @param dir 保存先のフォルダ
+ * @param keep 保持する世代数。これより古いバックアップは削除します (0以下の場合は削除しない)
+ * @return string 作成したバックアップファイルのパス
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * SQLiteの VACUUM INTO を使うため、アプリケーションの動作中でも整合性の取れたコピーが作成されます。
+ */
+func BackupDatabase(conn *sql.DB, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("バックアップフォルダの作成に失敗しました: %w", err)
+	}
+	path := filepath.Join(dir, databaseBackupPrefix+time.Now().Format("20060102_150405")+".db")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("バックアップファイル %s は既に存在します", path)
+	}
+	if _, err := conn.Exec(`VACUUM INTO ?`, path); err != nil {
+		return "", fmt.Errorf("データベースのバックアップに失敗しました: %w", err)
+	}
+	if keep > 0 {
+		if err := pruneDatabaseBackups(dir, keep); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+// pruneDatabaseBackups は新しい順に keep 世代を残し、それより古いバックアップを削除します。
+func pruneDatabaseBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), databaseBackupPrefix) && strings.HasSuffix(e.Name(), ".db") {
+			names = append(names, e.Name())
+		}
+	}
+	// ファイル名に日時を含むため、名前の降順が新しい順になる
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for i := keep; i < len(names); i++ {
+		if err := os.Remove(filepath.Join(dir, names[i])); err != nil {
+			return fmt.Errorf("古いバックアップの削除に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/model"
 )
@@ -87,7 +88,7 @@ func ImportCustomersHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -101,7 +102,8 @@ func ImportCustomersHandler(conn *sql.DB) http.HandlerFunc {
 		}
 		defer clientStmt.Close()
 
-		wholesalerStmt, err := tx.Prepare("INSERT OR REPLACE INTO wholesalers (wholesaler_code, wholesaler_name) VALUES (?, ?)")
+		wholesalerStmt, err := tx.Prepare(`INSERT INTO wholesalers (wholesaler_code, wholesaler_name) VALUES (?, ?)
+			ON CONFLICT(wholesaler_code) DO UPDATE SET wholesaler_name = excluded.wholesaler_name`)
 		if err != nil {
 			http.Error(w, "Failed to prepare wholesaler DB statement", http.StatusInternalServerError)
 			return
@@ -209,7 +211,7 @@ func ImportClientsHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -343,7 +345,7 @@ func ImportProductsHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"wasabi/audit"
 	"wasabi/db"
 )
 
@@ -34,7 +35,7 @@ func ExecuteCleanupHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
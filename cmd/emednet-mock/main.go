@@ -0,0 +1,83 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\cmd\emednet-mock\main.go
+
+// emednet-mock は e-mednet のモックサーバーを起動します。
+//
+//	go run ./cmd/emednet-mock -addr 127.0.0.1:8090 -dat sample1.DAT -dat sample2.DAT
+//
+// 設定画面の接続先URLに http://127.0.0.1:8090/ を指定すると、本番サイトの代わりに使用できます。
+// -run を指定すると、起動したモックサーバーに対して自動操作を1回実行し、結果を表示して終了します。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"wasabi/emednet"
+	"wasabi/emednet/mock"
+)
+
+type fileList []string
+
+func (f *fileList) String() string     { return strings.Join(*f, ",") }
+func (f *fileList) Set(v string) error { *f = append(*f, v); return nil }
+
+func main() {
+	var datFiles fileList
+	addr := flag.String("addr", "127.0.0.1:8090", "listen address")
+	userID := flag.String("user", "mock", "login user id")
+	password := flag.String("password", "mock", "login password")
+	delay := flag.Duration("delay", 2*time.Second, "delay before the receive result is shown")
+	run := flag.Bool("run", false, "run the automation once against the mock server and exit")
+	browserPath := flag.String("browser", "", "browser executable for -run (auto-detected if empty)")
+	downloadDir := flag.String("download", filepath.Join(os.TempDir(), "emednet-mock-download"), "download directory for -run")
+	flag.Var(&datFiles, "dat", "DAT file returned by the receive button (repeatable)")
+	flag.Parse()
+
+	server := mock.NewServer(*userID, *password)
+	server.ResultDelay = *delay
+	for _, path := range datFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", path, err)
+		}
+		server.AddDelivery(mock.Delivery{FileName: filepath.Base(path), Content: content})
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	baseURL := fmt.Sprintf("http://%s/", listener.Addr())
+	log.Printf("mock e-mednet listening on %s (user=%s, %d deliveries)", baseURL, *userID, len(datFiles))
+
+	if !*run {
+		log.Fatal(http.Serve(listener, server.Handler()))
+	}
+
+	go http.Serve(listener, server.Handler())
+	res, err := emednet.Run(context.Background(), emednet.Options{
+		BaseURL:       baseURL,
+		BrowserPath:   *browserPath,
+		Headless:      true,
+		NoSandbox:     true,
+		UserID:        *userID,
+		Password:      *password,
+		DownloadDir:   *downloadDir,
+		ScreenshotDir: filepath.Join(*downloadDir, "screenshots"),
+	})
+	if err != nil {
+		log.Fatalf("automation failed after %d attempt(s): %v", res.Attempts, err)
+	}
+	if res.NoData {
+		fmt.Println("no pending deliveries")
+		return
+	}
+	fmt.Println("downloaded:", res.FilePath)
+}
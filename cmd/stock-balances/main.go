@@ -0,0 +1,65 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\cmd\stock-balances\main.go
+
+// stock-balances は在庫スナップショット (stock_balances) を取引データからの再計算結果と比較します。
+//
+//	go run ./cmd/stock-balances -db ./wasabi.db
+//	go run ./cmd/stock-balances -db ./wasabi.db -rebuild
+//
+// 食い違いがあれば製品ごとに表示して終了コード1で終了します。
+// -rebuild を指定すると、食い違いを表示した後にスナップショットを全ての取引データから作成し直します。
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"wasabi/db"
+	"wasabi/model"
+)
+
+func main() {
+	dbPath := flag.String("db", "./wasabi.db", "database file")
+	rebuild := flag.Bool("rebuild", false, "rebuild stock balances from all transactions")
+	flag.Parse()
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		log.Fatalf("database not found: %v", err)
+	}
+	conn, err := sql.Open(db.DriverName, *dbPath)
+	if err != nil {
+		log.Fatalf("db open error: %v", err)
+	}
+	conn.Exec("PRAGMA busy_timeout = 5000;")
+	conn.SetMaxOpenConns(1)
+	defer conn.Close()
+
+	drifts, err := db.VerifyStockBalances(conn)
+	if err != nil {
+		log.Fatalf("verify failed: %v", err)
+	}
+	for _, d := range drifts {
+		fmt.Printf("%s\tstored=%s\texpected=%s\n", d.ProductCode, formatBalance(d.Stored), formatBalance(d.Expected))
+	}
+	fmt.Printf("%d products drifted\n", len(drifts))
+
+	if *rebuild {
+		count, err := db.RebuildStockBalances(conn)
+		if err != nil {
+			log.Fatalf("rebuild failed: %v", err)
+		}
+		fmt.Printf("rebuilt stock balances for %d products\n", count)
+		return
+	}
+	if len(drifts) > 0 {
+		os.Exit(1)
+	}
+}
+
+func formatBalance(b *model.StockBalance) string {
+	if b == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%g (inventory %q %g, change %g)", b.BaseQuantity+b.NetChange, b.LastInventoryDate, b.BaseQuantity, b.NetChange)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+	"wasabi/model"
 )
 
 // Config はアプリケーションの設定情報を保持する構造体です。
@@ -23,6 +24,32 @@ type Config struct {
 	// ▼▼▼【ここに追加】▼▼▼
 	EdgePath string `json:"edgePath"` // Edgeの実行可能ファイルパス
 	// ▲▲▲【追加ここまで】▲▲▲
+	// 自動取込 (ingestパッケージ) の設定
+	AutoIngestEnabled         bool   `json:"autoIngestEnabled"`
+	AutoIngestIntervalSeconds int    `json:"autoIngestIntervalSeconds"` // 監視フォルダの確認間隔(秒)
+	DatWatchFolderPath        string `json:"datWatchFolderPath"`        // 空の場合、DATファイルの監視は行わない
+	// UsageFormats は標準形式以外のUSAGEファイル形式の定義です。取込時にヘッダーから自動判別します。
+	UsageFormats []model.UsageFormat `json:"usageFormats"`
+	// NsipsLayout はNSIPS処方データのレコード識別子と列位置です。nil の場合は標準のレイアウトを使用します。
+	NsipsLayout *model.NsipsLayout `json:"nsipsLayout"`
+	// e-mednet 自動操作 (emednetパッケージ) の設定
+	EmednetBaseURL     string `json:"emednetBaseUrl"`     // 空の場合は本番サイト。動作確認ではモックサーバーのURLを指定する
+	ChromePath         string `json:"chromePath"`         // 空の場合は標準のインストール先から探す
+	AutomationHeadless bool   `json:"automationHeadless"` // ブラウザを画面に表示せずに操作する
+	AutomationRetries  int    `json:"automationRetries"`  // 失敗時の再試行回数
+	// ExpiryAlertDays は有効期限切迫レポートで集計する日数です (空の場合は 30, 60, 90日)。
+	ExpiryAlertDays []int `json:"expiryAlertDays"`
+	// 循環棚卸 (cyclecountパッケージ) の設定。0 の場合は既定値を使用する
+	CycleCountDaysA      int `json:"cycleCountDaysA"`      // A分類の棚卸間隔(日)
+	CycleCountDaysB      int `json:"cycleCountDaysB"`      // B分類の棚卸間隔(日)
+	CycleCountDaysC      int `json:"cycleCountDaysC"`      // C分類の棚卸間隔(日)
+	CycleCountDailyLimit int `json:"cycleCountDailyLimit"` // 1日に棚卸する品目(YJ)の上限。0 の場合は上限なし
+	// 発注点の需要予測 (forecastパッケージ) の設定。空・0 の場合は既定値を使用する
+	ForecastMethod     string  `json:"forecastMethod"`     // moving_average, exponential_smoothing, day_of_week, max_usage
+	ForecastWindowDays int     `json:"forecastWindowDays"` // 移動平均・曜日別平均に使う直近の日数
+	ForecastAlpha      float64 `json:"forecastAlpha"`      // 指数平滑の平滑化係数
+	LeadTimeDays       int     `json:"leadTimeDays"`       // 発注から納品までの日数
+	ServiceLevel       float64 `json:"serviceLevel"`       // 欠品しない確率の目標 (例: 0.95)
 }
 
 var (
@@ -32,6 +59,12 @@ var (
 	mu sync.RWMutex
 )
 
+// DefaultExpiryAlertDays は有効期限切迫レポートで集計する日数の既定値です。
+var DefaultExpiryAlertDays = []int{30, 60, 90}
+
+// DefaultCycleCountDays は循環棚卸のABC分類ごとの棚卸間隔(日)の既定値です。
+var DefaultCycleCountDays = map[string]int{"A": 30, "B": 90, "C": 180}
+
 // configFilePath は設定ファイルのパスを定義する定数です。
 const configFilePath = "./config.json"
 
@@ -54,6 +87,7 @@ func LoadConfig() (Config, error) {
 			return Config{
 				// ▼▼▼【修正】日数のデフォルト値を設定 ▼▼▼
 				CalculationPeriodDays: 90,
+				ExpiryAlertDays:       DefaultExpiryAlertDays,
 			}, nil
 		}
 		return Config{}, err
@@ -0,0 +1,240 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\controlled\annual_report.go
+
+package controlled
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// annualReportHeaders は麻薬年間届の出力列です (CSV・Excel・PDFで共通)。
+var annualReportHeaders = []string{
+	"品名", "規格・包装", "単位", "年初在庫 (10月1日)", "受入", "施用・交付", "譲渡", "廃棄", "調整", "年末在庫 (9月30日)",
+}
+
+// loadAnnualReport は ?year= (期間が終わる年) の麻薬年間届を作成します。指定が無い場合は直近に終わった期間です。
+func loadAnnualReport(conn *sql.DB, r *http.Request) (*model.NarcoticAnnualReport, error) {
+	year := db.NarcoticReportYear(time.Now())
+	if v := r.URL.Query().Get("year"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("届出年が不正です: %s", v)
+		}
+		year = parsed
+	}
+	return db.GetNarcoticAnnualReport(conn, year)
+}
+
+// annualReportRow は1品目の出力値を annualReportHeaders の順に返します。
+func annualReportRow(item model.NarcoticAnnualReportItem) []string {
+	return []string{
+		item.ProductName, item.PackageSpec, item.YjUnitName,
+		formatQty(item.Opening), formatQty(item.Received), formatQty(item.Dispensed),
+		formatQty(item.Transferred), formatQty(item.Disposed), formatQty(item.Adjusted), formatQty(item.Closing),
+	}
+}
+
+// unresolvedNotesWarning は取引に対応しない帳簿の記載がある場合の警告文を返します。無い場合は空文字列です。
+func unresolvedNotesWarning(report *model.NarcoticAnnualReport) string {
+	if len(report.UnresolvedNotes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("取引に対応しない帳簿の記載が%d件あります (集計に含まれていません)。伝票の修正・削除を確認してください。", len(report.UnresolvedNotes))
+}
+
+func annualReportFileName(report *model.NarcoticAnnualReport, ext string) string {
+	return fmt.Sprintf("麻薬年間届_%d年9月30日_%s.%s", report.Year, time.Now().Format("20060102"), ext)
+}
+
+// GetAnnualReportHandler は麻薬年間届の集計を返します。
+func GetAnnualReportHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadAnnualReport(conn, r)
+		if err != nil {
+			http.Error(w, "麻薬年間届の作成に失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// ExportAnnualReportCSVHandler は麻薬年間届を、届出様式への転記用のCSVとしてエクスポートします。
+func ExportAnnualReportCSVHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadAnnualReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get narcotic annual report for export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+annualReportFileName(report, "csv")+`"`)
+		w.Write([]byte{0xEF, 0xBB, 0xBF}) // UTF-8 BOM
+
+		csvWriter := csv.NewWriter(w)
+		defer csvWriter.Flush()
+
+		if err := csvWriter.Write(append([]string{"製品コード"}, annualReportHeaders...)); err != nil {
+			http.Error(w, "Failed to write CSV header", http.StatusInternalServerError)
+			return
+		}
+		for _, item := range report.Items {
+			if err := csvWriter.Write(append([]string{fmt.Sprintf("=%q", item.ProductCode)}, annualReportRow(item)...)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ExportAnnualReportExcelHandler は麻薬年間届をExcelファイルとしてエクスポートします。
+func ExportAnnualReportExcelHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadAnnualReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get narcotic annual report for export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f := excelize.NewFile()
+		sheetName := "麻薬年間届"
+		index, _ := f.NewSheet(sheetName)
+		f.SetActiveSheet(index)
+		f.DeleteSheet("Sheet1")
+
+		f.SetCellValue(sheetName, "A1", fmt.Sprintf("麻薬年間届 集計表 (%s ～ %s)", formatDate(report.StartDate), formatDate(report.EndDate)))
+		headerStyle, _ := f.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true},
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center"},
+		})
+		for i, h := range annualReportHeaders {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 3)
+			f.SetCellValue(sheetName, cell, h)
+			f.SetCellStyle(sheetName, cell, cell, headerStyle)
+		}
+		for i, item := range report.Items {
+			row := strconv.Itoa(i + 4)
+			f.SetCellValue(sheetName, "A"+row, item.ProductName)
+			f.SetCellValue(sheetName, "B"+row, item.PackageSpec)
+			f.SetCellValue(sheetName, "C"+row, item.YjUnitName)
+			f.SetCellValue(sheetName, "D"+row, item.Opening)
+			f.SetCellValue(sheetName, "E"+row, item.Received)
+			f.SetCellValue(sheetName, "F"+row, item.Dispensed)
+			f.SetCellValue(sheetName, "G"+row, item.Transferred)
+			f.SetCellValue(sheetName, "H"+row, item.Disposed)
+			f.SetCellValue(sheetName, "I"+row, item.Adjusted)
+			f.SetCellValue(sheetName, "J"+row, item.Closing)
+		}
+		if warning := unresolvedNotesWarning(report); warning != "" {
+			f.SetCellValue(sheetName, "A2", warning)
+		}
+		f.SetColWidth(sheetName, "A", "A", 40)
+		f.SetColWidth(sheetName, "B", "B", 25)
+		f.SetColWidth(sheetName, "D", "J", 14)
+
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+annualReportFileName(report, "xlsx"))
+		if err := f.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// ExportAnnualReportPDFHandler は麻薬年間届をPDFファイルとしてエクスポートします。
+func ExportAnnualReportPDFHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadAnnualReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get narcotic annual report for export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		const (
+			pageHeight   = 210.0
+			leftMargin   = 10.0
+			topMargin    = 10.0
+			rightMargin  = 10.0
+			bottomMargin = 15.0
+			rowHeight    = 7.0
+		)
+		widths := []float64{70, 45, 14, 21, 21, 21, 21, 21, 21, 21}
+		aligns := []string{"L", "L", "C", "R", "R", "R", "R", "R", "R", "R"}
+
+		pdf := gofpdf.New("L", "mm", "A4", "")
+		pdf.SetMargins(leftMargin, topMargin, rightMargin)
+		pdf.SetAutoPageBreak(false, bottomMargin)
+		pdf.AddUTF8Font("ipaexg", "", "SOU/ipaexg.ttf")
+		pdf.AddPage()
+
+		pdf.SetFont("ipaexg", "", 14)
+		pdf.Cell(0, 10, fmt.Sprintf("麻薬年間届 集計表 (%s ～ %s)", formatDate(report.StartDate), formatDate(report.EndDate)))
+		pdf.Ln(12)
+
+		// セル幅に収まらない文字列は末尾を切り詰める
+		fit := func(s string, width float64) string {
+			for s != "" && pdf.GetStringWidth(s) > width-2 {
+				runes := []rune(s)
+				s = string(runes[:len(runes)-1])
+			}
+			return s
+		}
+		drawHeader := func() {
+			pdf.SetFont("ipaexg", "", 8)
+			pdf.SetFillColor(240, 240, 240)
+			for i, h := range annualReportHeaders {
+				pdf.CellFormat(widths[i], rowHeight, fit(h, widths[i]), "1", 0, "C", true, 0, "")
+			}
+			pdf.Ln(rowHeight)
+			pdf.SetFont("ipaexg", "", 9)
+		}
+		drawHeader()
+
+		if len(report.Items) == 0 {
+			pdf.CellFormat(0, rowHeight, "期間内に記載する麻薬の取引・在庫はありません。", "1", 1, "L", false, 0, "")
+		}
+		for _, item := range report.Items {
+			if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+				pdf.AddPage()
+				drawHeader()
+			}
+			for i, v := range annualReportRow(item) {
+				pdf.CellFormat(widths[i], rowHeight, fit(v, widths[i]), "1", 0, aligns[i], false, 0, "")
+			}
+			pdf.Ln(rowHeight)
+		}
+		pdf.Ln(2)
+		pdf.SetFont("ipaexg", "", 8)
+		pdf.Cell(0, 5, "数量は調剤単位 (YJ単位) です。調整には棚卸増減と実在庫との差異を含みます。")
+		if warning := unresolvedNotesWarning(report); warning != "" {
+			pdf.Ln(5)
+			pdf.SetTextColor(200, 0, 0)
+			pdf.Cell(0, 5, warning)
+			pdf.SetTextColor(0, 0, 0)
+		}
+
+		var buffer bytes.Buffer
+		if err := pdf.Output(&buffer); err != nil {
+			http.Error(w, "PDFの生成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", annualReportFileName(report, "pdf")))
+		w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+		if _, err := buffer.WriteTo(w); err != nil {
+			http.Error(w, "PDFの送信に失敗しました: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
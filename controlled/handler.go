@@ -0,0 +1,263 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\controlled\handler.go
+
+package controlled
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wasabi/audit"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// parseFilters はクエリパラメータから帳簿の抽出条件を作成します。日付は YYYY-MM-DD と YYYYMMDD のどちらも受け付けます。
+func parseFilters(r *http.Request) model.ControlledRegisterFilters {
+	q := r.URL.Query()
+	filters := model.ControlledRegisterFilters{
+		ProductCode: strings.TrimSpace(q.Get("productCode")),
+		StartDate:   strings.ReplaceAll(q.Get("startDate"), "-", ""),
+		EndDate:     strings.ReplaceAll(q.Get("endDate"), "-", ""),
+		ByLot:       q.Get("byLot") == "true" || q.Get("byLot") == "1",
+	}
+	if v := q.Get("drugTypes"); v != "" {
+		filters.DrugTypes = strings.Split(v, ",")
+	}
+	return filters
+}
+
+// GetRegisterHandler は麻薬・向精神薬帳簿を返します。
+func GetRegisterHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registers, err := db.GetControlledRegisters(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "帳簿の作成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registers)
+	}
+}
+
+// SaveNoteHandler は帳簿の行に区分 (廃棄など)・立会人・備考を記録します。
+func SaveNoteHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var note model.ControlledRegisterNote
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := db.SaveControlledRegisterNote(conn, note, audit.RequestUser(r)); err != nil {
+			if db.IsPeriodClosedError(err) {
+				http.Error(w, "締め済みの期間の帳簿は変更できません: "+err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "帳簿の記載を保存しました。"})
+	}
+}
+
+// ExportRegisterPDFHandler は麻薬・向精神薬帳簿を、品目 (ロット別の場合は品目とロット) ごとに1ページから始まる帳簿の様式でPDF出力します。
+func ExportRegisterPDFHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters := parseFilters(r)
+		registers, err := db.GetControlledRegisters(conn, filters)
+		if err != nil {
+			http.Error(w, "Failed to get controlled register for export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		const (
+			pageHeight   = 297.0
+			leftMargin   = 10.0
+			topMargin    = 10.0
+			rightMargin  = 10.0
+			bottomMargin = 15.0
+			rowHeight    = 7.0
+		)
+		columns := []struct {
+			title string
+			width float64
+			align string
+		}{
+			{"年月日", 20, "C"},
+			{"区分", 16, "C"},
+			{"相手方・患者", 32, "L"},
+			{"伝票番号", 20, "L"},
+			{"製造番号", 20, "L"},
+			{"受入", 16, "R"},
+			{"払出", 16, "R"},
+			{"残高", 16, "R"},
+			{"備考", 24, "L"},
+			{"確認", 10, "C"},
+		}
+
+		pdf := gofpdf.New("P", "mm", "A4", "")
+		pdf.SetMargins(leftMargin, topMargin, rightMargin)
+		pdf.SetAutoPageBreak(false, bottomMargin)
+		pdf.AddUTF8Font("ipaexg", "", "SOU/ipaexg.ttf")
+
+		// セル幅に収まらない文字列は末尾を切り詰める
+		fit := func(s string, width float64) string {
+			for s != "" && pdf.GetStringWidth(s) > width-2 {
+				runes := []rune(s)
+				s = string(runes[:len(runes)-1])
+			}
+			return s
+		}
+		drawRow := func(values []string, fill bool) {
+			for i, c := range columns {
+				pdf.CellFormat(c.width, rowHeight, fit(values[i], c.width), "1", 0, c.align, fill, 0, "")
+			}
+			pdf.Ln(rowHeight)
+		}
+		period := periodLabel(filters)
+
+		if len(registers) == 0 {
+			pdf.AddPage()
+			pdf.SetFont("ipaexg", "", 12)
+			pdf.Cell(0, 10, "対象期間に記載する取引はありません。 "+period)
+		}
+		for _, reg := range registers {
+			drawPageHeader := func() {
+				pdf.AddPage()
+				pdf.SetFont("ipaexg", "", 14)
+				pdf.CellFormat(0, 10, registerTitle(reg.DrugClass), "", 1, "C", false, 0, "")
+				pdf.SetFont("ipaexg", "", 10)
+				pdf.CellFormat(120, 6, "品名: "+reg.ProductName, "", 0, "L", false, 0, "")
+				pdf.CellFormat(0, 6, "区分: "+reg.DrugClass, "", 1, "L", false, 0, "")
+				pdf.CellFormat(120, 6, "規格・包装: "+reg.PackageSpec, "", 0, "L", false, 0, "")
+				pdf.CellFormat(0, 6, "単位: "+reg.YjUnitName, "", 1, "L", false, 0, "")
+				pdf.CellFormat(120, 6, "製品コード: "+reg.ProductCode, "", 0, "L", false, 0, "")
+				pdf.CellFormat(0, 6, "期間: "+period, "", 1, "L", false, 0, "")
+				if filters.ByLot {
+					pdf.CellFormat(120, 6, "製造番号: "+valueOr(reg.LotNumber, "(不明)"), "", 0, "L", false, 0, "")
+					pdf.CellFormat(0, 6, "有効期限: "+reg.ExpiryDate, "", 1, "L", false, 0, "")
+				}
+				pdf.Ln(2)
+				pdf.SetFont("ipaexg", "", 8)
+				pdf.SetFillColor(240, 240, 240)
+				titles := make([]string, len(columns))
+				for i, c := range columns {
+					titles[i] = c.title
+				}
+				drawRow(titles, true)
+			}
+			drawPageHeader()
+			drawRow([]string{"", "前期繰越", "", "", "", "", "", formatQty(reg.OpeningBalance), "", ""}, false)
+
+			for _, e := range reg.Entries {
+				if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+					drawPageHeader()
+				}
+				received, issued, remarks := "", "", e.Note
+				if e.Received != 0 {
+					received = formatQty(e.Received)
+				}
+				if e.Issued != 0 {
+					issued = formatQty(e.Issued)
+				}
+				if e.Category == "inventory" {
+					remarks = "帳簿 " + formatQty(e.BookBalance)
+					if e.Discrepancy != 0 {
+						remarks += " 差異 " + formatSignedQty(e.Discrepancy)
+						pdf.SetTextColor(200, 0, 0)
+					}
+				} else if e.Witness != "" {
+					remarks = strings.TrimSpace("立会 " + e.Witness + " " + e.Note)
+				}
+				client := e.ClientName
+				if client == "" {
+					client = e.ClientCode
+				}
+				drawRow([]string{
+					formatDate(e.Date), e.CategoryLabel, client, e.ReceiptNumber, e.LotNumber,
+					received, issued, formatQty(e.Balance), remarks, "",
+				}, false)
+				pdf.SetTextColor(0, 0, 0)
+			}
+
+			if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+				drawPageHeader()
+			}
+			pdf.SetFillColor(240, 240, 240)
+			totals := fmt.Sprintf("差異 %d件", reg.DiscrepancyCount)
+			drawRow([]string{"", "合計", "", "", "", formatQty(reg.TotalReceived), formatQty(reg.TotalIssued), formatQty(reg.ClosingBalance), totals, ""}, true)
+		}
+
+		var buffer bytes.Buffer
+		if err := pdf.Output(&buffer); err != nil {
+			http.Error(w, "PDFの生成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fileName := fmt.Sprintf("麻薬向精神薬帳簿_%s.pdf", time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fileName))
+		w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+		if _, err := buffer.WriteTo(w); err != nil {
+			http.Error(w, "PDFの送信に失敗しました: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// registerTitle は規制区分に応じた帳簿の表題を返します。
+func registerTitle(drugClass string) string {
+	if drugClass == "麻薬" {
+		return "麻薬帳簿"
+	}
+	return drugClass + " 受払記録簿"
+}
+
+func periodLabel(filters model.ControlledRegisterFilters) string {
+	if filters.StartDate == "" && filters.EndDate == "" {
+		return "全期間"
+	}
+	return formatDate(filters.StartDate) + " ～ " + formatDate(filters.EndDate)
+}
+
+// formatDate は YYYYMMDD 形式の日付を YYYY-MM-DD 形式に変換します。
+func formatDate(s string) string {
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:6] + "-" + s[6:]
+}
+
+// formatQty は数量を小数点以下3桁までで表示します (末尾の0は省略)。
+func formatQty(v float64) string {
+	v = math.Round(v*1000) / 1000
+	if v == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatSignedQty(v float64) string {
+	if v > 0 {
+		return "+" + formatQty(v)
+	}
+	return formatQty(v)
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
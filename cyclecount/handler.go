@@ -0,0 +1,133 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\cyclecount\handler.go
+
+package cyclecount
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wasabi/config"
+	"wasabi/db"
+	"wasabi/model"
+)
+
+// DefaultUsagePeriodDays はABC分類に使う処方の集計日数の既定値です。
+const DefaultUsagePeriodDays = 180
+
+// CycleCountResponse は循環棚卸画面のレスポンスです。
+type CycleCountResponse struct {
+	Date    string                         `json:"date"` // YYYYMMDD
+	Policy  model.CycleCountPolicy         `json:"policy"`
+	Summary []model.CycleCountClassSummary `json:"summary"`
+	Shelves []model.CycleCountShelf        `json:"shelves"`
+}
+
+// Policy は設定から循環棚卸の条件を作成します。未設定の間隔は既定値を使用します。
+func Policy() model.CycleCountPolicy {
+	cfg := config.GetConfig()
+	policy := model.CycleCountPolicy{
+		IntervalDays:    make(map[string]int),
+		DailyLimit:      cfg.CycleCountDailyLimit,
+		UsagePeriodDays: DefaultUsagePeriodDays,
+	}
+	for class, days := range config.DefaultCycleCountDays {
+		policy.IntervalDays[class] = days
+	}
+	for class, days := range map[string]int{"A": cfg.CycleCountDaysA, "B": cfg.CycleCountDaysB, "C": cfg.CycleCountDaysC} {
+		if days > 0 {
+			policy.IntervalDays[class] = days
+		}
+	}
+	return policy
+}
+
+// parseDate は ?date= (YYYY-MM-DD または YYYYMMDD) を YYYYMMDD で返します。省略時は今日です。
+func parseDate(r *http.Request) (string, error) {
+	date := strings.ReplaceAll(r.URL.Query().Get("date"), "-", "")
+	if date == "" {
+		return time.Now().Format("20060102"), nil
+	}
+	if _, err := time.Parse("20060102", date); err != nil {
+		return "", fmt.Errorf("日付が不正です: %s", r.URL.Query().Get("date"))
+	}
+	return date, nil
+}
+
+func writeResponse(w http.ResponseWriter, conn *sql.DB, date string, policy model.CycleCountPolicy, shelves []model.CycleCountShelf) {
+	summary, err := db.GetCycleCountSummary(conn, time.Now(), policy)
+	if err != nil {
+		http.Error(w, "棚卸の実施状況の集計に失敗しました: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CycleCountResponse{Date: date, Policy: policy, Summary: summary, Shelves: shelves})
+}
+
+// GetListHandler は指定日の循環棚卸リストと、分類ごとの実施状況を返します。
+func GetListHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date, err := parseDate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shelves, err := db.GetCycleCountList(conn, date)
+		if err != nil {
+			http.Error(w, "棚卸リストの取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(w, conn, date, Policy(), shelves)
+	}
+}
+
+// GenerateListHandler は指定日の循環棚卸リストを作成 (作り直し) します。
+func GenerateListHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		date, err := parseDate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		policy := Policy()
+		shelves, err := db.GenerateCycleCountList(conn, date, policy)
+		if err != nil {
+			http.Error(w, "棚卸リストの作成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeResponse(w, conn, date, policy, shelves)
+	}
+}
+
+// ClassifyHandler は処方金額によるABC分類をやり直します。?days= で集計日数を指定できます。
+func ClassifyHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		days := DefaultUsagePeriodDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			d, err := strconv.Atoi(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "days の指定が不正です: "+v, http.StatusBadRequest)
+				return
+			}
+			days = d
+		}
+		count, err := db.ClassifyProductsABC(conn, time.Now(), days)
+		if err != nil {
+			http.Error(w, "ABC分類に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("%d件の製品を過去%d日の処方金額で分類しました。", count, days)})
+	}
+}
@@ -4,16 +4,21 @@ package dat
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/mastermanager"
@@ -30,10 +35,22 @@ INSERT OR REPLACE INTO transaction_records (
     yj_quantity, yj_pack_unit_qty, yj_unit_name, unit_price, purchase_price, supplier_wholesale,
     subtotal, tax_amount, tax_rate, expiry_date, lot_number, flag_poison,
     flag_deleterious, flag_narcotic, flag_psychotropic, flag_stimulant,
-    flag_stimulant_raw, process_flag_ma
-) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+    flag_stimulant_raw, process_flag_ma, import_batch_id
+) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// errDatValidation は厳格モードで検証エラーが見つかった場合に返されます。
+var errDatValidation = errors.New("DAT file has validation errors")
+
+// DatFileResult はDATファイル1件分の取込結果です。
+type DatFileResult struct {
+	FileName    string                  `json:"fileName"`
+	RecordCount int                     `json:"recordCount"`
+	Diagnostics []parsers.DatDiagnostic `json:"diagnostics"`
+	Error       string                  `json:"error,omitempty"`
+}
 
 // UploadDatHandler はDATファイルのアップロードを処理するHTTPハンドラです。
+// フォーム値 strict=true の場合、検証エラーのあるファイルは取り込まずに結果で報告します。
 func UploadDatHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -41,145 +58,233 @@ func UploadDatHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 		defer r.MultipartForm.RemoveAll()
+		strict := r.FormValue("strict") == "true"
 
 		var allFilePaths []string
 		for _, fileHeader := range r.MultipartForm.File["file"] {
-			file, err := fileHeader.Open()
-			if err != nil {
-				log.Printf("Failed to open uploaded file %s: %v", fileHeader.Filename, err)
-				continue
-			}
-
-			tempFile, err := os.CreateTemp("", "dat-*.tmp")
+			tempPath, err := stageUploadedFile(fileHeader)
 			if err != nil {
-				log.Printf("Failed to create temp file: %v", err)
-				file.Close()
+				log.Printf("Failed to stage uploaded file %s: %v", fileHeader.Filename, err)
 				continue
 			}
-			_, err = io.Copy(tempFile, file)
-			file.Close()
+			destPath, err := organizeDatFile(tempPath, fileHeader.Filename)
 			if err != nil {
-				log.Printf("Failed to copy to temp file: %v", err)
-				tempFile.Close()
-				os.Remove(tempFile.Name())
+				log.Printf("Failed to organize uploaded file %s: %v", fileHeader.Filename, err)
 				continue
 			}
-			tempFile.Seek(0, 0)
-			scanner := bufio.NewScanner(tempFile)
-			var destDir string
-			var newBaseName string
-			if scanner.Scan() {
-				firstLine := scanner.Text()
-				if strings.HasPrefix(firstLine, "S") && len(firstLine) >= 39 {
-					timestampStr := firstLine[27:39]
-					yy, mm, dd, h, m, s := timestampStr[0:2], timestampStr[2:4], timestampStr[4:6], timestampStr[6:8], timestampStr[8:10], timestampStr[10:12]
-					newBaseName = fmt.Sprintf("20%s%s%s_%s%s%s", yy, mm, dd, h, m, s)
-				}
-			}
-			if newBaseName != "" {
-				destDir = filepath.Join("download", "DAT")
-			} else {
-				destDir = filepath.Join("download", "DAT", "unorganized")
-				newBaseName = time.Now().Format("20060102150405")
-			}
-			os.MkdirAll(destDir, 0755)
-			destPath := filepath.Join(destDir, newBaseName+filepath.Ext(fileHeader.Filename))
-
-			if err := os.Rename(tempFile.Name(), destPath); err != nil {
-				tempFile.Seek(0, 0)
-				destFile, createErr := os.Create(destPath)
-				if createErr != nil {
-					log.Printf("Failed to create destination file for copying: %v", createErr)
-					tempFile.Close()
-					os.Remove(tempFile.Name())
-					continue
-				}
-				_, copyErr := io.Copy(destFile, tempFile)
-				destFile.Close()
-				tempFile.Close()
-				os.Remove(tempFile.Name())
-				if copyErr != nil {
-					log.Printf("Failed to copy temp file to destination: %v", copyErr)
-					os.Remove(destPath)
-					continue
-				}
-			}
-
 			log.Printf("Successfully saved and organized file to: %s", destPath)
 			allFilePaths = append(allFilePaths, destPath)
 		}
 
-		var allProcessedRecords []model.TransactionRecord
-		for _, path := range allFilePaths {
-			processed, err := ProcessDatFile(conn, path)
-			if err != nil {
-				log.Printf("Failed to process DAT file %s: %v", path, err)
-				// 1つのファイルの処理に失敗しても他のファイルの処理は続ける
-				continue
-			}
-			allProcessedRecords = append(allProcessedRecords, processed...)
-		}
-
-		// ▼▼▼【ここから修正】▼▼▼
-		// 処理した納品データを使って発注残を消し込む
-		if len(allProcessedRecords) > 0 {
-			var deliveredItems []model.Backorder
-			for _, rec := range allProcessedRecords {
-				// 納品(flag=1)の取引のみを消し込み対象とする
-				if rec.Flag == 1 {
-					deliveredItems = append(deliveredItems, model.Backorder{
-						YjCode:          rec.YjCode,
-						PackageForm:     rec.PackageForm,
-						JanPackInnerQty: rec.JanPackInnerQty,
-						YjUnitName:      rec.YjUnitName,
-						YjQuantity:      rec.YjQuantity,
-					})
-				}
-			}
-
-			if len(deliveredItems) > 0 {
-				if err := db.ReconcileBackorders(conn, deliveredItems); err != nil {
-					// 消し込みに失敗しても、納品登録自体は完了しているため、エラーログを出力するに留める
-					log.Printf("WARN: Failed to reconcile backorders after DAT import: %v", err)
-					http.Error(w, "納品データの登録には成功しましたが、発注残の自動消し込みに失敗しました。手動で調整してください。: "+err.Error(), http.StatusMultiStatus)
-					return
-				}
-				log.Printf("Successfully reconciled %d backorder items.", len(deliveredItems))
-			}
+		allProcessedRecords, fileResults, err := ImportDatFiles(conn, allFilePaths, strict, audit.RequestUser(r))
+		if err != nil {
+			// 消し込みに失敗しても、納品登録自体は完了しているため、エラーログを出力するに留める
+			log.Printf("WARN: Failed to reconcile backorders after DAT import: %v", err)
+			http.Error(w, "納品データの登録には成功しましたが、発注残の自動消し込みに失敗しました。手動で調整してください。: "+err.Error(), http.StatusMultiStatus)
+			return
 		}
-		// ▲▲▲【修正ここまで】▲▲▲
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message": fmt.Sprintf("Parsed and processed %d DAT files successfully.", len(allFilePaths)),
 			"records": allProcessedRecords,
+			"files":   fileResults,
 		})
 	}
 }
 
-// ProcessDatFile は単一のDATファイルを解析し、内容をデータベースに登録します。
-func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, error) {
-	file, err := os.Open(filePath)
+// stageUploadedFile はアップロードされたファイルを一時ファイルに保存し、そのパスを返します。
+func stageUploadedFile(fileHeader *multipart.FileHeader) (string, error) {
+	file, err := fileHeader.Open()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open organized file %s: %w", filePath, err)
+		return "", fmt.Errorf("failed to open uploaded file: %w", err)
 	}
 	defer file.Close()
 
-	parsed, err := parsers.ParseDat(file)
+	tempFile, err := os.CreateTemp("", "dat-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, file); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to copy to temp file: %w", err)
+	}
+	return tempFile.Name(), nil
+}
+
+// organizeDatFile は一時ファイルをヘッダーの日時に基づいたファイル名で download/DAT 配下へ移動します。
+func organizeDatFile(tempPath, originalName string) (string, error) {
+	tempFile, err := os.Open(tempPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open temp file: %w", err)
+	}
+	scanner := bufio.NewScanner(tempFile)
+	var destDir string
+	var newBaseName string
+	if scanner.Scan() {
+		firstLine := scanner.Text()
+		if strings.HasPrefix(firstLine, "S") && len(firstLine) >= 39 {
+			timestampStr := firstLine[27:39]
+			yy, mm, dd, h, m, s := timestampStr[0:2], timestampStr[2:4], timestampStr[4:6], timestampStr[6:8], timestampStr[8:10], timestampStr[10:12]
+			newBaseName = fmt.Sprintf("20%s%s%s_%s%s%s", yy, mm, dd, h, m, s)
+		}
+	}
+	tempFile.Close()
+
+	if newBaseName != "" {
+		destDir = filepath.Join("download", "DAT")
+	} else {
+		destDir = filepath.Join("download", "DAT", "unorganized")
+		newBaseName = time.Now().Format("20060102150405")
+	}
+	os.MkdirAll(destDir, 0755)
+	destPath := filepath.Join(destDir, newBaseName+filepath.Ext(originalName))
+
+	if err := os.Rename(tempPath, destPath); err != nil {
+		// 別ドライブ間の移動などでRenameできない場合はコピーする
+		src, openErr := os.Open(tempPath)
+		if openErr != nil {
+			os.Remove(tempPath)
+			return "", fmt.Errorf("failed to reopen temp file for copying: %w", openErr)
+		}
+		destFile, createErr := os.Create(destPath)
+		if createErr != nil {
+			src.Close()
+			os.Remove(tempPath)
+			return "", fmt.Errorf("failed to create destination file for copying: %w", createErr)
+		}
+		_, copyErr := io.Copy(destFile, src)
+		destFile.Close()
+		src.Close()
+		os.Remove(tempPath)
+		if copyErr != nil {
+			os.Remove(destPath)
+			return "", fmt.Errorf("failed to copy temp file to destination: %w", copyErr)
+		}
+	}
+	return destPath, nil
+}
+
+// ImportDatFiles は整理済みのDATファイル群を登録し、納品分で発注残を消し込みます。
+// 消し込みはファイル（取込バッチ）ごとに行い、バッチのロールバック時に復元できるよう記録します。
+// ファイルごとの件数・検証結果・エラーは DatFileResult として返します。
+// 返されるエラーは発注残の消し込みに関するもので、取引データの登録は完了しています。
+// user は監査ログに記録する操作者です (自動取込・定期実行では処理の名前)。
+func ImportDatFiles(conn *sql.DB, filePaths []string, strict bool, user string) ([]model.TransactionRecord, []DatFileResult, error) {
+	var allProcessedRecords []model.TransactionRecord
+	fileResults := make([]DatFileResult, 0, len(filePaths))
+	var reconcileErr error
+	for _, path := range filePaths {
+		processed, batchID, diags, err := processDatFile(conn, path, strict, user)
+		result := DatFileResult{FileName: filepath.Base(path), Diagnostics: diags}
+		if err != nil {
+			log.Printf("Failed to process DAT file %s: %v", path, err)
+			// 1つのファイルの処理に失敗しても他のファイルの処理は続ける
+			result.Error = err.Error()
+			fileResults = append(fileResults, result)
+			continue
+		}
+		result.RecordCount = len(processed)
+		fileResults = append(fileResults, result)
+		allProcessedRecords = append(allProcessedRecords, processed...)
+
+		// 処理した納品データを使って発注残を消し込む
+		deliveredItems := deliveredBackorderItems(processed)
+		if len(deliveredItems) > 0 {
+			if err := db.ReconcileBackordersForBatch(conn, batchID, deliveredItems, user); err != nil {
+				reconcileErr = err
+				continue
+			}
+			log.Printf("Successfully reconciled %d backorder items.", len(deliveredItems))
+		}
+	}
+	return allProcessedRecords, fileResults, reconcileErr
+}
+
+// deliveredBackorderItems は取引データのうち納品(flag=1)のものを発注残の消し込み用データに変換します。
+func deliveredBackorderItems(records []model.TransactionRecord) []model.Backorder {
+	var deliveredItems []model.Backorder
+	for _, rec := range records {
+		if rec.Flag == 1 {
+			deliveredItems = append(deliveredItems, model.Backorder{
+				YjCode:                rec.YjCode,
+				PackageForm:           rec.PackageForm,
+				JanPackInnerQty:       rec.JanPackInnerQty,
+				YjUnitName:            rec.YjUnitName,
+				YjQuantity:            rec.YjQuantity,
+				ProductCode:           rec.JanCode,
+				WholesalerCode:        sql.NullString{String: rec.ClientCode, Valid: rec.ClientCode != ""},
+				DeliveryDate:          rec.TransactionDate,
+				DeliveryReceiptNumber: rec.ReceiptNumber,
+				DeliveryLineNumber:    rec.LineNumber,
+			})
+		}
+	}
+	return deliveredItems
+}
+
+// ProcessDatFile は単一のDATファイルを解析し、内容をデータベースに登録します。
+// 登録したレコードはファイルごとの取込バッチに紐付けられ、同一内容のファイルは再登録できません。
+// user は監査ログに記録する操作者です。
+func ProcessDatFile(conn *sql.DB, filePath string, user string) ([]model.TransactionRecord, error) {
+	records, _, diags, err := processDatFile(conn, filePath, false, user)
+	if len(diags) > 0 {
+		log.Printf("DAT file %s has %d validation warnings", filePath, len(diags))
+	}
+	return records, err
+}
+
+// processDatFile は ProcessDatFile の本体で、作成した取込バッチのIDと検証結果も返します。
+func processDatFile(conn *sql.DB, filePath string, strict bool, user string) ([]model.TransactionRecord, int64, []parsers.DatDiagnostic, error) {
+	tx, err := db.BeginAuditTx(conn, user)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	finalRecords, batchID, diags, err := processDatFileInTx(tx, filePath, strict, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+		return nil, 0, diags, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, diags, fmt.Errorf("transaction commit error (final): %w", err)
+	}
+
+	return finalRecords, batchID, diags, nil
+}
+
+// processDatFileInTx はDATファイルの解析から取引データの登録までを、渡されたトランザクション内で行います。
+// strict が true の場合、検証エラー(ERROR)が1件でもあればファイル全体を取り込みません。
+// preview が nil でない場合、各行が新規追加か既存行の置換か、新たに作成されるマスターを記録します。
+func processDatFileInTx(tx *sql.Tx, filePath string, strict bool, preview *DatFilePreview) ([]model.TransactionRecord, int64, []parsers.DatDiagnostic, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to open organized file %s: %w", filePath, err)
+	}
+
+	parsed, diags, err := parsers.ParseDatStrict(bytes.NewReader(content))
+	if err != nil {
+		return nil, 0, diags, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	if preview != nil {
+		preview.Diagnostics = diags
+	}
+	if strict && parsers.HasDatErrors(diags) {
+		return nil, 0, diags, fmt.Errorf("%w: %s", errDatValidation, filepath.Base(filePath))
 	}
 
 	filteredRecords := removeDatDuplicates(parsed)
 	if len(filteredRecords) == 0 {
-		return []model.TransactionRecord{}, nil
+		return []model.TransactionRecord{}, 0, diags, nil
 	}
 
-	tx, err := conn.Begin()
+	batchID, err := db.CreateImportBatchInTx(tx, "DAT", filepath.Base(filePath), fmt.Sprintf("%x", sha256.Sum256(content)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, 0, diags, fmt.Errorf("failed to register import batch for %s: %w", filePath, err)
 	}
-	defer tx.Rollback()
 
 	var keyList, janList []string
 	keySet, janSet := make(map[string]struct{}), make(map[string]struct{})
@@ -190,10 +295,7 @@ func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, e
 				janList = append(janList, rec.JanCode)
 			}
 		}
-		key := rec.JanCode
-		if key == "" || key == "0000000000000" {
-			key = fmt.Sprintf("9999999999999%s", rec.ProductName)
-		}
+		key := masterKey(rec.JanCode, rec.ProductName)
 		if _, seen := keySet[key]; !seen {
 			keySet[key] = struct{}{}
 			keyList = append(keyList, key)
@@ -202,17 +304,17 @@ func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, e
 
 	mastersMap, err := db.GetProductMastersByCodesMap(tx, keyList)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pre-fetch product masters: %w", err)
+		return nil, 0, diags, fmt.Errorf("failed to pre-fetch product masters: %w", err)
 	}
 
 	jcshmsMap, err := db.GetJcshmsByCodesMap(tx, janList)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pre-fetch JCSHMS data: %w", err)
+		return nil, 0, diags, fmt.Errorf("failed to pre-fetch JCSHMS data: %w", err)
 	}
 
 	stmt, err := tx.Prepare(insertTransactionQuery)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		return nil, 0, diags, fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -225,9 +327,19 @@ func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, e
 			ExpiryDate: rec.ExpiryDate, LotNumber: rec.LotNumber,
 		}
 
+		_, masterKnown := mastersMap[masterKey(rec.JanCode, rec.ProductName)]
 		master, err := mastermanager.FindOrCreate(tx, rec.JanCode, rec.ProductName, mastersMap, jcshmsMap)
 		if err != nil {
-			return nil, fmt.Errorf("mastermanager failed for jan %s: %w", rec.JanCode, err)
+			return nil, 0, diags, fmt.Errorf("mastermanager failed for jan %s: %w", rec.JanCode, err)
+		}
+		if preview != nil && !masterKnown {
+			// 事前取得したマップに無かったマスターは、FindOrCreateで新規作成されたもの
+			preview.NewMasters = append(preview.NewMasters, DatPreviewMaster{
+				ProductCode: master.ProductCode,
+				YjCode:      master.YjCode,
+				ProductName: master.ProductName,
+				Origin:      master.Origin,
+			})
 		}
 
 		if master.YjPackUnitQty > 0 {
@@ -254,6 +366,18 @@ func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, e
 		mappers.MapProductMasterToTransaction(&ar, master)
 		ar.ProcessFlagMA = "COMPLETE"
 
+		var replacedIDs []int
+		if ar.ReceiptNumber != "" {
+			// 一意インデックス(日付・得意先・伝票番号・行番号)が一致する行は INSERT OR REPLACE で置き換えられるため、
+			// バッチのロールバックで復元できるよう退避しておく
+			replacedIDs, err = db.SnapshotDisplacedRecordsInTx(tx, batchID,
+				"transaction_date = ? AND client_code = ? AND receipt_number = ? AND line_number = ?",
+				ar.TransactionDate, ar.ClientCode, ar.ReceiptNumber, ar.LineNumber)
+			if err != nil {
+				return nil, 0, diags, fmt.Errorf("failed to check existing record for receipt %s: %w", ar.ReceiptNumber, err)
+			}
+		}
+
 		_, err = stmt.Exec(
 			ar.TransactionDate, ar.ClientCode, ar.ReceiptNumber, ar.LineNumber, ar.Flag,
 			ar.JanCode, ar.YjCode, ar.ProductName, ar.KanaName, ar.UsageClassification, ar.PackageForm, ar.PackageSpec, ar.MakerName,
@@ -261,19 +385,32 @@ func ProcessDatFile(conn *sql.DB, filePath string) ([]model.TransactionRecord, e
 			ar.YjQuantity, ar.YjPackUnitQty, ar.YjUnitName, ar.UnitPrice, ar.PurchasePrice, ar.SupplierWholesale,
 			ar.Subtotal, ar.TaxAmount, ar.TaxRate, ar.ExpiryDate, ar.LotNumber, ar.FlagPoison,
 			ar.FlagDeleterious, ar.FlagNarcotic, ar.FlagPsychotropic, ar.FlagStimulant,
-			ar.FlagStimulantRaw, ar.ProcessFlagMA,
+			ar.FlagStimulantRaw, ar.ProcessFlagMA, batchID,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to insert record for JAN %s: %w", ar.JanCode, err)
+			return nil, 0, diags, fmt.Errorf("failed to insert record for JAN %s: %w", ar.JanCode, err)
 		}
 		finalRecords = append(finalRecords, ar)
+
+		if preview != nil {
+			line := DatPreviewLine{Action: "INSERT", Record: ar}
+			if len(replacedIDs) > 0 {
+				line.Action = "REPLACE"
+				line.ReplacedID = replacedIDs[0]
+			}
+			preview.Lines = append(preview.Lines, line)
+		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("transaction commit error (final): %w", err)
+	if err := db.RefreshStockBalancesInTx(tx); err != nil {
+		return nil, 0, diags, err
 	}
 
-	return finalRecords, nil
+	if err := db.FinalizeImportBatchInTx(tx, batchID); err != nil {
+		return nil, 0, diags, err
+	}
+
+	return finalRecords, batchID, diags, nil
 }
 
 // removeDatDuplicates はDATレコードから重複を除外します。
@@ -290,3 +427,11 @@ func removeDatDuplicates(records []model.UnifiedInputRecord) []model.UnifiedInpu
 	}
 	return result
 }
+
+// masterKey は mastermanager.FindOrCreate と同じ規則で製品マスターの検索キーを返します。
+func masterKey(janCode, productName string) string {
+	if janCode == "" || janCode == "0000000000000" {
+		return fmt.Sprintf("9999999999999%s", productName)
+	}
+	return janCode
+}
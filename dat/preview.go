@@ -0,0 +1,255 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\dat\preview.go
+
+package dat
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"wasabi/audit"
+	"wasabi/db"
+	"wasabi/model"
+	"wasabi/parsers"
+)
+
+// previewTTL はプレビュー結果（一時保存したファイル）を確定待ちで保持する時間です。
+const previewTTL = 1 * time.Hour
+
+// DatPreviewLine はプレビューにおける取引データ1行分の結果です。
+type DatPreviewLine struct {
+	Action     string                  `json:"action"` // "INSERT" または "REPLACE"
+	ReplacedID int                     `json:"replacedId,omitempty"`
+	Record     model.TransactionRecord `json:"record"`
+}
+
+// DatPreviewMaster は取込によって新規作成される製品マスターです。
+type DatPreviewMaster struct {
+	ProductCode string `json:"productCode"`
+	YjCode      string `json:"yjCode"`
+	ProductName string `json:"productName"`
+	Origin      string `json:"origin"` // "JCSHMS" または "PROVISIONAL"
+}
+
+// DatFilePreview はファイル単位のプレビュー結果です。
+type DatFilePreview struct {
+	FileName    string                  `json:"fileName"`
+	Lines       []DatPreviewLine        `json:"lines"`
+	NewMasters  []DatPreviewMaster      `json:"newMasters"`
+	Diagnostics []parsers.DatDiagnostic `json:"diagnostics"`
+	Error       string                  `json:"error,omitempty"`
+}
+
+// DatPreviewReport はDATインポートのプレビュー結果全体です。
+type DatPreviewReport struct {
+	PreviewID          string                          `json:"previewId"`
+	Files              []DatFilePreview                `json:"files"`
+	InsertCount        int                             `json:"insertCount"`
+	ReplaceCount       int                             `json:"replaceCount"`
+	ConsumedBackorders []model.BackorderReconciliation `json:"consumedBackorders"`
+}
+
+// previewSession は確定待ちのプレビューで一時保存したファイルを保持します。
+type previewSession struct {
+	files     []stagedFile
+	strict    bool
+	createdAt time.Time
+}
+
+type stagedFile struct {
+	tempPath     string
+	originalName string
+}
+
+var (
+	previewMu       sync.Mutex
+	previewSessions = make(map[string]*previewSession)
+)
+
+// PreviewDatHandler はアップロードされたDATファイルを、ロールバックするトランザクション内で
+// 本番と同じ処理にかけ、登録・置換・マスター作成・発注残消し込みの予定を返します。
+// ファイルは一時保存され、ConfirmDatHandler に previewId を渡すことで実際に取り込まれます。
+// フォーム値 strict=true の場合、確定時も厳格モードで取り込みます。
+func PreviewDatHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "File upload error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		strict := r.FormValue("strict") == "true"
+		cleanupExpiredPreviews()
+
+		var staged []stagedFile
+		for _, fileHeader := range r.MultipartForm.File["file"] {
+			tempPath, err := stageUploadedFile(fileHeader)
+			if err != nil {
+				log.Printf("Failed to stage uploaded file %s: %v", fileHeader.Filename, err)
+				continue
+			}
+			staged = append(staged, stagedFile{tempPath: tempPath, originalName: fileHeader.Filename})
+		}
+		if len(staged) == 0 {
+			http.Error(w, "No files were uploaded.", http.StatusBadRequest)
+			return
+		}
+
+		report, err := buildDatPreview(conn, staged, strict, audit.RequestUser(r))
+		if err != nil {
+			removeStagedFiles(staged)
+			http.Error(w, "Failed to build DAT import preview: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		previewID, err := newPreviewID()
+		if err != nil {
+			removeStagedFiles(staged)
+			http.Error(w, "Failed to create preview id: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.PreviewID = previewID
+
+		previewMu.Lock()
+		previewSessions[previewID] = &previewSession{files: staged, strict: strict, createdAt: time.Now()}
+		previewMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// ConfirmDatHandler はプレビュー済みのDATファイルを実際に取り込みます。
+// リクエストボディ: {"previewId": "..."}
+func ConfirmDatHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PreviewID string `json:"previewId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		previewMu.Lock()
+		session, ok := previewSessions[req.PreviewID]
+		delete(previewSessions, req.PreviewID)
+		previewMu.Unlock()
+		if !ok {
+			http.Error(w, "指定されたプレビューが見つかりません。期限切れの可能性があるため、再度プレビューしてください。", http.StatusNotFound)
+			return
+		}
+
+		var allFilePaths []string
+		for _, f := range session.files {
+			destPath, err := organizeDatFile(f.tempPath, f.originalName)
+			if err != nil {
+				log.Printf("Failed to organize previewed file %s: %v", f.originalName, err)
+				continue
+			}
+			log.Printf("Successfully saved and organized file to: %s", destPath)
+			allFilePaths = append(allFilePaths, destPath)
+		}
+
+		allProcessedRecords, fileResults, err := ImportDatFiles(conn, allFilePaths, session.strict, audit.RequestUser(r))
+		if err != nil {
+			log.Printf("WARN: Failed to reconcile backorders after DAT import: %v", err)
+			http.Error(w, "納品データの登録には成功しましたが、発注残の自動消し込みに失敗しました。手動で調整してください。: "+err.Error(), http.StatusMultiStatus)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": fmt.Sprintf("Parsed and processed %d DAT files successfully.", len(allFilePaths)),
+			"records": allProcessedRecords,
+			"files":   fileResults,
+		})
+	}
+}
+
+// buildDatPreview は全ファイルを1つのトランザクションで処理し、結果を記録した後にロールバックします。
+// 複数ファイル間で同じ伝票行を置き換える場合も、本番の取込と同じ結果になります。
+func buildDatPreview(conn *sql.DB, files []stagedFile, strict bool, user string) (*DatPreviewReport, error) {
+	tx, err := db.BeginAuditTx(conn, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// プレビューでは決してコミットしない
+	defer tx.Rollback()
+
+	report := &DatPreviewReport{}
+	var allProcessedRecords []model.TransactionRecord
+	for _, f := range files {
+		filePreview := DatFilePreview{FileName: f.originalName}
+		// 本番ではファイルごとにトランザクションが分かれるため、セーブポイントで失敗したファイルの変更だけを取り消す
+		if _, err := tx.Exec("SAVEPOINT dat_preview_file"); err != nil {
+			return nil, fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		processed, _, _, err := processDatFileInTx(tx, f.tempPath, strict, &filePreview)
+		if err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO dat_preview_file"); rbErr != nil {
+				return nil, fmt.Errorf("failed to rollback to savepoint: %w", rbErr)
+			}
+			filePreview.Error = err.Error()
+			filePreview.Lines = nil
+			filePreview.NewMasters = nil
+			// 検証結果は失敗したファイルでも確認できるよう残す
+		} else {
+			allProcessedRecords = append(allProcessedRecords, processed...)
+		}
+		if _, err := tx.Exec("RELEASE dat_preview_file"); err != nil {
+			return nil, fmt.Errorf("failed to release savepoint: %w", err)
+		}
+		for _, line := range filePreview.Lines {
+			if line.Action == "REPLACE" {
+				report.ReplaceCount++
+			} else {
+				report.InsertCount++
+			}
+		}
+		report.Files = append(report.Files, filePreview)
+	}
+
+	deliveredItems := deliveredBackorderItems(allProcessedRecords)
+	if len(deliveredItems) > 0 {
+		consumed, err := db.ReconcileBackordersInTx(tx, deliveredItems)
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate backorder reconciliation: %w", err)
+		}
+		report.ConsumedBackorders = consumed
+	}
+
+	return report, nil
+}
+
+// cleanupExpiredPreviews は確定されずに期限切れとなったプレビューの一時ファイルを削除します。
+func cleanupExpiredPreviews() {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	for id, session := range previewSessions {
+		if time.Since(session.createdAt) > previewTTL {
+			removeStagedFiles(session.files)
+			delete(previewSessions, id)
+		}
+	}
+}
+
+func removeStagedFiles(files []stagedFile) {
+	for _, f := range files {
+		os.Remove(f.tempPath)
+	}
+}
+
+func newPreviewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
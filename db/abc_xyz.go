@@ -0,0 +1,316 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\abc_xyz.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"wasabi/model"
+	"wasabi/units"
+)
+
+// XYZ分類の変動係数の境界 (0.5以下をX、1.0以下をY、それを超える品目と処方の無い品目をZとします)。
+const (
+	xyzClassXLimit = 0.5
+	xyzClassYLimit = 1.0
+)
+
+// abcXyzCellOrder はマトリクスの並び順です。
+var abcXyzCellOrder = []string{"AX", "AY", "AZ", "BX", "BY", "BZ", "CX", "CY", "CZ"}
+
+// PackageKey は在庫台帳と同じ包装キー (YJコード|包装形態|内包装数量|YJ単位) を返します。
+func PackageKey(m *model.ProductMaster) string {
+	return fmt.Sprintf("%s|%s|%g|%s", m.YjCode, m.PackageForm, m.JanPackInnerQty, m.YjUnitName)
+}
+
+// xyzClassOf は週ごとの処方数量の変動係数からXYZ分類を返します。
+func xyzClassOf(mean, cv float64) string {
+	switch {
+	case mean <= 0:
+		return "Z"
+	case cv <= xyzClassXLimit:
+		return "X"
+	case cv <= xyzClassYLimit:
+		return "Y"
+	}
+	return "Z"
+}
+
+// abcXyzGroup は分析の集計中の品目です。
+type abcXyzGroup struct {
+	item   model.AbcXyzItem
+	weekly []float64
+	rep    *model.ProductMaster
+}
+
+func (g *abcXyzGroup) add(m *model.ProductMaster, weekly []float64, quantity float64) {
+	// 製品名・包装はJCSHMS由来のマスターを優先する
+	if g.rep == nil || (g.rep.Origin != "JCSHMS" && m.Origin == "JCSHMS") {
+		g.rep = m
+	}
+	g.item.ProductCodes = append(g.item.ProductCodes, m.ProductCode)
+	g.item.UsageQuantity += quantity
+	g.item.UsageValue += quantity * m.NhiPrice
+	for i, q := range weekly {
+		g.weekly[i] += q
+	}
+}
+
+// classifyAbcXyz は品目をABC・XYZに分類し、マトリクスを集計します。
+func classifyAbcXyz(groups map[string]*abcXyzGroup, withPackageSpec bool) model.AbcXyzResult {
+	result := model.AbcXyzResult{Items: make([]model.AbcXyzItem, 0, len(groups))}
+	for _, g := range groups {
+		item := g.item
+		item.YjCode = g.rep.YjCode
+		item.ProductName = g.rep.ProductName
+		item.YjUnitName = g.rep.YjUnitName
+		if withPackageSpec {
+			tempJcshms := model.JCShms{
+				JC037: g.rep.PackageForm, JC039: g.rep.YjUnitName, JC044: g.rep.YjPackUnitQty,
+				JA006: sql.NullFloat64{Float64: g.rep.JanPackInnerQty, Valid: true},
+				JA008: sql.NullFloat64{Float64: g.rep.JanPackUnitQty, Valid: true},
+				JA007: sql.NullString{String: fmt.Sprintf("%d", g.rep.JanUnitCode), Valid: true},
+			}
+			item.PackageSpec = units.FormatSimplePackageSpec(&tempJcshms)
+		}
+
+		var sum, sumSq float64
+		for _, q := range g.weekly {
+			sum += q
+		}
+		if n := float64(len(g.weekly)); n > 0 {
+			item.WeeklyMean = sum / n
+			for _, q := range g.weekly {
+				sumSq += (q - item.WeeklyMean) * (q - item.WeeklyMean)
+			}
+			item.WeeklyStdDev = math.Sqrt(sumSq / n)
+		}
+		if item.WeeklyMean > 0 {
+			item.CV = item.WeeklyStdDev / item.WeeklyMean
+		}
+		item.XyzClass = xyzClassOf(item.WeeklyMean, item.CV)
+		result.TotalValue += item.UsageValue
+		result.Items = append(result.Items, item)
+	}
+
+	sort.Slice(result.Items, func(i, j int) bool {
+		if result.Items[i].UsageValue != result.Items[j].UsageValue {
+			return result.Items[i].UsageValue > result.Items[j].UsageValue
+		}
+		return result.Items[i].Key < result.Items[j].Key
+	})
+
+	cells := make(map[string]*model.AbcXyzCell, len(abcXyzCellOrder))
+	for _, key := range abcXyzCellOrder {
+		cells[key] = &model.AbcXyzCell{AbcClass: key[:1], XyzClass: key[1:]}
+	}
+	var cumulative float64
+	for i := range result.Items {
+		item := &result.Items[i]
+		item.AbcClass = abcClassOf(cumulative, item.UsageValue, result.TotalValue)
+		cumulative += item.UsageValue
+		if result.TotalValue > 0 {
+			item.CumulativeShare = cumulative / result.TotalValue
+		}
+		cell := cells[item.AbcClass+item.XyzClass]
+		cell.Count++
+		cell.UsageValue += item.UsageValue
+	}
+	for _, key := range abcXyzCellOrder {
+		cell := cells[key]
+		if result.TotalValue > 0 {
+			cell.ValueShare = cell.UsageValue / result.TotalValue
+		}
+		result.Matrix = append(result.Matrix, *cell)
+	}
+	return result
+}
+
+/**
+ * @brief 処方金額 (ABC) と週ごとの処方数量の変動 (XYZ) で、YJコードと包装を分類します。
+ * @param conn データベース接続
+ * @param filters 分析期間
+ * @return *model.AbcXyzAnalysis YJコード単位と包装単位の分類結果とマトリクス
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 期間に処方がある製品と、現在庫がある製品を対象とします。処方金額は処方数量 (YJ単位) に薬価を掛けた金額です。
+ * ABCは期間全体の処方金額の累積構成比、XYZは終了日から遡った7日ごとの処方数量の変動係数 (標準偏差 / 平均) で分類します。
+ * 7日に満たない期間の初めの端数日は、処方金額には含めますが変動係数の計算には含めません。
+ */
+func GetAbcXyzAnalysis(conn *sql.DB, filters model.AbcXyzFilters) (*model.AbcXyzAnalysis, error) {
+	start, err := time.Parse("20060102", filters.StartDate)
+	if err != nil {
+		return nil, fmt.Errorf("開始日が不正です: %s", filters.StartDate)
+	}
+	end, err := time.Parse("20060102", filters.EndDate)
+	if err != nil {
+		return nil, fmt.Errorf("終了日が不正です: %s", filters.EndDate)
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	weeks := days / 7
+	if weeks < 1 {
+		return nil, fmt.Errorf("分析期間は7日以上を指定してください")
+	}
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return nil, err
+	}
+
+	// 製品ごとの週別の処方数量 (週0 が終了日を含む週)
+	rows, err := conn.Query(`
+		SELECT jan_code, transaction_date, SUM(yj_quantity) FROM transaction_records
+		WHERE flag = 3 AND transaction_date BETWEEN ? AND ? AND jan_code != ''
+		GROUP BY jan_code, transaction_date`, filters.StartDate, filters.EndDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage for ABC/XYZ analysis: %w", err)
+	}
+	weeklyByProduct := make(map[string][]float64)
+	totalByProduct := make(map[string]float64)
+	for rows.Next() {
+		var code, date string
+		var quantity float64
+		if err := rows.Scan(&code, &date, &quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		totalByProduct[code] += quantity
+		d, err := time.Parse("20060102", date)
+		if err != nil {
+			continue
+		}
+		if week := int(end.Sub(d).Hours()/24) / 7; week < weeks {
+			if weeklyByProduct[code] == nil {
+				weeklyByProduct[code] = make([]float64, weeks)
+			}
+			weeklyByProduct[code][week] += quantity
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	q := `SELECT ` + SelectColumns + ` FROM product_master
+		WHERE product_code IN (SELECT product_code FROM stock_balances WHERE ABS(base_quantity + net_change) > 1e-9)`
+	var args []interface{}
+	if len(totalByProduct) > 0 {
+		q += ` OR product_code IN (?` + strings.Repeat(",?", len(totalByProduct)-1) + `)`
+		for code := range totalByProduct {
+			args = append(args, code)
+		}
+	}
+	masters, err := getAllProductMastersFiltered(conn, q+` ORDER BY product_code`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	yjGroups := make(map[string]*abcXyzGroup)
+	packageGroups := make(map[string]*abcXyzGroup)
+	for _, m := range masters {
+		if m.YjCode == "" {
+			continue
+		}
+		weekly := weeklyByProduct[m.ProductCode]
+		if weekly == nil {
+			weekly = make([]float64, weeks)
+		}
+		targets := []struct {
+			groups map[string]*abcXyzGroup
+			key    string
+		}{{yjGroups, m.YjCode}, {packageGroups, PackageKey(m)}}
+		for _, t := range targets {
+			g, ok := t.groups[t.key]
+			if !ok {
+				g = &abcXyzGroup{item: model.AbcXyzItem{Key: t.key}, weekly: make([]float64, weeks)}
+				t.groups[t.key] = g
+			}
+			g.add(m, weekly, totalByProduct[m.ProductCode])
+		}
+	}
+
+	return &model.AbcXyzAnalysis{
+		StartDate: filters.StartDate,
+		EndDate:   filters.EndDate,
+		Weeks:     weeks,
+		YjGroups:  classifyAbcXyz(yjGroups, false),
+		Packages:  classifyAbcXyz(packageGroups, true),
+	}, nil
+}
+
+/**
+ * @brief ABC/XYZ分析の包装単位の分類を、各製品の分類として product_abc_xyz_classifications に保存します。
+ * @param conn データベース接続
+ * @param analysis GetAbcXyzAnalysis の結果
+ * @return int 保存した製品数
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 同じ包装の製品には同じ分類・処方金額・変動係数を保存します。分析の対象外になった製品の分類は削除します。
+ * 循環棚卸の分類 (product_classifications) は ClassifyProductsABC が管理するため、ここでは変更しません。
+ */
+func SaveAbcXyzClassifications(conn *sql.DB, analysis *model.AbcXyzAnalysis) (int, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO product_abc_xyz_classifications
+			(product_code, abc_class, xyz_class, usage_value, demand_cv, start_date, end_date, classified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(product_code) DO UPDATE SET
+			abc_class = excluded.abc_class, xyz_class = excluded.xyz_class, usage_value = excluded.usage_value,
+			demand_cv = excluded.demand_cv, start_date = excluded.start_date, end_date = excluded.end_date,
+			classified_at = excluded.classified_at`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	classifiedAt := time.Now().Format("2006-01-02 15:04:05")
+	count := 0
+	for _, item := range analysis.Packages.Items {
+		for _, code := range item.ProductCodes {
+			if _, err := stmt.Exec(code, item.AbcClass, item.XyzClass, item.UsageValue, item.CV,
+				analysis.StartDate, analysis.EndDate, classifiedAt); err != nil {
+				return 0, fmt.Errorf("failed to save product classification for %s: %w", code, err)
+			}
+			count++
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM product_abc_xyz_classifications WHERE classified_at != ?`, classifiedAt); err != nil {
+		return 0, fmt.Errorf("failed to delete stale product classifications: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+/**
+ * @brief ABC/XYZ分析で保存した製品ごとの分類を取得します。
+ * @param conn データベース接続
+ * @return map[string]string 製品コードをキーとする分類 (例: "AX")
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 補充条件の分類ごとの条件 (scope = 'class') の適用と、製品マスター編集画面の表示に使用します。
+ */
+func GetAbcXyzClassMap(conn *sql.DB) (map[string]string, error) {
+	rows, err := conn.Query(`SELECT product_code, abc_class || xyz_class FROM product_abc_xyz_classifications`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved ABC/XYZ classifications: %w", err)
+	}
+	defer rows.Close()
+
+	classes := make(map[string]string)
+	for rows.Next() {
+		var code, class string
+		if err := rows.Scan(&code, &class); err != nil {
+			return nil, err
+		}
+		classes[code] = class
+	}
+	return classes, rows.Err()
+}
@@ -6,10 +6,24 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
+	"wasabi/forecast"
 	"wasabi/model"
 	"wasabi/units"
 )
 
+// drugTypeConditions は薬品区分 (AggregationFilters.DrugTypes の値) ごとの製品マスター (別名 p) の条件です。
+var drugTypeConditions = map[string]string{
+	"poison":        "p.flag_poison = 1",
+	"deleterious":   "p.flag_deleterious = 1",
+	"narcotic":      "p.flag_narcotic = 1",
+	"psychotropic1": "p.flag_psychotropic = 1",
+	"psychotropic2": "p.flag_psychotropic = 2",
+	"psychotropic3": "p.flag_psychotropic = 3",
+	"stimulant":     "p.flag_stimulant = 1",
+	"stimulant_raw": "p.flag_stimulant_raw = 1",
+}
+
 // ▼▼▼【ここから修正】▼▼▼
 
 /**
@@ -29,6 +43,7 @@ import (
  * - 最新棚卸日に記録がない包装は、その時点での在庫を0とみなします。
  * 6. 期間内の取引を時系列で処理し、「期間内変動」「最大使用量」「期間終了在庫」を算出します。
  * 7. 発注残と予製引当数を考慮し、「有効在庫」と「発注点」を計算します。
+ * - 発注点は filters.Forecast の方法で期間内の日ごとの処方数量から需要を予測し、リードタイム需要 + 安全在庫 とします。
  * 8. 全ての包装グループのデータをYJコードごとに集計し、最終的なレポートを生成します。
  * 9. 結果を剤型とカナ名でソートして返却します。
  */
@@ -63,18 +78,8 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 	}
 	if len(filters.DrugTypes) > 0 && filters.DrugTypes[0] != "" {
 		var conditions []string
-		flagMap := map[string]string{
-			"poison":        "p.flag_poison = 1",
-			"deleterious":   "p.flag_deleterious = 1",
-			"narcotic":      "p.flag_narcotic = 1",
-			"psychotropic1": "p.flag_psychotropic = 1",
-			"psychotropic2": "p.flag_psychotropic = 2",
-			"psychotropic3": "p.flag_psychotropic = 3",
-			"stimulant":     "p.flag_stimulant = 1",
-			"stimulant_raw": "p.flag_stimulant_raw = 1",
-		}
 		for _, dt := range filters.DrugTypes {
-			if cond, ok := flagMap[dt]; ok {
+			if cond, ok := drugTypeConditions[dt]; ok {
 				conditions = append(conditions, cond)
 			}
 		}
@@ -126,6 +131,9 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 		return nil, fmt.Errorf("failed to get all transactions for product codes: %w", err)
 	}
 
+	// 需要予測の履歴は期間の初日から前日 (終了日が前日より前の場合は終了日) までとする
+	historyStart, historyDays := forecastHistoryRange(filters.StartDate, filters.EndDate, time.Now())
+
 	// ステップ4: YJコードごとに集計処理
 	var result []model.StockLedgerYJGroup
 	for _, yjCode := range yjCodes {
@@ -178,7 +186,7 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 		// 包装キーでマスターをグループ化
 		mastersByPackageKey := make(map[string][]*model.ProductMaster)
 		for _, m := range mastersInYjGroup {
-			key := fmt.Sprintf("%s|%s|%g|%s", m.YjCode, m.PackageForm, m.JanPackInnerQty, m.YjUnitName)
+			key := PackageKey(m)
 			mastersByPackageKey[key] = append(mastersByPackageKey[key], m)
 		}
 
@@ -222,6 +230,7 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 			var transactionsInPeriod []model.LedgerTransaction
 			var netChange, maxUsage float64
 			runningBalance := startingBalance
+			usageByDate := make(map[string]float64)
 
 			var txsForPackageInPeriod []*model.TransactionRecord
 			for _, m := range mastersInPackageGroup {
@@ -262,8 +271,11 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 				}
 				transactionsInPeriod = append(transactionsInPeriod, model.LedgerTransaction{TransactionRecord: *t, RunningBalance: runningBalance})
 				netChange += t.SignedYjQty()
-				if t.Flag == 3 && t.YjQuantity > maxUsage {
-					maxUsage = t.YjQuantity
+				if t.Flag == 3 {
+					usageByDate[t.TransactionDate] += t.YjQuantity
+					if t.YjQuantity > maxUsage {
+						maxUsage = t.YjQuantity
+					}
 				}
 				lastProcessedDate = t.TransactionDate
 			}
@@ -295,7 +307,13 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 				}
 			}
 
-			pkg.BaseReorderPoint = maxUsage * filters.Coefficient
+			forecastParams := filters.Forecast
+			if forecastParams.Method == "" {
+				forecastParams = model.ForecastParams{Method: forecast.MethodMaxUsage, Coefficient: filters.Coefficient}
+			}
+			baseReorderPoint, forecastResult := forecast.ReorderPoint(forecastParams, dailyUsageHistory(usageByDate, historyStart, historyDays), historyStart, maxUsage)
+			pkg.BaseReorderPoint = baseReorderPoint
+			pkg.Forecast = &forecastResult
 			pkg.PrecompoundedTotal = precompTotalForPackage
 			pkg.ReorderPoint = pkg.BaseReorderPoint + pkg.PrecompoundedTotal
 			pkg.IsReorderNeeded = effectiveEndingBalance < pkg.ReorderPoint && pkg.MaxUsage > 0
@@ -382,6 +400,38 @@ func GetStockLedger(conn *sql.DB, filters model.AggregationFilters) ([]model.Sto
 }
 
 // ヘルパー関数群
+
+// forecastHistoryRange は需要予測に使う履歴の初日と日数を返します。
+// 当日の処方はまだ揃っていないため、終了日が今日以降 (99991231 など) の場合は前日までとします。
+func forecastHistoryRange(startDate, endDate string, now time.Time) (time.Time, int) {
+	start, err := time.Parse("20060102", startDate)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	end, _ := time.Parse("20060102", now.AddDate(0, 0, -1).Format("20060102"))
+	if e, err := time.Parse("20060102", endDate); err == nil && e.Before(end) {
+		end = e
+	}
+	if end.Before(start) {
+		return start, 0
+	}
+	return start, int(end.Sub(start).Hours()/24) + 1
+}
+
+// dailyUsageHistory は日付ごとの処方数量を、start から days 日分の日ごとの配列にします。
+func dailyUsageHistory(usageByDate map[string]float64, start time.Time, days int) []float64 {
+	history := make([]float64, days)
+	for date, quantity := range usageByDate {
+		d, err := time.Parse("20060102", date)
+		if err != nil {
+			continue
+		}
+		if i := int(d.Sub(start).Hours() / 24); i >= 0 && i < days {
+			history[i] += quantity
+		}
+	}
+	return history
+}
 func getAllProductCodesForYjCodes(conn *sql.DB, yjCodes []string) ([]string, error) {
 	if len(yjCodes) == 0 {
 		return []string{}, nil
@@ -0,0 +1,229 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\audit_log.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"wasabi/model"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName は監査ログ用の関数を登録した SQLite ドライバの名前です。
+// audit_log に書き込むトリガーが audit_user() を呼び出すため、アプリケーションは
+// "sqlite3" ではなくこのドライバでデータベースを開く必要があります。
+const DriverName = "sqlite3_wasabi"
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{ConnectHook: registerAuditFunctions})
+}
+
+// registerAuditFunctions は接続ごとに操作者を保持する関数を登録します。
+// 操作者はトランザクションの開始時に set_audit_user() で設定し、
+// コミット・ロールバック時に消去するため、次のトランザクションに引き継がれることはありません。
+// 操作者が設定されていない書き込み (自動取込・ジョブなど) は 'system' として記録されます。
+func registerAuditFunctions(conn *sqlite3.SQLiteConn) error {
+	var user string
+	if err := conn.RegisterFunc("audit_user", func() string { return user }, false); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("set_audit_user", func(name string) string {
+		user = name
+		return name
+	}, false); err != nil {
+		return err
+	}
+	conn.RegisterCommitHook(func() int {
+		user = ""
+		return 0
+	})
+	conn.RegisterRollbackHook(func() { user = "" })
+	return nil
+}
+
+// auditedTables は変更を audit_log に記録するテーブルと、その主キーです。
+var auditedTables = []struct{ table, key string }{
+	{"product_master", "product_code"},
+	{"transaction_records", "id"},
+	{"backorders", "id"},
+	{"precomp_records", "id"},
+	{"dead_stock_list", "id"},
+	{"replenishment_policies", "policy_key"},
+}
+
+/**
+ * @brief トランザクションを開始し、その中の変更を記録する操作者を設定します。
+ * @param conn データベース接続
+ * @param user 操作者名 (空の場合は 'system' として記録されます)
+ * @return *sql.Tx 開始したトランザクション
+ * @return error 処理中にエラーが発生した場合
+ */
+func BeginAuditTx(conn *sql.DB, user string) (*sql.Tx, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := SetAuditUserInTx(tx, user); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// SetAuditUserInTx はトランザクション内の変更を記録する操作者を設定します。設定はコミット・ロールバックまで有効です。
+func SetAuditUserInTx(tx *sql.Tx, user string) error {
+	var set string
+	if err := tx.QueryRow(`SELECT set_audit_user(?)`, strings.TrimSpace(user)).Scan(&set); err != nil {
+		return fmt.Errorf("failed to set audit user: %w", err)
+	}
+	return nil
+}
+
+/**
+ * @brief 監査対象のテーブルに、変更を audit_log に記録するトリガーを作成します。
+ * @param conn データベース接続
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 変更前後の行は全ての列を json_object で記録します。列の追加に追従できるよう、
+ * 起動時に PRAGMA table_info から列を取得してトリガーを作り直します。
+ * 値が変わらない UPDATE (同じ内容での上書き) は記録しません。
+ */
+func ensureAuditTriggers(conn *sql.DB) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range auditedTables {
+		columns, err := tableColumns(tx, t.table)
+		if err != nil {
+			return err
+		}
+		oldRow, newRow := auditJSONObject("OLD", columns), auditJSONObject("NEW", columns)
+		const insertLog = `INSERT INTO audit_log (entity, entity_key, action, before_json, after_json, user_name)
+  VALUES ('%s', %s, '%s', %s, %s, COALESCE(NULLIF(audit_user(), ''), 'system'));`
+		triggers := map[string]string{
+			"insert": fmt.Sprintf("AFTER INSERT ON %s\nBEGIN\n  "+insertLog+"\nEND;",
+				t.table, t.table, "NEW."+t.key, "INSERT", "NULL", newRow),
+			"update": fmt.Sprintf("AFTER UPDATE ON %s\nWHEN %s IS NOT %s\nBEGIN\n  "+insertLog+"\nEND;",
+				t.table, oldRow, newRow, t.table, "NEW."+t.key, "UPDATE", oldRow, newRow),
+			"delete": fmt.Sprintf("AFTER DELETE ON %s\nBEGIN\n  "+insertLog+"\nEND;",
+				t.table, t.table, "OLD."+t.key, "DELETE", oldRow, "NULL"),
+		}
+		for action, body := range triggers {
+			name := fmt.Sprintf("trg_audit_%s_%s", t.table, action)
+			if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+			}
+			if _, err := tx.Exec(`CREATE TRIGGER ` + name + "\n" + body); err != nil {
+				return fmt.Errorf("failed to create trigger %s: %w", name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// tableColumns はテーブルの列名を定義順に返します。
+func tableColumns(dbtx DBTX, table string) ([]string, error) {
+	rows, err := dbtx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table info for %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to scan table info for %s: %w", table, err)
+		}
+		columns = append(columns, name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+	return columns, rows.Err()
+}
+
+// auditJSONObject は行の全ての列を json_object で表す式を作成します (prefix は OLD または NEW)。
+func auditJSONObject(prefix string, columns []string) string {
+	args := make([]string, 0, len(columns))
+	for _, c := range columns {
+		args = append(args, fmt.Sprintf("'%s', %s.%s", c, prefix, c))
+	}
+	return "json_object(" + strings.Join(args, ", ") + ")"
+}
+
+/**
+ * @brief 条件に一致する監査ログを新しい順に取得します。
+ * @param conn データベース接続
+ * @param filters 検索条件 (空の項目は条件に含めません)
+ * @return []model.AuditLogEntry 監査ログ
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 件数の上限は filters.Limit で指定します (0 以下の場合は 1000 件)。
+ */
+func GetAuditLogs(conn *sql.DB, filters model.AuditLogFilters) ([]model.AuditLogEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filters.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filters.Entity)
+	}
+	if filters.EntityKey != "" {
+		conditions = append(conditions, "entity_key = ?")
+		args = append(args, filters.EntityKey)
+	}
+	if filters.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, strings.ToUpper(filters.Action))
+	}
+	if filters.UserName != "" {
+		conditions = append(conditions, "user_name LIKE ?")
+		args = append(args, "%"+filters.UserName+"%")
+	}
+	if filters.Keyword != "" {
+		conditions = append(conditions, "(COALESCE(before_json, '') LIKE ? OR COALESCE(after_json, '') LIKE ?)")
+		args = append(args, "%"+filters.Keyword+"%", "%"+filters.Keyword+"%")
+	}
+	if filters.DateFrom != "" {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filters.DateFrom)
+	}
+	if filters.DateTo != "" {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filters.DateTo+" 23:59:59")
+	}
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	q := `SELECT id, created_at, entity, entity_key, action, COALESCE(before_json, ''), COALESCE(after_json, ''), user_name FROM audit_log`
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]model.AuditLogEntry, 0)
+	for rows.Next() {
+		var e model.AuditLogEntry
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Entity, &e.EntityKey, &e.Action, &e.BeforeJSON, &e.AfterJSON, &e.UserName); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
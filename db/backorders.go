@@ -4,10 +4,18 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 	"wasabi/model"
 )
 
+// ErrBackorderNotFound は指定した発注残が存在しない場合に返されます。
+var ErrBackorderNotFound = errors.New("backorder not found")
+
+// ErrBackorderStatus は発注残の現在の状態では指定した変更ができない場合に返されます。
+var ErrBackorderStatus = errors.New("backorder status does not allow the change")
+
 // ▼▼▼【ここから修正】▼▼▼
 
 /**
@@ -23,8 +31,9 @@ func InsertBackordersInTx(tx *sql.Tx, backorders []model.Backorder) error {
 		INSERT INTO backorders (
 			order_date, yj_code, product_name, package_form, jan_pack_inner_qty, 
 			yj_unit_name, order_quantity, remaining_quantity, wholesaler_code,
-			yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+			yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code, product_code, po_number,
+			status, expected_date
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	stmt, err := tx.Prepare(q)
 	if err != nil {
 		return fmt.Errorf("failed to prepare backorder insert statement: %w", err)
@@ -32,10 +41,14 @@ func InsertBackordersInTx(tx *sql.Tx, backorders []model.Backorder) error {
 	defer stmt.Close()
 
 	for _, bo := range backorders {
+		if bo.Status == "" {
+			bo.Status = model.BackorderStatusOrdered
+		}
 		_, err := stmt.Exec(
 			bo.OrderDate, bo.YjCode, bo.ProductName, bo.PackageForm, bo.JanPackInnerQty,
 			bo.YjUnitName, bo.OrderQuantity, bo.RemainingQuantity, bo.WholesalerCode,
-			bo.YjPackUnitQty, bo.JanPackUnitQty, bo.JanUnitCode,
+			bo.YjPackUnitQty, bo.JanPackUnitQty, bo.JanUnitCode, bo.ProductCode, bo.PoNumber,
+			bo.Status, bo.ExpectedDate,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to execute backorder insert for yj %s: %w", bo.YjCode, err)
@@ -50,8 +63,8 @@ func InsertBackordersInTx(tx *sql.Tx, backorders []model.Backorder) error {
  * @param deliveredItems 納品された品物のスライス
  * @return error 処理中にエラーが発生した場合
  * @details
- * 納品された各品物について、対応する発注残を古いものから順に消し込みます。
- * 発注残数量が0になったレコードは削除されます。
+ * 納品された各品物について、対応する未完了の発注残を古いものから順に消し込みます。
+ * 発注残数量が0になったレコードは納品完了、一部が残ったレコードは一部納品になります。
  */
 func ReconcileBackorders(conn *sql.DB, deliveredItems []model.Backorder) error {
 	tx, err := conn.Begin()
@@ -60,50 +73,141 @@ func ReconcileBackorders(conn *sql.DB, deliveredItems []model.Backorder) error {
 	}
 	defer tx.Rollback()
 
+	if _, err := ReconcileBackordersInTx(tx, deliveredItems); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+/**
+ * @brief 取込バッチの納品データで発注残を消し込み、消し込み内容をバッチに記録します。
+ * @param conn データベース接続
+ * @param batchID 取込バッチID
+ * @param deliveredItems 納品された品物のスライス
+ * @param user 監査ログに記録する操作者
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 記録した内容は、取込バッチのロールバック時に発注残を復元するために使われます。
+ */
+func ReconcileBackordersForBatch(conn *sql.DB, batchID int64, deliveredItems []model.Backorder, user string) error {
+	tx, err := BeginAuditTx(conn, user)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for reconciliation: %w", err)
+	}
+	defer tx.Rollback()
+
+	reconciled, err := ReconcileBackordersInTx(tx, deliveredItems)
+	if err != nil {
+		return err
+	}
+	if err := RecordBatchBackordersInTx(tx, batchID, reconciled); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+/**
+ * @brief 納品データに基づく発注残の消し込みをトランザクション内で行います。
+ * @param tx SQLトランザクションオブジェクト
+ * @param deliveredItems 納品された品物のスライス
+ * @return []model.BackorderReconciliation 消し込まれた発注残の明細
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * ReconcileBackorders の本体です。DATインポートのプレビューでは、
+ * ロールバック前提のトランザクションで呼び出し、消し込み対象を確認するために使用します。
+ * 納品した卸 (WholesalerCode) に発注した発注残を優先し、その中で発注日の古い順に消し込みます。
+ * 消し込んだ納品データの伝票は backorder_fulfillments に記録します。
+ */
+func ReconcileBackordersInTx(tx *sql.Tx, deliveredItems []model.Backorder) ([]model.BackorderReconciliation, error) {
+	var results []model.BackorderReconciliation
+
+	fulfillStmt, err := tx.Prepare(`
+		INSERT INTO backorder_fulfillments (
+			backorder_id, transaction_date, client_code, receipt_number, line_number, jan_code, quantity, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare backorder fulfillment insert: %w", err)
+	}
+	defer fulfillStmt.Close()
+	now := time.Now()
+
 	for _, item := range deliveredItems {
 		deliveryQty := item.YjQuantity
 
 		rows, err := tx.Query(`
-			SELECT id, remaining_quantity FROM backorders 
+			SELECT id, order_date, product_name, package_form, jan_pack_inner_qty, yj_unit_name,
+				order_quantity, wholesaler_code, COALESCE(yj_pack_unit_qty, 0), COALESCE(jan_pack_unit_qty, 0),
+				COALESCE(jan_unit_code, 0), remaining_quantity, product_code, po_number
+			FROM backorders 
 			WHERE yj_code = ? AND package_form = ? AND jan_pack_inner_qty = ? AND yj_unit_name = ?
-			ORDER BY order_date, id`,
-			item.YjCode, item.PackageForm, item.JanPackInnerQty, item.YjUnitName,
+				AND status IN ('ordered', 'partial') AND remaining_quantity > 0
+			ORDER BY COALESCE(wholesaler_code, '') = ? DESC, order_date, id`,
+			item.YjCode, item.PackageForm, item.JanPackInnerQty, item.YjUnitName, item.WholesalerCode.String,
 		)
 		if err != nil {
-			return fmt.Errorf("failed to query backorders for reconciliation: %w", err)
+			return nil, fmt.Errorf("failed to query backorders for reconciliation: %w", err)
 		}
 
+		// 走査中に同じテーブルを更新しないよう、対象を先に読み切る
+		var targets []model.BackorderReconciliation
 		for rows.Next() {
+			var rec model.BackorderReconciliation
+			var productName sql.NullString
+			if err := rows.Scan(
+				&rec.BackorderID, &rec.OrderDate, &productName, &rec.PackageForm, &rec.JanPackInnerQty, &rec.YjUnitName,
+				&rec.OrderQuantity, &rec.WholesalerCode, &rec.YjPackUnitQty, &rec.JanPackUnitQty,
+				&rec.JanUnitCode, &rec.RemainingBefore, &rec.ProductCode, &rec.PoNumber,
+			); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan backorder row: %w", err)
+			}
+			rec.YjCode = item.YjCode
+			rec.ProductName = productName.String
+			targets = append(targets, rec)
+		}
+		rows.Close()
+
+		for _, rec := range targets {
 			if deliveryQty <= 0 {
 				break
 			}
-			var id int
-			var remainingQty float64
-			if err := rows.Scan(&id, &remainingQty); err != nil {
-				rows.Close()
-				return fmt.Errorf("failed to scan backorder row: %w", err)
-			}
 
-			if deliveryQty >= remainingQty {
+			deliveryDate := item.DeliveryDate
+			if deliveryDate == "" {
+				deliveryDate = now.Format("20060102")
+			}
+			if deliveryQty >= rec.RemainingBefore {
 				// 納品数で発注残が完全にカバーされる場合
-				if _, err := tx.Exec(`DELETE FROM backorders WHERE id = ?`, id); err != nil {
-					rows.Close()
-					return fmt.Errorf("failed to delete reconciled backorder id %d: %w", id, err)
+				if _, err := tx.Exec(`UPDATE backorders SET remaining_quantity = 0, status = ?, closed_date = ? WHERE id = ?`,
+					model.BackorderStatusReceived, deliveryDate, rec.BackorderID); err != nil {
+					return nil, fmt.Errorf("failed to update reconciled backorder id %d: %w", rec.BackorderID, err)
 				}
-				deliveryQty -= remainingQty
+				rec.ConsumedQuantity = rec.RemainingBefore
+				rec.RemainingAfter = 0
+				deliveryQty -= rec.RemainingBefore
 			} else {
 				// 納品数の一部で発注残を減らす場合
-				newRemaining := remainingQty - deliveryQty
-				if _, err := tx.Exec(`UPDATE backorders SET remaining_quantity = ? WHERE id = ?`, newRemaining, id); err != nil {
-					rows.Close()
-					return fmt.Errorf("failed to update partially reconciled backorder id %d: %w", id, err)
+				newRemaining := rec.RemainingBefore - deliveryQty
+				if _, err := tx.Exec(`UPDATE backorders SET remaining_quantity = ?, status = ? WHERE id = ?`,
+					newRemaining, model.BackorderStatusPartial, rec.BackorderID); err != nil {
+					return nil, fmt.Errorf("failed to update partially reconciled backorder id %d: %w", rec.BackorderID, err)
 				}
+				rec.ConsumedQuantity = deliveryQty
+				rec.RemainingAfter = newRemaining
 				deliveryQty = 0
 			}
+			res, err := fulfillStmt.Exec(rec.BackorderID, deliveryDate, item.WholesalerCode.String, item.DeliveryReceiptNumber,
+				item.DeliveryLineNumber, item.ProductCode, rec.ConsumedQuantity, now.Format("2006-01-02 15:04:05"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to record fulfillment of backorder id %d: %w", rec.BackorderID, err)
+			}
+			if rec.FulfillmentID, err = res.LastInsertId(); err != nil {
+				return nil, err
+			}
+			results = append(results, rec)
 		}
-		rows.Close()
 	}
-	return tx.Commit()
+	return results, nil
 }
 
 /**
@@ -119,6 +223,7 @@ func GetAllBackordersMap(conn *sql.DB) (map[string]float64, error) {
 	const q = `
 		SELECT yj_code, package_form, jan_pack_inner_qty, yj_unit_name, SUM(remaining_quantity)
 		FROM backorders
+		WHERE status IN ('ordered', 'partial')
 		GROUP BY yj_code, package_form, jan_pack_inner_qty, yj_unit_name`
 	rows, err := conn.Query(q)
 	if err != nil {
@@ -139,63 +244,223 @@ func GetAllBackordersMap(conn *sql.DB) (map[string]float64, error) {
 	return backordersMap, nil
 }
 
+// backorderColumns は発注残の一覧で取得する列です (scanBackorders と同じ順)。
+const backorderColumns = `
+	id, order_date, yj_code, product_name, package_form, jan_pack_inner_qty,
+	yj_unit_name, order_quantity, remaining_quantity, wholesaler_code,
+	yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code, product_code, po_number,
+	status, expected_date, status_reason, closed_date`
+
+func scanBackorders(rows *sql.Rows) ([]model.Backorder, error) {
+	defer rows.Close()
+	var backorders []model.Backorder
+	for rows.Next() {
+		var bo model.Backorder
+		if err := rows.Scan(
+			&bo.ID, &bo.OrderDate, &bo.YjCode, &bo.ProductName, &bo.PackageForm, &bo.JanPackInnerQty,
+			&bo.YjUnitName, &bo.OrderQuantity, &bo.RemainingQuantity, &bo.WholesalerCode,
+			&bo.YjPackUnitQty, &bo.JanPackUnitQty, &bo.JanUnitCode, &bo.ProductCode, &bo.PoNumber,
+			&bo.Status, &bo.ExpectedDate, &bo.StatusReason, &bo.ClosedDate,
+		); err != nil {
+			return nil, err
+		}
+		backorders = append(backorders, bo)
+	}
+	return backorders, rows.Err()
+}
+
 /**
- * @brief 全ての発注残を画面表示用のリスト形式で取得します。
+ * @brief 未完了 (発注済み・一部納品) の発注残を画面表示用のリスト形式で取得します。
  * @param conn データベース接続
  * @return []model.Backorder 発注残レコードのスライス
  * @return error 処理中にエラーが発生した場合
  */
 func GetAllBackordersList(conn *sql.DB) ([]model.Backorder, error) {
-	const q = `
-		SELECT
-			id, order_date, yj_code, product_name, package_form, jan_pack_inner_qty, 
-			yj_unit_name, order_quantity, remaining_quantity, wholesaler_code,
-			yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code
-		FROM backorders
-		ORDER BY order_date, product_name, id
-	`
+	return GetBackordersList(conn, "open")
+}
+
+/**
+ * @brief 状態で絞り込んだ発注残を画面表示用のリスト形式で取得します。
+ * @param conn データベース接続
+ * @param scope "open" (未完了)、"closed" (納品完了・取消・欠品)、"all" (全て)
+ * @return []model.Backorder 発注残レコードのスライス
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetBackordersList(conn *sql.DB, scope string) ([]model.Backorder, error) {
+	q := `SELECT ` + backorderColumns + ` FROM backorders`
+	switch scope {
+	case "closed":
+		q += ` WHERE status NOT IN ('ordered', 'partial') ORDER BY closed_date DESC, order_date DESC, id DESC`
+	case "all":
+		q += ` ORDER BY order_date, product_name, id`
+	default:
+		q += ` WHERE status IN ('ordered', 'partial') ORDER BY order_date, product_name, id`
+	}
 	rows, err := conn.Query(q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query all backorders list: %w", err)
+		return nil, fmt.Errorf("failed to query backorders list: %w", err)
+	}
+	return scanBackorders(rows)
+}
+
+/**
+ * @brief 発注残を取消または卸の欠品として締めるか、未完了に戻します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param id 発注残のID
+ * @param status model.BackorderStatusCancelled、model.BackorderStatusOutOfStock、model.BackorderStatusOrdered (再開) のいずれか
+ * @param reason 取消・欠品の理由
+ * @return error 存在しない場合は ErrBackorderNotFound、現在の状態では変更できない場合は ErrBackorderStatus をラップしたエラー
+ * @details
+ * 取消・欠品にできるのは発注済み・一部納品の発注残だけです (取消済みの理由を上書きしないよう、締めた発注残は対象外)。
+ * 締めた発注残は残数量を残したまま、発注残の集計と納品データの消し込みの対象から外れます。
+ * 再開した発注残は、残数量に応じて発注済みまたは一部納品に戻ります。納品完了の発注残は再開できません。
+ */
+func SetBackorderStatusInTx(tx *sql.Tx, id int, status, reason string) error {
+	var current string
+	var orderQty, remaining float64
+	err := tx.QueryRow(`SELECT status, order_quantity, remaining_quantity FROM backorders WHERE id = ?`, id).Scan(&current, &orderQty, &remaining)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: id %d", ErrBackorderNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get backorder id %d: %w", id, err)
+	}
+
+	switch status {
+	case model.BackorderStatusCancelled, model.BackorderStatusOutOfStock:
+		if current != model.BackorderStatusOrdered && current != model.BackorderStatusPartial {
+			return fmt.Errorf("%w: 発注済み・一部納品の発注残だけを取消・欠品にできます (ID: %d, 現在の状態: %s)", ErrBackorderStatus, id, current)
+		}
+		_, err = tx.Exec(`UPDATE backorders SET status = ?, status_reason = ?, closed_date = ? WHERE id = ?`,
+			status, reason, time.Now().Format("20060102"), id)
+	case model.BackorderStatusOrdered:
+		if current == model.BackorderStatusReceived {
+			return fmt.Errorf("%w: 納品が完了した発注残は再開できません (ID: %d)", ErrBackorderStatus, id)
+		}
+		reopened := model.BackorderStatusOrdered
+		if remaining < orderQty-1e-9 {
+			reopened = model.BackorderStatusPartial
+		}
+		_, err = tx.Exec(`UPDATE backorders SET status = ?, status_reason = '', closed_date = '' WHERE id = ?`, reopened, id)
+	default:
+		return fmt.Errorf("発注残の状態が不正です: %s", status)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update status of backorder id %d: %w", id, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 発注残の納品予定日を設定します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param id 発注残のID
+ * @param expectedDate 納品予定日 (YYYYMMDD、空の場合は未定)
+ * @return error 存在しない場合は ErrBackorderNotFound をラップしたエラー
+ */
+func SetBackorderExpectedDateInTx(tx *sql.Tx, id int, expectedDate string) error {
+	if expectedDate != "" {
+		if _, err := time.Parse("20060102", expectedDate); err != nil {
+			return fmt.Errorf("納品予定日が不正です: %s", expectedDate)
+		}
+	}
+	res, err := tx.Exec(`UPDATE backorders SET expected_date = ? WHERE id = ?`, expectedDate, id)
+	if err != nil {
+		return fmt.Errorf("failed to update expected date of backorder id %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: id %d", ErrBackorderNotFound, id)
+	}
+	return nil
+}
+
+/**
+ * @brief 発注残を消し込んだ納品データを、発注残IDごとに取得します。
+ * @param conn データベース接続
+ * @return map[int][]model.BackorderFulfillment 発注残IDごとの納品データ (納品日順)
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetBackorderFulfillmentsMap(conn *sql.DB) (map[int][]model.BackorderFulfillment, error) {
+	rows, err := conn.Query(`
+		SELECT id, backorder_id, transaction_date, client_code, receipt_number, line_number, jan_code, quantity
+		FROM backorder_fulfillments ORDER BY transaction_date, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query backorder fulfillments: %w", err)
 	}
 	defer rows.Close()
 
-	var backorders []model.Backorder
+	fulfillments := make(map[int][]model.BackorderFulfillment)
 	for rows.Next() {
-		var bo model.Backorder
-		if err := rows.Scan(
-			&bo.ID, &bo.OrderDate, &bo.YjCode, &bo.ProductName, &bo.PackageForm, &bo.JanPackInnerQty,
-			&bo.YjUnitName, &bo.OrderQuantity, &bo.RemainingQuantity, &bo.WholesalerCode,
-			&bo.YjPackUnitQty, &bo.JanPackUnitQty, &bo.JanUnitCode,
-		); err != nil {
+		var f model.BackorderFulfillment
+		if err := rows.Scan(&f.ID, &f.BackorderID, &f.TransactionDate, &f.ClientCode, &f.ReceiptNumber, &f.LineNumber, &f.JanCode, &f.Quantity); err != nil {
 			return nil, err
 		}
-		backorders = append(backorders, bo)
+		fulfillments[f.BackorderID] = append(fulfillments[f.BackorderID], f)
 	}
-	return backorders, nil
+	return fulfillments, rows.Err()
 }
 
 /**
- * @brief 指定されたIDの発注残レコードをトランザクション内で削除します。
- * @param tx SQLトランザクションオブジェクト
- * @param id 削除対象のID
+ * @brief 発注から指定日数を超えて未完了の発注残を、卸ごとに集計します。
+ * @param conn データベース接続
+ * @param date 基準日
+ * @param days 経過日数 (発注日から基準日までの日数がこれを超える発注残を対象とします)
+ * @return *model.BackorderAgingReport 卸ごとの発注残 (卸は最も古い発注日の順、発注残は発注日の古い順)
  * @return error 処理中にエラーが発生した場合
  */
-func DeleteBackorderInTx(tx *sql.Tx, id int) error {
-	const q = `DELETE FROM backorders WHERE id = ?`
-
-	res, err := tx.Exec(q, id)
+func GetBackorderAgingReport(conn *sql.DB, date time.Time, days int) (*model.BackorderAgingReport, error) {
+	threshold := date.AddDate(0, 0, -days).Format("20060102")
+	rows, err := conn.Query(`SELECT `+backorderColumns+` FROM backorders
+		WHERE status IN ('ordered', 'partial') AND order_date < ?
+		ORDER BY order_date, id`, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aging backorders: %w", err)
+	}
+	backorders, err := scanBackorders(rows)
 	if err != nil {
-		return fmt.Errorf("failed to delete backorder for id %d: %w", id, err)
+		return nil, err
 	}
-	rowsAffected, err := res.RowsAffected()
+	wholesalers, err := GetAllWholesalers(conn)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected for backorder id %d: %w", id, err)
+		return nil, err
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("no backorder found to delete for id %d", id)
+	names := make(map[string]string, len(wholesalers))
+	for _, w := range wholesalers {
+		names[w.Code] = w.Name
 	}
-	return nil
+
+	report := &model.BackorderAgingReport{Date: date.Format("20060102"), Days: days, Groups: make([]model.BackorderAgingGroup, 0)}
+	groupIndex := make(map[string]int)
+	today, _ := time.Parse("20060102", report.Date)
+	for _, bo := range backorders {
+		item := model.BackorderAgingItem{Backorder: bo}
+		if orderDate, err := time.Parse("20060102", bo.OrderDate); err == nil {
+			item.DaysOpen = int(today.Sub(orderDate).Hours() / 24)
+		}
+		item.Overdue = bo.ExpectedDate != "" && bo.ExpectedDate < report.Date
+
+		code := bo.WholesalerCode.String
+		i, ok := groupIndex[code]
+		if !ok {
+			i = len(report.Groups)
+			groupIndex[code] = i
+			report.Groups = append(report.Groups, model.BackorderAgingGroup{
+				WholesalerCode:  code,
+				WholesalerName:  names[code],
+				OldestOrderDate: bo.OrderDate,
+			})
+		}
+		g := &report.Groups[i]
+		g.Count++
+		if item.Overdue {
+			g.OverdueCount++
+		}
+		if item.DaysOpen > g.MaxDaysOpen {
+			g.MaxDaysOpen = item.DaysOpen
+		}
+		g.Items = append(g.Items, item)
+	}
+	return report, nil
 }
 
 // ▲▲▲【修正ここまで】▲▲▲
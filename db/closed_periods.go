@@ -0,0 +1,204 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\closed_periods.go
+
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wasabi/model"
+)
+
+// ErrPeriodClosed は締め済みの期間の取引データを変更しようとした場合に返されます。
+// schema.sql のトリガーも同じメッセージで変更を拒否します。
+var ErrPeriodClosed = errors.New("transaction date is in a closed period")
+
+// ErrInvalidPeriodRequest は締め・再開の指定が不正な場合 (月の形式・まだ終わっていない月・再開の理由なし) に返されます。
+var ErrInvalidPeriodRequest = errors.New("invalid period close request")
+
+// ErrPeriodAlreadyClosed は既に締め済みの月を締めようとした場合に返されます。
+var ErrPeriodAlreadyClosed = errors.New("period is already closed")
+
+// ErrPeriodNotClosed は再開しようとした期間が締め済みでない (見つからない・再開済み) 場合に返されます。
+var ErrPeriodNotClosed = errors.New("period is not closed")
+
+// IsPeriodClosedError はエラーが締め済みの期間への変更によるものかを判定します。
+// トリガーによるエラーは SQLite のエラーとして返されるため、メッセージでも判定します。
+func IsPeriodClosedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, ErrPeriodClosed) || strings.Contains(err.Error(), ErrPeriodClosed.Error())
+}
+
+const closedPeriodColumns = `id, period, start_date, end_date, closed_at, closed_by, total_nhi_value, total_purchase_value, reopened_at, reopened_by, reopen_reason`
+
+func scanClosedPeriod(row interface{ Scan(...interface{}) error }) (*model.ClosedPeriod, error) {
+	var p model.ClosedPeriod
+	err := row.Scan(&p.ID, &p.Period, &p.StartDate, &p.EndDate, &p.ClosedAt, &p.ClosedBy,
+		&p.TotalNhiValue, &p.TotalPurchaseValue, &p.ReopenedAt, &p.ReopenedBy, &p.ReopenReason)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+/**
+ * @brief 指定した期間 (日付の範囲) に締め済みの期間が含まれていないかを確認します。
+ * @param dbtx DB接続またはトランザクション
+ * @param startDate 開始日 (YYYYMMDD)
+ * @param endDate 終了日 (YYYYMMDD)
+ * @return error 締め済みの期間と重なる場合は ErrPeriodClosed をラップしたエラー
+ */
+func CheckPeriodOpen(dbtx DBTX, startDate, endDate string) error {
+	var period string
+	err := dbtx.QueryRow(`
+		SELECT period FROM closed_periods
+		WHERE reopened_at = '' AND start_date <= ? AND end_date >= ?
+		ORDER BY period LIMIT 1`, endDate, startDate).Scan(&period)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check closed periods: %w", err)
+	}
+	return fmt.Errorf("%w (period %s)", ErrPeriodClosed, period)
+}
+
+// GetLatestClosedDate は締め済みの期間の最終日 (YYYYMMDD) のうち最も新しい日付を返します。締め済みの期間が無い場合は空文字です。
+func GetLatestClosedDate(dbtx DBTX) (string, error) {
+	var date string
+	if err := dbtx.QueryRow(`SELECT COALESCE(MAX(end_date), '') FROM closed_periods WHERE reopened_at = ''`).Scan(&date); err != nil {
+		return "", fmt.Errorf("failed to get latest closed date: %w", err)
+	}
+	return date, nil
+}
+
+/**
+ * @brief 会計期間 (月) を締め、月末時点の在庫評価を保存します。
+ * @param conn データベース接続
+ * @param period 締める月 (YYYYMM)
+ * @param closedBy 締めを行った担当者
+ * @return *model.ClosedPeriod 登録した締め済みの期間
+ * @return error 期間が不正・まだ終わっていない場合は ErrInvalidPeriodRequest、締め済みの場合は ErrPeriodAlreadyClosed、または処理中にエラーが発生した場合
+ * @details
+ * 在庫評価は GetInventoryValuation を月末日で実行した結果をJSONで保存します。
+ * 締めた後は、その月の取引データの登録・変更・削除がトリガーにより拒否されます。
+ */
+func ClosePeriod(conn *sql.DB, period string, closedBy string) (*model.ClosedPeriod, error) {
+	start, err := time.Parse("200601", period)
+	if err != nil {
+		return nil, fmt.Errorf("締める月の形式が不正です (YYYYMM): %s (%w)", period, ErrInvalidPeriodRequest)
+	}
+	end := start.AddDate(0, 1, -1)
+	if !end.Before(time.Now().Truncate(24 * time.Hour)) {
+		return nil, fmt.Errorf("%s年%s月はまだ終わっていないため締められません (%w)", period[:4], period[4:], ErrInvalidPeriodRequest)
+	}
+	startDate, endDate := start.Format("20060102"), end.Format("20060102")
+
+	var exists int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM closed_periods WHERE period = ? AND reopened_at = ''`, period).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check closed periods: %w", err)
+	}
+	if exists > 0 {
+		return nil, fmt.Errorf("%s年%s月は既に締め済みです (%w)", period[:4], period[4:], ErrPeriodAlreadyClosed)
+	}
+
+	valuation, err := GetInventoryValuation(conn, model.ValuationFilters{Date: endDate})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory valuation for %s: %w", period, err)
+	}
+	snapshot, err := json.Marshal(valuation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode valuation snapshot: %w", err)
+	}
+
+	p := model.ClosedPeriod{
+		Period:    period,
+		StartDate: startDate,
+		EndDate:   endDate,
+		ClosedAt:  time.Now().Format("2006-01-02 15:04:05"),
+		ClosedBy:  closedBy,
+	}
+	for _, group := range valuation {
+		p.TotalNhiValue += group.TotalNhiValue
+		p.TotalPurchaseValue += group.TotalPurchaseValue
+	}
+
+	res, err := conn.Exec(`
+		INSERT INTO closed_periods (period, start_date, end_date, closed_at, closed_by, total_nhi_value, total_purchase_value, valuation_snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Period, p.StartDate, p.EndDate, p.ClosedAt, p.ClosedBy, p.TotalNhiValue, p.TotalPurchaseValue, string(snapshot))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save closed period %s: %w", period, err)
+	}
+	id, _ := res.LastInsertId()
+	p.ID = int(id)
+	return &p, nil
+}
+
+/**
+ * @brief 締め済みの期間を再開し、取引データを変更できる状態に戻します。
+ * @param conn データベース接続
+ * @param id 締め済みの期間のID
+ * @param reopenedBy 再開を行った管理者
+ * @param reason 再開の理由 (必須)
+ * @return error 理由が空の場合は ErrInvalidPeriodRequest、期間が見つからない・既に再開済みの場合は ErrPeriodNotClosed、または処理中にエラーが発生した場合
+ * @details
+ * 行は削除せず、再開日時・管理者・理由を記録します。同じ月は再度締めることができます。
+ */
+func ReopenPeriod(conn *sql.DB, id int, reopenedBy string, reason string) error {
+	if strings.TrimSpace(reopenedBy) == "" || strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("期間の再開には管理者名と理由が必要です (%w)", ErrInvalidPeriodRequest)
+	}
+	res, err := conn.Exec(`
+		UPDATE closed_periods SET reopened_at = ?, reopened_by = ?, reopen_reason = ?
+		WHERE id = ? AND reopened_at = ''`,
+		time.Now().Format("2006-01-02 15:04:05"), strings.TrimSpace(reopenedBy), strings.TrimSpace(reason), id)
+	if err != nil {
+		return fmt.Errorf("failed to reopen period %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("締め済みの期間が見つかりません (ID: %d) (%w)", id, ErrPeriodNotClosed)
+	}
+	return nil
+}
+
+// GetClosedPeriods は締め・再開の履歴を新しい月の順に返します。
+func GetClosedPeriods(conn *sql.DB) ([]model.ClosedPeriod, error) {
+	rows, err := conn.Query(`SELECT ` + closedPeriodColumns + ` FROM closed_periods ORDER BY period DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get closed periods: %w", err)
+	}
+	defer rows.Close()
+
+	periods := make([]model.ClosedPeriod, 0)
+	for rows.Next() {
+		p, err := scanClosedPeriod(rows)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, *p)
+	}
+	return periods, rows.Err()
+}
+
+// GetClosedPeriodSnapshot は締め時点に保存した在庫評価 (GetInventoryValuation の結果) を返します。
+func GetClosedPeriodSnapshot(conn *sql.DB, id int) (*model.ClosedPeriod, []ValuationGroup, error) {
+	var snapshot string
+	p, err := scanClosedPeriod(conn.QueryRow(`SELECT `+closedPeriodColumns+` FROM closed_periods WHERE id = ?`, id))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.QueryRow(`SELECT valuation_snapshot FROM closed_periods WHERE id = ?`, id).Scan(&snapshot); err != nil {
+		return nil, nil, err
+	}
+	var groups []ValuationGroup
+	if err := json.Unmarshal([]byte(snapshot), &groups); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode valuation snapshot: %w", err)
+	}
+	return p, groups, nil
+}
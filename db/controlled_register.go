@@ -0,0 +1,485 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\controlled_register.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"wasabi/model"
+	"wasabi/units"
+)
+
+// controlledDrugTypes は麻薬・向精神薬帳簿の対象とする薬品区分です (区分の指定が無い場合に使用します)。
+var controlledDrugTypes = []string{"narcotic", "psychotropic1", "psychotropic2", "psychotropic3", "stimulant", "stimulant_raw"}
+
+// controlledCategoryLabels は帳簿の区分の表示名です。
+var controlledCategoryLabels = map[string]string{
+	"receipt":   "受入",
+	"dispense":  "施用・交付",
+	"transfer":  "譲渡",
+	"disposal":  "廃棄",
+	"adjust":    "調整",
+	"inventory": "棚卸",
+}
+
+// ControlledCategoryLabel は帳簿の区分の表示名を返します。
+func ControlledCategoryLabel(category string) string {
+	if label, ok := controlledCategoryLabels[category]; ok {
+		return label
+	}
+	return category
+}
+
+// defaultControlledCategory は取引種別 (flag) から帳簿の区分を決めます。帳簿の対象外の取引は空文字です。
+func defaultControlledCategory(flag int) string {
+	switch flag {
+	case 0:
+		return "inventory"
+	case 1, 11:
+		return "receipt"
+	case 3:
+		return "dispense"
+	case 2, 12:
+		return "transfer"
+	case 4, 5:
+		return "adjust"
+	}
+	return ""
+}
+
+// controlledDrugClass は製品マスターの規制区分フラグから帳簿に表示する区分名を返します。
+func controlledDrugClass(m *model.ProductMaster) string {
+	switch {
+	case m.FlagNarcotic == 1:
+		return "麻薬"
+	case m.FlagStimulant == 1:
+		return "覚醒剤"
+	case m.FlagStimulantRaw == 1:
+		return "覚醒剤原料"
+	case m.FlagPsychotropic >= 1 && m.FlagPsychotropic <= 3:
+		return fmt.Sprintf("向精神薬%d種", m.FlagPsychotropic)
+	}
+	return ""
+}
+
+type controlledTransaction struct {
+	model.ControlledRegisterEntry
+	quantity float64
+}
+
+/**
+ * @brief 麻薬・向精神薬帳簿を作成します。
+ * @param conn データベース接続
+ * @param filters 抽出条件 (区分・製品・期間・ロット別)
+ * @return []model.ControlledRegister 製品 (またはロット) ごとの帳簿
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 取引データを日付順に受入・払出として記載し、行ごとの残高を計算します。期間の開始日より前の取引は繰越残高に含めます。
+ * 棚卸は日末の実在庫として扱い (在庫計算と同じく、同じ日の他の取引の後に記載)、帳簿残高との差異を記録した上で残高を実在庫に合わせます。
+ * ロット別の場合、棚卸の日に数えられなかったロットは実在庫 0 として差異を記録します。
+ * 区分は controlled_register_notes の指定 (廃棄など) を優先し、無ければ取引種別から決めます。
+ */
+func GetControlledRegisters(conn *sql.DB, filters model.ControlledRegisterFilters) ([]model.ControlledRegister, error) {
+	drugTypes := filters.DrugTypes
+	if len(drugTypes) == 0 || drugTypes[0] == "" {
+		drugTypes = controlledDrugTypes
+	}
+	var conditions, txConditions []string
+	for _, dt := range drugTypes {
+		if cond, ok := drugTypeConditions[dt]; ok {
+			conditions = append(conditions, cond)
+			// 取引時点で規制区分が付いていた製品も対象とする (マスターの区分が後から変わった場合など)
+			txConditions = append(txConditions, strings.Replace(cond, "p.", "t.", 1))
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("帳簿の対象となる薬品区分が指定されていません")
+	}
+	q := `SELECT ` + SelectColumns + ` FROM product_master p WHERE (` + strings.Join(conditions, " OR ") +
+		` OR p.product_code IN (SELECT t.jan_code FROM transaction_records t WHERE ` + strings.Join(txConditions, " OR ") + `))`
+	var args []interface{}
+	if filters.ProductCode != "" {
+		q += ` AND p.product_code = ?`
+		args = append(args, filters.ProductCode)
+	}
+	q += ` ORDER BY p.kana_name, p.product_name`
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get controlled substance masters: %w", err)
+	}
+	var masters []*model.ProductMaster
+	for rows.Next() {
+		m, err := ScanProductMaster(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		masters = append(masters, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	names, err := getCounterpartyNames(conn)
+	if err != nil {
+		return nil, err
+	}
+	closedRanges, err := getClosedRanges(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	registers := make([]model.ControlledRegister, 0)
+	for _, m := range masters {
+		txs, err := getControlledTransactions(conn, m.ProductCode, filters.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		for i := range txs {
+			t := &txs[i]
+			if t.Flag == 1 || t.Flag == 2 {
+				t.ClientName = names.wholesalers[t.ClientCode]
+			} else {
+				t.ClientName = names.clients[t.ClientCode]
+			}
+			t.Closed = closedRanges.contains(t.Date)
+		}
+		for _, reg := range buildControlledRegisters(m, txs, filters) {
+			if len(reg.Entries) == 0 && math.Abs(reg.OpeningBalance) < stockBalanceTolerance {
+				continue
+			}
+			registers = append(registers, reg)
+		}
+	}
+	return registers, nil
+}
+
+// controlledNoteJoin は帳簿の記載 (n) を取引 (t) に対応させる結合条件です。
+// 記載は伝票キーで取引に対応させ、伝票の行が別の製品に置き換わった場合は対応させません。
+const controlledNoteJoin = `t.receipt_number != '' AND n.transaction_date = t.transaction_date
+	AND n.client_code = COALESCE(t.client_code, '') AND n.receipt_number = t.receipt_number
+	AND n.line_number = COALESCE(t.line_number, '') AND n.jan_code = t.jan_code`
+
+// getUnresolvedControlledNotes は期間内の帳簿の記載のうち、取引に対応しないものを取得します。
+// 製品マスターが無い記載は区分を判定できないため、対象に含めます。
+func getUnresolvedControlledNotes(conn *sql.DB, drugType, startDate, endDate string) ([]model.UnresolvedRegisterNote, error) {
+	cond, ok := drugTypeConditions[drugType]
+	if !ok {
+		return nil, fmt.Errorf("unknown drug type: %s", drugType)
+	}
+	rows, err := conn.Query(`
+		SELECT n.transaction_date, n.client_code, n.receipt_number, n.line_number, n.jan_code, n.category, n.witness, n.note
+		FROM controlled_register_notes n
+		LEFT JOIN product_master p ON p.product_code = n.jan_code
+		WHERE n.transaction_date BETWEEN ? AND ? AND (p.product_code IS NULL OR `+cond+`)
+			AND NOT EXISTS (SELECT 1 FROM transaction_records t WHERE `+controlledNoteJoin+`)
+		ORDER BY n.transaction_date, n.receipt_number, n.line_number`, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unresolved controlled register notes: %w", err)
+	}
+	defer rows.Close()
+
+	notes := make([]model.UnresolvedRegisterNote, 0)
+	for rows.Next() {
+		var n model.UnresolvedRegisterNote
+		if err := rows.Scan(&n.TransactionDate, &n.ClientCode, &n.ReceiptNumber, &n.LineNumber, &n.JanCode,
+			&n.Category, &n.Witness, &n.Note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// getControlledTransactions は製品の帳簿の対象となる取引を、区分・立会人・備考と共に日付順に取得します。
+func getControlledTransactions(conn *sql.DB, productCode, endDate string) ([]controlledTransaction, error) {
+	q := `
+		SELECT t.id, t.transaction_date, t.flag, COALESCE(t.client_code, ''), COALESCE(t.receipt_number, ''),
+			COALESCE(t.lot_number, ''), COALESCE(t.expiry_date, ''), COALESCE(t.yj_quantity, 0),
+			COALESCE(n.category, ''), COALESCE(n.witness, ''), COALESCE(n.note, '')
+		FROM transaction_records t
+		LEFT JOIN controlled_register_notes n ON ` + controlledNoteJoin + `
+		WHERE t.jan_code = ? AND t.flag IN (0, 1, 2, 3, 4, 5, 11, 12)`
+	args := []interface{}{productCode}
+	if endDate != "" {
+		q += ` AND t.transaction_date <= ?`
+		args = append(args, endDate)
+	}
+	// 棚卸は日末の実在庫のため、同じ日の取引の後に並べる
+	q += ` ORDER BY t.transaction_date, CASE WHEN t.flag = 0 THEN 1 ELSE 0 END, t.id`
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions for controlled register of %s: %w", productCode, err)
+	}
+	defer rows.Close()
+
+	var txs []controlledTransaction
+	for rows.Next() {
+		var t controlledTransaction
+		if err := rows.Scan(&t.TransactionID, &t.Date, &t.Flag, &t.ClientCode, &t.ReceiptNumber,
+			&t.LotNumber, &t.ExpiryDate, &t.quantity, &t.Category, &t.Witness, &t.Note); err != nil {
+			return nil, err
+		}
+		if t.Flag == 0 || t.Category == "" {
+			t.Category = defaultControlledCategory(t.Flag)
+		}
+		t.ExpiryDate = NormalizeExpiry(t.ExpiryDate)
+		txs = append(txs, t)
+	}
+	return txs, rows.Err()
+}
+
+// buildControlledRegisters は1製品の取引から帳簿を作成します。ロット別の場合はロットごとに帳簿を分けます。
+func buildControlledRegisters(m *model.ProductMaster, txs []controlledTransaction, filters model.ControlledRegisterFilters) []model.ControlledRegister {
+	tempJcshms := model.JCShms{
+		JC037: m.PackageForm, JC039: m.YjUnitName, JC044: m.YjPackUnitQty,
+		JA006: sql.NullFloat64{Float64: m.JanPackInnerQty, Valid: true},
+		JA008: sql.NullFloat64{Float64: m.JanPackUnitQty, Valid: true},
+		JA007: sql.NullString{String: fmt.Sprintf("%d", m.JanUnitCode), Valid: true},
+	}
+	base := model.ControlledRegister{
+		ProductCode: m.ProductCode,
+		YjCode:      m.YjCode,
+		ProductName: m.ProductName,
+		PackageSpec: units.FormatSimplePackageSpec(&tempJcshms),
+		YjUnitName:  m.YjUnitName,
+		DrugClass:   controlledDrugClass(m),
+	}
+
+	keyOf := func(t *controlledTransaction) lotKey {
+		if !filters.ByLot {
+			return lotKey{}
+		}
+		return newLotKey(t.LotNumber, t.ExpiryDate)
+	}
+	registers := make(map[lotKey]*model.ControlledRegister)
+	balances := make(map[lotKey]float64)
+	var order []lotKey
+	registerFor := func(key lotKey) *model.ControlledRegister {
+		if reg, ok := registers[key]; ok {
+			return reg
+		}
+		reg := base
+		reg.LotNumber, reg.ExpiryDate = key.lot, key.expiry
+		reg.Entries = make([]model.ControlledRegisterEntry, 0)
+		registers[key] = &reg
+		order = append(order, key)
+		return &reg
+	}
+	inPeriod := func(date string) bool { return filters.StartDate == "" || date >= filters.StartDate }
+	appendEntry := func(key lotKey, e model.ControlledRegisterEntry) {
+		reg := registerFor(key)
+		if !inPeriod(e.Date) {
+			reg.OpeningBalance = e.Balance
+			return
+		}
+		e.CategoryLabel = ControlledCategoryLabel(e.Category)
+		reg.TotalReceived += e.Received
+		reg.TotalIssued += e.Issued
+		if e.Category == "inventory" && math.Abs(e.Discrepancy) > stockBalanceTolerance {
+			reg.DiscrepancyCount++
+		}
+		reg.Entries = append(reg.Entries, e)
+	}
+
+	for i := 0; i < len(txs); i++ {
+		t := &txs[i]
+		if t.Flag != 0 {
+			key := keyOf(t)
+			e := t.ControlledRegisterEntry
+			switch t.Flag {
+			case 1, 4, 11:
+				e.Received = t.quantity
+				balances[key] += t.quantity
+			case 2, 3, 5, 12:
+				e.Issued = t.quantity
+				balances[key] -= t.quantity
+			}
+			e.Balance = balances[key]
+			appendEntry(key, e)
+			continue
+		}
+
+		// 同じ日の棚卸をまとめて、ロット (またはロット別でない場合は製品) ごとの実在庫とする
+		counted := make(map[lotKey]float64)
+		first := make(map[lotKey]*controlledTransaction)
+		var countedKeys []lotKey
+		j := i
+		for ; j < len(txs) && txs[j].Flag == 0 && txs[j].Date == t.Date; j++ {
+			key := keyOf(&txs[j])
+			if _, ok := first[key]; !ok {
+				first[key] = &txs[j]
+				countedKeys = append(countedKeys, key)
+			}
+			counted[key] += txs[j].quantity
+		}
+		// 帳簿残高があるのに数えられなかったロットは実在庫 0 とする
+		for _, key := range sortedLotKeys(balances) {
+			if _, ok := first[key]; !ok && math.Abs(balances[key]) > stockBalanceTolerance {
+				first[key] = &controlledTransaction{ControlledRegisterEntry: model.ControlledRegisterEntry{
+					Date: t.Date, Category: "inventory", LotNumber: key.lot, ExpiryDate: key.expiry, Closed: t.Closed,
+				}}
+				countedKeys = append(countedKeys, key)
+			}
+		}
+		for _, key := range countedKeys {
+			e := first[key].ControlledRegisterEntry
+			if !filters.ByLot {
+				e.LotNumber, e.ExpiryDate = "", ""
+			}
+			e.BookBalance = balances[key]
+			e.Counted = counted[key]
+			e.Discrepancy = e.Counted - e.BookBalance
+			if math.Abs(e.Discrepancy) < stockBalanceTolerance {
+				e.Discrepancy = 0
+			}
+			e.Balance = e.Counted
+			balances[key] = e.Counted
+			appendEntry(key, e)
+		}
+		i = j - 1
+	}
+
+	result := make([]model.ControlledRegister, 0, len(order))
+	for _, key := range order {
+		reg := registers[key]
+		reg.ClosingBalance = balances[key]
+		result = append(result, *reg)
+	}
+	if filters.ByLot {
+		sort.SliceStable(result, func(a, b int) bool {
+			if result[a].ExpiryDate != result[b].ExpiryDate {
+				return result[a].ExpiryDate < result[b].ExpiryDate
+			}
+			return result[a].LotNumber < result[b].LotNumber
+		})
+	}
+	return result
+}
+
+type counterpartyNames struct {
+	clients     map[string]string
+	wholesalers map[string]string
+}
+
+// getCounterpartyNames は帳簿の相手方 (患者・卸) の名前を取得します。
+func getCounterpartyNames(conn *sql.DB) (counterpartyNames, error) {
+	names := counterpartyNames{clients: make(map[string]string), wholesalers: make(map[string]string)}
+	clients, err := GetAllClients(conn)
+	if err != nil {
+		return names, fmt.Errorf("failed to get clients for controlled register: %w", err)
+	}
+	for _, c := range clients {
+		names.clients[c.Code] = c.Name
+	}
+	wholesalers, err := GetAllWholesalers(conn)
+	if err != nil {
+		return names, fmt.Errorf("failed to get wholesalers for controlled register: %w", err)
+	}
+	for _, w := range wholesalers {
+		names.wholesalers[w.Code] = w.Name
+	}
+	return names, nil
+}
+
+type closedRanges [][2]string
+
+func (r closedRanges) contains(date string) bool {
+	for _, rng := range r {
+		if date >= rng[0] && date <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// getClosedRanges は締め済みの期間 (開始日・終了日) の一覧を取得します。
+func getClosedRanges(dbtx DBTX) (closedRanges, error) {
+	rows, err := dbtx.Query(`SELECT start_date, end_date FROM closed_periods WHERE reopened_at = ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get closed periods: %w", err)
+	}
+	defer rows.Close()
+	var ranges closedRanges
+	for rows.Next() {
+		var rng [2]string
+		if err := rows.Scan(&rng[0], &rng[1]); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges, rows.Err()
+}
+
+/**
+ * @brief 帳簿の行 (取引) に区分・立会人・備考を記録します。
+ * @param conn データベース接続
+ * @param note 記録する内容 (区分が空の場合は取引種別の区分に戻します)
+ * @param updatedBy 記録した担当者
+ * @return error 区分が不正・取引が見つからない・伝票番号の無い取引・締め済みの期間の取引の場合、または処理中にエラーが発生した場合
+ * @details
+ * 廃棄は出庫・棚卸減の取引にのみ、立会人と共に指定できます。締め済みの期間の取引はトリガーにより変更が拒否されます。
+ * 記載は取引IDではなく伝票キー (日付・取引先・伝票番号・行番号) で保存するため、伝票の編集や再取込で取引が登録し直されても引き継がれます。
+ * 伝票番号の無い取引 (USAGEの処方データなど) は伝票キーで特定できないため記載できません。
+ */
+func SaveControlledRegisterNote(conn *sql.DB, note model.ControlledRegisterNote, updatedBy string) error {
+	var flag int
+	var date, clientCode, receiptNumber, lineNumber, janCode string
+	err := conn.QueryRow(`
+		SELECT flag, transaction_date, COALESCE(client_code, ''), COALESCE(receipt_number, ''), COALESCE(line_number, ''), COALESCE(jan_code, '')
+		FROM transaction_records WHERE id = ?`, note.TransactionID).Scan(&flag, &date, &clientCode, &receiptNumber, &lineNumber, &janCode)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("取引が見つかりません (ID: %d)", note.TransactionID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get transaction %d: %w", note.TransactionID, err)
+	}
+	if flag == 0 {
+		return fmt.Errorf("棚卸の行には区分を指定できません")
+	}
+	if receiptNumber == "" {
+		return fmt.Errorf("伝票番号の無い取引には記載できません")
+	}
+	receiving := flag == 1 || flag == 4 || flag == 11
+	switch note.Category {
+	case "", "adjust":
+	case "receipt":
+		if !receiving {
+			return fmt.Errorf("受入は入庫・納品の取引にのみ指定できます")
+		}
+	case "dispense", "transfer":
+		if receiving {
+			return fmt.Errorf("払出の区分は出庫・返品・処方の取引にのみ指定できます")
+		}
+	case "disposal":
+		if flag != 12 && flag != 5 {
+			return fmt.Errorf("廃棄は出庫・棚卸減の取引にのみ指定できます")
+		}
+		if strings.TrimSpace(note.Witness) == "" {
+			return fmt.Errorf("廃棄の場合は立会人を入力してください")
+		}
+	default:
+		return fmt.Errorf("帳簿の区分が不正です: %s", note.Category)
+	}
+
+	_, err = conn.Exec(`
+		INSERT INTO controlled_register_notes (transaction_date, client_code, receipt_number, line_number, jan_code,
+			category, witness, note, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(transaction_date, client_code, receipt_number, line_number) DO UPDATE SET
+			jan_code = excluded.jan_code, category = excluded.category, witness = excluded.witness, note = excluded.note,
+			updated_at = excluded.updated_at, updated_by = excluded.updated_by`,
+		date, clientCode, receiptNumber, lineNumber, janCode, note.Category, strings.TrimSpace(note.Witness), strings.TrimSpace(note.Note),
+		time.Now().Format("2006-01-02 15:04:05"), updatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save controlled register note for transaction %d: %w", note.TransactionID, err)
+	}
+	return nil
+}
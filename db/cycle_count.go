@@ -0,0 +1,377 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\cycle_count.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"wasabi/model"
+)
+
+// ABC分類の累積構成比の境界 (処方金額の上位80%までをA、95%までをB、残りをCとします)。
+const (
+	abcClassALimit = 0.80
+	abcClassBLimit = 0.95
+)
+
+// abcClassOf は、処方金額の多い順に並べたときの累積構成比からABC分類を返します。
+// 累積構成比はその品目を加える前の比率で判定します (1品目で80%を超える品目もAとします)。処方の無い品目はCです。
+func abcClassOf(cumulativeBefore, value, total float64) string {
+	if value <= 0 || total <= 0 {
+		return "C"
+	}
+	switch share := cumulativeBefore / total; {
+	case share < abcClassALimit:
+		return "A"
+	case share < abcClassBLimit:
+		return "B"
+	}
+	return "C"
+}
+
+// abcClassOrder はABC分類の優先順 (棚卸の頻度が高い順) です。
+var abcClassOrder = map[string]int{"A": 0, "B": 1, "C": 2}
+
+/**
+ * @brief 処方金額の累積構成比で製品をABC分類し、product_classifications を更新します。
+ * @param conn データベース接続
+ * @param today 基準日
+ * @param periodDays 処方の集計日数 (基準日を含む)
+ * @return int 分類した製品数
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 集計期間に処方がある製品と、現在庫がある製品を対象とします。処方金額は処方数量 (YJ単位) に薬価を掛けた金額です。
+ * 処方の無い製品 (金額0) はCに分類します。対象外になった製品の分類は削除します。
+ * ABC/XYZ分析で保存した分類 (product_abc_xyz_classifications) は別のテーブルのため変更しません。
+ */
+func ClassifyProductsABC(conn *sql.DB, today time.Time, periodDays int) (int, error) {
+	if periodDays <= 0 {
+		return 0, fmt.Errorf("集計日数が不正です: %d", periodDays)
+	}
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return 0, err
+	}
+	startDate := today.AddDate(0, 0, -(periodDays - 1)).Format("20060102")
+	endDate := today.Format("20060102")
+
+	rows, err := conn.Query(`
+		SELECT p.product_code, COALESCE(u.quantity, 0) * p.nhi_price
+		FROM product_master p
+		LEFT JOIN (
+			SELECT jan_code, SUM(yj_quantity) AS quantity FROM transaction_records
+			WHERE flag = 3 AND transaction_date BETWEEN ? AND ? GROUP BY jan_code
+		) u ON u.jan_code = p.product_code
+		LEFT JOIN stock_balances s ON s.product_code = p.product_code
+		WHERE u.jan_code IS NOT NULL OR ABS(COALESCE(s.base_quantity + s.net_change, 0)) > 1e-9`,
+		startDate, endDate)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get usage values for ABC classification: %w", err)
+	}
+	type usageValue struct {
+		productCode string
+		value       float64
+	}
+	var values []usageValue
+	var total float64
+	for rows.Next() {
+		var v usageValue
+		if err := rows.Scan(&v.productCode, &v.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, v)
+		total += v.value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].value != values[j].value {
+			return values[i].value > values[j].value
+		}
+		return values[i].productCode < values[j].productCode
+	})
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO product_classifications (product_code, abc_class, usage_value, classified_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(product_code) DO UPDATE SET
+			abc_class = excluded.abc_class, usage_value = excluded.usage_value, classified_at = excluded.classified_at`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	classifiedAt := time.Now().Format("2006-01-02 15:04:05")
+	var cumulative float64
+	for _, v := range values {
+		class := abcClassOf(cumulative, v.value, total)
+		cumulative += v.value
+		if _, err := stmt.Exec(v.productCode, class, v.value, classifiedAt); err != nil {
+			return 0, fmt.Errorf("failed to save product classification for %s: %w", v.productCode, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM product_classifications WHERE classified_at != ?`, classifiedAt); err != nil {
+		return 0, fmt.Errorf("failed to delete stale product classifications: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+/**
+ * @brief 分類済みの製品をYJコードごとにまとめ、前回棚卸日と棚卸期限を求めます。
+ * @param conn データベース接続
+ * @param policy 循環棚卸の条件
+ * @return []model.CycleCountItem YJコードごとの品目 (CountDate・Status は未設定)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 棚卸調整はYJコード単位で保存するため、YJコードの中で最も頻度の高い分類と、最も古い前回棚卸日を使用します。
+ * 棚番・製品名は、その分類の製品 (同じ分類の中では棚番のある製品) のものを使用します。
+ */
+func getCycleCountGroups(conn *sql.DB, policy model.CycleCountPolicy) ([]model.CycleCountItem, error) {
+	lastCountDates, err := GetLastInventoryDateMap(conn)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(`
+		SELECT p.product_code, p.yj_code, p.product_name, p.shelf_number, c.abc_class
+		FROM product_classifications c JOIN product_master p ON p.product_code = c.product_code
+		WHERE p.yj_code != ''
+		ORDER BY p.yj_code, p.product_code`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get classified products: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*model.CycleCountItem)
+	neverCounted := make(map[string]bool)
+	var order []string
+	for rows.Next() {
+		var productCode, yjCode, productName, shelfNumber, class string
+		if err := rows.Scan(&productCode, &yjCode, &productName, &shelfNumber, &class); err != nil {
+			return nil, err
+		}
+		group, ok := groups[yjCode]
+		if !ok {
+			group = &model.CycleCountItem{YjCode: yjCode, ProductName: productName, ShelfNumber: shelfNumber, AbcClass: class}
+			groups[yjCode] = group
+			order = append(order, yjCode)
+		} else if abcClassOrder[class] < abcClassOrder[group.AbcClass] || (class == group.AbcClass && group.ShelfNumber == "" && shelfNumber != "") {
+			group.AbcClass = class
+			group.ProductName = productName
+			group.ShelfNumber = shelfNumber
+		}
+
+		last := lastCountDates[productCode]
+		if last == "" {
+			neverCounted[yjCode] = true
+		} else if group.LastCountDate == "" || last < group.LastCountDate {
+			group.LastCountDate = last
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]model.CycleCountItem, 0, len(order))
+	for _, yjCode := range order {
+		group := groups[yjCode]
+		// 棚卸していない包装がある場合は、YJコードとして未実施とする
+		if neverCounted[yjCode] {
+			group.LastCountDate = ""
+		}
+		if group.LastCountDate != "" {
+			last, err := time.Parse("20060102", group.LastCountDate)
+			if err == nil {
+				group.DueDate = last.AddDate(0, 0, policy.IntervalDays[group.AbcClass]).Format("20060102")
+			}
+		}
+		result = append(result, *group)
+	}
+	return result, nil
+}
+
+/**
+ * @brief 指定日の循環棚卸リストを作成します。
+ * @param conn データベース接続
+ * @param date 棚卸予定日 (YYYYMMDD)
+ * @param policy 循環棚卸の条件
+ * @return []model.CycleCountShelf 棚番ごとの棚卸リスト
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 棚卸期限が予定日以前の品目 (未実施を含む) を、分類の頻度が高い順・期限の古い順に1日の上限まで選びます。
+ * 同じ日のリストを作り直す場合、実施済みの行は残し、未実施の行を置き換えます。
+ * 分類が1件も無い場合と、最後の分類から処方の集計日数 (UsagePeriodDays) 以上経過している場合は、先にABC分類をやり直します。
+ */
+func GenerateCycleCountList(conn *sql.DB, date string, policy model.CycleCountPolicy) ([]model.CycleCountShelf, error) {
+	countDate, err := time.Parse("20060102", date)
+	if err != nil {
+		return nil, fmt.Errorf("棚卸予定日が不正です: %s", date)
+	}
+	var lastClassifiedAt string
+	if err := conn.QueryRow(`SELECT COALESCE(MAX(classified_at), '') FROM product_classifications`).Scan(&lastClassifiedAt); err != nil {
+		return nil, err
+	}
+	staleBefore := time.Now().AddDate(0, 0, -policy.UsagePeriodDays).Format("2006-01-02 15:04:05")
+	if lastClassifiedAt == "" || lastClassifiedAt < staleBefore {
+		if _, err := ClassifyProductsABC(conn, countDate, policy.UsagePeriodDays); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := getCycleCountGroups(conn, policy)
+	if err != nil {
+		return nil, err
+	}
+	var due []model.CycleCountItem
+	for _, g := range groups {
+		if g.DueDate == "" || g.DueDate <= date {
+			due = append(due, g)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if abcClassOrder[due[i].AbcClass] != abcClassOrder[due[j].AbcClass] {
+			return abcClassOrder[due[i].AbcClass] < abcClassOrder[due[j].AbcClass]
+		}
+		return due[i].DueDate < due[j].DueDate
+	})
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM cycle_count_items WHERE count_date = ? AND status = 'pending'`, date); err != nil {
+		return nil, fmt.Errorf("failed to clear pending cycle count items: %w", err)
+	}
+	var doneCount int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM cycle_count_items WHERE count_date = ?`, date).Scan(&doneCount); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`
+		INSERT OR IGNORE INTO cycle_count_items (count_date, yj_code, product_name, shelf_number, abc_class, last_count_date, due_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	added := 0
+	for _, g := range due {
+		if policy.DailyLimit > 0 && doneCount+added >= policy.DailyLimit {
+			break
+		}
+		res, err := stmt.Exec(date, g.YjCode, g.ProductName, g.ShelfNumber, g.AbcClass, g.LastCountDate, g.DueDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert cycle count item for %s: %w", g.YjCode, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetCycleCountList(conn, date)
+}
+
+/**
+ * @brief 指定日の循環棚卸リストを棚番ごとに取得します。
+ * @param conn データベース接続
+ * @param date 棚卸予定日 (YYYYMMDD)
+ * @return []model.CycleCountShelf 棚番順の棚卸リスト (棚番の無い品目は最後)
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetCycleCountList(conn *sql.DB, date string) ([]model.CycleCountShelf, error) {
+	rows, err := conn.Query(`
+		SELECT count_date, yj_code, product_name, shelf_number, abc_class, last_count_date, due_date, status, completed_at
+		FROM cycle_count_items WHERE count_date = ?
+		ORDER BY shelf_number = '', shelf_number, product_name`, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cycle count items: %w", err)
+	}
+	defer rows.Close()
+
+	shelves := make([]model.CycleCountShelf, 0)
+	for rows.Next() {
+		var item model.CycleCountItem
+		if err := rows.Scan(&item.CountDate, &item.YjCode, &item.ProductName, &item.ShelfNumber, &item.AbcClass,
+			&item.LastCountDate, &item.DueDate, &item.Status, &item.CompletedAt); err != nil {
+			return nil, err
+		}
+		if n := len(shelves); n == 0 || shelves[n-1].ShelfNumber != item.ShelfNumber {
+			shelves = append(shelves, model.CycleCountShelf{ShelfNumber: item.ShelfNumber})
+		}
+		shelves[len(shelves)-1].Items = append(shelves[len(shelves)-1].Items, item)
+	}
+	return shelves, rows.Err()
+}
+
+/**
+ * @brief 棚卸の保存に合わせて、循環棚卸リストの未実施の行を実施済みにします。
+ * @param tx トランザクション
+ * @param date 棚卸日 (YYYYMMDD)
+ * @param yjCodes 棚卸したYJコード
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 棚卸日以前の予定日の行が対象です (前日までに実施できなかった行も、棚卸した時点で実施済みになります)。
+ */
+func CompleteCycleCountItemsInTx(tx *sql.Tx, date string, yjCodes []string) error {
+	if len(yjCodes) == 0 {
+		return nil
+	}
+	args := []interface{}{date, date}
+	for _, code := range yjCodes {
+		args = append(args, code)
+	}
+	q := `UPDATE cycle_count_items SET status = 'done', completed_at = ?
+		WHERE status = 'pending' AND count_date <= ? AND yj_code IN (?` + strings.Repeat(",?", len(yjCodes)-1) + `)`
+	if _, err := tx.Exec(q, args...); err != nil {
+		return fmt.Errorf("failed to complete cycle count items: %w", err)
+	}
+	return nil
+}
+
+/**
+ * @brief ABC分類ごとの棚卸の実施状況を集計します。
+ * @param conn データベース接続
+ * @param today 基準日
+ * @param policy 循環棚卸の条件
+ * @return []model.CycleCountClassSummary A・B・Cの順の集計
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetCycleCountSummary(conn *sql.DB, today time.Time, policy model.CycleCountPolicy) ([]model.CycleCountClassSummary, error) {
+	groups, err := getCycleCountGroups(conn, policy)
+	if err != nil {
+		return nil, err
+	}
+	todayStr := today.Format("20060102")
+	summaries := []model.CycleCountClassSummary{{AbcClass: "A"}, {AbcClass: "B"}, {AbcClass: "C"}}
+	for i := range summaries {
+		summaries[i].IntervalDays = policy.IntervalDays[summaries[i].AbcClass]
+	}
+	for _, g := range groups {
+		idx, ok := abcClassOrder[g.AbcClass]
+		if !ok {
+			continue
+		}
+		s := &summaries[idx]
+		s.GroupCount++
+		if g.LastCountDate == "" {
+			s.NeverCounted++
+			s.OverdueCount++
+		} else if g.DueDate < todayStr {
+			s.OverdueCount++
+		}
+	}
+	return summaries, nil
+}
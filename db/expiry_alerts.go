@@ -0,0 +1,216 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\expiry_alerts.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"wasabi/model"
+	"wasabi/units"
+)
+
+/**
+ * @brief 有効期限が指定日数以内のロットの在庫を取得します。
+ * @param conn データベース接続
+ * @param today 基準日
+ * @param days 基準日からの日数 (期限切れのロットも含みます)
+ * @return []model.ExpiryAlertItem 期限の近い順の在庫
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * ロット別在庫 (lot_balances) の期限付きの在庫を対象とします。
+ * 期限付きのロット別在庫が無い製品は、デッドストックリストに登録された期限・数量を現在庫の範囲で使用します。
+ * 金額は在庫評価と同じく、薬価はYJ単位の薬価、納入価は包装単位の納入価をYJ包装数量で割った単価で計算します。
+ */
+func GetExpiryAlerts(conn *sql.DB, today time.Time, days int) ([]model.ExpiryAlertItem, error) {
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return nil, err
+	}
+	todayStr := today.Format("2006-01-02")
+	limit := today.AddDate(0, 0, days).Format("2006-01-02")
+
+	var items []model.ExpiryAlertItem
+	rows, err := conn.Query(`
+		SELECT product_code, lot_number, expiry_date, quantity FROM lot_balances
+		WHERE quantity > 0 AND expiry_date != '' AND expiry_date <= ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expiring lots: %w", err)
+	}
+	for rows.Next() {
+		item := model.ExpiryAlertItem{Source: "lot"}
+		if err := rows.Scan(&item.ProductCode, &item.LotNumber, &item.ExpiryDate, &item.Quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deadStockItems, err := getDeadStockExpiryItems(conn, limit)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, deadStockItems...)
+	if len(items) == 0 {
+		return []model.ExpiryAlertItem{}, nil
+	}
+
+	codeSet := make(map[string]bool)
+	var codes []string
+	for _, item := range items {
+		if !codeSet[item.ProductCode] {
+			codeSet[item.ProductCode] = true
+			codes = append(codes, item.ProductCode)
+		}
+	}
+	masters, err := GetProductMastersByCodesMap(conn, codes)
+	if err != nil {
+		return nil, err
+	}
+	lastUsageDates, err := getLastTransactionDateMap(conn, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last usage dates: %w", err)
+	}
+
+	todayDate, _ := time.Parse("2006-01-02", todayStr)
+	for i := range items {
+		item := &items[i]
+		if expiry, err := time.Parse("2006-01-02", item.ExpiryDate); err == nil {
+			item.DaysLeft = int(math.Round(expiry.Sub(todayDate).Hours() / 24))
+		}
+		item.LastUsageDate = lastUsageDates[item.ProductCode]
+
+		master, ok := masters[item.ProductCode]
+		if !ok {
+			item.ProductName = item.ProductCode
+			continue
+		}
+		tempJcshms := model.JCShms{
+			JC037: master.PackageForm, JC039: master.YjUnitName, JC044: master.YjPackUnitQty,
+			JA006: sql.NullFloat64{Float64: master.JanPackInnerQty, Valid: true},
+			JA008: sql.NullFloat64{Float64: master.JanPackUnitQty, Valid: true},
+			JA007: sql.NullString{String: fmt.Sprintf("%d", master.JanUnitCode), Valid: true},
+		}
+		item.YjCode = master.YjCode
+		item.ProductName = master.ProductName
+		item.PackageSpec = units.FormatSimplePackageSpec(&tempJcshms)
+		item.ShelfNumber = master.ShelfNumber
+		item.YjUnitName = master.YjUnitName
+		item.NhiValue = item.Quantity * master.NhiPrice
+		if master.YjPackUnitQty > 0 {
+			item.PurchaseValue = item.Quantity * master.PurchasePrice / master.YjPackUnitQty
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].ExpiryDate != items[j].ExpiryDate {
+			return items[i].ExpiryDate < items[j].ExpiryDate
+		}
+		if items[i].ProductName != items[j].ProductName {
+			return items[i].ProductName < items[j].ProductName
+		}
+		return items[i].LotNumber < items[j].LotNumber
+	})
+	return items, nil
+}
+
+// getDeadStockExpiryItems は期限付きのロット別在庫が無い製品について、デッドストックリストの期限・数量から期限切迫の在庫を作成します。
+// 数量は期限の近い順に現在庫を上限として割り当てます。
+func getDeadStockExpiryItems(conn *sql.DB, limit string) ([]model.ExpiryAlertItem, error) {
+	rows, err := conn.Query(`
+		SELECT d.product_code, COALESCE(d.lot_number, ''), COALESCE(d.expiry_date, ''),
+			d.stock_quantity_jan * COALESCE(NULLIF(d.jan_pack_inner_qty, 0), p.jan_pack_inner_qty, 0)
+		FROM dead_stock_list d
+		LEFT JOIN product_master p ON p.product_code = d.product_code
+		WHERE d.stock_quantity_jan > 0
+		  AND NOT EXISTS (SELECT 1 FROM lot_balances l WHERE l.product_code = d.product_code AND l.expiry_date != '')`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead stock expiry dates: %w", err)
+	}
+	recordsByProduct := make(map[string][]model.ExpiryAlertItem)
+	for rows.Next() {
+		item := model.ExpiryAlertItem{Source: "deadstock"}
+		var expiry string
+		if err := rows.Scan(&item.ProductCode, &item.LotNumber, &expiry, &item.Quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if item.ExpiryDate = NormalizeExpiry(expiry); item.ExpiryDate == "" {
+			continue
+		}
+		recordsByProduct[item.ProductCode] = append(recordsByProduct[item.ProductCode], item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(recordsByProduct) == 0 {
+		return nil, nil
+	}
+
+	stockMap, err := GetAllCurrentStockMap(conn)
+	if err != nil {
+		return nil, err
+	}
+	var items []model.ExpiryAlertItem
+	for code, records := range recordsByProduct {
+		sort.SliceStable(records, func(i, j int) bool { return records[i].ExpiryDate < records[j].ExpiryDate })
+		remaining := stockMap[code]
+		for _, rec := range records {
+			if remaining <= stockBalanceTolerance {
+				break
+			}
+			rec.Quantity = math.Min(rec.Quantity, remaining)
+			remaining -= rec.Quantity
+			if rec.ExpiryDate <= limit {
+				items = append(items, rec)
+			}
+		}
+	}
+	return items, nil
+}
+
+/**
+ * @brief 有効期限切迫の在庫を期間ごとに集計します。
+ * @param conn データベース接続
+ * @param today 基準日
+ * @param windows 集計する日数のリスト (例: 30, 60, 90)
+ * @return []model.ExpiryAlertWindow 期限切れ (Days = 0) と各日数以内の件数・金額
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 各日数の集計は期限切れを除き、短い日数の分も含みます (60日以内には30日以内の在庫も含む)。
+ */
+func GetExpiryAlertSummary(conn *sql.DB, today time.Time, windows []int) ([]model.ExpiryAlertWindow, error) {
+	maxDays := 0
+	for _, d := range windows {
+		if d > maxDays {
+			maxDays = d
+		}
+	}
+	items, err := GetExpiryAlerts(conn, today, maxDays)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := make([]model.ExpiryAlertWindow, 0, len(windows)+1)
+	summary = append(summary, model.ExpiryAlertWindow{Days: 0})
+	for _, d := range windows {
+		summary = append(summary, model.ExpiryAlertWindow{Days: d})
+	}
+	for _, item := range items {
+		for i := range summary {
+			w := &summary[i]
+			if (w.Days == 0 && item.DaysLeft < 0) || (w.Days > 0 && item.DaysLeft >= 0 && item.DaysLeft <= w.Days) {
+				w.ItemCount++
+				w.NhiValue += item.NhiValue
+				w.PurchaseValue += item.PurchaseValue
+			}
+		}
+	}
+	return summary, nil
+}
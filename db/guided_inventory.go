@@ -17,11 +17,31 @@ func SaveGuidedInventoryData(tx *sql.Tx, date string, yjCode string, allPackagin
 		mastersMap[pkg.ProductCode] = &p
 	}
 
+	// 棚卸を登録すると理論在庫との差が在庫計算の起点に吸収されるため、削除・登録の前に差異を記録する
+	varianceMasters := make([]*model.ProductMaster, 0, len(allProductCodes))
+	countedYj := make(map[string]float64, len(allProductCodes))
+	for _, code := range allProductCodes {
+		m := mastersMap[code]
+		varianceMasters = append(varianceMasters, m)
+		countedYj[code] = inventoryData[code] * m.JanPackInnerQty
+	}
+	if err := RecordInventoryVariancesInTx(tx, date, varianceMasters, countedYj, "guided"); err != nil {
+		return fmt.Errorf("failed to record inventory variances: %w", err)
+	}
+	if err := CompleteCycleCountItemsInTx(tx, date, []string{yjCode}); err != nil {
+		return err
+	}
+
 	if len(allProductCodes) > 0 {
+		// 締め済みの期間の棚卸は、締め時点の在庫評価の根拠として残す
+		latestClosed, err := GetLatestClosedDate(tx)
+		if err != nil {
+			return err
+		}
 		placeholders := strings.Repeat("?,", len(allProductCodes)-1) + "?"
-		pastDeleteQuery := fmt.Sprintf(`DELETE FROM transaction_records WHERE flag = 0 AND transaction_date < ? AND jan_code IN (%s)`, placeholders)
-		args := make([]interface{}, 0, len(allProductCodes)+1)
-		args = append(args, date)
+		pastDeleteQuery := fmt.Sprintf(`DELETE FROM transaction_records WHERE flag = 0 AND transaction_date < ? AND transaction_date > ? AND jan_code IN (%s)`, placeholders)
+		args := make([]interface{}, 0, len(allProductCodes)+2)
+		args = append(args, date, latestClosed)
 		for _, code := range allProductCodes {
 			args = append(args, code)
 		}
@@ -54,6 +74,14 @@ INSERT INTO transaction_records (
 	receiptNumber := fmt.Sprintf("ADJ-%s-%s", date, yjCode)
 	var productCodesWithInventory []string
 
+	// ロット・期限を入力した数量は、ロット別在庫の起点とするためロットごとの棚卸レコードとして登録する
+	lotsByProduct := make(map[string][]model.DeadStockRecord)
+	for _, ds := range deadstockData {
+		if ds.StockQuantityJan > 0 && (ds.LotNumber != "" || ds.ExpiryDate != "") {
+			lotsByProduct[ds.ProductCode] = append(lotsByProduct[ds.ProductCode], ds)
+		}
+	}
+
 	for i, productCode := range allProductCodes {
 		master, ok := mastersMap[productCode]
 		if !ok {
@@ -65,35 +93,59 @@ INSERT INTO transaction_records (
 			productCodesWithInventory = append(productCodesWithInventory, productCode)
 		}
 
+		lots := lotsByProduct[productCode]
+		lotTotal := 0.0
+		for _, lot := range lots {
+			lotTotal += lot.StockQuantityJan
+		}
+		if lotTotal > janQty+1e-9 {
+			return fmt.Errorf("ロット別数量の合計(%g)が棚卸数量(%g)を超えています (JAN: %s)", lotTotal, janQty, productCode)
+		}
+
 		tr := model.TransactionRecord{
 			TransactionDate: date,
 			Flag:            0,
 			ReceiptNumber:   receiptNumber,
 			LineNumber:      fmt.Sprintf("%d", i+1),
-			JanQuantity:     janQty,
+			JanQuantity:     janQty - lotTotal,
 			ProcessFlagMA:   "COMPLETE",
 		}
+		records := make([]model.TransactionRecord, 0, len(lots)+1)
+		// ロット不明の残数 (ロット入力が無い場合は棚卸数量全体。0の場合も棚卸済みとして登録する)
+		if len(lots) == 0 || tr.JanQuantity > 1e-9 {
+			records = append(records, tr)
+		}
+		for j, lot := range lots {
+			lotRecord := tr
+			lotRecord.LineNumber = fmt.Sprintf("%d-%d", i+1, j+1)
+			lotRecord.JanQuantity = lot.StockQuantityJan
+			lotRecord.ExpiryDate = lot.ExpiryDate
+			lotRecord.LotNumber = lot.LotNumber
+			records = append(records, lotRecord)
+		}
 
-		tr.YjQuantity = janQty * master.JanPackInnerQty
-		mappers.MapProductMasterToTransaction(&tr, master)
-		tr.ClientCode = ""
-		tr.SupplierWholesale = ""
-
-		// ▼▼▼【修正】Subtotalを計算する処理を追加 ▼▼▼
-		tr.Subtotal = tr.YjQuantity * tr.UnitPrice
-		// ▲▲▲【修正ここまで】▲▲▲
-
-		_, err := stmt.Exec(
-			tr.TransactionDate, tr.ClientCode, tr.ReceiptNumber, tr.LineNumber, tr.Flag,
-			tr.JanCode, tr.YjCode, tr.ProductName, tr.KanaName, tr.UsageClassification, tr.PackageForm, tr.PackageSpec, tr.MakerName,
-			tr.DatQuantity, tr.JanPackInnerQty, tr.JanQuantity, tr.JanPackUnitQty, tr.JanUnitName, tr.JanUnitCode,
-			tr.YjQuantity, tr.YjPackUnitQty, tr.YjUnitName, tr.UnitPrice, tr.PurchasePrice, tr.SupplierWholesale,
-			tr.Subtotal, tr.TaxAmount, tr.TaxRate, tr.ExpiryDate, tr.LotNumber, tr.FlagPoison,
-			tr.FlagDeleterious, tr.FlagNarcotic, tr.FlagPsychotropic, tr.FlagStimulant,
-			tr.FlagStimulantRaw, tr.ProcessFlagMA,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert inventory record for %s: %w", productCode, err)
+		for _, tr := range records {
+			tr.YjQuantity = tr.JanQuantity * master.JanPackInnerQty
+			mappers.MapProductMasterToTransaction(&tr, master)
+			tr.ClientCode = ""
+			tr.SupplierWholesale = ""
+
+			// ▼▼▼【修正】Subtotalを計算する処理を追加 ▼▼▼
+			tr.Subtotal = tr.YjQuantity * tr.UnitPrice
+			// ▲▲▲【修正ここまで】▲▲▲
+
+			_, err := stmt.Exec(
+				tr.TransactionDate, tr.ClientCode, tr.ReceiptNumber, tr.LineNumber, tr.Flag,
+				tr.JanCode, tr.YjCode, tr.ProductName, tr.KanaName, tr.UsageClassification, tr.PackageForm, tr.PackageSpec, tr.MakerName,
+				tr.DatQuantity, tr.JanPackInnerQty, tr.JanQuantity, tr.JanPackUnitQty, tr.JanUnitName, tr.JanUnitCode,
+				tr.YjQuantity, tr.YjPackUnitQty, tr.YjUnitName, tr.UnitPrice, tr.PurchasePrice, tr.SupplierWholesale,
+				tr.Subtotal, tr.TaxAmount, tr.TaxRate, tr.ExpiryDate, tr.LotNumber, tr.FlagPoison,
+				tr.FlagDeleterious, tr.FlagNarcotic, tr.FlagPsychotropic, tr.FlagStimulant,
+				tr.FlagStimulantRaw, tr.ProcessFlagMA,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert inventory record for %s: %w", productCode, err)
+			}
 		}
 	}
 
@@ -0,0 +1,394 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\import_batches.go
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wasabi/model"
+)
+
+// ErrDuplicateImport は同一内容のファイルが既に取り込まれている場合に返されます。
+var ErrDuplicateImport = errors.New("identical file has already been imported")
+
+// ErrImportBatchNotFound は指定した取込バッチが存在しない場合に返されます。
+var ErrImportBatchNotFound = errors.New("import batch not found")
+
+// ErrImportBatchConflict は取込バッチの状態や後続のデータと競合してロールバックできない場合に返されます。
+var ErrImportBatchConflict = errors.New("import batch cannot be rolled back")
+
+// transactionDataColumns は transaction_records のうち id を除いたデータ列です。
+// import_batch_displaced_records も同じ列を持ち、退避と復元に使用します。
+var transactionDataColumns = strings.TrimPrefix(strings.TrimSpace(TransactionColumns), "id,") + ", import_batch_id"
+
+/**
+ * @brief 取込バッチを作成します。同一ハッシュの有効なバッチが既にあれば ErrDuplicateImport を返します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param sourceType 取込元の種別 ("DAT", "USAGE", "INVENTORY")
+ * @param fileName 取り込んだファイル名
+ * @param fileHash ファイル内容のSHA-256 (16進数文字列)
+ * @return int64 作成されたバッチID
+ * @return error 処理中にエラーが発生した場合
+ */
+func CreateImportBatchInTx(tx *sql.Tx, sourceType, fileName, fileHash string) (int64, error) {
+	var existingID int
+	var importedAt string
+	err := tx.QueryRow(`
+		SELECT id, imported_at FROM import_batches
+		WHERE source_type = ? AND file_hash = ? AND status = 'ACTIVE'
+		ORDER BY id LIMIT 1`, sourceType, fileHash).Scan(&existingID, &importedAt)
+	if err == nil {
+		return 0, fmt.Errorf("%w (batch #%d, imported at %s)", ErrDuplicateImport, existingID, importedAt)
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to check duplicate import batch: %w", err)
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO import_batches (source_type, file_name, file_hash, imported_at)
+		VALUES (?, ?, ?, ?)`,
+		sourceType, fileName, fileHash, time.Now().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create import batch: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+/**
+ * @brief 取込で置換・削除される既存の取引レコードを退避します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param batchID 取込バッチID
+ * @param where transaction_records に対する WHERE 句 (プレースホルダ使用可)
+ * @param args WHERE 句のパラメータ
+ * @return []int 退避したレコードのID
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 退避したレコードは、バッチをロールバックした際に元のIDのまま復元されます。
+ * レコードの削除自体は呼び出し側（INSERT OR REPLACE や DELETE）で行います。
+ */
+func SnapshotDisplacedRecordsInTx(tx *sql.Tx, batchID int64, where string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(`SELECT id FROM transaction_records WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query displaced records: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	q := `INSERT INTO import_batch_displaced_records (batch_id, original_id, ` + transactionDataColumns + `)
+		SELECT ?, id, ` + transactionDataColumns + ` FROM transaction_records WHERE ` + where
+	if _, err := tx.Exec(q, append([]interface{}{batchID}, args...)...); err != nil {
+		return nil, fmt.Errorf("failed to snapshot displaced records: %w", err)
+	}
+	return ids, nil
+}
+
+/**
+ * @brief 条件に一致する取引レコードを取込バッチに紐付けます。
+ * @param tx SQLトランザクションオブジェクト
+ * @param batchID 取込バッチID
+ * @param where transaction_records に対する WHERE 句 (プレースホルダ使用可)
+ * @param args WHERE 句のパラメータ
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 共通の登録関数 (PersistTransactionRecordsInTx など) で登録したレコードに、後からバッチIDを設定する場合に使用します。
+ */
+func AssignImportBatchInTx(tx *sql.Tx, batchID int64, where string, args ...interface{}) error {
+	q := `UPDATE transaction_records SET import_batch_id = ? WHERE ` + where
+	if _, err := tx.Exec(q, append([]interface{}{batchID}, args...)...); err != nil {
+		return fmt.Errorf("failed to assign import batch %d: %w", batchID, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 取込バッチに、そのバッチで消し込んだ発注残を記録します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param batchID 取込バッチID
+ * @param reconciled ReconcileBackordersInTx が返した消し込み明細
+ * @return error 処理中にエラーが発生した場合
+ */
+func RecordBatchBackordersInTx(tx *sql.Tx, batchID int64, reconciled []model.BackorderReconciliation) error {
+	if len(reconciled) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO import_batch_backorders (
+			batch_id, backorder_id, order_date, yj_code, product_name, package_form, jan_pack_inner_qty,
+			yj_unit_name, order_quantity, wholesaler_code, yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code,
+			remaining_before, consumed_quantity, product_code, po_number
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch backorder insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range reconciled {
+		if _, err := stmt.Exec(
+			batchID, r.BackorderID, r.OrderDate, r.YjCode, r.ProductName, r.PackageForm, r.JanPackInnerQty,
+			r.YjUnitName, r.OrderQuantity, r.WholesalerCode, r.YjPackUnitQty, r.JanPackUnitQty, r.JanUnitCode,
+			r.RemainingBefore, r.ConsumedQuantity, r.ProductCode, r.PoNumber,
+		); err != nil {
+			return fmt.Errorf("failed to record reconciled backorder id %d: %w", r.BackorderID, err)
+		}
+	}
+
+	for _, r := range reconciled {
+		if _, err := tx.Exec(`UPDATE backorder_fulfillments SET import_batch_id = ? WHERE id = ?`, batchID, r.FulfillmentID); err != nil {
+			return fmt.Errorf("failed to link backorder fulfillment to import batch %d: %w", batchID, err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE import_batches SET backorder_count = backorder_count + ? WHERE id = ?`, len(reconciled), batchID)
+	return err
+}
+
+/**
+ * @brief 取込バッチの件数（登録件数・退避件数）を集計して更新します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param batchID 取込バッチID
+ * @return error 処理中にエラーが発生した場合
+ */
+func FinalizeImportBatchInTx(tx *sql.Tx, batchID int64) error {
+	const q = `
+		UPDATE import_batches SET
+			record_count = (SELECT COUNT(*) FROM transaction_records WHERE import_batch_id = ?),
+			displaced_count = (SELECT COUNT(*) FROM import_batch_displaced_records WHERE batch_id = ?)
+		WHERE id = ?`
+	if _, err := tx.Exec(q, batchID, batchID, batchID); err != nil {
+		return fmt.Errorf("failed to finalize import batch %d: %w", batchID, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 1件もレコードを登録しなかった取込バッチを削除します。
+ * @param conn データベース接続
+ * @param batchID 取込バッチID
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 複数のトランザクションにまたがる取込で全て失敗した場合に、同一ファイルの再取込を妨げないよう台帳から取り除きます。
+ */
+func DeleteEmptyImportBatch(conn *sql.DB, batchID int64) error {
+	const q = `
+		DELETE FROM import_batches WHERE id = ?
+		AND NOT EXISTS (SELECT 1 FROM transaction_records WHERE import_batch_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM import_batch_displaced_records WHERE batch_id = ?)`
+	if _, err := conn.Exec(q, batchID, batchID, batchID); err != nil {
+		return fmt.Errorf("failed to delete empty import batch %d: %w", batchID, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 同一内容のファイルが有効な取込バッチとして登録済みかを判定します。
+ * @param conn データベース接続
+ * @param sourceType 取込元の種別 ("DAT", "USAGE", "INVENTORY")
+ * @param fileHash ファイル内容のSHA-256 (16進数文字列)
+ * @return bool 登録済みの場合は true
+ * @return error 処理中にエラーが発生した場合
+ */
+func IsFileImported(conn DBTX, sourceType, fileHash string) (bool, error) {
+	var exists bool
+	err := conn.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM import_batches WHERE source_type = ? AND file_hash = ? AND status = 'ACTIVE')`,
+		sourceType, fileHash).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check imported file: %w", err)
+	}
+	return exists, nil
+}
+
+/**
+ * @brief 取込バッチの一覧を新しい順に取得します。
+ * @param conn データベース接続
+ * @param sourceType 取込元の種別で絞り込む場合に指定 (空文字の場合は全件)
+ * @return []model.ImportBatch 取込バッチのスライス
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetImportBatches(conn *sql.DB, sourceType string) ([]model.ImportBatch, error) {
+	q := `SELECT id, source_type, file_name, file_hash, imported_at, record_count, displaced_count,
+			backorder_count, status, COALESCE(rolled_back_at, '')
+		FROM import_batches`
+	var args []interface{}
+	if sourceType != "" {
+		q += ` WHERE source_type = ?`
+		args = append(args, sourceType)
+	}
+	q += ` ORDER BY id DESC`
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get import batches: %w", err)
+	}
+	defer rows.Close()
+
+	batches := make([]model.ImportBatch, 0)
+	for rows.Next() {
+		var b model.ImportBatch
+		if err := rows.Scan(&b.ID, &b.SourceType, &b.FileName, &b.FileHash, &b.ImportedAt, &b.RecordCount,
+			&b.DisplacedCount, &b.BackorderCount, &b.Status, &b.RolledBackAt); err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+	return batches, nil
+}
+
+/**
+ * @brief 取込バッチをロールバックします。
+ * @param tx SQLトランザクションオブジェクト
+ * @param batchID 取込バッチID
+ * @return error 存在しない場合は ErrImportBatchNotFound、取消済みや後続データと競合する場合は ErrImportBatchConflict をラップしたエラー
+ * @details
+ * 1. バッチで登録された取引レコードを削除します。
+ * 2. バッチで置換・削除された既存レコードを元のIDで復元します。
+ * 3. バッチの納品で消し込まれた発注残を復元します（削除されていた場合は再作成）。
+ * 後続の有効なバッチがこのバッチのレコードを置換している場合は、先にそちらをロールバックする必要があります。
+ * 復元するレコードと同じIDまたは伝票キーのレコードが既に存在する場合は、上書きせずにロールバックを中止します。
+ */
+func RollbackImportBatchInTx(tx *sql.Tx, batchID int64) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM import_batches WHERE id = ?`, batchID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: id %d", ErrImportBatchNotFound, batchID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get import batch %d: %w", batchID, err)
+	}
+	if status != "ACTIVE" {
+		return fmt.Errorf("%w: batch %d has already been rolled back", ErrImportBatchConflict, batchID)
+	}
+
+	var laterBatchID int
+	err = tx.QueryRow(`
+		SELECT d.batch_id FROM import_batch_displaced_records d
+		JOIN import_batches b ON b.id = d.batch_id
+		WHERE d.import_batch_id = ? AND b.status = 'ACTIVE'
+		LIMIT 1`, batchID).Scan(&laterBatchID)
+	if err == nil {
+		return fmt.Errorf("%w: records of batch %d were replaced by batch %d; roll back batch %d first", ErrImportBatchConflict, batchID, laterBatchID, laterBatchID)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check later import batches: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM transaction_records WHERE import_batch_id = ?`, batchID); err != nil {
+		return fmt.Errorf("failed to delete records of import batch %d: %w", batchID, err)
+	}
+
+	// 後から登録された伝票を消さないよう、復元先が空いていることを確認してから挿入する
+	var conflictID int64
+	var conflictDate, conflictReceipt string
+	err = tx.QueryRow(`
+		SELECT t.id, t.transaction_date, t.receipt_number
+		FROM import_batch_displaced_records d
+		JOIN transaction_records t ON t.id = d.original_id
+			OR (d.receipt_number != '' AND t.transaction_date = d.transaction_date AND t.client_code = d.client_code
+				AND t.receipt_number = d.receipt_number AND t.line_number = d.line_number)
+		WHERE d.batch_id = ?
+		LIMIT 1`, batchID).Scan(&conflictID, &conflictDate, &conflictReceipt)
+	if err == nil {
+		return fmt.Errorf("%w: record %d (date %s, receipt %s) was registered after batch %d and would be overwritten",
+			ErrImportBatchConflict, conflictID, conflictDate, conflictReceipt, batchID)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check conflicting records: %w", err)
+	}
+
+	restoreQuery := `INSERT INTO transaction_records (id, ` + transactionDataColumns + `)
+		SELECT original_id, ` + transactionDataColumns + ` FROM import_batch_displaced_records WHERE batch_id = ?`
+	if _, err := tx.Exec(restoreQuery, batchID); err != nil {
+		return fmt.Errorf("failed to restore displaced records of import batch %d: %w", batchID, err)
+	}
+
+	if err := restoreBatchBackordersInTx(tx, batchID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE import_batches SET status = 'ROLLED_BACK', rolled_back_at = ? WHERE id = ?`,
+		time.Now().Format("2006-01-02 15:04:05"), batchID)
+	if err != nil {
+		return fmt.Errorf("failed to update import batch status: %w", err)
+	}
+	return nil
+}
+
+// restoreBatchBackordersInTx はバッチで消し込まれた発注残を元に戻します。
+func restoreBatchBackordersInTx(tx *sql.Tx, batchID int64) error {
+	rows, err := tx.Query(`
+		SELECT backorder_id, order_date, yj_code, product_name, package_form, jan_pack_inner_qty,
+			yj_unit_name, order_quantity, wholesaler_code, yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code,
+			consumed_quantity, product_code, po_number
+		FROM import_batch_backorders WHERE batch_id = ?`, batchID)
+	if err != nil {
+		return fmt.Errorf("failed to get reconciled backorders of import batch %d: %w", batchID, err)
+	}
+	var items []model.BackorderReconciliation
+	for rows.Next() {
+		var r model.BackorderReconciliation
+		var productName sql.NullString
+		if err := rows.Scan(&r.BackorderID, &r.OrderDate, &r.YjCode, &productName, &r.PackageForm, &r.JanPackInnerQty,
+			&r.YjUnitName, &r.OrderQuantity, &r.WholesalerCode, &r.YjPackUnitQty, &r.JanPackUnitQty, &r.JanUnitCode,
+			&r.ConsumedQuantity, &r.ProductCode, &r.PoNumber); err != nil {
+			rows.Close()
+			return err
+		}
+		r.ProductName = productName.String
+		items = append(items, r)
+	}
+	rows.Close()
+
+	for _, r := range items {
+		// 納品による状態 (一部納品・納品完了) は残数量に応じて戻し、取消・欠品はそのままにする
+		res, err := tx.Exec(`
+			UPDATE backorders SET
+				remaining_quantity = remaining_quantity + ?,
+				status = CASE WHEN status IN ('received', 'partial')
+					THEN CASE WHEN remaining_quantity + ? >= order_quantity - 1e-9 THEN 'ordered' ELSE 'partial' END
+					ELSE status END,
+				closed_date = CASE WHEN status = 'received' THEN '' ELSE closed_date END
+			WHERE id = ?`, r.ConsumedQuantity, r.ConsumedQuantity, r.BackorderID)
+		if err != nil {
+			return fmt.Errorf("failed to restore backorder id %d: %w", r.BackorderID, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		// 全量消し込みで削除されていた発注残 (状態の管理を始める前の取込) は、元のIDで再作成する
+		status := model.BackorderStatusOrdered
+		if r.ConsumedQuantity < r.OrderQuantity-1e-9 {
+			status = model.BackorderStatusPartial
+		}
+		_, err = tx.Exec(`
+			INSERT INTO backorders (
+				id, order_date, yj_code, product_name, package_form, jan_pack_inner_qty,
+				yj_unit_name, order_quantity, remaining_quantity, wholesaler_code,
+				yj_pack_unit_qty, jan_pack_unit_qty, jan_unit_code, product_code, po_number, status
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.BackorderID, r.OrderDate, r.YjCode, r.ProductName, r.PackageForm, r.JanPackInnerQty,
+			r.YjUnitName, r.OrderQuantity, r.ConsumedQuantity, r.WholesalerCode,
+			r.YjPackUnitQty, r.JanPackUnitQty, r.JanUnitCode, r.ProductCode, r.PoNumber, status)
+		if err != nil {
+			return fmt.Errorf("failed to recreate backorder id %d: %w", r.BackorderID, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM backorder_fulfillments WHERE import_batch_id = ?`, batchID); err != nil {
+		return fmt.Errorf("failed to delete backorder fulfillments of import batch %d: %w", batchID, err)
+	}
+	return nil
+}
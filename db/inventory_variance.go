@@ -0,0 +1,235 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\inventory_variance.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"wasabi/model"
+)
+
+// varianceUsageLabels は剤型コードの表示名です (剤型が名称で登録されている場合はそのまま使用します)。
+var varianceUsageLabels = map[string]string{"1": "内", "2": "外", "3": "歯", "4": "注", "5": "機", "6": "他"}
+
+// varianceDrugType は棚卸差異の集計に使う薬品区分を返します。
+func varianceDrugType(m *model.ProductMaster) string {
+	if class := controlledDrugClass(m); class != "" {
+		return class
+	}
+	switch {
+	case m.FlagPoison == 1:
+		return "毒薬"
+	case m.FlagDeleterious == 1:
+		return "劇薬"
+	}
+	return "一般"
+}
+
+/**
+ * @brief 棚卸日の棚卸を登録する直前の理論在庫を計算します。
+ * @param dbtx データベース接続またはトランザクション
+ * @param productCode 製品コード
+ * @param date 棚卸日 (YYYYMMDD)
+ * @return float64 理論在庫 (YJ単位)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 棚卸は当日の入出庫の後の在庫を表すため、前日の在庫に当日の入出庫を加えた数量とします。
+ * 同じ日に既に保存した棚卸がある場合も、その棚卸数量ではなく取引から計算した在庫になります。
+ */
+func stockBeforeCount(dbtx DBTX, productCode string, date string) (float64, error) {
+	countDate, err := time.Parse("20060102", date)
+	if err != nil {
+		return 0, fmt.Errorf("棚卸日が不正です: %s", date)
+	}
+	stock, err := CalculateStockOnDate(dbtx, productCode, countDate.AddDate(0, 0, -1).Format("20060102"))
+	if err != nil {
+		return 0, err
+	}
+	var sameDayChange sql.NullFloat64
+	err = dbtx.QueryRow(`
+		SELECT SUM(CASE WHEN flag IN (1, 4, 11) THEN yj_quantity WHEN flag IN (2, 3, 5, 12) THEN -yj_quantity ELSE 0 END)
+		FROM transaction_records
+		WHERE jan_code = ? AND flag != 0 AND transaction_date = ?`,
+		productCode, date).Scan(&sameDayChange)
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate same-day change for %s on %s: %w", productCode, date, err)
+	}
+	return stock + sameDayChange.Float64, nil
+}
+
+/**
+ * @brief 棚卸の保存時に、理論在庫と実棚数の差異を記録します。
+ * @param tx トランザクション
+ * @param date 棚卸日 (YYYYMMDD)
+ * @param masters 棚卸の対象の製品マスター
+ * @param counted 製品コードごとの実棚数 (YJ単位。含まれない製品は0として扱います)
+ * @param source 棚卸の入力元 ("guided" または "manual")
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 棚卸レコードを削除・登録する前に呼び出してください。登録後は棚卸数量が在庫計算の起点となり、差異が残らないためです。
+ * 同じ棚卸日に再度保存した場合は、その製品の差異を置き換えます。理論在庫と実棚数がどちらも0の製品は記録しません。
+ */
+func RecordInventoryVariancesInTx(tx *sql.Tx, date string, masters []*model.ProductMaster, counted map[string]float64, source string) error {
+	deleteStmt, err := tx.Prepare(`DELETE FROM inventory_variances WHERE count_date = ? AND product_code = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare inventory variance delete: %w", err)
+	}
+	defer deleteStmt.Close()
+	insertStmt, err := tx.Prepare(`
+		INSERT INTO inventory_variances (
+			count_date, product_code, yj_code, product_name, shelf_number, usage_classification, drug_type, yj_unit_name,
+			theoretical_quantity, counted_quantity, nhi_price, purchase_price, source
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare inventory variance insert: %w", err)
+	}
+	defer insertStmt.Close()
+
+	for _, m := range masters {
+		theoretical, err := stockBeforeCount(tx, m.ProductCode, date)
+		if err != nil {
+			return err
+		}
+		if _, err := deleteStmt.Exec(date, m.ProductCode); err != nil {
+			return fmt.Errorf("failed to delete inventory variance for %s: %w", m.ProductCode, err)
+		}
+		countedQty := counted[m.ProductCode]
+		if math.Abs(theoretical) < 1e-9 && math.Abs(countedQty) < 1e-9 {
+			continue
+		}
+
+		// 在庫評価と同じく、納入価は包装単位の納入価をYJ包装数量で割った単価とする
+		var unitPurchasePrice float64
+		if m.YjPackUnitQty > 0 {
+			unitPurchasePrice = m.PurchasePrice / m.YjPackUnitQty
+		}
+		if _, err := insertStmt.Exec(
+			date, m.ProductCode, m.YjCode, m.ProductName, m.ShelfNumber, strings.TrimSpace(m.UsageClassification), varianceDrugType(m), m.YjUnitName,
+			theoretical, countedQty, m.NhiPrice, unitPurchasePrice, source,
+		); err != nil {
+			return fmt.Errorf("failed to insert inventory variance for %s: %w", m.ProductCode, err)
+		}
+	}
+	return nil
+}
+
+// varianceGroupKey は集計区分に応じた品目の集計キーを返します。
+func varianceGroupKey(item model.InventoryVarianceItem, groupBy string) string {
+	switch groupBy {
+	case "form":
+		if label, ok := varianceUsageLabels[item.UsageClassification]; ok {
+			return label
+		}
+		if item.UsageClassification == "" {
+			return "(剤型なし)"
+		}
+		return item.UsageClassification
+	case "drugType":
+		return item.DrugType
+	}
+	if item.ShelfNumber == "" {
+		return "(棚番なし)"
+	}
+	return item.ShelfNumber
+}
+
+/**
+ * @brief 棚卸差異レポートを作成します。
+ * @param conn データベース接続
+ * @param filters 抽出条件 (棚卸日の期間・集計区分・差異のある品目のみ)
+ * @return *model.InventoryVarianceReport 棚卸日・集計区分ごとの集計と品目ごとの明細
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 金額は差異数量にYJ単位の薬価・納入単価 (棚卸時点) を掛けて計算します。
+ * 集計・明細とも新しい棚卸日から順に並べ、同じ棚卸日の中は集計キー順です。
+ */
+func GetInventoryVarianceReport(conn *sql.DB, filters model.InventoryVarianceFilters) (*model.InventoryVarianceReport, error) {
+	groupBy := filters.GroupBy
+	if groupBy != "form" && groupBy != "drugType" {
+		groupBy = "shelf"
+	}
+
+	q := `SELECT count_date, product_code, yj_code, product_name, shelf_number, usage_classification, drug_type, yj_unit_name,
+		theoretical_quantity, counted_quantity, nhi_price, purchase_price, source
+		FROM inventory_variances WHERE 1=1`
+	var args []interface{}
+	if filters.StartDate != "" {
+		q += " AND count_date >= ?"
+		args = append(args, filters.StartDate)
+	}
+	if filters.EndDate != "" {
+		q += " AND count_date <= ?"
+		args = append(args, filters.EndDate)
+	}
+	if filters.DifferencesOnly {
+		q += " AND ABS(counted_quantity - theoretical_quantity) >= 0.0005"
+	}
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory variances: %w", err)
+	}
+	defer rows.Close()
+
+	report := &model.InventoryVarianceReport{
+		GroupBy:   groupBy,
+		Summaries: make([]model.InventoryVarianceSummary, 0),
+		Items:     make([]model.InventoryVarianceItem, 0),
+	}
+	for rows.Next() {
+		var item model.InventoryVarianceItem
+		var nhiPrice, purchasePrice float64
+		if err := rows.Scan(
+			&item.CountDate, &item.ProductCode, &item.YjCode, &item.ProductName, &item.ShelfNumber, &item.UsageClassification, &item.DrugType, &item.YjUnitName,
+			&item.TheoreticalQuantity, &item.CountedQuantity, &nhiPrice, &purchasePrice, &item.Source,
+		); err != nil {
+			return nil, err
+		}
+		item.Difference = math.Round((item.CountedQuantity-item.TheoreticalQuantity)*1000) / 1000
+		item.NhiValue = item.Difference * nhiPrice
+		item.PurchaseValue = item.Difference * purchasePrice
+		report.Items = append(report.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if a.CountDate != b.CountDate {
+			return a.CountDate > b.CountDate
+		}
+		if ka, kb := varianceGroupKey(a, groupBy), varianceGroupKey(b, groupBy); ka != kb {
+			return ka < kb
+		}
+		return a.ProductName < b.ProductName
+	})
+
+	for _, item := range report.Items {
+		key := varianceGroupKey(item, groupBy)
+		n := len(report.Summaries)
+		if n == 0 || report.Summaries[n-1].CountDate != item.CountDate || report.Summaries[n-1].GroupKey != key {
+			report.Summaries = append(report.Summaries, model.InventoryVarianceSummary{CountDate: item.CountDate, GroupKey: key})
+			n++
+		}
+		summary := &report.Summaries[n-1]
+		summary.ItemCount++
+		if item.Difference != 0 {
+			summary.DiscrepancyCount++
+		}
+		if item.Difference < 0 {
+			summary.ShortageNhiValue += item.NhiValue
+			summary.ShortagePurchaseValue += item.PurchaseValue
+		} else {
+			summary.SurplusNhiValue += item.NhiValue
+			summary.SurplusPurchaseValue += item.PurchaseValue
+		}
+		summary.NetNhiValue += item.NhiValue
+		summary.NetPurchaseValue += item.PurchaseValue
+	}
+	return report, nil
+}
@@ -199,3 +199,45 @@ func GetJcshmsRecordByGS1(tx *sql.Tx, gs1Code string) (*model.JCShms, string, er
 
 	return jcshms, janCode, nil
 }
+
+/**
+ * @brief YJコードから代表となるJANコードを求めます。
+ * @param dbtx DBTX (トランザクションまたはDB接続)
+ * @param yjCode 検索対象のYJコード
+ * @return string 見つかったJANコード (見つからない場合は空文字)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 採用品（製品マスターに登録済みの包装）を先に検索し、無ければJCSHMSマスターから検索します。
+ * 採用品が複数ある場合は、現在発注している包装を選ぶため、
+ * 発注停止でないもの → 卸が設定されているもの → 直近に納品されたもの → JCSHMS由来のもの の順に優先します。
+ * 処方データのようにYJコードしか持たない明細を、JANコードを前提とする mastermanager で扱うために使用します。
+ */
+func GetRepresentativeJanByYjCode(dbtx DBTX, yjCode string) (string, error) {
+	var jan string
+	err := dbtx.QueryRow(`
+		SELECT p.product_code FROM product_master p
+		WHERE p.yj_code = ?
+		ORDER BY
+			COALESCE(p.is_order_stopped, 0),
+			CASE WHEN COALESCE(p.supplier_wholesale, '') != '' THEN 0 ELSE 1 END,
+			COALESCE((SELECT MAX(t.transaction_date) FROM transaction_records t
+				WHERE t.flag = 1 AND t.jan_code = p.product_code), '') DESC,
+			CASE WHEN p.origin = 'JCSHMS' THEN 0 ELSE 1 END,
+			p.product_code
+		LIMIT 1`, yjCode).Scan(&jan)
+	if err == nil {
+		return jan, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to search product master by yj code %s: %w", yjCode, err)
+	}
+
+	err = dbtx.QueryRow(`SELECT JC000 FROM jcshms WHERE JC009 = ? ORDER BY JC000 LIMIT 1`, yjCode).Scan(&jan)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to search jcshms by yj code %s: %w", yjCode, err)
+	}
+	return jan, nil
+}
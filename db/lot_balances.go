@@ -0,0 +1,282 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\lot_balances.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"wasabi/model"
+)
+
+// lotKey はロット別在庫を区別するキーです。ロット番号・有効期限とも空のキーはロット不明の在庫です。
+type lotKey struct {
+	lot    string
+	expiry string
+}
+
+/**
+ * @brief 有効期限の文字列を YYYY-MM-DD 形式に変換します。
+ * @param s 有効期限 (YYMM, YYMMDD, YYYYMM, YYYYMMDD。区切り文字は無視します)
+ * @return string YYYY-MM-DD 形式の日付。日が無い期限は月末日。解釈できない場合は空文字
+ * @details
+ * 6桁の値は DAT の検証と同じく YYMMDD を優先し、日付として不正な場合に YYYYMM として解釈します。
+ */
+func NormalizeExpiry(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+
+	var t time.Time
+	var err error
+	monthOnly := false
+	switch len(digits) {
+	case 4:
+		t, err = time.Parse("0601", digits)
+		monthOnly = true
+	case 6:
+		if t, err = time.Parse("060102", digits); err != nil {
+			t, err = time.Parse("200601", digits)
+			monthOnly = true
+		}
+	case 8:
+		t, err = time.Parse("20060102", digits)
+	default:
+		return ""
+	}
+	if err != nil {
+		return ""
+	}
+	if monthOnly {
+		t = t.AddDate(0, 1, -1)
+	}
+	return t.Format("2006-01-02")
+}
+
+/**
+ * @brief 取引データから単一製品のロット別在庫を計算します。
+ * @param executor DB接続またはトランザクション
+ * @param janCode 製品コード
+ * @param lastInventoryDate 最新の棚卸日 (棚卸が無い場合は空文字)
+ * @return []model.LotBalance ロット別在庫 (期限の近い順、ロット不明の在庫は最後)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 最新棚卸日の棚卸ロットを起点に、その後の入出庫を日付順に反映します。
+ * 出庫はロット番号・有効期限が指定されていればそのロットから、残りは期限の近いロットから順に引き当てます。
+ * 引き当てられなかった出庫はロット不明の在庫から差し引き、後からロット付きで入庫した分と相殺します。
+ * 全ロットの合計は CalculateCurrentStockForProduct の在庫と一致します。
+ */
+func calculateLotBalances(executor DBTX, janCode string, lastInventoryDate string) ([]model.LotBalance, error) {
+	lots := make(map[lotKey]float64)
+
+	if lastInventoryDate != "" {
+		rows, err := executor.Query(`
+			SELECT COALESCE(lot_number, ''), COALESCE(expiry_date, ''), COALESCE(yj_quantity, 0)
+			FROM transaction_records
+			WHERE jan_code = ? AND flag = 0 AND transaction_date = ?`, janCode, lastInventoryDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get inventory lots for %s: %w", janCode, err)
+		}
+		for rows.Next() {
+			var lot, expiry string
+			var qty float64
+			if err := rows.Scan(&lot, &expiry, &qty); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			lots[newLotKey(lot, expiry)] += qty
+		}
+		rows.Close()
+	}
+
+	query := `
+		SELECT flag, COALESCE(yj_quantity, 0), COALESCE(lot_number, ''), COALESCE(expiry_date, '')
+		FROM transaction_records
+		WHERE jan_code = ? AND flag != 0`
+	args := []interface{}{janCode}
+	if lastInventoryDate != "" {
+		query += ` AND transaction_date > ?`
+		args = append(args, lastInventoryDate)
+	}
+	query += ` ORDER BY transaction_date, id`
+
+	rows, err := executor.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions for lot calculation of %s: %w", janCode, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var flag int
+		var qty float64
+		var lot, expiry string
+		if err := rows.Scan(&flag, &qty, &lot, &expiry); err != nil {
+			return nil, err
+		}
+		key := newLotKey(lot, expiry)
+		switch flag {
+		case 1, 4, 11:
+			applyLotMovement(lots, key, qty)
+		case 2, 3, 5, 12:
+			applyLotMovement(lots, key, -qty)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]model.LotBalance, 0, len(lots))
+	for _, key := range sortedLotKeys(lots) {
+		if math.Abs(lots[key]) < stockBalanceTolerance {
+			continue
+		}
+		result = append(result, model.LotBalance{
+			ProductCode: janCode,
+			LotNumber:   key.lot,
+			ExpiryDate:  key.expiry,
+			Quantity:    lots[key],
+		})
+	}
+	return result, nil
+}
+
+func newLotKey(lot, expiry string) lotKey {
+	return lotKey{lot: strings.TrimSpace(lot), expiry: NormalizeExpiry(expiry)}
+}
+
+// applyLotMovement は入庫 (qty > 0) または出庫 (qty < 0) をロット別在庫に反映します。
+func applyLotMovement(lots map[lotKey]float64, key lotKey, qty float64) {
+	unknown := lotKey{}
+	if qty >= 0 {
+		// ロット不明の在庫がマイナス (引き当てられなかった出庫) なら、入庫したロットで相殺する
+		if key != unknown && lots[unknown] < 0 {
+			offset := math.Min(qty, -lots[unknown])
+			lots[unknown] += offset
+			qty -= offset
+		}
+		lots[key] += qty
+		return
+	}
+
+	remaining := -qty
+	// 1. ロット番号・有効期限が指定されていれば、一致するロットから引き当てる
+	if key != unknown {
+		for _, k := range sortedLotKeys(lots) {
+			if remaining <= 0 {
+				break
+			}
+			if k == unknown || (key.lot != "" && k.lot != key.lot) || (key.expiry != "" && k.expiry != key.expiry) {
+				continue
+			}
+			remaining -= takeFromLot(lots, k, remaining)
+		}
+	}
+	// 2. 残りは期限の近いロットから順に引き当てる (ロット不明の在庫は最後)
+	for _, k := range sortedLotKeys(lots) {
+		if remaining <= 0 {
+			break
+		}
+		remaining -= takeFromLot(lots, k, remaining)
+	}
+	// 3. 引き当てられなかった分はロット不明の在庫から差し引く
+	if remaining > 0 {
+		lots[unknown] -= remaining
+	}
+}
+
+func takeFromLot(lots map[lotKey]float64, k lotKey, qty float64) float64 {
+	if lots[k] <= 0 {
+		return 0
+	}
+	taken := math.Min(lots[k], qty)
+	lots[k] -= taken
+	return taken
+}
+
+// sortedLotKeys はロットを期限の近い順 (期限不明のロット、ロット不明の在庫の順で最後) に並べます。
+func sortedLotKeys(lots map[lotKey]float64) []lotKey {
+	keys := make([]lotKey, 0, len(lots))
+	for k := range lots {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if (a == lotKey{}) != (b == lotKey{}) {
+			return b == lotKey{}
+		}
+		if (a.expiry == "") != (b.expiry == "") {
+			return b.expiry == ""
+		}
+		if a.expiry != b.expiry {
+			return a.expiry < b.expiry
+		}
+		return a.lot < b.lot
+	})
+	return keys
+}
+
+// refreshLotBalancesInTx は単一製品のロット別在庫を計算し直して保存します。
+func refreshLotBalancesInTx(tx *sql.Tx, janCode string, lastInventoryDate string) error {
+	if _, err := tx.Exec(`DELETE FROM lot_balances WHERE product_code = ?`, janCode); err != nil {
+		return fmt.Errorf("failed to delete lot balances for %s: %w", janCode, err)
+	}
+	lots, err := calculateLotBalances(tx, janCode, lastInventoryDate)
+	if err != nil {
+		return err
+	}
+	for _, l := range lots {
+		if _, err := tx.Exec(`
+			INSERT INTO lot_balances (product_code, lot_number, expiry_date, quantity) VALUES (?, ?, ?, ?)`,
+			l.ProductCode, l.LotNumber, l.ExpiryDate, l.Quantity); err != nil {
+			return fmt.Errorf("failed to save lot balance for %s: %w", janCode, err)
+		}
+	}
+	return nil
+}
+
+/**
+ * @brief 指定された製品のロット別在庫を取得します。
+ * @param conn データベース接続
+ * @param productCodes 製品コードのリスト
+ * @return map[string][]model.LotBalance 製品コードをキーとしたロット別在庫 (期限の近い順、ロット不明の在庫は最後)
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetLotBalancesByProductCodes(conn *sql.DB, productCodes []string) (map[string][]model.LotBalance, error) {
+	result := make(map[string][]model.LotBalance)
+	if len(productCodes) == 0 {
+		return result, nil
+	}
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return nil, err
+	}
+
+	placeholders := strings.Repeat("?,", len(productCodes)-1) + "?"
+	args := make([]interface{}, len(productCodes))
+	for i, code := range productCodes {
+		args[i] = code
+	}
+	rows, err := conn.Query(`
+		SELECT product_code, lot_number, expiry_date, quantity FROM lot_balances
+		WHERE product_code IN (`+placeholders+`)
+		ORDER BY product_code,
+			CASE WHEN lot_number = '' AND expiry_date = '' THEN 2 WHEN expiry_date = '' THEN 1 ELSE 0 END,
+			expiry_date, lot_number`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lot balances: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l model.LotBalance
+		if err := rows.Scan(&l.ProductCode, &l.LotNumber, &l.ExpiryDate, &l.Quantity); err != nil {
+			return nil, err
+		}
+		result[l.ProductCode] = append(result[l.ProductCode], l)
+	}
+	return result, rows.Err()
+}
@@ -25,11 +25,75 @@ func ApplyMigrations(conn *sql.DB) error {
 	}
 
 	log.Println("Applying database migrations...")
+
+	// 既存のデータベースに後から追加された列
+	columns := []struct{ table, column, definition string }{
+		{"transaction_records", "import_batch_id", "INTEGER"},
+		{"wholesalers", "min_order_amount", "REAL NOT NULL DEFAULT 0"},
+		{"backorders", "product_code", "TEXT NOT NULL DEFAULT ''"},
+		{"backorders", "po_number", "TEXT NOT NULL DEFAULT ''"},
+		{"import_batch_backorders", "product_code", "TEXT NOT NULL DEFAULT ''"},
+		{"import_batch_backorders", "po_number", "TEXT NOT NULL DEFAULT ''"},
+		{"backorders", "status", "TEXT NOT NULL DEFAULT 'ordered'"},
+		{"backorders", "expected_date", "TEXT NOT NULL DEFAULT ''"},
+		{"backorders", "status_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"backorders", "closed_date", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfNotExists(conn, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	migrations = append(migrations,
+		`CREATE INDEX IF NOT EXISTS idx_transactions_import_batch ON transaction_records (import_batch_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_backorders_po_number ON backorders (po_number);`,
+		`CREATE INDEX IF NOT EXISTS idx_backorders_status ON backorders (status);`,
+	)
+
 	for _, migration := range migrations {
 		if _, err := conn.Exec(migration); err != nil {
 			return fmt.Errorf("failed to apply migration (%s): %w", migration, err)
 		}
 	}
+	// 在庫スナップショットの追加前から使用しているデータベースでは、取引データから作成する
+	if err := initStockBalances(conn); err != nil {
+		return err
+	}
+	// 監査ログのトリガーは列の追加後に作り直す
+	if err := ensureAuditTriggers(conn); err != nil {
+		return err
+	}
+
 	log.Println("Database migrations applied successfully.")
 	return nil
 }
+
+// addColumnIfNotExists は、指定した列がテーブルに存在しない場合のみ ALTER TABLE で追加します。
+// SQLiteの ADD COLUMN は IF NOT EXISTS をサポートしないため、PRAGMA table_info で確認します。
+func addColumnIfNotExists(conn *sql.DB, table, column, definition string) error {
+	rows, err := conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to get table info for %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info for %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	log.Printf("Added column %s.%s", table, column)
+	return nil
+}
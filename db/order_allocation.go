@@ -0,0 +1,224 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\order_allocation.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"wasabi/model"
+)
+
+// allocationOption は発注の1行を振り分けられる卸と納入価です。
+type allocationOption struct {
+	wholesalerCode string
+	price          float64
+	validTo        string
+}
+
+// allocationState は振り分け中の発注の1行です。options は納入価の安い順で、choice が採用中の卸です。
+type allocationState struct {
+	line    model.OrderAllocationLine
+	options []allocationOption
+	choice  int
+}
+
+func (s *allocationState) apply() {
+	opt := s.options[s.choice]
+	s.line.WholesalerCode = opt.wholesalerCode
+	s.line.UnitPrice = opt.price
+	s.line.QuoteValidTo = opt.validTo
+}
+
+// nextOption は除外した卸を除いて、次に安い卸の位置を返します。無い場合は -1 です。
+func (s *allocationState) nextOption(dropped map[string]bool) int {
+	for i, opt := range s.options {
+		if !dropped[opt.wholesalerCode] {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+ * @brief 発注候補を、指定日に有効な見積の最も安い卸に振り分けます。
+ * @param conn データベース接続
+ * @param date 見積の有効期間の判定日 (YYYYMMDD)
+ * @param lines 発注候補 (製品コードと包装数)
+ * @return *model.OrderAllocationResult 卸ごとの振り分け結果と、現在の採用卸との差額
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 振り分け先は卸業者管理に登録された卸の見積と、現在の採用卸 (その卸の有効な見積が無い場合はマスターの納入価) から選びます。
+ * 発注不可の製品と、見積も採用卸も無い製品は除外します。
+ * 合計金額が最低発注金額に満たない卸は、その卸の全ての行を次に安い卸に移せる場合に限り移し替えます。
+ * 移せない場合は最低発注金額未満として、そのまま返します。
+ */
+func AllocateOrders(conn *sql.DB, date string, lines []model.OrderAllocationRequestLine) (*model.OrderAllocationResult, error) {
+	codes := make([]string, 0, len(lines))
+	for _, l := range lines {
+		codes = append(codes, l.ProductCode)
+	}
+	masters, err := getMastersByProductCodes(conn, codes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product masters for order allocation: %w", err)
+	}
+	wholesalerList, err := GetAllWholesalers(conn)
+	if err != nil {
+		return nil, err
+	}
+	wholesalers := make(map[string]model.Wholesaler, len(wholesalerList))
+	for _, w := range wholesalerList {
+		wholesalers[w.Code] = w
+	}
+	quotes, err := GetValidWholesalerQuotes(conn, date)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.OrderAllocationResult{
+		Date:     date,
+		Groups:   make([]model.OrderAllocationGroup, 0),
+		Excluded: make([]model.OrderAllocationLine, 0),
+	}
+	var states []*allocationState
+	for _, l := range lines {
+		line := model.OrderAllocationLine{ProductCode: l.ProductCode, Quantity: l.Quantity}
+		m, ok := masters[l.ProductCode]
+		if !ok {
+			line.Reason = "製品マスターがありません"
+			result.Excluded = append(result.Excluded, line)
+			continue
+		}
+		line.ProductName = m.ProductName
+		line.CurrentWholesalerCode = m.SupplierWholesale
+		line.CurrentUnitPrice = m.PurchasePrice
+		if m.IsOrderStopped == 1 {
+			line.Reason = "発注不可の製品です"
+			result.Excluded = append(result.Excluded, line)
+			continue
+		}
+		if l.Quantity <= 0 {
+			line.Reason = "発注数がありません"
+			result.Excluded = append(result.Excluded, line)
+			continue
+		}
+
+		state := &allocationState{line: line}
+		quotedCurrent := false
+		for _, q := range quotes[l.ProductCode] {
+			if _, ok := wholesalers[q.WholesalerCode]; !ok {
+				continue
+			}
+			state.options = append(state.options, allocationOption{wholesalerCode: q.WholesalerCode, price: q.Price, validTo: q.ValidTo})
+			if q.WholesalerCode == m.SupplierWholesale {
+				quotedCurrent = true
+			}
+		}
+		if len(state.options) == 0 {
+			if m.SupplierWholesale == "" {
+				line.Reason = "有効な見積も採用卸もありません"
+				result.Excluded = append(result.Excluded, line)
+				continue
+			}
+			state.line.Reason = "有効な見積が無いため採用卸のままです"
+		}
+		if !quotedCurrent && m.SupplierWholesale != "" {
+			state.options = append(state.options, allocationOption{wholesalerCode: m.SupplierWholesale, price: m.PurchasePrice})
+		}
+		// 同じ価格なら現在の採用卸を優先する
+		sort.SliceStable(state.options, func(i, j int) bool {
+			a, b := state.options[i], state.options[j]
+			if a.price != b.price {
+				return a.price < b.price
+			}
+			return a.wholesalerCode == m.SupplierWholesale && b.wholesalerCode != m.SupplierWholesale
+		})
+		state.apply()
+		states = append(states, state)
+	}
+
+	// 最低発注金額に満たない卸の行を、次に安い卸に移し替える
+	dropped := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		totals := make(map[string]float64)
+		var used []string
+		for _, s := range states {
+			if _, ok := totals[s.line.WholesalerCode]; !ok {
+				used = append(used, s.line.WholesalerCode)
+			}
+			totals[s.line.WholesalerCode] += s.line.UnitPrice * s.line.Quantity
+		}
+		// 不足の大きい (合計金額の小さい) 卸から順に移し替える
+		sort.Slice(used, func(i, j int) bool {
+			if totals[used[i]] != totals[used[j]] {
+				return totals[used[i]] < totals[used[j]]
+			}
+			return used[i] < used[j]
+		})
+		for _, code := range used {
+			total := totals[code]
+			w, ok := wholesalers[code]
+			if !ok || w.MinOrderAmount <= 0 || total >= w.MinOrderAmount || dropped[code] {
+				continue
+			}
+			dropped[code] = true
+			moves := make(map[*allocationState]int)
+			for _, s := range states {
+				if s.line.WholesalerCode != code {
+					continue
+				}
+				next := s.nextOption(dropped)
+				if next < 0 {
+					moves = nil
+					break
+				}
+				moves[s] = next
+			}
+			if moves == nil {
+				// 移せない行がある場合はこの卸に残し、最低発注金額未満として返す
+				delete(dropped, code)
+				continue
+			}
+			for s, next := range moves {
+				s.choice = next
+				s.apply()
+				s.line.Reason = fmt.Sprintf("%sの最低発注金額に満たないため次に安い卸に変更しました", w.Name)
+			}
+			changed = true
+			break
+		}
+	}
+
+	groups := make(map[string]*model.OrderAllocationGroup)
+	for _, s := range states {
+		line := s.line
+		line.Amount = line.UnitPrice * line.Quantity
+		currentAmount := line.Amount
+		if line.CurrentUnitPrice > 0 {
+			currentAmount = line.CurrentUnitPrice * line.Quantity
+			line.Savings = currentAmount - line.Amount
+		}
+		result.TotalAmount += line.Amount
+		result.CurrentTotalAmount += currentAmount
+		result.TotalSavings += line.Savings
+
+		g, ok := groups[line.WholesalerCode]
+		if !ok {
+			w := wholesalers[line.WholesalerCode]
+			g = &model.OrderAllocationGroup{WholesalerCode: line.WholesalerCode, WholesalerName: w.Name, MinOrderAmount: w.MinOrderAmount}
+			if g.WholesalerName == "" {
+				g.WholesalerName = line.WholesalerCode
+			}
+			groups[line.WholesalerCode] = g
+		}
+		g.TotalAmount += line.Amount
+		g.Lines = append(g.Lines, line)
+	}
+	for _, g := range groups {
+		g.BelowMinimum = g.MinOrderAmount > 0 && g.TotalAmount < g.MinOrderAmount
+		result.Groups = append(result.Groups, *g)
+	}
+	sort.Slice(result.Groups, func(i, j int) bool { return result.Groups[i].WholesalerCode < result.Groups[j].WholesalerCode })
+	return result, nil
+}
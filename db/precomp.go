@@ -160,13 +160,13 @@ func GetPreCompoundingRecordsByPatient(conn *sql.DB, patientNumber string) ([]mo
 
 /**
  * @brief 特定の患者の予製レコードをすべて削除します。
- * @param conn データベース接続
+ * @param dbtx DB接続またはトランザクション
  * @param patientNumber 対象の患者番号
  * @return error 処理中にエラーが発生した場合
  */
-func DeletePreCompoundingRecordsByPatient(conn *sql.DB, patientNumber string) error {
+func DeletePreCompoundingRecordsByPatient(dbtx DBTX, patientNumber string) error {
 	const q = `DELETE FROM precomp_records WHERE client_code = ?`
-	if _, err := conn.Exec(q, patientNumber); err != nil {
+	if _, err := dbtx.Exec(q, patientNumber); err != nil {
 		return fmt.Errorf("failed to delete precomp records for patient %s: %w", patientNumber, err)
 	}
 	return nil
@@ -333,3 +333,54 @@ func GetPreCompoundingStatusByPatient(conn *sql.DB, patientNumber string) (strin
 }
 
 // ▲▲▲【修正ここまで】▲▲▲
+
+// PrecompDispensingMatch は予製1品目と、同じ患者への実際の調剤（処方データ）との突き合わせ結果です。
+type PrecompDispensingMatch struct {
+	ProductCode         string  `json:"productCode"`
+	ProductName         string  `json:"productName"`
+	Status              string  `json:"status"`
+	PrecompYjQuantity   float64 `json:"precompYjQuantity"`
+	DispensedYjQuantity float64 `json:"dispensedYjQuantity"`
+	DispensedCount      int     `json:"dispensedCount"`
+	LastDispensedDate   string  `json:"lastDispensedDate"`
+}
+
+/**
+ * @brief 患者の予製レコードと、予製登録日以降の同じ患者・同じYJコードの処方データを突き合わせます。
+ * @param conn データベース接続
+ * @param patientNumber 対象の患者番号
+ * @return []PrecompDispensingMatch 予製品目ごとの突き合わせ結果
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 処方データの client_code に患者番号が入るのは、NSIPS処方データから取り込んだ場合のみです。
+ * NSIPSの明細はYJコードのみで、JANコードは代表包装から補っているため、包装違いでも一致するようYJコードで突き合わせます。
+ */
+func GetPrecompDispensingMatches(conn *sql.DB, patientNumber string) ([]PrecompDispensingMatch, error) {
+	const q = `
+		SELECT p.jan_code, p.product_name, p.status, COALESCE(p.yj_quantity, 0),
+			COALESCE(SUM(t.yj_quantity), 0), COUNT(t.id), COALESCE(MAX(t.transaction_date), '')
+		FROM precomp_records p
+		LEFT JOIN transaction_records t
+			ON t.flag = 3 AND t.client_code = p.client_code AND t.yj_code = p.yj_code AND p.yj_code != ''
+			AND t.transaction_date >= p.transaction_date
+		WHERE p.client_code = ?
+		GROUP BY p.id
+		ORDER BY p.id`
+
+	rows, err := conn.Query(q, patientNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match precomp records with dispensing for patient %s: %w", patientNumber, err)
+	}
+	defer rows.Close()
+
+	matches := make([]PrecompDispensingMatch, 0)
+	for rows.Next() {
+		var m PrecompDispensingMatch
+		if err := rows.Scan(&m.ProductCode, &m.ProductName, &m.Status, &m.PrecompYjQuantity,
+			&m.DispensedYjQuantity, &m.DispensedCount, &m.LastDispensedDate); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
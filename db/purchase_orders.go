@@ -0,0 +1,104 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\purchase_orders.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"wasabi/model"
+	"wasabi/units"
+)
+
+/**
+ * @brief 登録前の発注残に、卸ごとの発注書番号を採番します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param backorders 登録する発注残 (PoNumber を設定します)
+ * @return []string 採番した発注書番号 (卸が最初に現れた順)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 同じ卸 (卸が未選択の行は未選択どうし) の発注残には同じ発注書番号を設定します。
+ * InsertBackordersInTx の前に、同じトランザクションで呼び出してください。
+ */
+func AssignPurchaseOrderNumbersInTx(tx *sql.Tx, backorders []model.Backorder) ([]string, error) {
+	poNumbers := make(map[string]string)
+	var ordered []string
+	for i := range backorders {
+		wholesalerCode := backorders[i].WholesalerCode.String
+		poNumber, ok := poNumbers[wholesalerCode]
+		if !ok {
+			var err error
+			poNumber, err = NextSequenceInTx(tx, "PO", "PO", 6)
+			if err != nil {
+				return nil, err
+			}
+			poNumbers[wholesalerCode] = poNumber
+			ordered = append(ordered, poNumber)
+		}
+		backorders[i].PoNumber = poNumber
+	}
+	return ordered, nil
+}
+
+/**
+ * @brief 発注書番号の発注残から発注書を作成します。
+ * @param conn データベース接続
+ * @param poNumber 発注書番号
+ * @return *model.PurchaseOrder 発注書 (発注書番号の発注残が無い場合は nil)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 数量は発注時の数量で、その後の納品による消し込みや取消の影響を受けません。
+ */
+func GetPurchaseOrder(conn *sql.DB, poNumber string) (*model.PurchaseOrder, error) {
+	rows, err := conn.Query(`
+		SELECT b.order_date, COALESCE(b.wholesaler_code, ''), COALESCE(w.wholesaler_name, ''),
+			b.product_code, COALESCE(b.product_name, ''), b.package_form, b.jan_pack_inner_qty, b.yj_unit_name,
+			b.order_quantity, COALESCE(b.yj_pack_unit_qty, 0), COALESCE(b.jan_pack_unit_qty, 0), COALESCE(b.jan_unit_code, 0)
+		FROM backorders b
+		LEFT JOIN wholesalers w ON w.wholesaler_code = b.wholesaler_code
+		WHERE b.po_number = ?
+		ORDER BY b.id`, poNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get purchase order %s: %w", poNumber, err)
+	}
+	defer rows.Close()
+
+	var po *model.PurchaseOrder
+	for rows.Next() {
+		var orderDate, wholesalerCode, wholesalerName string
+		var line model.PurchaseOrderLine
+		var packageForm string
+		var janPackInnerQty, yjPackUnitQty, janPackUnitQty float64
+		var janUnitCode int
+		if err := rows.Scan(&orderDate, &wholesalerCode, &wholesalerName,
+			&line.ProductCode, &line.ProductName, &packageForm, &janPackInnerQty, &line.YjUnitName,
+			&line.YjQuantity, &yjPackUnitQty, &janPackUnitQty, &janUnitCode); err != nil {
+			return nil, err
+		}
+		if po == nil {
+			po = &model.PurchaseOrder{
+				PoNumber:       poNumber,
+				OrderDate:      orderDate,
+				WholesalerCode: wholesalerCode,
+				WholesalerName: wholesalerName,
+				Lines:          make([]model.PurchaseOrderLine, 0),
+			}
+		}
+		tempJcshms := model.JCShms{
+			JC037: packageForm, JC039: line.YjUnitName, JC044: yjPackUnitQty,
+			JA006: sql.NullFloat64{Float64: janPackInnerQty, Valid: true},
+			JA008: sql.NullFloat64{Float64: janPackUnitQty, Valid: true},
+			JA007: sql.NullString{String: fmt.Sprintf("%d", janUnitCode), Valid: true},
+		}
+		line.PackageSpec = units.FormatSimplePackageSpec(&tempJcshms)
+		// 発注残はYJ単位で保存しているため、包装数に戻す
+		line.Quantity = line.YjQuantity
+		if yjPackUnitQty > 0 {
+			line.Quantity = line.YjQuantity / yjPackUnitQty
+		}
+		po.Lines = append(po.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return po, nil
+}
@@ -0,0 +1,249 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\replenishment.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"wasabi/forecast"
+	"wasabi/model"
+)
+
+// replenishmentScopes は補充条件の対象の種類です。
+var replenishmentScopes = map[string]bool{"product": true, "package": true, "yj": true, "class": true}
+
+const replenishmentPolicyColumns = `r.policy_key, r.scope, r.min_quantity, r.max_quantity, r.safety_days, r.lead_time_days,
+	r.min_order_quantity, r.round_to_package, r.notes, r.updated_at`
+
+func scanReplenishmentPolicy(rows *sql.Rows, withName bool) (*model.ReplenishmentPolicy, error) {
+	var p model.ReplenishmentPolicy
+	var roundToPackage int
+	dest := []interface{}{
+		&p.PolicyKey, &p.Scope, &p.MinQuantity, &p.MaxQuantity, &p.SafetyDays, &p.LeadTimeDays,
+		&p.MinOrderQuantity, &roundToPackage, &p.Notes, &p.UpdatedAt,
+	}
+	if withName {
+		dest = append(dest, &p.TargetName)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	p.RoundToPackage = roundToPackage == 1
+	return &p, nil
+}
+
+/**
+ * @brief 全ての補充条件を、対象の製品名とともに取得します。
+ * @param conn データベース接続
+ * @return []model.ReplenishmentPolicy 補充条件のスライス (対象の種類・キー順)
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetReplenishmentPolicies(conn *sql.DB) ([]model.ReplenishmentPolicy, error) {
+	rows, err := conn.Query(`SELECT ` + replenishmentPolicyColumns + `,
+		COALESCE(CASE r.scope
+			WHEN 'product' THEN (SELECT product_name FROM product_master WHERE product_code = r.policy_key)
+			WHEN 'yj' THEN (SELECT product_name FROM product_master WHERE yj_code = r.policy_key ORDER BY origin = 'JCSHMS' DESC LIMIT 1)
+			WHEN 'class' THEN 'ABC/XYZ分類 ' || r.policy_key || ' の製品'
+			ELSE (SELECT product_name FROM product_master WHERE yj_code = substr(r.policy_key, 1, instr(r.policy_key, '|') - 1) ORDER BY origin = 'JCSHMS' DESC LIMIT 1)
+		END, '')
+		FROM replenishment_policies r ORDER BY CASE r.scope WHEN 'product' THEN 1 WHEN 'package' THEN 2 ELSE 3 END, r.policy_key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replenishment policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := make([]model.ReplenishmentPolicy, 0)
+	for rows.Next() {
+		p, err := scanReplenishmentPolicy(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+	return policies, rows.Err()
+}
+
+/**
+ * @brief 全ての補充条件をキーごとのマップで取得します。
+ * @param conn データベース接続
+ * @return map[string]*model.ReplenishmentPolicy policy_key をキーとする補充条件
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetReplenishmentPolicyMap(conn *sql.DB) (map[string]*model.ReplenishmentPolicy, error) {
+	rows, err := conn.Query(`SELECT ` + replenishmentPolicyColumns + ` FROM replenishment_policies r`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replenishment policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := make(map[string]*model.ReplenishmentPolicy)
+	for rows.Next() {
+		p, err := scanReplenishmentPolicy(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		policies[p.PolicyKey] = p
+	}
+	return policies, rows.Err()
+}
+
+/**
+ * @brief 補充条件を登録または更新します。
+ * @param tx トランザクション
+ * @param p 補充条件
+ * @return error 入力が不正な場合、または処理中にエラーが発生した場合
+ * @details
+ * 包装キーは YJコード|包装形態|内包装数量|YJ単位 の形式、分類は ABC/XYZ分析の分類 (AX～CZ) である必要があります。
+ * 数量・日数は0以上で、発注点と目標在庫の両方を指定する場合は目標在庫を発注点以上にしてください。
+ */
+func SaveReplenishmentPolicyInTx(tx *sql.Tx, p model.ReplenishmentPolicy) error {
+	p.PolicyKey = strings.TrimSpace(p.PolicyKey)
+	if !replenishmentScopes[p.Scope] {
+		return fmt.Errorf("補充条件の対象が不正です: %s", p.Scope)
+	}
+	if p.PolicyKey == "" {
+		return fmt.Errorf("補充条件の対象のコードを指定してください")
+	}
+	if p.Scope == "package" && strings.Count(p.PolicyKey, "|") != 3 {
+		return fmt.Errorf("包装キーは「YJコード|包装形態|内包装数量|YJ単位」の形式で指定してください: %s", p.PolicyKey)
+	}
+	if p.Scope == "class" {
+		p.PolicyKey = strings.ToUpper(p.PolicyKey)
+		if len(p.PolicyKey) != 2 || !strings.Contains("ABC", p.PolicyKey[:1]) || !strings.Contains("XYZ", p.PolicyKey[1:]) {
+			return fmt.Errorf("分類は ABC/XYZ分析の分類 (AX～CZ) で指定してください: %s", p.PolicyKey)
+		}
+	}
+	if p.MinQuantity < 0 || p.MaxQuantity < 0 || p.SafetyDays < 0 || p.LeadTimeDays < 0 || p.MinOrderQuantity < 0 {
+		return fmt.Errorf("補充条件の数量・日数は0以上で指定してください")
+	}
+	if p.MinQuantity > 0 && p.MaxQuantity > 0 && p.MaxQuantity < p.MinQuantity {
+		return fmt.Errorf("目標在庫 (%g) は発注点 (%g) 以上で指定してください", p.MaxQuantity, p.MinQuantity)
+	}
+
+	roundToPackage := 0
+	if p.RoundToPackage {
+		roundToPackage = 1
+	}
+	_, err := tx.Exec(`
+		INSERT INTO replenishment_policies (
+			policy_key, scope, min_quantity, max_quantity, safety_days, lead_time_days, min_order_quantity, round_to_package, notes, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(policy_key) DO UPDATE SET
+			scope = excluded.scope, min_quantity = excluded.min_quantity, max_quantity = excluded.max_quantity,
+			safety_days = excluded.safety_days, lead_time_days = excluded.lead_time_days, min_order_quantity = excluded.min_order_quantity,
+			round_to_package = excluded.round_to_package, notes = excluded.notes, updated_at = excluded.updated_at`,
+		p.PolicyKey, p.Scope, p.MinQuantity, p.MaxQuantity, p.SafetyDays, p.LeadTimeDays, p.MinOrderQuantity, roundToPackage,
+		p.Notes, time.Now().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("failed to save replenishment policy %s: %w", p.PolicyKey, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 補充条件を削除します。
+ * @param tx トランザクション
+ * @param policyKey 削除する補充条件のキー
+ * @return error 処理中にエラーが発生した場合
+ */
+func DeleteReplenishmentPolicyInTx(tx *sql.Tx, policyKey string) error {
+	if _, err := tx.Exec(`DELETE FROM replenishment_policies WHERE policy_key = ?`, policyKey); err != nil {
+		return fmt.Errorf("failed to delete replenishment policy %s: %w", policyKey, err)
+	}
+	return nil
+}
+
+// ResolveReplenishmentPolicy は製品に適用する補充条件を、製品 > 包装 > YJコード > ABC/XYZ分類の順に探して返します。無い場合は nil です。
+// abcXyzClass はABC/XYZ分析で保存した製品の分類 (GetAbcXyzClassMap) で、保存されていない場合は空です。
+func ResolveReplenishmentPolicy(policies map[string]*model.ReplenishmentPolicy, m *model.ProductMaster, abcXyzClass string) *model.ReplenishmentPolicy {
+	for _, c := range []struct{ scope, key string }{{"product", m.ProductCode}, {"package", PackageKey(m)}, {"yj", m.YjCode}, {"class", abcXyzClass}} {
+		if c.key == "" {
+			continue
+		}
+		if p, ok := policies[c.key]; ok && p.Scope == c.scope {
+			return p
+		}
+	}
+	return nil
+}
+
+/**
+ * @brief 在庫元帳の包装の在庫・発注点と補充条件から、製品の発注数を提案します。
+ * @param pkg 在庫元帳の包装 (発注点の計算方法の内訳を含む)
+ * @param m 発注する製品
+ * @param policy 適用する補充条件 (無い場合は nil)
+ * @return model.OrderSuggestion 発注数の提案
+ * @details
+ * 発注点は補充条件の発注点、補充条件のリードタイム・安全在庫日数と予測した1日の需要から計算した値、
+ * 在庫元帳の発注点の順に採用し、予製引当数を加えます。在庫 + 発注残が発注点を下回る場合に、
+ * 目標在庫 (無い場合は発注点) までの不足数を発注します。
+ * 包装単位で発注する場合は包装数を切り上げ、バラ発注の場合はJAN単位で切り上げます。最小発注数を下回る場合は最小発注数とします。
+ * 需要予測が従来方式 (最大処方量×係数) の場合は1日の需要が無いため、リードタイム・安全在庫日数は使用しません。
+ */
+func SuggestOrder(pkg *model.StockLedgerPackageGroup, m *model.ProductMaster, policy *model.ReplenishmentPolicy) model.OrderSuggestion {
+	s := model.OrderSuggestion{AvailableQuantity: pkg.EffectiveEndingBalance}
+	baseReorderPoint := pkg.BaseReorderPoint
+	hasDemand := pkg.MaxUsage > 0
+	roundToPackage := true
+	var minOrderQuantity float64
+
+	if policy != nil {
+		s.PolicyKey, s.Scope = policy.PolicyKey, policy.Scope
+		roundToPackage = policy.RoundToPackage
+		minOrderQuantity = policy.MinOrderQuantity
+		f := pkg.Forecast
+		switch {
+		case policy.MinQuantity > 0:
+			baseReorderPoint = policy.MinQuantity
+			hasDemand = true
+		case (policy.LeadTimeDays > 0 || policy.SafetyDays > 0) && f != nil && f.Method != forecast.MethodMaxUsage:
+			leadTime := float64(f.LeadTimeDays)
+			if policy.LeadTimeDays > 0 {
+				leadTime = float64(policy.LeadTimeDays)
+			}
+			if leadTime < 1 {
+				leadTime = 1
+			}
+			safetyStock := f.SafetyFactor * f.DemandStdDev * math.Sqrt(leadTime)
+			if policy.SafetyDays > 0 {
+				safetyStock = f.DailyDemand * policy.SafetyDays
+			}
+			baseReorderPoint = f.DailyDemand*leadTime + safetyStock
+		}
+	}
+
+	s.ReorderPoint = baseReorderPoint + pkg.PrecompoundedTotal
+	s.OrderUpTo = s.ReorderPoint
+	if policy != nil && policy.MaxQuantity > 0 {
+		s.OrderUpTo = math.Max(policy.MaxQuantity+pkg.PrecompoundedTotal, s.ReorderPoint)
+	}
+	s.ReorderNeeded = hasDemand && s.AvailableQuantity < s.ReorderPoint
+	if !s.ReorderNeeded {
+		return s
+	}
+	s.ShortfallQuantity = s.OrderUpTo - s.AvailableQuantity
+
+	packageQty := m.YjPackUnitQty
+	if packageQty <= 0 {
+		return s
+	}
+	if roundToPackage || m.JanPackInnerQty <= 0 || m.JanPackUnitQty <= 0 {
+		s.Packages = math.Ceil(s.ShortfallQuantity/packageQty - 1e-9)
+		if minOrderQuantity > s.Packages {
+			s.Packages = math.Ceil(minOrderQuantity - 1e-9)
+		}
+		s.YjQuantity = s.Packages * packageQty
+		s.JanQuantity = s.Packages * m.JanPackUnitQty
+		return s
+	}
+	s.JanQuantity = math.Ceil(s.ShortfallQuantity/m.JanPackInnerQty - 1e-9)
+	if minJan := math.Ceil(minOrderQuantity*m.JanPackUnitQty - 1e-9); minJan > s.JanQuantity {
+		s.JanQuantity = minJan
+	}
+	s.YjQuantity = s.JanQuantity * m.JanPackInnerQty
+	s.Packages = s.JanQuantity / m.JanPackUnitQty
+	return s
+}
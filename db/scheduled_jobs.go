@@ -0,0 +1,185 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\scheduled_jobs.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"wasabi/model"
+)
+
+/**
+ * @brief 定期実行ジョブが未登録の場合のみ、既定のスケジュール(無効状態)で登録します。
+ * @param conn データベース接続
+ * @param name タスク名
+ * @param cronExpr 既定のcron形式のスケジュール
+ * @return error 処理中にエラーが発生した場合
+ */
+func EnsureScheduledJob(conn *sql.DB, name, cronExpr string) error {
+	_, err := conn.Exec(`INSERT OR IGNORE INTO scheduled_jobs (name, cron_expr, enabled) VALUES (?, ?, 0)`, name, cronExpr)
+	if err != nil {
+		return fmt.Errorf("failed to register scheduled job %s: %w", name, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 定期実行ジョブの一覧を、直近の実行結果とともに取得します。
+ * @param conn データベース接続
+ * @return []model.ScheduledJob ジョブのスライス (名前順)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * Description と Running は呼び出し側 (schedulerパッケージ) で設定します。
+ */
+func GetScheduledJobs(conn *sql.DB) ([]model.ScheduledJob, error) {
+	rows, err := conn.Query(`
+		SELECT j.name, j.cron_expr, j.enabled, COALESCE(j.next_run_at, ''), COALESCE(j.last_run_at, ''),
+			COALESCE((SELECT r.status FROM job_runs r WHERE r.job_name = j.name ORDER BY r.id DESC LIMIT 1), '')
+		FROM scheduled_jobs j
+		ORDER BY j.name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]model.ScheduledJob, 0)
+	for rows.Next() {
+		var j model.ScheduledJob
+		if err := rows.Scan(&j.Name, &j.CronExpr, &j.Enabled, &j.NextRunAt, &j.LastRunAt, &j.LastStatus); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+/**
+ * @brief 定期実行ジョブのスケジュールと有効・無効を更新します。
+ * @param conn データベース接続
+ * @param name タスク名
+ * @param cronExpr cron形式のスケジュール
+ * @param enabled 有効にする場合は true
+ * @param nextRunAt 次回の実行予定日時。無効の場合は空文字
+ * @return error 処理中にエラーが発生した場合、またはジョブが存在しない場合
+ */
+func UpdateScheduledJob(conn *sql.DB, name, cronExpr string, enabled bool, nextRunAt string) error {
+	res, err := conn.Exec(`UPDATE scheduled_jobs SET cron_expr = ?, enabled = ?, next_run_at = NULLIF(?, '') WHERE name = ?`,
+		cronExpr, enabled, nextRunAt, name)
+	if err != nil {
+		return fmt.Errorf("failed to update scheduled job %s: %w", name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("scheduled job %s not found", name)
+	}
+	return nil
+}
+
+/**
+ * @brief 定期実行ジョブの次回の実行予定日時を更新します。
+ * @param conn データベース接続
+ * @param name タスク名
+ * @param nextRunAt 次回の実行予定日時。空文字の場合はNULL
+ * @return error 処理中にエラーが発生した場合
+ */
+func SetScheduledJobNextRun(conn *sql.DB, name, nextRunAt string) error {
+	if _, err := conn.Exec(`UPDATE scheduled_jobs SET next_run_at = NULLIF(?, '') WHERE name = ?`, nextRunAt, name); err != nil {
+		return fmt.Errorf("failed to set next run of %s: %w", name, err)
+	}
+	return nil
+}
+
+/**
+ * @brief ジョブの実行開始を履歴に記録します。
+ * @param conn データベース接続
+ * @param name タスク名
+ * @param triggerType 実行の契機 ("SCHEDULE", "MANUAL")
+ * @return int64 作成された実行履歴のID
+ * @return error 処理中にエラーが発生した場合
+ */
+func StartJobRun(conn *sql.DB, name, triggerType string) (int64, error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	res, err := conn.Exec(`INSERT INTO job_runs (job_name, trigger_type, started_at, status) VALUES (?, ?, ?, 'RUNNING')`,
+		name, triggerType, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start job run of %s: %w", name, err)
+	}
+	if _, err := conn.Exec(`UPDATE scheduled_jobs SET last_run_at = ? WHERE name = ?`, now, name); err != nil {
+		return 0, fmt.Errorf("failed to update last run of %s: %w", name, err)
+	}
+	return res.LastInsertId()
+}
+
+/**
+ * @brief ジョブの実行終了を履歴に記録します。
+ * @param conn データベース接続
+ * @param runID 実行履歴のID
+ * @param message 実行結果の概要
+ * @param runErr 失敗した場合のエラー。nil の場合は成功として記録します
+ * @return error 処理中にエラーが発生した場合
+ */
+func FinishJobRun(conn *sql.DB, runID int64, message string, runErr error) error {
+	status, errText := "SUCCESS", ""
+	if runErr != nil {
+		status, errText = "FAILED", runErr.Error()
+	}
+	_, err := conn.Exec(`UPDATE job_runs SET finished_at = ?, status = ?, message = ?, error = ? WHERE id = ?`,
+		time.Now().Format("2006-01-02 15:04:05"), status, message, errText, runID)
+	if err != nil {
+		return fmt.Errorf("failed to finish job run %d: %w", runID, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 実行中のまま終了していない履歴を失敗として記録します。
+ * @param conn データベース接続
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 実行中にアプリケーションが終了した場合に残る履歴を、起動時に整理するために使用します。
+ */
+func FailInterruptedJobRuns(conn *sql.DB) error {
+	_, err := conn.Exec(`UPDATE job_runs SET finished_at = ?, status = 'FAILED', error = ? WHERE status = 'RUNNING'`,
+		time.Now().Format("2006-01-02 15:04:05"), "アプリケーションの終了により中断されました")
+	if err != nil {
+		return fmt.Errorf("failed to clean up interrupted job runs: %w", err)
+	}
+	return nil
+}
+
+/**
+ * @brief ジョブの実行履歴を新しい順に取得します。
+ * @param conn データベース接続
+ * @param name タスク名で絞り込む場合に指定 (空文字の場合は全ジョブ)
+ * @param limit 取得する最大件数
+ * @return []model.JobRun 実行履歴のスライス
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetJobRuns(conn *sql.DB, name string, limit int) ([]model.JobRun, error) {
+	q := `SELECT id, job_name, trigger_type, started_at, COALESCE(finished_at, ''), status,
+			COALESCE(message, ''), COALESCE(error, '')
+		FROM job_runs`
+	var args []interface{}
+	if name != "" {
+		q += ` WHERE job_name = ?`
+		args = append(args, name)
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]model.JobRun, 0)
+	for rows.Next() {
+		var r model.JobRun
+		if err := rows.Scan(&r.ID, &r.JobName, &r.TriggerType, &r.StartedAt, &r.FinishedAt, &r.Status, &r.Message, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
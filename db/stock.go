@@ -5,31 +5,43 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"wasabi/model"
 )
 
 /**
  * @brief 指定された単一製品の現在の理論在庫を、棚卸を考慮して正確に計算します。
  */
 func CalculateCurrentStockForProduct(executor DBTX, janCode string) (float64, error) {
+	balance, err := calculateStockBalance(executor, janCode)
+	if err != nil {
+		return 0, err
+	}
+	return balance.BaseQuantity + balance.NetChange, nil
+}
+
+// calculateStockBalance は取引データから単一製品の在庫スナップショット (最新棚卸日・棚卸数量・棚卸後の増減) を計算します。
+func calculateStockBalance(executor DBTX, janCode string) (model.StockBalance, error) {
+	balance := model.StockBalance{ProductCode: janCode}
+
 	var latestInventoryDate sql.NullString
 	err := executor.QueryRow(`
 		SELECT MAX(transaction_date) FROM transaction_records
 		WHERE jan_code = ? AND flag = 0`, janCode).Scan(&latestInventoryDate)
 	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to get latest inventory date for %s: %w", janCode, err)
+		return balance, fmt.Errorf("failed to get latest inventory date for %s: %w", janCode, err)
 	}
 
-	var baseStock float64
 	var netChangeQuery string
 	var args []interface{}
 
 	if latestInventoryDate.Valid && latestInventoryDate.String != "" {
+		balance.LastInventoryDate = latestInventoryDate.String
 		err := executor.QueryRow(`
 			SELECT SUM(yj_quantity) FROM transaction_records
 			WHERE jan_code = ? AND flag = 0 AND transaction_date = ?`,
-			janCode, latestInventoryDate.String).Scan(&baseStock)
+			janCode, latestInventoryDate.String).Scan(&balance.BaseQuantity)
 		if err != nil {
-			return 0, fmt.Errorf("failed to sum inventory for %s on %s: %w", janCode, latestInventoryDate.String, err)
+			return balance, fmt.Errorf("failed to sum inventory for %s on %s: %w", janCode, latestInventoryDate.String, err)
 		}
 
 		netChangeQuery = `
@@ -44,7 +56,6 @@ func CalculateCurrentStockForProduct(executor DBTX, janCode string) (float64, er
 		args = []interface{}{janCode, latestInventoryDate.String}
 
 	} else {
-		baseStock = 0
 		netChangeQuery = `
 			SELECT
 				SUM(CASE
@@ -60,18 +71,45 @@ func CalculateCurrentStockForProduct(executor DBTX, janCode string) (float64, er
 	var nullNetChange sql.NullFloat64
 	err = executor.QueryRow(netChangeQuery, args...).Scan(&nullNetChange)
 	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to calculate net change for %s: %w", janCode, err)
+		return balance, fmt.Errorf("failed to calculate net change for %s: %w", janCode, err)
 	}
-	netChange := nullNetChange.Float64
+	balance.NetChange = nullNetChange.Float64
 
-	return baseStock + netChange, nil
+	return balance, nil
 }
 
 /**
- * @brief 全製品の現在庫を効率的に計算し、マップで返します。
+ * @brief 全製品の現在庫を在庫スナップショット (stock_balances) から取得し、マップで返します。
+ * @details
+ * 再計算が記録されたままの製品があれば、先にスナップショットを更新してから取得します。
  */
 func GetAllCurrentStockMap(conn *sql.DB) (map[string]float64, error) {
-	rows, err := conn.Query(`
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`SELECT product_code, base_quantity + net_change FROM stock_balances`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock balances: %w", err)
+	}
+	defer rows.Close()
+
+	stockMap := make(map[string]float64)
+	for rows.Next() {
+		var janCode string
+		var qty float64
+		if err := rows.Scan(&janCode, &qty); err != nil {
+			return nil, err
+		}
+		stockMap[janCode] = qty
+	}
+	return stockMap, rows.Err()
+}
+
+// calculateAllStockBalances は全ての取引データを走査して、全製品の在庫スナップショットを計算します。
+// スナップショットの作成し直しと検証に使用します。
+func calculateAllStockBalances(executor DBTX) (map[string]model.StockBalance, error) {
+	rows, err := executor.Query(`
 		SELECT jan_code, transaction_date, flag, yj_quantity 
 		FROM transaction_records 
 		ORDER BY jan_code, transaction_date, id`)
@@ -80,8 +118,6 @@ func GetAllCurrentStockMap(conn *sql.DB) (map[string]float64, error) {
 	}
 	defer rows.Close()
 
-	stockMap := make(map[string]float64)
-
 	type txRecord struct {
 		Date string
 		Flag int
@@ -90,55 +126,54 @@ func GetAllCurrentStockMap(conn *sql.DB) (map[string]float64, error) {
 	recordsByJan := make(map[string][]txRecord)
 
 	for rows.Next() {
-		var janCode, date string
-		var flag int
-		var qty float64
+		var janCode, date sql.NullString
+		var flag sql.NullInt64
+		var qty sql.NullFloat64
 		if err := rows.Scan(&janCode, &date, &flag, &qty); err != nil {
 			return nil, err
 		}
-		if janCode == "" {
+		if janCode.String == "" {
 			continue
 		}
-		recordsByJan[janCode] = append(recordsByJan[janCode], txRecord{Date: date, Flag: flag, Qty: qty})
+		recordsByJan[janCode.String] = append(recordsByJan[janCode.String], txRecord{Date: date.String, Flag: int(flag.Int64), Qty: qty.Float64})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
+	balances := make(map[string]model.StockBalance, len(recordsByJan))
 	for janCode, records := range recordsByJan {
-		var latestInvDate string
-		baseStock := 0.0
+		balance := model.StockBalance{ProductCode: janCode}
 
 		invStocksOnDate := make(map[string]float64)
 		for _, r := range records {
 			if r.Flag == 0 {
-				if r.Date > latestInvDate {
-					latestInvDate = r.Date
+				if r.Date > balance.LastInventoryDate {
+					balance.LastInventoryDate = r.Date
 				}
 				invStocksOnDate[r.Date] += r.Qty
 			}
 		}
-		if latestInvDate != "" {
-			baseStock = invStocksOnDate[latestInvDate]
+		if balance.LastInventoryDate != "" {
+			balance.BaseQuantity = invStocksOnDate[balance.LastInventoryDate]
 		}
 
-		netChange := 0.0
 		for _, r := range records {
-			startDate := "00000000"
-			if latestInvDate != "" {
-				startDate = latestInvDate
+			// 棚卸が無い製品は全期間の入出庫を合計する
+			if balance.LastInventoryDate != "" && r.Date <= balance.LastInventoryDate {
+				continue
 			}
-
-			if r.Date > startDate {
-				switch r.Flag {
-				case 1, 4, 11:
-					netChange += r.Qty
-				case 2, 3, 5, 12:
-					netChange -= r.Qty
-				}
+			switch r.Flag {
+			case 1, 4, 11:
+				balance.NetChange += r.Qty
+			case 2, 3, 5, 12:
+				balance.NetChange -= r.Qty
 			}
 		}
-		stockMap[janCode] = baseStock + netChange
+		balances[janCode] = balance
 	}
 
-	return stockMap, nil
+	return balances, nil
 }
 
 /**
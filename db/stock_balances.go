@@ -0,0 +1,243 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\stock_balances.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+	"wasabi/model"
+)
+
+// stockBalanceTolerance は在庫スナップショットの検証で誤差として扱う数量の差です。
+const stockBalanceTolerance = 1e-6
+
+/**
+ * @brief 取引データが変更された製品の在庫スナップショット (stock_balances) とロット別在庫 (lot_balances) を再計算します。
+ * @param tx SQLトランザクションオブジェクト
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * transaction_records のトリガーが変更された製品を stock_balance_changes に記録しているため、
+ * 取引データを登録・更新・削除した処理は、コミット前にこの関数を呼び出してください。
+ * 製品ごとに1回だけ再計算するので、大量の取引を登録した後でも呼び出しは1回で済みます。
+ */
+func RefreshStockBalancesInTx(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT product_code FROM stock_balance_changes`)
+	if err != nil {
+		return fmt.Errorf("failed to get changed products for stock balances: %w", err)
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return err
+		}
+		codes = append(codes, code)
+	}
+	rows.Close()
+	if len(codes) == 0 {
+		return nil
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, code := range codes {
+		if code == "" {
+			continue
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM transaction_records WHERE jan_code = ?`, code).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count transactions for %s: %w", code, err)
+		}
+		if count == 0 {
+			for _, table := range []string{"stock_balances", "lot_balances"} {
+				if _, err := tx.Exec(`DELETE FROM `+table+` WHERE product_code = ?`, code); err != nil {
+					return fmt.Errorf("failed to delete %s for %s: %w", table, code, err)
+				}
+			}
+			continue
+		}
+		balance, err := calculateStockBalance(tx, code)
+		if err != nil {
+			return err
+		}
+		balance.UpdatedAt = now
+		if err := upsertStockBalanceInTx(tx, balance); err != nil {
+			return err
+		}
+		if err := refreshLotBalancesInTx(tx, code, balance.LastInventoryDate); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM stock_balance_changes`); err != nil {
+		return fmt.Errorf("failed to clear stock balance changes: %w", err)
+	}
+	return nil
+}
+
+// RefreshPendingStockBalances は再計算が記録されたままの製品があれば、在庫スナップショットを更新します。
+// RefreshStockBalancesInTx を呼び出していない処理で取引データが変更された場合の備えです。
+func RefreshPendingStockBalances(conn *sql.DB) error {
+	var pending int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM stock_balance_changes`).Scan(&pending); err != nil {
+		return fmt.Errorf("failed to check stock balance changes: %w", err)
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for stock balances: %w", err)
+	}
+	defer tx.Rollback()
+	if err := RefreshStockBalancesInTx(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func upsertStockBalanceInTx(tx *sql.Tx, b model.StockBalance) error {
+	_, err := tx.Exec(`
+		INSERT INTO stock_balances (product_code, last_inventory_date, base_quantity, net_change, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(product_code) DO UPDATE SET
+			last_inventory_date = excluded.last_inventory_date,
+			base_quantity = excluded.base_quantity,
+			net_change = excluded.net_change,
+			updated_at = excluded.updated_at`,
+		b.ProductCode, b.LastInventoryDate, b.BaseQuantity, b.NetChange, b.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save stock balance for %s: %w", b.ProductCode, err)
+	}
+	return nil
+}
+
+/**
+ * @brief 全ての取引データから在庫スナップショットとロット別在庫を作成し直します。
+ * @param conn データベース接続
+ * @return int 作成した製品の件数
+ * @return error 処理中にエラーが発生した場合
+ */
+func RebuildStockBalances(conn *sql.DB) (int, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction for stock balances: %w", err)
+	}
+	defer tx.Rollback()
+
+	balances, err := calculateAllStockBalances(tx)
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"stock_balances", "lot_balances", "stock_balance_changes"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return 0, fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, b := range balances {
+		b.UpdatedAt = now
+		if err := upsertStockBalanceInTx(tx, b); err != nil {
+			return 0, err
+		}
+		if err := refreshLotBalancesInTx(tx, b.ProductCode, b.LastInventoryDate); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit stock balances: %w", err)
+	}
+	return len(balances), nil
+}
+
+/**
+ * @brief 在庫スナップショットを全ての取引データからの再計算結果と比較し、食い違いを返します。
+ * @param conn データベース接続
+ * @return []model.StockBalanceDrift 食い違いのある製品 (製品コード順)。一致していれば空
+ * @return error 処理中にエラーが発生した場合
+ */
+func VerifyStockBalances(conn *sql.DB) ([]model.StockBalanceDrift, error) {
+	if err := RefreshPendingStockBalances(conn); err != nil {
+		return nil, err
+	}
+
+	expected, err := calculateAllStockBalances(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`SELECT product_code, last_inventory_date, base_quantity, net_change, updated_at FROM stock_balances`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock balances: %w", err)
+	}
+	defer rows.Close()
+	stored := make(map[string]model.StockBalance)
+	for rows.Next() {
+		var b model.StockBalance
+		if err := rows.Scan(&b.ProductCode, &b.LastInventoryDate, &b.BaseQuantity, &b.NetChange, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		stored[b.ProductCode] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	drifts := make([]model.StockBalanceDrift, 0)
+	for code, e := range expected {
+		e := e
+		s, ok := stored[code]
+		if !ok {
+			drifts = append(drifts, model.StockBalanceDrift{ProductCode: code, Expected: &e})
+			continue
+		}
+		if s.LastInventoryDate != e.LastInventoryDate ||
+			math.Abs(s.BaseQuantity-e.BaseQuantity) > stockBalanceTolerance ||
+			math.Abs(s.NetChange-e.NetChange) > stockBalanceTolerance {
+			drifts = append(drifts, model.StockBalanceDrift{ProductCode: code, Stored: &s, Expected: &e})
+		}
+	}
+	for code, s := range stored {
+		s := s
+		if _, ok := expected[code]; !ok {
+			drifts = append(drifts, model.StockBalanceDrift{ProductCode: code, Stored: &s})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].ProductCode < drifts[j].ProductCode })
+	return drifts, nil
+}
+
+// initStockBalances は在庫スナップショットまたはロット別在庫が未作成 (テーブルの追加前から使用しているデータベース) の場合に作成します。
+func initStockBalances(conn *sql.DB) error {
+	var balances, nonZero, lots, transactions int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM stock_balances`).Scan(&balances); err != nil {
+		return fmt.Errorf("failed to count stock balances: %w", err)
+	}
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM stock_balances WHERE ABS(base_quantity + net_change) > ?`, stockBalanceTolerance).Scan(&nonZero); err != nil {
+		return fmt.Errorf("failed to count stock balances: %w", err)
+	}
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM lot_balances`).Scan(&lots); err != nil {
+		return fmt.Errorf("failed to count lot balances: %w", err)
+	}
+	if balances > 0 && (lots > 0 || nonZero == 0) {
+		return nil
+	}
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM transaction_records WHERE jan_code != ''`).Scan(&transactions); err != nil {
+		return fmt.Errorf("failed to count transactions: %w", err)
+	}
+	if transactions == 0 {
+		return nil
+	}
+	count, err := RebuildStockBalances(conn)
+	if err != nil {
+		return fmt.Errorf("failed to build stock balances: %w", err)
+	}
+	log.Printf("Stock balances built for %d products.", count)
+	return nil
+}
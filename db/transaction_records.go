@@ -3,6 +3,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -174,6 +175,16 @@ func GetTransactionsByReceiptNumber(conn *sql.DB, receiptNumber string) ([]model
 }
 
 func DeleteTransactionsByReceiptNumberInTx(tx *sql.Tx, receiptNumber string) error {
+	var minDate, maxDate sql.NullString
+	if err := tx.QueryRow(`SELECT MIN(transaction_date), MAX(transaction_date) FROM transaction_records WHERE receipt_number = ?`, receiptNumber).Scan(&minDate, &maxDate); err != nil {
+		return fmt.Errorf("failed to get dates for receipt %s: %w", receiptNumber, err)
+	}
+	if minDate.Valid {
+		if err := CheckPeriodOpen(tx, minDate.String, maxDate.String); err != nil {
+			return fmt.Errorf("receipt %s: %w", receiptNumber, err)
+		}
+	}
+
 	const q = `DELETE FROM transaction_records WHERE receipt_number = ?`
 	_, err := tx.Exec(q, receiptNumber)
 	if err != nil {
@@ -223,6 +234,13 @@ func GetInventoryTransactionsByDate(conn *sql.DB, date string) ([]model.Transact
 }
 
 func DeleteTransactionByIDInTx(tx *sql.Tx, id int) error {
+	var date string
+	if err := tx.QueryRow(`SELECT transaction_date FROM transaction_records WHERE id = ?`, id).Scan(&date); err == nil {
+		if err := CheckPeriodOpen(tx, date, date); err != nil {
+			return fmt.Errorf("transaction %d: %w", id, err)
+		}
+	}
+
 	const q = `DELETE FROM transaction_records WHERE id = ?`
 	res, err := tx.Exec(q, id)
 	if err != nil {
@@ -373,18 +391,43 @@ func UpdateFullTransactionInTx(tx *sql.Tx, record *model.TransactionRecord) erro
 	return nil
 }
 
-func ClearAllTransactions(conn *sql.DB) error {
-	tx, err := conn.Begin()
+// ClearAllTransactions は全ての取引データと関連する記録を削除します。user は監査ログに記録する操作者です。
+func ClearAllTransactions(conn *sql.DB, user string) error {
+	tx, err := BeginAuditTx(conn, user)
 	if err != nil {
 		return fmt.Errorf("failed to start transaction for clearing transactions: %w",
 			err)
 	}
 	defer tx.Rollback()
 
+	// 締め済みの期間の取引データは削除できないため、先に全ての期間を再開する必要がある
+	latest, err := GetLatestClosedDate(tx)
+	if err != nil {
+		return err
+	}
+	if latest != "" {
+		return fmt.Errorf("%w: 締め済みの期間を全て再開してから削除してください", ErrPeriodClosed)
+	}
+
+	// 取込バッチの台帳や帳簿の記載、棚卸の差異・循環棚卸の実施状況など、取引データに付随する記録も共に初期化する
+	for _, table := range []string{"import_batch_backorders", "import_batch_displaced_records", "import_batches", "backorder_fulfillments",
+		"controlled_register_notes", "inventory_variances", "cycle_count_items"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
 	if _, err := tx.Exec(`DELETE FROM transaction_records`); err != nil {
 		return fmt.Errorf("failed to execute delete from transaction_records: %w", err)
 	}
 
+	// 取引データが無くなるため、在庫スナップショットも再計算せずに空にする
+	for _, table := range []string{"stock_balances", "lot_balances", "stock_balance_changes"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
 	if _, err := tx.Exec(`UPDATE sqlite_sequence SET seq = 0 WHERE name = 'transaction_records'`); err != nil {
 		log.Printf("Could not reset sequence for transaction_records (this is normal if table was empty): %v", err)
 	}
@@ -392,8 +435,51 @@ func ClearAllTransactions(conn *sql.DB) error {
 	return tx.Commit()
 }
 
+// USAGE と NSIPS はどちらも処方 (flag=3) として登録するため、取込元は取込バッチの種別で区別する。
+// 取込バッチの無い処方データは取込バッチ導入前の USAGE 取込によるものとして扱う。
+const (
+	// UsageRecordCondition は USAGE 取込による処方データを選ぶ WHERE 句です。
+	UsageRecordCondition = `flag = 3 AND COALESCE(import_batch_id, 0) NOT IN (SELECT id FROM import_batches WHERE source_type = 'NSIPS')`
+	// NsipsRecordCondition は NSIPS 取込による処方データを選ぶ WHERE 句です。
+	NsipsRecordCondition = `flag = 3 AND import_batch_id IN (SELECT id FROM import_batches WHERE source_type = 'NSIPS')`
+)
+
+// ErrUsageSourceConflict は USAGE と NSIPS の処方データを同じ日付に取り込もうとした場合に返されます。
+var ErrUsageSourceConflict = errors.New("prescription data of another source already exists for the date")
+
+/**
+ * @brief 指定した日付に、別の取込元の処方データが登録されていないかを確認します。
+ * @param tx SQLトランザクションオブジェクト
+ * @param sourceType これから取り込む取込元 ("USAGE" または "NSIPS")
+ * @param dates 取り込む処方データの日付 (YYYYMMDD)
+ * @return error 別の取込元の処方データがある場合は ErrUsageSourceConflict をラップしたエラー
+ * @details
+ * 同じ日付に両方の処方データがあると使用量が二重に計上されるため、取込元を混在させずに拒否します。
+ */
+func CheckUsageSourceInTx(tx *sql.Tx, sourceType string, dates []string) error {
+	other, otherName := NsipsRecordCondition, "NSIPS"
+	if sourceType == "NSIPS" {
+		other, otherName = UsageRecordCondition, "USAGE"
+	}
+	for _, date := range dates {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM transaction_records WHERE transaction_date = ? AND `+other+`)`, date).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check prescription source for %s: %w", date, err)
+		}
+		if exists {
+			return fmt.Errorf("%w: %s の処方データが %s に登録済みです。先にその取込を取り消してください", ErrUsageSourceConflict, otherName, date)
+		}
+	}
+	return nil
+}
+
+// DeleteUsageTransactionsInDateRange は期間内の USAGE 取込による処方データを削除します。NSIPS の処方データは削除しません。
 func DeleteUsageTransactionsInDateRange(tx *sql.Tx, minDate, maxDate string) error {
-	const q = `DELETE FROM transaction_records WHERE flag = 3 AND transaction_date BETWEEN ? AND ?`
+	if err := CheckPeriodOpen(tx, minDate, maxDate); err != nil {
+		return err
+	}
+	const q = `DELETE FROM transaction_records WHERE ` + UsageRecordCondition + ` AND transaction_date BETWEEN ? AND ?`
 	_, err := tx.Exec(q, minDate, maxDate)
 	if err != nil {
 		return fmt.Errorf("failed to delete usage transactions: %w", err)
@@ -0,0 +1,127 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\db\wholesaler_quotes.go
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+	"wasabi/model"
+)
+
+const wholesalerQuoteColumns = `id, product_code, wholesaler_code, wholesaler_name, price, valid_from, valid_to, quoted_at`
+
+func scanWholesalerQuotes(rows *sql.Rows) ([]model.WholesalerQuote, error) {
+	defer rows.Close()
+	quotes := make([]model.WholesalerQuote, 0)
+	for rows.Next() {
+		var q model.WholesalerQuote
+		if err := rows.Scan(&q.ID, &q.ProductCode, &q.WholesalerCode, &q.WholesalerName, &q.Price, &q.ValidFrom, &q.ValidTo, &q.QuotedAt); err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, q)
+	}
+	return quotes, rows.Err()
+}
+
+/**
+ * @brief 卸の見積価格を履歴に記録します。
+ * @param tx トランザクション
+ * @param quotes 見積価格 (WholesalerCode が空の場合は卸業者名・コードから卸業者管理の卸を探します)
+ * @return int 記録した件数
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 同じ製品・卸・有効期間の開始日の見積は、価格と終了日を上書きします (同じファイルを再度取り込んだ場合など)。
+ */
+func SaveWholesalerQuotesInTx(tx *sql.Tx, quotes []model.WholesalerQuote) (int, error) {
+	codeByName := make(map[string]string)
+	rows, err := tx.Query(`SELECT wholesaler_code, wholesaler_name FROM wholesalers`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get wholesalers for quotes: %w", err)
+	}
+	for rows.Next() {
+		var code, name string
+		if err := rows.Scan(&code, &name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		codeByName[name] = code
+		codeByName[code] = code
+	}
+	rows.Close()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO wholesaler_quotes (product_code, wholesaler_code, wholesaler_name, price, valid_from, valid_to, quoted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(product_code, wholesaler_name, valid_from) DO UPDATE SET
+			wholesaler_code = excluded.wholesaler_code, price = excluded.price, valid_to = excluded.valid_to, quoted_at = excluded.quoted_at`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare wholesaler quote insert: %w", err)
+	}
+	defer stmt.Close()
+
+	quotedAt := time.Now().Format("2006-01-02 15:04:05")
+	for _, q := range quotes {
+		if q.WholesalerCode == "" {
+			q.WholesalerCode = codeByName[q.WholesalerName]
+		}
+		if _, err := stmt.Exec(q.ProductCode, q.WholesalerCode, q.WholesalerName, q.Price, q.ValidFrom, q.ValidTo, quotedAt); err != nil {
+			return 0, fmt.Errorf("failed to save quote of %s for %s: %w", q.WholesalerName, q.ProductCode, err)
+		}
+	}
+	return len(quotes), nil
+}
+
+/**
+ * @brief 製品の見積価格の履歴を取得します。
+ * @param conn データベース接続
+ * @param productCode 製品コード
+ * @return []model.WholesalerQuote 見積価格 (有効期間の開始日の新しい順)
+ * @return error 処理中にエラーが発生した場合
+ */
+func GetWholesalerQuoteHistory(conn *sql.DB, productCode string) ([]model.WholesalerQuote, error) {
+	rows, err := conn.Query(`SELECT `+wholesalerQuoteColumns+` FROM wholesaler_quotes
+		WHERE product_code = ? ORDER BY valid_from DESC, wholesaler_name`, productCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote history for %s: %w", productCode, err)
+	}
+	return scanWholesalerQuotes(rows)
+}
+
+/**
+ * @brief 指定日に有効な見積価格を、製品ごとに取得します。
+ * @param conn データベース接続
+ * @param date 判定日 (YYYYMMDD)
+ * @return map[string][]model.WholesalerQuote 製品コードごとの見積価格 (卸ごとに1件、価格の安い順)
+ * @return error 処理中にエラーが発生した場合
+ * @details
+ * 同じ卸に有効な見積が複数ある場合は、有効期間の開始日が最も新しい見積を使用します。
+ */
+func GetValidWholesalerQuotes(conn *sql.DB, date string) (map[string][]model.WholesalerQuote, error) {
+	rows, err := conn.Query(`SELECT `+wholesalerQuoteColumns+` FROM wholesaler_quotes
+		WHERE valid_from <= ? AND (valid_to = '' OR valid_to >= ?)
+		ORDER BY product_code, valid_from DESC, id DESC`, date, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get valid quotes on %s: %w", date, err)
+	}
+	quotes, err := scanWholesalerQuotes(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]model.WholesalerQuote)
+	seen := make(map[string]bool)
+	for _, q := range quotes {
+		key := q.ProductCode + "|" + q.WholesalerName
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result[q.ProductCode] = append(result[q.ProductCode], q)
+	}
+	for _, list := range result {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Price < list[j].Price })
+	}
+	return result, nil
+}
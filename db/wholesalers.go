@@ -15,7 +15,7 @@ import (
  * @return error 処理中にエラーが発生した場合
  */
 func GetAllWholesalers(conn *sql.DB) ([]model.Wholesaler, error) {
-	rows, err := conn.Query("SELECT wholesaler_code, wholesaler_name FROM wholesalers ORDER BY wholesaler_code")
+	rows, err := conn.Query("SELECT wholesaler_code, wholesaler_name, min_order_amount FROM wholesalers ORDER BY wholesaler_code")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all wholesalers: %w", err)
 	}
@@ -25,7 +25,7 @@ func GetAllWholesalers(conn *sql.DB) ([]model.Wholesaler, error) {
 	wholesalers := make([]model.Wholesaler, 0)
 	for rows.Next() {
 		var w model.Wholesaler
-		if err := rows.Scan(&w.Code, &w.Name); err != nil {
+		if err := rows.Scan(&w.Code, &w.Name, &w.MinOrderAmount); err != nil {
 			return nil, err
 		}
 		wholesalers = append(wholesalers, w)
@@ -38,17 +38,36 @@ func GetAllWholesalers(conn *sql.DB) ([]model.Wholesaler, error) {
  * @param conn データベース接続
  * @param code 卸業者コード
  * @param name 卸業者名
+ * @param minOrderAmount 1回の発注の最低金額 (0 の場合は制限なし)
  * @return error 処理中にエラーが発生した場合
  */
-func CreateWholesaler(conn *sql.DB, code, name string) error {
-	const q = `INSERT INTO wholesalers (wholesaler_code, wholesaler_name) VALUES (?, ?)`
-	_, err := conn.Exec(q, code, name)
+func CreateWholesaler(conn *sql.DB, code, name string, minOrderAmount float64) error {
+	const q = `INSERT INTO wholesalers (wholesaler_code, wholesaler_name, min_order_amount) VALUES (?, ?, ?)`
+	_, err := conn.Exec(q, code, name, minOrderAmount)
 	if err != nil {
 		return fmt.Errorf("CreateWholesaler failed: %w", err)
 	}
 	return nil
 }
 
+/**
+ * @brief 卸業者の最低発注金額を更新します。
+ * @param conn データベース接続
+ * @param code 卸業者コード
+ * @param minOrderAmount 1回の発注の最低金額 (0 の場合は制限なし)
+ * @return error 処理中にエラーが発生した場合
+ */
+func UpdateWholesalerMinOrderAmount(conn *sql.DB, code string, minOrderAmount float64) error {
+	res, err := conn.Exec(`UPDATE wholesalers SET min_order_amount = ? WHERE wholesaler_code = ?`, minOrderAmount, code)
+	if err != nil {
+		return fmt.Errorf("failed to update minimum order amount of wholesaler %s: %w", code, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("卸業者が見つかりません: %s", code)
+	}
+	return nil
+}
+
 /**
  * @brief 指定されたコードの卸業者を削除します。
  * @param conn データベース接続
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"wasabi/audit"
 	"wasabi/config"
 	"wasabi/db"
 	"wasabi/model"
@@ -68,7 +69,7 @@ func SaveDeadStockHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -168,7 +169,7 @@ func ImportDeadStockHandler(conn *sql.DB) http.HandlerFunc {
 			productCodes = append(productCodes, code)
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -0,0 +1,66 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\emednet\browser.go
+
+package emednet
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Browser は自動操作に使用するブラウザの種類です。
+type Browser string
+
+const (
+	BrowserChrome Browser = "chrome"
+	BrowserEdge   Browser = "edge"
+)
+
+// FindBrowserPath はOSごとの標準的なインストール先からブラウザの実行ファイルを探します。
+// 見つからない場合は空文字を返します。
+func FindBrowserPath(b Browser) string {
+	var candidates []string
+	switch runtime.GOOS {
+	case "windows":
+		var rel string
+		if b == BrowserEdge {
+			rel = filepath.Join("Microsoft", "Edge", "Application", "msedge.exe")
+		} else {
+			rel = filepath.Join("Google", "Chrome", "Application", "chrome.exe")
+		}
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "LocalAppData"} {
+			if dir := os.Getenv(env); dir != "" {
+				candidates = append(candidates, filepath.Join(dir, rel))
+			}
+		}
+	case "darwin":
+		if b == BrowserEdge {
+			candidates = []string{"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge"}
+		} else {
+			candidates = []string{
+				"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+				"/Applications/Chromium.app/Contents/MacOS/Chromium",
+			}
+		}
+	default:
+		var names []string
+		if b == BrowserEdge {
+			names = []string{"microsoft-edge", "microsoft-edge-stable"}
+		} else {
+			names = []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"}
+		}
+		for _, name := range names {
+			if path, err := exec.LookPath(name); err == nil {
+				return path
+			}
+		}
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\emednet\flow.go
+
+package emednet
+
+import "strings"
+
+// Action は自動操作の1手順で行う操作の種類です。
+type Action string
+
+const (
+	// ActionNavigate はベースURL(Valueが空の場合)またはベースURLからの相対パスへ移動します。
+	ActionNavigate Action = "navigate"
+	// ActionWaitVisible はSelectorの要素が表示されるまで待ちます。
+	ActionWaitVisible Action = "waitVisible"
+	// ActionWaitReady はSelectorの要素がDOMに現れるまで待ちます。
+	ActionWaitReady Action = "waitReady"
+	// ActionSendKeys はSelectorの入力欄にValueを入力します。Value中の {{userId}} {{password}} は置換されます。
+	ActionSendKeys Action = "sendKeys"
+	// ActionClick はSelectorの要素をクリックします。
+	ActionClick Action = "click"
+	// ActionCaptureText はSelectorの要素の文字列を、Valueで指定した名前で保持します。要素が無い場合は空文字です。
+	ActionCaptureText Action = "captureText"
+)
+
+// BaselineVar は受信ボタンを押す前の最新の送受信日時を保持する変数名です。
+// 結果の確認では、この値から変化した行を今回の受信結果とみなします。
+const BaselineVar = "baseline"
+
+// Step は自動操作の1手順の定義です。セレクタはCSSとXPath(// で始まるもの)のどちらでも指定できます。
+type Step struct {
+	Name     string `json:"name"`
+	Action   Action `json:"action"`
+	Selector string `json:"selector"`
+	Value    string `json:"value"`
+}
+
+// ResultCheck は受信ボタンを押した後に、結果一覧の先頭行から受信結果を判定するための定義です。
+type ResultCheck struct {
+	TimestampSelector string `json:"timestampSelector"` // 先頭行の送受信日時
+	ResultSelector    string `json:"resultSelector"`    // 先頭行の処理結果
+	SuccessText       string `json:"successText"`
+	NoDataText        string `json:"noDataText"`
+}
+
+// Flow はログインからファイル取得までの一連の手順です。
+type Flow struct {
+	Name   string      `json:"name"`
+	Steps  []Step      `json:"steps"`
+	Result ResultCheck `json:"result"`
+}
+
+// DeliveryFlow は e-mednet の「納品受信(JAN)」で未受信の納品データを受信する手順を返します。
+// ログイン → 業務メニュー(busi_id=11) → 納品受信(JAN) → 未受信データ受信 の順に操作します。
+func DeliveryFlow() Flow {
+	const (
+		latestTimestamp = `table.result-list-table tbody tr:first-child td.col-transceiving-date`
+		latestResult    = `table.result-list-table tbody tr:first-child td.col-result`
+		receiveButton   = `input[name="unreceive_button"]`
+		businessLink    = `//a[contains(@href, "busi_id=11")]`
+		deliveryLink    = `//a[contains(text(), "納品受信(JAN)")]`
+	)
+	return Flow{
+		Name: "納品受信(JAN)",
+		Steps: []Step{
+			{Name: "ログイン画面を開く", Action: ActionNavigate},
+			{Name: "ログイン画面の表示待ち", Action: ActionWaitVisible, Selector: `input[name="userid"]`},
+			{Name: "ユーザーID入力", Action: ActionSendKeys, Selector: `input[name="userid"]`, Value: "{{userId}}"},
+			{Name: "パスワード入力", Action: ActionSendKeys, Selector: `input[name="userpsw"]`, Value: "{{password}}"},
+			{Name: "ログイン", Action: ActionClick, Selector: `input[type="submit"][value="ログイン"]`},
+			{Name: "業務メニューの表示待ち", Action: ActionWaitVisible, Selector: businessLink},
+			{Name: "業務メニューを開く", Action: ActionClick, Selector: businessLink},
+			{Name: "納品受信(JAN)の表示待ち", Action: ActionWaitVisible, Selector: deliveryLink},
+			{Name: "納品受信(JAN)を開く", Action: ActionClick, Selector: deliveryLink},
+			{Name: "受信ボタンの表示待ち", Action: ActionWaitReady, Selector: receiveButton},
+			{Name: "最新の送受信日時を記録", Action: ActionCaptureText, Selector: latestTimestamp, Value: BaselineVar},
+			{Name: "未受信データ受信", Action: ActionClick, Selector: receiveButton},
+		},
+		Result: ResultCheck{
+			TimestampSelector: latestTimestamp,
+			ResultSelector:    latestResult,
+			SuccessText:       "正常完了",
+			NoDataText:        "受信データなし",
+		},
+	}
+}
+
+// expandValue は手順の入力値に含まれる {{userId}} {{password}} をログイン情報で置き換えます。
+func expandValue(value string, opts Options) string {
+	return strings.NewReplacer("{{userId}}", opts.UserID, "{{password}}", opts.Password).Replace(value)
+}
@@ -0,0 +1,79 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\emednet\handler.go
+
+package emednet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"wasabi/audit"
+	"wasabi/config"
+	"wasabi/dat"
+)
+
+// OptionsFromConfig は設定ファイルの内容から自動操作の実行条件を作成します。
+// ログイン情報とブラウザの実行パスは連携先ごとに異なるため、呼び出し側で設定します。
+func OptionsFromConfig(cfg config.Config) Options {
+	return Options{
+		BaseURL:       cfg.EmednetBaseURL,
+		Headless:      cfg.AutomationHeadless,
+		Retries:       cfg.AutomationRetries,
+		DownloadDir:   filepath.Join(".", "download", "DAT"),
+		ScreenshotDir: filepath.Join(".", "download", "screenshots"),
+	}
+}
+
+/**
+ * @brief 納品受信の自動操作を実行し、受信したDATファイルを取り込みます。
+ * @param ctx キャンセルされると自動操作も中断します
+ * @param conn データベース接続
+ * @param opts 自動操作の実行条件
+ * @param user 監査ログに記録する操作者
+ * @return int 登録した納品データの件数
+ * @return Result 自動操作の結果。NoData の場合は取込を行いません
+ * @return error 自動操作または取込に失敗した場合
+ */
+func ReceiveDeliveries(ctx context.Context, conn *sql.DB, opts Options, user string) (int, Result, error) {
+	res, err := Run(ctx, opts)
+	if err != nil || res.NoData {
+		return 0, res, err
+	}
+	processedRecords, err := dat.ProcessDatFile(conn, res.FilePath, user)
+	if err != nil {
+		return 0, res, fmt.Errorf("ダウンロードしたDATファイルの処理に失敗: %w", err)
+	}
+	return len(processedRecords), res, nil
+}
+
+// ServeDownload は ReceiveDeliveries の結果をJSONで返します。
+// medrec (Chrome) と edge (Edge) の連携ボタンから共通で使用され、クライアントが切断すると自動操作も中断します。
+func ServeDownload(w http.ResponseWriter, r *http.Request, conn *sql.DB, opts Options) {
+	count, res, err := ReceiveDeliveries(r.Context(), conn, opts, audit.RequestUser(r))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrResultTimeout) {
+			code = http.StatusRequestTimeout
+		}
+		writeJsonError(w, err.Error(), code)
+		return
+	}
+	if res.NoData {
+		writeJsonError(w, "未受信の納品データはありませんでした。", http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("%d件の納品データをダウンロードし登録しました。", count),
+	})
+}
+
+func writeJsonError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
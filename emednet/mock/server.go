@@ -0,0 +1,248 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\emednet\mock\server.go
+
+// Package mock は e-mednet の ログイン → 納品受信(JAN) → ダウンロード の流れを再現するモックサーバーです。
+// 本番サイトに接続せずに emednet パッケージの自動操作を確認するために使用します。
+package mock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "mock_emednet_session"
+
+// Delivery は受信ボタンを押したときに返す納品データのファイルです。
+type Delivery struct {
+	FileName string
+	Content  []byte
+}
+
+// receiveLog は納品受信(JAN)画面の結果一覧の1行です。
+type receiveLog struct {
+	ID     int
+	At     time.Time
+	Result string
+	File   *Delivery
+}
+
+// Server はモックサイトの状態(ログイン情報・未受信の納品データ・受信履歴)を保持します。
+type Server struct {
+	UserID   string
+	Password string
+	// ResultDelay は受信ボタンを押してから結果が表示されるまでの時間です。
+	ResultDelay time.Duration
+
+	mu       sync.Mutex
+	pending  []Delivery
+	history  []receiveLog
+	sessions map[string]bool
+}
+
+// NewServer はログイン情報を指定してモックサーバーを作成します。
+func NewServer(userID, password string) *Server {
+	return &Server{
+		UserID:   userID,
+		Password: password,
+		sessions: make(map[string]bool),
+	}
+}
+
+// AddDelivery は未受信の納品データを追加します。受信ボタンを押すたびに追加した順に1件ずつ返されます。
+func (s *Server) AddDelivery(d Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, d)
+}
+
+// Handler はモックサイトのハンドラを返します。
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleLogin)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/menu", s.requireLogin(s.handleMenu))
+	mux.HandleFunc("/business", s.requireLogin(s.handleBusiness))
+	mux.HandleFunc("/receive", s.requireLogin(s.handleReceive))
+	mux.HandleFunc("/download", s.requireLogin(s.handleDownload))
+	return mux
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/login" {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]string{}
+	if r.Method == http.MethodPost {
+		if r.FormValue("userid") == s.UserID && r.FormValue("userpsw") == s.Password {
+			token := newToken()
+			s.mu.Lock()
+			s.sessions[token] = true
+			s.mu.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/"})
+			http.Redirect(w, r, "/menu", http.StatusSeeOther)
+			return
+		}
+		data["Error"] = "ユーザーIDまたはパスワードが正しくありません。"
+	}
+	render(w, loginPage, data)
+}
+
+func (s *Server) handleMenu(w http.ResponseWriter, r *http.Request) {
+	render(w, menuPage, nil)
+}
+
+func (s *Server) handleBusiness(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("busi_id") != "11" {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, businessPage, nil)
+}
+
+// handleReceive は納品受信(JAN)画面です。POST は受信ボタンの押下で、未受信の納品データを1件受信します。
+// 受信できた場合は、結果一覧の表示後にブラウザがファイルをダウンロードします。
+func (s *Server) handleReceive(w http.ResponseWriter, r *http.Request) {
+	var downloadID int
+	if r.Method == http.MethodPost && r.FormValue("unreceive_button") != "" {
+		if s.ResultDelay > 0 {
+			time.Sleep(s.ResultDelay)
+		}
+		downloadID = s.receive()
+	}
+
+	s.mu.Lock()
+	rows := make([]receiveLog, len(s.history))
+	// 新しい受信結果を先頭に表示する
+	for i, h := range s.history {
+		rows[len(s.history)-1-i] = h
+	}
+	s.mu.Unlock()
+
+	render(w, receivePage, map[string]interface{}{
+		"Rows":       rows,
+		"DownloadID": downloadID,
+	})
+}
+
+// receive は未受信の納品データを1件受信済みにして履歴に追加し、ダウンロード用のIDを返します。
+// 未受信の納品データが無い場合は「受信データなし」を記録して0を返します。
+func (s *Server) receive() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := time.Now().Truncate(time.Second)
+	// 画面の日時は秒までのため、同じ秒に続けて受信しても結果の行を区別できるようにする
+	if n := len(s.history); n > 0 && !at.After(s.history[n-1].At) {
+		at = s.history[n-1].At.Add(time.Second)
+	}
+	entry := receiveLog{ID: len(s.history) + 1, At: at, Result: "受信データなし"}
+	if len(s.pending) > 0 {
+		d := s.pending[0]
+		s.pending = s.pending[1:]
+		entry.Result = "正常完了"
+		entry.File = &d
+	}
+	s.history = append(s.history, entry)
+	if entry.File == nil {
+		return 0
+	}
+	return entry.ID
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	s.mu.Lock()
+	var file *Delivery
+	if id > 0 && id <= len(s.history) {
+		file = s.history[id-1].File
+	}
+	s.mu.Unlock()
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, file.FileName))
+	w.Write(file.Content)
+}
+
+func (s *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookie)
+		s.mu.Lock()
+		ok := err == nil && s.sessions[c.Value]
+		s.mu.Unlock()
+		if !ok {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="ja"><head><meta charset="utf-8"><title>e-mednet (mock) ログイン</title></head>
+<body>
+<h1>e-mednet ログイン</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/login">
+  <p>ユーザーID <input type="text" name="userid"></p>
+  <p>パスワード <input type="password" name="userpsw"></p>
+  <p><input type="submit" value="ログイン"></p>
+</form>
+</body></html>`))
+
+var menuPage = template.Must(template.New("menu").Parse(`<!DOCTYPE html>
+<html lang="ja"><head><meta charset="utf-8"><title>e-mednet (mock) メニュー</title></head>
+<body>
+<h1>業務メニュー</h1>
+<ul>
+  <li><a href="/business?busi_id=10">発注業務</a></li>
+  <li><a href="/business?busi_id=11">納品業務</a></li>
+</ul>
+</body></html>`))
+
+var businessPage = template.Must(template.New("business").Parse(`<!DOCTYPE html>
+<html lang="ja"><head><meta charset="utf-8"><title>e-mednet (mock) 納品業務</title></head>
+<body>
+<h1>納品業務</h1>
+<ul>
+  <li><a href="/receive">納品受信(JAN)</a></li>
+</ul>
+</body></html>`))
+
+var receivePage = template.Must(template.New("receive").Parse(`<!DOCTYPE html>
+<html lang="ja"><head><meta charset="utf-8"><title>e-mednet (mock) 納品受信(JAN)</title></head>
+<body>
+<h1>納品受信(JAN)</h1>
+<form method="post" action="/receive">
+  <input type="submit" name="unreceive_button" value="未受信データ受信">
+</form>
+<table class="result-list-table">
+  <thead><tr><th>送受信日時</th><th>処理結果</th></tr></thead>
+  <tbody>
+  {{range .Rows}}<tr><td class="col-transceiving-date">{{.At.Format "2006/01/02 15:04:05"}}</td><td class="col-result">{{.Result}}</td></tr>
+  {{end}}
+  </tbody>
+</table>
+{{if .DownloadID}}<script>window.location.href = "/download?id={{.DownloadID}}";</script>{{end}}
+</body></html>`))
@@ -0,0 +1,361 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\emednet\runner.go
+
+package emednet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// DefaultBaseURL は e-mednet 本番サイトのURLです。
+const DefaultBaseURL = "https://www.e-mednet.jp/"
+
+const (
+	defaultStepTimeout   = 30 * time.Second
+	defaultResultTimeout = 30 * time.Second
+	defaultFileTimeout   = 10 * time.Second
+	defaultPollInterval  = 1 * time.Second
+	// checkTimeout は結果確認で1回の読み取りにかける時間の上限です。
+	checkTimeout = 4 * time.Second
+)
+
+var (
+	// ErrResultTimeout は受信ボタンを押した後、時間内に受信結果が表示されなかったことを示します。
+	ErrResultTimeout = errors.New("サイトの反応が確認できませんでした")
+	// ErrFileTimeout は受信結果は正常完了でしたが、時間内にファイルが保存されなかったことを示します。
+	ErrFileTimeout = errors.New("ファイルが見つかりませんでした")
+)
+
+// Options は自動操作の実行条件です。ゼロ値の項目には既定値が使われます。
+type Options struct {
+	BaseURL     string  // 空の場合は DefaultBaseURL。モックサーバーで動作確認する場合はそのURL
+	Browser     Browser // BrowserPath が空の場合に FindBrowserPath で探すブラウザ
+	BrowserPath string
+	Headless    bool
+	NoSandbox   bool
+
+	UserID   string
+	Password string
+
+	DownloadDir   string // 受信したファイルの保存先
+	ScreenshotDir string // 失敗時のスクリーンショットの保存先。空の場合は保存しない
+
+	Retries       int // 失敗時の再試行回数
+	StepTimeout   time.Duration
+	ResultTimeout time.Duration
+	FileTimeout   time.Duration
+	PollInterval  time.Duration
+
+	Flow *Flow // nil の場合は DeliveryFlow
+}
+
+// Result は自動操作の結果です。
+type Result struct {
+	FilePath string // 受信したファイル。NoData の場合は空
+	NoData   bool   // 未受信のデータが無かった
+	Attempts int    // 実行した回数(再試行を含む)
+}
+
+/**
+ * @brief 手順定義に従ってブラウザを自動操作し、受信したファイルのパスを返します。
+ * @param ctx 呼び出し元のコンテキスト。キャンセルされると自動操作も中断します
+ * @param opts 実行条件
+ * @return Result 受信結果
+ * @return error 再試行しても失敗した場合、最後の試行のエラー
+ * @details
+ * 試行ごとに一時プロファイルで新しくブラウザを起動します。失敗した試行はスクリーンショットを保存し、
+ * opts.Retries 回まで再試行します。前の試行で遅れて保存されたファイルがあれば、再試行せずにそれを返します。
+ */
+func Run(ctx context.Context, opts Options) (Result, error) {
+	opts = withDefaults(opts)
+	flow := DeliveryFlow()
+	if opts.Flow != nil {
+		flow = *opts.Flow
+	}
+
+	if err := os.MkdirAll(opts.DownloadDir, 0755); err != nil {
+		return Result{}, fmt.Errorf("ダウンロードディレクトリの作成に失敗: %w", err)
+	}
+	filesBefore, err := listFiles(opts.DownloadDir)
+	if err != nil {
+		return Result{}, fmt.Errorf("ダウンロードディレクトリの読み取りに失敗: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= opts.Retries+1; attempt++ {
+		if attempt > 1 {
+			if path := findNewFile(opts.DownloadDir, filesBefore); path != "" {
+				return Result{FilePath: path, Attempts: attempt - 1}, nil
+			}
+			log.Printf("emednet: retrying %s (attempt %d/%d): %v", flow.Name, attempt, opts.Retries+1, lastErr)
+		}
+
+		res, err := runAttempt(ctx, opts, flow, filesBefore, attempt)
+		res.Attempts = attempt
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return Result{Attempts: opts.Retries + 1}, lastErr
+}
+
+func withDefaults(opts Options) Options {
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.Browser == "" {
+		opts.Browser = BrowserChrome
+	}
+	if opts.DownloadDir == "" {
+		opts.DownloadDir = filepath.Join(".", "download", "DAT")
+	}
+	if abs, err := filepath.Abs(opts.DownloadDir); err == nil {
+		opts.DownloadDir = abs
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.StepTimeout <= 0 {
+		opts.StepTimeout = defaultStepTimeout
+	}
+	if opts.ResultTimeout <= 0 {
+		opts.ResultTimeout = defaultResultTimeout
+	}
+	if opts.FileTimeout <= 0 {
+		opts.FileTimeout = defaultFileTimeout
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	return opts
+}
+
+// runAttempt はブラウザを1回起動して手順を実行します。失敗時はブラウザを閉じる前にスクリーンショットを保存します。
+func runAttempt(ctx context.Context, opts Options, flow Flow, filesBefore map[string]bool, attempt int) (Result, error) {
+	tempDir, err := os.MkdirTemp("", "chromedp-emednet-")
+	if err != nil {
+		return Result{}, fmt.Errorf("一時プロファイルディレクトリの作成に失敗: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", opts.Headless),
+		chromedp.Flag("disable-gpu", true),
+		chromedp.UserDataDir(tempDir),
+	)
+	if opts.NoSandbox {
+		allocOpts = append(allocOpts, chromedp.NoSandbox)
+	}
+	execPath := opts.BrowserPath
+	if execPath == "" {
+		execPath = FindBrowserPath(opts.Browser)
+	}
+	if execPath != "" {
+		allocOpts = append(allocOpts, chromedp.ExecPath(execPath))
+	}
+
+	allocCtx, allocCancel := chromedp.NewExecAllocator(ctx, allocOpts...)
+	defer allocCancel()
+	browserCtx, cancel := chromedp.NewContext(allocCtx, chromedp.WithLogf(log.Printf))
+	defer cancel()
+
+	// 最初の Run でブラウザが起動する。タイムアウト付きのコンテキストで起動すると
+	// タイムアウト時にブラウザごと閉じてしまうため、ここでは browserCtx をそのまま使う
+	if err := chromedp.Run(browserCtx,
+		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllow).WithDownloadPath(opts.DownloadDir),
+	); err != nil {
+		return Result{}, fmt.Errorf("ブラウザの起動に失敗しました: %w", err)
+	}
+
+	res, err := runFlow(browserCtx, opts, flow, filesBefore)
+	if err != nil && opts.ScreenshotDir != "" && ctx.Err() == nil {
+		if shot, shotErr := saveScreenshot(browserCtx, opts.ScreenshotDir, attempt); shotErr != nil {
+			log.Printf("emednet: failed to save screenshot: %v", shotErr)
+		} else {
+			err = fmt.Errorf("%w (スクリーンショット: %s)", err, shot)
+		}
+	}
+	return res, err
+}
+
+// runFlow は手順を順に実行し、受信結果の表示とファイルの保存を待ちます。
+func runFlow(ctx context.Context, opts Options, flow Flow, filesBefore map[string]bool) (Result, error) {
+	vars := make(map[string]string)
+	for i, step := range flow.Steps {
+		action, err := stepAction(step, opts, vars)
+		if err != nil {
+			return Result{}, err
+		}
+		stepCtx, cancel := context.WithTimeout(ctx, opts.StepTimeout)
+		err = chromedp.Run(stepCtx, action)
+		cancel()
+		if err != nil {
+			return Result{}, fmt.Errorf("自動操作に失敗しました (手順%d %s): %w", i+1, step.Name, err)
+		}
+	}
+
+	noData, err := waitResult(ctx, opts, flow.Result, vars[BaselineVar])
+	if err != nil {
+		return Result{}, err
+	}
+	if noData {
+		return Result{NoData: true}, nil
+	}
+
+	path, err := waitFile(ctx, opts, filesBefore)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{FilePath: path}, nil
+}
+
+// stepAction は手順の定義を chromedp のアクションに変換します。
+func stepAction(step Step, opts Options, vars map[string]string) (chromedp.Action, error) {
+	switch step.Action {
+	case ActionNavigate:
+		target, err := resolveURL(opts.BaseURL, step.Value)
+		if err != nil {
+			return nil, err
+		}
+		return chromedp.Navigate(target), nil
+	case ActionWaitVisible:
+		return chromedp.WaitVisible(step.Selector), nil
+	case ActionWaitReady:
+		return chromedp.WaitReady(step.Selector), nil
+	case ActionSendKeys:
+		return chromedp.SendKeys(step.Selector, expandValue(step.Value, opts)), nil
+	case ActionClick:
+		return chromedp.Click(step.Selector), nil
+	case ActionCaptureText:
+		return chromedp.ActionFunc(func(ctx context.Context) error {
+			var text string
+			if err := chromedp.Text(step.Selector, &text, chromedp.AtLeast(0)).Do(ctx); err != nil {
+				return err
+			}
+			vars[step.Value] = strings.TrimSpace(text)
+			return nil
+		}), nil
+	}
+	return nil, fmt.Errorf("手順 %s: 不明な操作 %q です", step.Name, step.Action)
+}
+
+// waitResult は結果一覧の先頭行の送受信日時が baseline から変わるのを待ち、処理結果を判定します。
+// 未受信データが無かった場合は true を返します。
+func waitResult(ctx context.Context, opts Options, check ResultCheck, baseline string) (bool, error) {
+	timeout := time.After(opts.ResultTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timeout:
+			return false, fmt.Errorf("%d秒以内に%w。", int(opts.ResultTimeout.Seconds()), ErrResultTimeout)
+		case <-time.After(opts.PollInterval):
+			if readText(ctx, check.TimestampSelector) == baseline {
+				continue
+			}
+			switch readText(ctx, check.ResultSelector) {
+			case check.SuccessText:
+				return false, nil
+			case check.NoDataText:
+				return true, nil
+			}
+		}
+	}
+}
+
+// readText はセレクタの要素の文字列を読み取ります。読み取れない場合は空文字を返します。
+func readText(ctx context.Context, selector string) string {
+	var text string
+	checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	_ = chromedp.Run(checkCtx, chromedp.Text(selector, &text, chromedp.AtLeast(0)))
+	return strings.TrimSpace(text)
+}
+
+// waitFile はダウンロードディレクトリに新しいファイルが保存されるのを待ちます。
+func waitFile(ctx context.Context, opts Options, filesBefore map[string]bool) (string, error) {
+	timeout := time.After(opts.FileTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("サイトの反応はありましたが、%d秒以内に%w。", int(opts.FileTimeout.Seconds()), ErrFileTimeout)
+		case <-time.After(500 * time.Millisecond):
+			if path := findNewFile(opts.DownloadDir, filesBefore); path != "" {
+				return path, nil
+			}
+		}
+	}
+}
+
+func listFiles(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		files[e.Name()] = true
+	}
+	return files, nil
+}
+
+// findNewFile は filesBefore に無い、ダウンロードが完了したファイルを探します。
+func findNewFile(dir string, filesBefore map[string]bool) string {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() || filesBefore[e.Name()] || strings.HasSuffix(e.Name(), ".crdownload") {
+			continue
+		}
+		return filepath.Join(dir, e.Name())
+	}
+	return ""
+}
+
+func resolveURL(baseURL, path string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("ベースURL %q が正しくありません: %w", baseURL, err)
+	}
+	if path == "" {
+		return base.String(), nil
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("移動先 %q が正しくありません: %w", path, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// saveScreenshot は現在の画面を ScreenshotDir に保存し、保存したパスを返します。
+func saveScreenshot(ctx context.Context, dir string, attempt int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	var buf []byte
+	shotCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := chromedp.Run(shotCtx, chromedp.CaptureScreenshot(&buf)); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("emednet_%s_%d.png", time.Now().Format("20060102_150405"), attempt))
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,301 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\expiry\handler.go
+
+package expiry
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wasabi/config"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// ExpiryAlertReport は有効期限切迫レポートのレスポンスです。
+type ExpiryAlertReport struct {
+	Date    string                  `json:"date"` // 基準日 (YYYY-MM-DD)
+	Days    int                     `json:"days"`
+	Windows []int                   `json:"windows"`
+	Items   []model.ExpiryAlertItem `json:"items"`
+}
+
+// alertWindows は設定から集計する日数を昇順で返します。未設定の場合は既定値を返します。
+func alertWindows() []int {
+	var windows []int
+	for _, d := range config.GetConfig().ExpiryAlertDays {
+		if d > 0 {
+			windows = append(windows, d)
+		}
+	}
+	if len(windows) == 0 {
+		windows = append(windows, config.DefaultExpiryAlertDays...)
+	}
+	sort.Ints(windows)
+	return windows
+}
+
+// loadReport はリクエストの days パラメータ (省略時は設定の最大日数) でレポートを作成します。
+func loadReport(conn *sql.DB, r *http.Request) (*ExpiryAlertReport, error) {
+	windows := alertWindows()
+	days := windows[len(windows)-1]
+	if v := r.URL.Query().Get("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("days の指定が不正です: %s", v)
+		}
+		days = d
+	}
+	today := time.Now()
+	items, err := db.GetExpiryAlerts(conn, today, days)
+	if err != nil {
+		return nil, err
+	}
+	return &ExpiryAlertReport{Date: today.Format("2006-01-02"), Days: days, Windows: windows, Items: items}, nil
+}
+
+// GetExpiryAlertsHandler は有効期限が指定日数以内の在庫をJSONで返します。
+func GetExpiryAlertsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get expiry alerts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// GetExpirySummaryHandler はホーム画面の警告表示用に、期限切れと設定日数ごとの件数・金額を返します。
+func GetExpirySummaryHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary, err := db.GetExpiryAlertSummary(conn, time.Now(), alertWindows())
+		if err != nil {
+			http.Error(w, "Failed to get expiry summary: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"windows": summary})
+	}
+}
+
+// ExportExpiryAlertsHandler は有効期限切迫レポートをExcelファイルとしてエクスポートします。
+func ExportExpiryAlertsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get expiry alerts for export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		f := excelize.NewFile()
+		sheetName := "期限切迫一覧"
+		index, _ := f.NewSheet(sheetName)
+		f.SetActiveSheet(index)
+		f.DeleteSheet("Sheet1")
+
+		headers := []string{"有効期限", "残日数", "製品名", "包装", "棚番", "ロット番号", "数量", "YJ単位", "薬価金額", "納入価金額", "最終処方日"}
+		headerStyle, _ := f.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true},
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center"},
+		})
+		for i, h := range headers {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+			f.SetCellValue(sheetName, cell, h)
+			f.SetCellStyle(sheetName, cell, cell, headerStyle)
+		}
+
+		rowNum := 2
+		var totalNhi, totalPurchase float64
+		for _, item := range report.Items {
+			row := strconv.Itoa(rowNum)
+			f.SetCellValue(sheetName, "A"+row, item.ExpiryDate)
+			f.SetCellValue(sheetName, "B"+row, item.DaysLeft)
+			f.SetCellValue(sheetName, "C"+row, item.ProductName)
+			f.SetCellValue(sheetName, "D"+row, item.PackageSpec)
+			f.SetCellValue(sheetName, "E"+row, item.ShelfNumber)
+			f.SetCellValue(sheetName, "F"+row, item.LotNumber)
+			f.SetCellValue(sheetName, "G"+row, item.Quantity)
+			f.SetCellValue(sheetName, "H"+row, item.YjUnitName)
+			f.SetCellValue(sheetName, "I"+row, item.NhiValue)
+			f.SetCellValue(sheetName, "J"+row, item.PurchaseValue)
+			f.SetCellValue(sheetName, "K"+row, formatDate(item.LastUsageDate))
+			totalNhi += item.NhiValue
+			totalPurchase += item.PurchaseValue
+			rowNum++
+		}
+		totalRow := strconv.Itoa(rowNum + 1)
+		f.SetCellValue(sheetName, "H"+totalRow, "総合計")
+		f.SetCellValue(sheetName, "I"+totalRow, totalNhi)
+		f.SetCellValue(sheetName, "J"+totalRow, totalPurchase)
+		currencyStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 2})
+		totalStyle, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}, NumFmt: 2})
+		f.SetCellStyle(sheetName, "I2", "J"+totalRow, currencyStyle)
+		f.SetCellStyle(sheetName, "H"+totalRow, "J"+totalRow, totalStyle)
+		f.SetColWidth(sheetName, "C", "C", 40)
+		f.SetColWidth(sheetName, "D", "D", 25)
+
+		fileName := fmt.Sprintf("期限切迫一覧_%d日以内_%s.xlsx", report.Days, strings.ReplaceAll(report.Date, "-", ""))
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+		if err := f.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// ExportExpiryAlertsPDFHandler は有効期限切迫レポートをPDFファイルとしてエクスポートします。
+func ExportExpiryAlertsPDFHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := loadReport(conn, r)
+		if err != nil {
+			http.Error(w, "Failed to get expiry alerts for export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		const (
+			pageHeight   = 210.0
+			leftMargin   = 10.0
+			topMargin    = 10.0
+			rightMargin  = 10.0
+			bottomMargin = 15.0
+			rowHeight    = 7.0
+		)
+		columns := []struct {
+			title string
+			width float64
+			align string
+		}{
+			{"有効期限", 22, "C"},
+			{"残日数", 14, "R"},
+			{"製品名", 70, "L"},
+			{"包装", 45, "L"},
+			{"棚番", 18, "L"},
+			{"ロット番号", 25, "L"},
+			{"数量", 22, "R"},
+			{"薬価金額", 20, "R"},
+			{"納入価金額", 20, "R"},
+			{"最終処方日", 21, "C"},
+		}
+
+		pdf := gofpdf.New("L", "mm", "A4", "")
+		pdf.SetMargins(leftMargin, topMargin, rightMargin)
+		pdf.SetAutoPageBreak(false, bottomMargin)
+		pdf.AddUTF8Font("ipaexg", "", "SOU/ipaexg.ttf")
+		pdf.AddPage()
+
+		pdf.SetFont("ipaexg", "", 14)
+		pdf.Cell(0, 10, fmt.Sprintf("%s 有効期限切迫一覧 (%d日以内)", report.Date, report.Days))
+		pdf.Ln(12)
+
+		drawHeader := func() {
+			pdf.SetFont("ipaexg", "", 9)
+			pdf.SetFillColor(240, 240, 240)
+			for _, c := range columns {
+				pdf.CellFormat(c.width, rowHeight, c.title, "1", 0, "C", true, 0, "")
+			}
+			pdf.Ln(rowHeight)
+		}
+		drawHeader()
+
+		// セル幅に収まらない文字列は末尾を切り詰める
+		fit := func(s string, width float64) string {
+			for s != "" && pdf.GetStringWidth(s) > width-2 {
+				runes := []rune(s)
+				s = string(runes[:len(runes)-1])
+			}
+			return s
+		}
+
+		var totalNhi, totalPurchase float64
+		for _, item := range report.Items {
+			if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+				pdf.AddPage()
+				drawHeader()
+			}
+			values := []string{
+				item.ExpiryDate,
+				strconv.Itoa(item.DaysLeft),
+				item.ProductName,
+				item.PackageSpec,
+				item.ShelfNumber,
+				item.LotNumber,
+				fmt.Sprintf("%.2f %s", item.Quantity, item.YjUnitName),
+				formatCurrency(item.NhiValue),
+				formatCurrency(item.PurchaseValue),
+				formatDate(item.LastUsageDate),
+			}
+			if item.DaysLeft < 0 {
+				pdf.SetTextColor(200, 0, 0)
+			}
+			for i, c := range columns {
+				pdf.CellFormat(c.width, rowHeight, fit(values[i], c.width), "1", 0, c.align, false, 0, "")
+			}
+			pdf.SetTextColor(0, 0, 0)
+			pdf.Ln(rowHeight)
+			totalNhi += item.NhiValue
+			totalPurchase += item.PurchaseValue
+		}
+
+		if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+			pdf.AddPage()
+			drawHeader()
+		}
+		var labelWidth float64
+		for _, c := range columns[:7] {
+			labelWidth += c.width
+		}
+		pdf.SetFillColor(240, 240, 240)
+		pdf.CellFormat(labelWidth, rowHeight, fmt.Sprintf("総合計 (%d件)", len(report.Items)), "1", 0, "R", true, 0, "")
+		pdf.CellFormat(columns[7].width, rowHeight, formatCurrency(totalNhi), "1", 0, "R", true, 0, "")
+		pdf.CellFormat(columns[8].width, rowHeight, formatCurrency(totalPurchase), "1", 0, "R", true, 0, "")
+		pdf.CellFormat(columns[9].width, rowHeight, "", "1", 1, "R", true, 0, "")
+
+		var buffer bytes.Buffer
+		if err := pdf.Output(&buffer); err != nil {
+			http.Error(w, "PDFの生成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fileName := fmt.Sprintf("期限切迫一覧_%d日以内_%s.pdf", report.Days, strings.ReplaceAll(report.Date, "-", ""))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fileName))
+		w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+		if _, err := buffer.WriteTo(w); err != nil {
+			http.Error(w, "PDFの送信に失敗しました: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// formatDate は YYYYMMDD 形式の日付を YYYY-MM-DD 形式に変換します。
+func formatDate(s string) string {
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:6] + "-" + s[6:]
+}
+
+func formatCurrency(value float64) string {
+	s := strconv.FormatFloat(value, 'f', 0, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	var parts []string
+	for len(s) > 3 {
+		parts = append([]string{s[len(s)-3:]}, parts...)
+		s = s[:len(s)-3]
+	}
+	parts = append([]string{s}, parts...)
+	return sign + strings.Join(parts, ",")
+}
@@ -0,0 +1,256 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\forecast\forecast.go
+
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"wasabi/config"
+	"wasabi/model"
+)
+
+// 需要予測の方法です。
+const (
+	MethodMovingAverage        = "moving_average"        // 直近 WindowDays 日の1日平均
+	MethodExponentialSmoothing = "exponential_smoothing" // 単純指数平滑
+	MethodDayOfWeek            = "day_of_week"           // 直近 WindowDays 日の曜日別の平均
+	MethodMaxUsage             = "max_usage"             // 従来方式 (期間中の1回の最大処方量 × 係数)
+)
+
+// DefaultParams は設定が無い場合の需要予測の条件です。
+// 予測方法が未設定の場合は、各画面の係数を使う従来方式 (max_usage) とします。
+var DefaultParams = model.ForecastParams{
+	Method:       MethodMaxUsage,
+	WindowDays:   28,
+	Alpha:        0.3,
+	LeadTimeDays: 2,
+	ServiceLevel: 0.95,
+}
+
+// Forecaster は日ごとの需要の履歴から将来の需要を予測します。
+type Forecaster interface {
+	// Forecast は history (history[0] が firstDay の需要) から、最終日の翌日以降 days 日分の需要と、
+	// 1日の需要の予測誤差の標準偏差を返します。
+	Forecast(history []float64, firstDay time.Time, days int) (daily []float64, stdDev float64)
+}
+
+// forecasters は方法ごとの Forecaster の作成関数です。Register で追加できます。
+var forecasters = map[string]func(p model.ForecastParams) Forecaster{
+	MethodMovingAverage:        func(p model.ForecastParams) Forecaster { return movingAverage{window: p.WindowDays} },
+	MethodExponentialSmoothing: func(p model.ForecastParams) Forecaster { return exponentialSmoothing{alpha: p.Alpha} },
+	MethodDayOfWeek:            func(p model.ForecastParams) Forecaster { return dayOfWeek{window: p.WindowDays} },
+}
+
+// Register は需要予測の方法を追加 (または置き換え) します。
+func Register(method string, newForecaster func(p model.ForecastParams) Forecaster) {
+	forecasters[method] = newForecaster
+}
+
+// Methods は登録されている需要予測の方法 (従来方式を含む) を返します。
+func Methods() []string {
+	methods := []string{MethodMaxUsage}
+	for method := range forecasters {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// ParamsFromConfig は設定から需要予測の条件を作成します。未設定の項目は既定値を使用します。
+// coefficient は従来方式 (max_usage) の係数です。
+func ParamsFromConfig(cfg config.Config, coefficient float64) model.ForecastParams {
+	p := DefaultParams
+	if cfg.ForecastMethod != "" {
+		p.Method = cfg.ForecastMethod
+	}
+	if cfg.ForecastWindowDays > 0 {
+		p.WindowDays = cfg.ForecastWindowDays
+	}
+	if cfg.ForecastAlpha > 0 && cfg.ForecastAlpha <= 1 {
+		p.Alpha = cfg.ForecastAlpha
+	}
+	if cfg.LeadTimeDays > 0 {
+		p.LeadTimeDays = cfg.LeadTimeDays
+	}
+	if cfg.ServiceLevel > 0 && cfg.ServiceLevel < 1 {
+		p.ServiceLevel = cfg.ServiceLevel
+	}
+	p.Coefficient = coefficient
+	return p
+}
+
+// Validate は需要予測の方法が登録されているかを確認します。
+func Validate(method string) error {
+	if method == MethodMaxUsage {
+		return nil
+	}
+	if _, ok := forecasters[method]; !ok {
+		return fmt.Errorf("不明な需要予測の方法です: %s", method)
+	}
+	return nil
+}
+
+// SafetyFactor はサービス率 (欠品しない確率) に対応する標準正規分布の値 (z) を返します。
+func SafetyFactor(serviceLevel float64) float64 {
+	if serviceLevel <= 0.5 {
+		return 0
+	}
+	if serviceLevel > 0.9999 {
+		serviceLevel = 0.9999
+	}
+	return math.Sqrt2 * math.Erfinv(2*serviceLevel-1)
+}
+
+/**
+ * @brief 日ごとの需要の履歴から発注点を計算します。
+ * @param p 需要予測の方法と条件
+ * @param history 日ごとの需要 (YJ単位。history[0] が firstDay)
+ * @param firstDay 履歴の初日
+ * @param maxUsage 期間中の1回の最大処方量 (従来方式でのみ使用)
+ * @return float64 発注点 (リードタイム需要 + 安全在庫)
+ * @return model.ForecastResult 計算の内訳
+ * @details
+ * リードタイム需要は最終日の翌日から LeadTimeDays 日分の予測需要の合計、安全在庫は
+ * 安全係数 × 1日の予測誤差の標準偏差 × √リードタイム です。
+ * 従来方式 (max_usage) と不明な方法の場合は、最大処方量 × 係数を発注点とします。
+ */
+func ReorderPoint(p model.ForecastParams, history []float64, firstDay time.Time, maxUsage float64) (float64, model.ForecastResult) {
+	result := model.ForecastResult{ForecastParams: p, HistoryDays: len(history)}
+	newForecaster, ok := forecasters[p.Method]
+	if !ok {
+		result.ForecastParams = model.ForecastParams{Method: MethodMaxUsage, Coefficient: p.Coefficient}
+		result.LeadTimeDemand = maxUsage * p.Coefficient
+		return result.LeadTimeDemand, result
+	}
+	result.Coefficient = 0
+
+	leadTime := p.LeadTimeDays
+	if leadTime < 1 {
+		leadTime = 1
+	}
+	daily, stdDev := newForecaster(p).Forecast(history, firstDay, leadTime)
+	for _, d := range daily {
+		result.LeadTimeDemand += d
+	}
+	result.DailyDemand = result.LeadTimeDemand / float64(leadTime)
+	result.DemandStdDev = stdDev
+	result.SafetyFactor = SafetyFactor(p.ServiceLevel)
+	result.SafetyStock = result.SafetyFactor * stdDev * math.Sqrt(float64(leadTime))
+	return result.LeadTimeDemand + result.SafetyStock, result
+}
+
+// lastDays は履歴の直近 window 日 (window が0以下または履歴より長い場合は全期間) と、その初日を返します。
+func lastDays(history []float64, firstDay time.Time, window int) ([]float64, time.Time) {
+	if window <= 0 || window >= len(history) {
+		return history, firstDay
+	}
+	offset := len(history) - window
+	return history[offset:], firstDay.AddDate(0, 0, offset)
+}
+
+// meanStdDev は平均と標準偏差を返します。
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum, sumSq float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	for _, v := range values {
+		sumSq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sumSq / float64(len(values)))
+}
+
+// repeat は同じ値を days 日分並べます。
+func repeat(value float64, days int) []float64 {
+	daily := make([]float64, days)
+	for i := range daily {
+		daily[i] = value
+	}
+	return daily
+}
+
+// movingAverage は直近 window 日の1日平均を毎日の需要とします。
+type movingAverage struct {
+	window int
+}
+
+func (f movingAverage) Forecast(history []float64, firstDay time.Time, days int) ([]float64, float64) {
+	recent, _ := lastDays(history, firstDay, f.window)
+	mean, stdDev := meanStdDev(recent)
+	return repeat(mean, days), stdDev
+}
+
+// exponentialSmoothing は単純指数平滑の水準を毎日の需要とします。
+// 初期値は最初の7日の平均とし、予測誤差は1日先の予測と実績の差の二乗平均平方根です。
+type exponentialSmoothing struct {
+	alpha float64
+}
+
+func (f exponentialSmoothing) Forecast(history []float64, firstDay time.Time, days int) ([]float64, float64) {
+	if len(history) == 0 {
+		return repeat(0, days), 0
+	}
+	alpha := f.alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultParams.Alpha
+	}
+	initDays := 7
+	if initDays > len(history) {
+		initDays = len(history)
+	}
+	level, _ := meanStdDev(history[:initDays])
+	var sumSqErr float64
+	for _, v := range history {
+		err := v - level
+		sumSqErr += err * err
+		level += alpha * err
+	}
+	return repeat(level, days), math.Sqrt(sumSqErr / float64(len(history)))
+}
+
+// dayOfWeek は直近 window 日の曜日ごとの平均を、その曜日の需要とします。
+// 期間内に無い曜日は全体の平均を使い、予測誤差は曜日の平均との差の標準偏差です。
+type dayOfWeek struct {
+	window int
+}
+
+func (f dayOfWeek) Forecast(history []float64, firstDay time.Time, days int) ([]float64, float64) {
+	recent, start := lastDays(history, firstDay, f.window)
+	mean, _ := meanStdDev(recent)
+	var sums, counts [7]float64
+	for i, v := range recent {
+		wd := start.AddDate(0, 0, i).Weekday()
+		sums[wd] += v
+		counts[wd]++
+	}
+	var means [7]float64
+	for wd := range means {
+		means[wd] = mean
+		if counts[wd] > 0 {
+			means[wd] = sums[wd] / counts[wd]
+		}
+	}
+
+	var sumSqErr float64
+	for i, v := range recent {
+		err := v - means[start.AddDate(0, 0, i).Weekday()]
+		sumSqErr += err * err
+	}
+	var stdDev float64
+	if len(recent) > 0 {
+		stdDev = math.Sqrt(sumSqErr / float64(len(recent)))
+	}
+
+	next := start.AddDate(0, 0, len(recent))
+	daily := make([]float64, days)
+	for i := range daily {
+		daily[i] = means[next.AddDate(0, 0, i).Weekday()]
+	}
+	return daily, stdDev
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"time"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/model"
 	"wasabi/units"
@@ -171,7 +172,7 @@ func SaveInventoryDataHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -190,6 +191,11 @@ func SaveInventoryDataHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to save inventory data: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
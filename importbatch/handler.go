@@ -0,0 +1,82 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\importbatch\handler.go
+
+package importbatch
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"wasabi/audit"
+	"wasabi/db"
+)
+
+// ListImportBatchesHandler は取込バッチの一覧を返します。
+// クエリパラメータ sourceType ("DAT", "USAGE", "INVENTORY") で絞り込めます。
+func ListImportBatchesHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		batches, err := db.GetImportBatches(conn, r.URL.Query().Get("sourceType"))
+		if err != nil {
+			http.Error(w, "取込履歴の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batches)
+	}
+}
+
+// RollbackImportBatchHandler は取込バッチを丸ごと取り消します。
+// 登録した取引データを削除し、置換された既存データと消し込んだ発注残を元に戻します。
+func RollbackImportBatchHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := db.RollbackImportBatchInTx(tx, req.ID); err != nil {
+			switch {
+			case errors.Is(err, db.ErrImportBatchNotFound):
+				http.Error(w, "取込バッチが見つかりません: "+err.Error(), http.StatusNotFound)
+			case errors.Is(err, db.ErrImportBatchConflict):
+				http.Error(w, "取込の取り消しができません: "+err.Error(), http.StatusConflict)
+			case db.IsPeriodClosedError(err):
+				http.Error(w, "締め済みの期間の取引データは変更できません: "+err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, "取込の取り消しに失敗しました: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": fmt.Sprintf("取込バッチ #%d を取り消しました。", req.ID),
+		})
+	}
+}
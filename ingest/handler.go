@@ -0,0 +1,53 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\ingest\handler.go
+
+package ingest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+// StatusHandler は自動取込サービスの状態（監視対象ごとの最終確認・最終取込・最終エラー）を返します。
+func StatusHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := getService()
+		if s == nil {
+			http.Error(w, "自動取込サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.status())
+	}
+}
+
+// ErrorsHandler は自動取込で発生したエラーの履歴を新しい順に返します。
+func ErrorsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := getService()
+		if s == nil {
+			http.Error(w, "自動取込サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.recentErrors())
+	}
+}
+
+// RunNowHandler は次回の定期確認を待たずに監視対象を確認します（自動取込が無効でも実行します）。
+func RunNowHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := getService()
+		if s == nil {
+			http.Error(w, "自動取込サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		s.runOnce()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.status())
+	}
+}
@@ -0,0 +1,453 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\ingest\service.go
+
+package ingest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"wasabi/config"
+	"wasabi/dat"
+	"wasabi/db"
+	"wasabi/usage"
+)
+
+const (
+	// defaultIntervalSeconds は設定で確認間隔が指定されていない場合の既定値です。
+	defaultIntervalSeconds = 60
+	// minIntervalSeconds は確認間隔の下限です。
+	minIntervalSeconds = 10
+	// stableAge は書き込み途中のファイルを避けるため、最終更新からこの時間が経過したファイルのみ取り込みます。
+	stableAge = 5 * time.Second
+	// maxRecentErrors は保持するエラー履歴の件数です。
+	maxRecentErrors = 50
+	// auditUser は自動取込による変更を監査ログに記録する際の操作者名です。
+	auditUser = "自動取込"
+)
+
+// WatcherStatus は監視対象1件（USAGE または DAT）の状態です。
+type WatcherStatus struct {
+	SourceType     string `json:"sourceType"`
+	Path           string `json:"path"`
+	LastScanAt     string `json:"lastScanAt,omitempty"`
+	LastFile       string `json:"lastFile,omitempty"`
+	LastImportedAt string `json:"lastImportedAt,omitempty"`
+	ImportedCount  int    `json:"importedCount"`
+	SkippedCount   int    `json:"skippedCount"` // 取込済みのため登録せずにアーカイブしたファイル数
+	LastError      string `json:"lastError,omitempty"`
+	LastErrorAt    string `json:"lastErrorAt,omitempty"`
+}
+
+// IngestError は自動取込で発生したエラー1件分の記録です。
+type IngestError struct {
+	At         string `json:"at"`
+	SourceType string `json:"sourceType"`
+	File       string `json:"file"`
+	Message    string `json:"message"`
+}
+
+// Status は自動取込サービス全体の状態です。
+type Status struct {
+	Enabled         bool            `json:"enabled"`
+	IntervalSeconds int             `json:"intervalSeconds"`
+	Scanning        bool            `json:"scanning"`
+	LastRunAt       string          `json:"lastRunAt,omitempty"`
+	Watchers        []WatcherStatus `json:"watchers"`
+}
+
+// service はバックグラウンドで監視フォルダを確認し、新規・変更されたファイルを取り込みます。
+type service struct {
+	conn *sql.DB
+
+	// scanMu は定期実行と手動実行が同時に走らないようにするためのロックです。
+	scanMu sync.Mutex
+
+	mu        sync.Mutex
+	scanning  bool
+	lastRunAt string
+	watchers  map[string]*WatcherStatus
+	errors    []IngestError
+	// errorCount は起動後に記録したエラーの総数です。RunOnce で1回の確認ごとの件数を求めるために使います。
+	errorCount int
+	// seen は取込を試みたファイルのパスと内容のハッシュです。内容が変わらない限り再試行しません。
+	seen map[string]string
+}
+
+var (
+	svcMu sync.Mutex
+	svc   *service
+)
+
+// Start は自動取込サービスをバックグラウンドで開始します。
+// 設定の autoIngestEnabled が false の間は何も取り込まず、設定変更は次回の確認時に反映されます。
+func Start(conn *sql.DB) {
+	svcMu.Lock()
+	defer svcMu.Unlock()
+	if svc != nil {
+		return
+	}
+	svc = &service{
+		conn: conn,
+		watchers: map[string]*WatcherStatus{
+			"USAGE": {SourceType: "USAGE"},
+			"DAT":   {SourceType: "DAT"},
+		},
+		seen: make(map[string]string),
+	}
+	go svc.loop()
+	log.Println("Auto-ingest service started.")
+}
+
+func getService() *service {
+	svcMu.Lock()
+	defer svcMu.Unlock()
+	return svc
+}
+
+// RunSummary は監視対象を1回確認した結果の件数です。
+type RunSummary struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// RunOnce は自動取込の設定に関わらず、監視対象を1回確認して結果の件数を返します。
+// 定期実行 (schedulerパッケージ) の夜間取込から呼び出されます。
+func RunOnce() (RunSummary, error) {
+	s := getService()
+	if s == nil {
+		return RunSummary{}, errors.New("自動取込サービスが起動していません。")
+	}
+	before := s.counts()
+	s.runOnce()
+	after := s.counts()
+	return RunSummary{
+		Imported: after.Imported - before.Imported,
+		Skipped:  after.Skipped - before.Skipped,
+		Failed:   after.Failed - before.Failed,
+	}, nil
+}
+
+// counts は起動後の取込・スキップ・エラーの累計です。
+func (s *service) counts() RunSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := RunSummary{Failed: s.errorCount}
+	for _, ws := range s.watchers {
+		sum.Imported += ws.ImportedCount
+		sum.Skipped += ws.SkippedCount
+	}
+	return sum
+}
+
+func (s *service) loop() {
+	for {
+		cfg := config.GetConfig()
+		if cfg.AutoIngestEnabled {
+			s.runOnce()
+		}
+		time.Sleep(time.Duration(intervalSeconds(cfg)) * time.Second)
+	}
+}
+
+// intervalSeconds は設定された確認間隔を下限・既定値を考慮して返します。
+func intervalSeconds(cfg config.Config) int {
+	if cfg.AutoIngestIntervalSeconds <= 0 {
+		return defaultIntervalSeconds
+	}
+	if cfg.AutoIngestIntervalSeconds < minIntervalSeconds {
+		return minIntervalSeconds
+	}
+	return cfg.AutoIngestIntervalSeconds
+}
+
+// runOnce は設定された監視対象を1回確認します。
+// USAGEのパスがフォルダの場合はフォルダ内のファイルを取り込んでアーカイブへ移動し、
+// ファイルの場合は内容が変わったときだけ取り込み、元のファイルは残してアーカイブへコピーします。
+func (s *service) runOnce() {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+
+	s.mu.Lock()
+	s.scanning = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.scanning = false
+		s.lastRunAt = now()
+		s.mu.Unlock()
+	}()
+
+	cfg := config.GetConfig()
+	if usagePath := normalizePath(cfg.UsageFolderPath); usagePath != "" {
+		s.scanPath("USAGE", usagePath, true)
+	}
+	if datPath := normalizePath(cfg.DatWatchFolderPath); datPath != "" {
+		s.scanPath("DAT", datPath, false)
+	}
+}
+
+// scanPath は監視対象のパスを確認し、取り込むべきファイルを処理します。
+func (s *service) scanPath(sourceType, path string, allowSingleFile bool) {
+	s.updateWatcher(sourceType, func(ws *WatcherStatus) {
+		ws.Path = path
+		ws.LastScanAt = now()
+	})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		s.recordError(sourceType, path, fmt.Errorf("監視対象を開けませんでした: %w", err))
+		return
+	}
+
+	if !info.IsDir() {
+		if !allowSingleFile {
+			s.recordError(sourceType, path, fmt.Errorf("監視対象がフォルダではありません"))
+			return
+		}
+		s.ingestFile(sourceType, path, false)
+		return
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		s.recordError(sourceType, path, fmt.Errorf("フォルダの読み込みに失敗しました: %w", err))
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || isTemporaryFile(entry.Name()) {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil || time.Since(fileInfo.ModTime()) < stableAge {
+			// 書き込み途中の可能性があるため次回に回す
+			continue
+		}
+		s.ingestFile(sourceType, filepath.Join(path, entry.Name()), true)
+	}
+}
+
+// ingestFile はファイル1件を取り込み、成功したらアーカイブします。
+// move が true の場合はアーカイブへ移動し、false の場合はコピーして元のファイルを残します。
+func (s *service) ingestFile(sourceType, path string, move bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		s.recordError(sourceType, path, fmt.Errorf("ファイルの読み込みに失敗しました: %w", err))
+		return
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	s.mu.Lock()
+	unchanged := s.seen[path] == hash
+	s.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	// 再起動後や手動取込済みのファイルは、取込台帳で判定して二重登録しない
+	imported, err := db.IsFileImported(s.conn, sourceType, hash)
+	if err != nil {
+		s.recordError(sourceType, path, err)
+		return
+	}
+	if imported {
+		s.skipImportedFile(sourceType, path, hash, move)
+		return
+	}
+
+	var importErr error
+	switch sourceType {
+	case "USAGE":
+		_, importErr = usage.ProcessUsageFile(s.conn, bytes.NewReader(content), filepath.Base(path), auditUser)
+	case "DAT":
+		importErr = s.importDatFile(path)
+	}
+	if errors.Is(importErr, db.ErrDuplicateImport) {
+		// NSIPSなど、監視対象と異なる取込元の種別で取込済みの場合
+		s.skipImportedFile(sourceType, path, hash, move)
+		return
+	}
+	if importErr != nil {
+		// 失敗したファイルは監視フォルダに残し、内容が変わるまで再試行しない
+		s.mu.Lock()
+		s.seen[path] = hash
+		s.mu.Unlock()
+		s.recordError(sourceType, path, importErr)
+		return
+	}
+
+	log.Printf("Auto-ingested %s file: %s", sourceType, path)
+	s.finishFile(sourceType, path, hash, move)
+	s.updateWatcher(sourceType, func(ws *WatcherStatus) {
+		ws.LastFile = filepath.Base(path)
+		ws.LastImportedAt = now()
+		ws.ImportedCount++
+	})
+}
+
+// importDatFile はDATファイル1件を厳格モードで取り込みます。
+// 発注残の消し込みに失敗した場合も取引データは登録済みのため、エラーとして記録するのみとします。
+func (s *service) importDatFile(path string) error {
+	_, results, reconcileErr := dat.ImportDatFiles(s.conn, []string{path}, true, auditUser)
+	if len(results) > 0 && results[0].Error != "" {
+		return fmt.Errorf("%s", results[0].Error)
+	}
+	if len(results) > 0 && results[0].RecordCount == 0 && len(results[0].Diagnostics) > 0 {
+		// DATファイルとして認識できない内容は、取込済みとしてアーカイブせずに残す
+		return fmt.Errorf("取込可能なレコードがありません (警告 %d件)", len(results[0].Diagnostics))
+	}
+	if reconcileErr != nil {
+		s.recordError("DAT", path, fmt.Errorf("発注残の自動消し込みに失敗しました: %w", reconcileErr))
+	}
+	return nil
+}
+
+// skipImportedFile は取込済みのファイルを登録せずに処理済みとします。
+func (s *service) skipImportedFile(sourceType, path, hash string, move bool) {
+	if move {
+		s.finishFile(sourceType, path, hash, move)
+	} else {
+		// 元のファイルを残す場合、取込時にアーカイブ済みのためコピーし直さない
+		s.mu.Lock()
+		s.seen[path] = hash
+		s.mu.Unlock()
+	}
+	s.updateWatcher(sourceType, func(ws *WatcherStatus) { ws.SkippedCount++ })
+}
+
+// finishFile は取込済みのファイルをアーカイブし、監視状態を更新します。
+func (s *service) finishFile(sourceType, path, hash string, move bool) {
+	if _, err := archiveFile(sourceType, path, move); err != nil {
+		s.recordError(sourceType, path, err)
+	}
+	s.mu.Lock()
+	if move {
+		delete(s.seen, path)
+	} else {
+		s.seen[path] = hash
+	}
+	s.mu.Unlock()
+}
+
+// archiveFile はファイルを download/archive/<種別>/<YYYYMMDD>/ 配下へ移動またはコピーします。
+func archiveFile(sourceType, path string, move bool) (string, error) {
+	destDir := filepath.Join("download", "archive", sourceType, time.Now().Format("20060102"))
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("アーカイブフォルダの作成に失敗しました: %w", err)
+	}
+
+	base := filepath.Base(path)
+	destPath := filepath.Join(destDir, base)
+	if _, err := os.Stat(destPath); err == nil {
+		ext := filepath.Ext(base)
+		destPath = filepath.Join(destDir, fmt.Sprintf("%s_%s%s", strings.TrimSuffix(base, ext), time.Now().Format("150405.000"), ext))
+	}
+
+	if move {
+		if err := os.Rename(path, destPath); err == nil {
+			return destPath, nil
+		}
+		// 別ドライブ間の移動などでRenameできない場合はコピーしてから削除する
+	}
+	if err := copyFile(path, destPath); err != nil {
+		return "", fmt.Errorf("アーカイブへのコピーに失敗しました: %w", err)
+	}
+	if move {
+		if err := os.Remove(path); err != nil {
+			return destPath, fmt.Errorf("取込済みファイルの削除に失敗しました: %w", err)
+		}
+	}
+	return destPath, nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
+
+func (s *service) updateWatcher(sourceType string, fn func(ws *WatcherStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.watchers[sourceType])
+}
+
+func (s *service) recordError(sourceType, path string, err error) {
+	log.Printf("WARN: auto-ingest %s %s: %v", sourceType, path, err)
+	at := now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws := s.watchers[sourceType]
+	ws.LastError = err.Error()
+	ws.LastErrorAt = at
+	s.errorCount++
+	s.errors = append(s.errors, IngestError{At: at, SourceType: sourceType, File: filepath.Base(path), Message: err.Error()})
+	if len(s.errors) > maxRecentErrors {
+		s.errors = s.errors[len(s.errors)-maxRecentErrors:]
+	}
+}
+
+func (s *service) status() Status {
+	cfg := config.GetConfig()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Enabled:         cfg.AutoIngestEnabled,
+		IntervalSeconds: intervalSeconds(cfg),
+		Scanning:        s.scanning,
+		LastRunAt:       s.lastRunAt,
+	}
+	for _, key := range []string{"USAGE", "DAT"} {
+		st.Watchers = append(st.Watchers, *s.watchers[key])
+	}
+	return st
+}
+
+// recentErrors は新しい順にエラー履歴を返します。
+func (s *service) recentErrors() []IngestError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]IngestError, 0, len(s.errors))
+	for i := len(s.errors) - 1; i >= 0; i-- {
+		result = append(result, s.errors[i])
+	}
+	return result
+}
+
+// normalizePath は設定画面で入力されたパスの前後の空白と " を取り除きます。
+func normalizePath(raw string) string {
+	p := strings.Trim(strings.TrimSpace(raw), "\"")
+	return strings.ReplaceAll(p, "\\", "/")
+}
+
+// isTemporaryFile はダウンロード途中などで取込対象外とするファイルかを判定します。
+func isTemporaryFile(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") ||
+		strings.HasSuffix(lower, ".crdownload") || strings.HasSuffix(lower, ".tmp") || strings.HasSuffix(lower, ".part")
+}
+
+func now() string {
+	return time.Now().Format("2006-01-02 15:04:05")
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"time"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/mastermanager"
@@ -43,7 +44,7 @@ func SaveInOutHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -84,6 +85,10 @@ func SaveInOutHandler(conn *sql.DB) http.HandlerFunc {
 			receiptNumber = payload.OriginalReceiptNumber
 			// 既存の伝票を編集する場合、まず古い明細をすべて削除する
 			if err := db.DeleteTransactionsByReceiptNumberInTx(tx, receiptNumber); err != nil {
+				if db.IsPeriodClosedError(err) {
+					http.Error(w, "締め済みの期間の取引データは変更できません: "+err.Error(), http.StatusConflict)
+					return
+				}
 				http.Error(w, "Failed to delete old items from slip", http.StatusInternalServerError)
 				return
 			}
@@ -178,11 +183,20 @@ func SaveInOutHandler(conn *sql.DB) http.HandlerFunc {
 		if len(finalRecords) > 0 {
 			if err := db.PersistTransactionRecordsInTx(tx, finalRecords); err != nil {
 				log.Printf("Failed to persist records: %v", err)
+				if db.IsPeriodClosedError(err) {
+					http.Error(w, "締め済みの期間の取引データは変更できません: "+err.Error(), http.StatusConflict)
+					return
+				}
 				http.Error(w, "Failed to save records to database.", http.StatusInternalServerError)
 				return
 			}
 		}
 
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/mastermanager"
@@ -57,7 +58,7 @@ func UploadInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -81,6 +82,10 @@ func UploadInventoryHandler(conn *sql.DB) http.HandlerFunc {
 		}
 
 		if len(recordsToProcess) == 0 {
+			if err := db.RefreshStockBalancesInTx(tx); err != nil {
+				http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
 			if err := tx.Commit(); err != nil {
 				http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
 				return
@@ -187,12 +192,17 @@ func UploadInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			finalRecords = append(finalRecords, tr)
 
 			if (i+1)%batchSize == 0 && i < len(recordsToProcess)-1 {
+				if err := db.RefreshStockBalancesInTx(tx); err != nil {
+					tx.Rollback()
+					http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
 				if err := tx.Commit(); err != nil {
 					log.Printf("transaction commit error (batch): %v", err)
 					http.Error(w, "internal server error", http.StatusInternalServerError)
 					return
 				}
-				tx, err = conn.Begin()
+				tx, err = db.BeginAuditTx(conn, audit.RequestUser(r))
 				if err != nil {
 					http.Error(w, "Failed to begin next transaction", http.StatusInternalServerError)
 					return
@@ -206,6 +216,11 @@ func UploadInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			}
 		}
 
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			tx.Rollback()
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 		if err := tx.Commit(); err != nil {
 			log.Printf("transaction commit error (final): %v", err)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/model"
@@ -62,7 +63,7 @@ func SaveManualInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -95,6 +96,23 @@ func SaveManualInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		varianceMasters := make([]*model.ProductMaster, 0, len(mastersMap))
+		var yjCodes []string
+		for _, code := range productCodes {
+			if master, ok := mastersMap[code]; ok {
+				varianceMasters = append(varianceMasters, master)
+				yjCodes = append(yjCodes, master.YjCode)
+			}
+		}
+		if err := db.RecordInventoryVariancesInTx(tx, payload.Date, varianceMasters, recordsMap, "manual"); err != nil {
+			http.Error(w, "Failed to record inventory variances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := db.CompleteCycleCountItemsInTx(tx, payload.Date, yjCodes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		var finalRecords []model.TransactionRecord
 		receiptNumber := fmt.Sprintf("INV%s", payload.Date)
 
@@ -137,6 +155,11 @@ func SaveManualInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
@@ -2,14 +2,19 @@ package inventory
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/model"
@@ -28,14 +33,20 @@ type MigrationResultRow struct {
 // MigrateInventoryHandler は在庫移行用のCSVアップロードを処理します
 func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		file, _, err := r.FormFile("file")
+		file, header, err := r.FormFile("file")
 		if err != nil {
 			http.Error(w, "ファイルのアップロードエラー: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 		defer file.Close()
 
-		br := bufio.NewReader(file)
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "ファイルの読み込みに失敗: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		br := bufio.NewReader(bytes.NewReader(content))
 		bom, err := br.Peek(3)
 		if err == nil && bom[0] == 0xef && bom[1] == 0xbb && bom[2] == 0xbf {
 			br.Discard(3)
@@ -89,6 +100,27 @@ func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			}
 		}
 
+		// 日付ごとにトランザクションが分かれるため、取込バッチは先に登録しておく
+		batchTx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err != nil {
+			http.Error(w, "トランザクションの開始に失敗しました", http.StatusInternalServerError)
+			return
+		}
+		batchID, err := db.CreateImportBatchInTx(batchTx, "INVENTORY", header.Filename, fmt.Sprintf("%x", sha256.Sum256(content)))
+		if err != nil {
+			batchTx.Rollback()
+			status := http.StatusInternalServerError
+			if errors.Is(err, db.ErrDuplicateImport) {
+				status = http.StatusConflict
+			}
+			http.Error(w, "取込バッチの登録に失敗: "+err.Error(), status)
+			return
+		}
+		if err := batchTx.Commit(); err != nil {
+			http.Error(w, "取込バッチの登録に失敗: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		var finalResults []MigrationResultRow
 		var totalImported int
 
@@ -101,7 +133,7 @@ func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 				})
 			}
 
-			tx, err := conn.Begin()
+			tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 			if err != nil {
 				for i := range dateResults {
 					dateResults[i].Error = "トランザクション開始エラー: " + err.Error()
@@ -166,6 +198,16 @@ func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 				continue
 			}
 
+			// 同日の既存の棚卸データは置き換えられるため、ロールバックに備えて退避する
+			if _, err := db.SnapshotDisplacedRecordsInTx(tx, batchID, "flag = 0 AND transaction_date = ?", date); err != nil {
+				tx.Rollback()
+				for i := range dateResults {
+					dateResults[i].Error = "古い棚卸データの退避に失敗: " + err.Error()
+				}
+				finalResults = append(finalResults, dateResults...)
+				continue
+			}
+
 			if err := db.DeleteTransactionsByFlagAndDate(tx, 0, date); err != nil {
 				tx.Rollback()
 				for i := range dateResults {
@@ -266,6 +308,21 @@ func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 				totalImported += len(zeroFillRecords)
 			}
 
+			err = db.AssignImportBatchInTx(tx, batchID, "flag = 0 AND transaction_date = ? AND receipt_number = ?", date, receiptNumber)
+			if err == nil {
+				err = db.RefreshStockBalancesInTx(tx)
+			}
+			if err != nil {
+				tx.Rollback()
+				for j := range dateResults {
+					if dateResults[j].Error == "" {
+						dateResults[j].Error = err.Error()
+					}
+				}
+				finalResults = append(finalResults, dateResults...)
+				continue
+			}
+
 			finalResults = append(finalResults, dateResults...)
 			finalResults = append(finalResults, zeroFillResults...)
 
@@ -274,6 +331,17 @@ func MigrateInventoryHandler(conn *sql.DB) http.HandlerFunc {
 			}
 		}
 
+		finalizeTx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err == nil {
+			if err := db.FinalizeImportBatchInTx(finalizeTx, batchID); err != nil {
+				log.Printf("Failed to finalize import batch %d: %v", batchID, err)
+			}
+			finalizeTx.Commit()
+		}
+		if err := db.DeleteEmptyImportBatch(conn, batchID); err != nil {
+			log.Printf("%v", err)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message": fmt.Sprintf("計%d件の在庫データを処理しました。", totalImported),
@@ -0,0 +1,164 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\jobs\handler.go
+
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// minEventInterval は進捗イベントを送信する最短の間隔です。細かい進捗の更新はまとめて送ります。
+	minEventInterval = 200 * time.Millisecond
+	// keepAliveInterval は進捗に変化がない間も接続を維持するためにコメント行を送る間隔です。
+	keepAliveInterval = 15 * time.Second
+)
+
+/**
+ * @brief 処理をジョブとして開始し、ジョブIDを返すレスポンスを書き込みます。
+ * @param w レスポンス
+ * @param kind ジョブの種類
+ * @param title 画面に表示する処理名
+ * @param fn 実行する処理
+ * @details
+ * 開始した場合は 202 Accepted、同じ種類のジョブが実行中の場合は 409 Conflict と実行中のジョブIDを返します。
+ * クライアントは /api/jobs/events?id=... で進捗を受信します。
+ */
+func ServeSubmit(w http.ResponseWriter, kind, title string, fn Func) {
+	job, err := Submit(kind, title, fn)
+	s, _ := job.Snapshot()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if errors.Is(err, ErrAlreadyRunning) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{
+			"jobId":   s.ID,
+			"message": fmt.Sprintf("%sは既に実行中です。実行中の処理の進捗を表示します。", title),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"jobId":   s.ID,
+		"message": fmt.Sprintf("%sを開始しました。", title),
+	})
+}
+
+// ListHandler は実行中のジョブと最近終了したジョブの一覧を返します。
+// クエリパラメータ status=RUNNING で実行中のジョブのみに絞り込めます (画面の再読み込み後の再接続用)。
+func ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		list := make([]Snapshot, 0)
+		for _, s := range List() {
+			if status == "" || s.Status == status {
+				list = append(list, s)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// StatusHandler はジョブの現在の状態を返します。
+func StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := Get(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "ジョブが見つかりません。", http.StatusNotFound)
+			return
+		}
+		s, _ := job.Snapshot()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s)
+	}
+}
+
+// CancelHandler は実行中のジョブの中止を要求します。
+func CancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		job, ok := Get(req.ID)
+		if !ok {
+			http.Error(w, "ジョブが見つかりません。", http.StatusNotFound)
+			return
+		}
+		if s, _ := job.Snapshot(); s.Done() {
+			http.Error(w, "ジョブは既に終了しています。", http.StatusConflict)
+			return
+		}
+		job.Cancel()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"message": "中止を要求しました。"})
+	}
+}
+
+// EventsHandler はジョブの進捗を Server-Sent Events で送信します。
+// 接続時に現在の状態を送るため、切断後に再接続しても続きから表示できます。
+// 進捗は "progress" イベント、終了時は "done" イベントとして送信し、終了後に接続を閉じます。
+func EventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := Get(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "ジョブが見つかりません。", http.StatusNotFound)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			s, changed := job.Snapshot()
+			event := "progress"
+			if s.Done() {
+				event = "done"
+			}
+			data, err := json.Marshal(s)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			flusher.Flush()
+			if s.Done() {
+				return
+			}
+
+		wait:
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-keepAlive.C:
+					fmt.Fprint(w, ": keep-alive\n\n")
+					flusher.Flush()
+				case <-changed:
+					break wait
+				}
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(minEventInterval):
+			}
+		}
+	}
+}
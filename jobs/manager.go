@@ -0,0 +1,227 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\jobs\manager.go
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusRunning   = "RUNNING"
+	StatusSucceeded = "SUCCEEDED"
+	StatusFailed    = "FAILED"
+	StatusCanceled  = "CANCELED"
+)
+
+// maxFinishedJobs は終了後も状態を参照できるように保持するジョブの件数です。
+const maxFinishedJobs = 20
+
+// ErrAlreadyRunning は同じ種類のジョブが既に実行中であることを示します。
+var ErrAlreadyRunning = errors.New("同じ処理が既に実行中です")
+
+// Reporter は処理の進捗を報告するためのインターフェースです。
+// ジョブとして実行しない場合 (定期実行など) は NopReporter を渡します。
+type Reporter interface {
+	Report(phase string, processed, total int)
+}
+
+// NopReporter は進捗を報告しない Reporter です。
+type NopReporter struct{}
+
+func (NopReporter) Report(string, int, int) {}
+
+// Func はジョブとして実行する処理です。戻り値の result はJSONとしてクライアントに返されます。
+// ctx がキャンセルされた場合は、できるだけ早く ctx.Err() を返して終了してください。
+type Func func(ctx context.Context, r Reporter) (result interface{}, err error)
+
+// Snapshot はジョブのある時点の状態です。
+type Snapshot struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Title      string      `json:"title"`
+	Status     string      `json:"status"`
+	Phase      string      `json:"phase,omitempty"`
+	Processed  int         `json:"processed"`
+	Total      int         `json:"total"`
+	StartedAt  string      `json:"startedAt"`
+	FinishedAt string      `json:"finishedAt,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// Done はジョブが終了しているかを返します。
+func (s Snapshot) Done() bool {
+	return s.Status != StatusRunning
+}
+
+// Job は実行中または終了したジョブです。
+type Job struct {
+	seq    int64
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	snapshot Snapshot
+	// changed は状態が変わるたびに close して作り直すチャネルです。SSEの送信側が変更を待つのに使います。
+	changed chan struct{}
+}
+
+// Report は進捗を更新します。
+func (j *Job) Report(phase string, processed, total int) {
+	j.update(func(s *Snapshot) {
+		s.Phase, s.Processed, s.Total = phase, processed, total
+	})
+}
+
+// Cancel はジョブの中止を要求します。処理が中止を確認するまでは実行中のままです。
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Snapshot は現在の状態と、次に状態が変わったときに close されるチャネルを返します。
+func (j *Job) Snapshot() (Snapshot, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshot, j.changed
+}
+
+func (j *Job) update(fn func(s *Snapshot)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.snapshot)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// manager は実行中・終了済みのジョブをメモリ上で管理します。
+type manager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	seq  int64
+}
+
+var defaultManager = &manager{jobs: make(map[string]*Job)}
+
+/**
+ * @brief 処理をバックグラウンドのジョブとして開始します。
+ * @param kind ジョブの種類。同じ種類のジョブは同時に1つだけ実行できます
+ * @param title 画面に表示する処理名
+ * @param fn 実行する処理
+ * @return *Job 開始したジョブ。同じ種類のジョブが実行中の場合はそのジョブ
+ * @return error 同じ種類のジョブが実行中の場合は ErrAlreadyRunning
+ */
+func Submit(kind, title string, fn Func) (*Job, error) {
+	return defaultManager.submit(kind, title, fn)
+}
+
+// Get はIDでジョブを取得します。
+func Get(id string) (*Job, bool) {
+	defaultManager.mu.Lock()
+	defer defaultManager.mu.Unlock()
+	j, ok := defaultManager.jobs[id]
+	return j, ok
+}
+
+// List は実行中のジョブと最近終了したジョブの状態を新しい順に返します。
+func List() []Snapshot {
+	defaultManager.mu.Lock()
+	defer defaultManager.mu.Unlock()
+	return defaultManager.snapshotsLocked()
+}
+
+func (m *manager) submit(kind, title string, fn Func) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range m.jobs {
+		if s, _ := j.Snapshot(); s.Kind == kind && !s.Done() {
+			return j, ErrAlreadyRunning
+		}
+	}
+
+	m.seq++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		seq:    m.seq,
+		cancel: cancel,
+		snapshot: Snapshot{
+			ID:        fmt.Sprintf("%s-%d", time.Now().Format("20060102150405"), m.seq),
+			Kind:      kind,
+			Title:     title,
+			Status:    StatusRunning,
+			StartedAt: time.Now().Format("2006-01-02 15:04:05"),
+		},
+		changed: make(chan struct{}),
+	}
+	m.jobs[job.snapshot.ID] = job
+	m.pruneLocked()
+
+	go m.run(ctx, job, fn)
+	log.Printf("job %s (%s) started", job.snapshot.ID, kind)
+	return job, nil
+}
+
+// run はジョブを実行し、結果を記録します。処理内のpanicは失敗として記録します。
+func (m *manager) run(ctx context.Context, job *Job, fn Func) {
+	defer job.cancel()
+
+	var result interface{}
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		result, err = fn(ctx, job)
+	}()
+
+	job.update(func(s *Snapshot) {
+		s.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+		s.Result = result
+		switch {
+		case err == nil:
+			s.Status = StatusSucceeded
+		case errors.Is(err, context.Canceled):
+			s.Status = StatusCanceled
+			s.Error = "中止しました。"
+		default:
+			s.Status = StatusFailed
+			s.Error = err.Error()
+		}
+	})
+	s, _ := job.Snapshot()
+	log.Printf("job %s (%s) finished: %s %s", s.ID, s.Kind, s.Status, s.Error)
+}
+
+// pruneLocked は古い終了済みのジョブを削除します。m.mu をロックした状態で呼び出します。
+func (m *manager) pruneLocked() {
+	var finished []Snapshot
+	for _, s := range m.snapshotsLocked() {
+		if s.Done() {
+			finished = append(finished, s)
+		}
+	}
+	for i := maxFinishedJobs; i < len(finished); i++ {
+		delete(m.jobs, finished[i].ID)
+	}
+}
+
+func (m *manager) snapshotsLocked() []Snapshot {
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].seq > jobs[b].seq })
+
+	list := make([]Snapshot, 0, len(jobs))
+	for _, j := range jobs {
+		s, _ := j.Snapshot()
+		list = append(list, s)
+	}
+	return list
+}
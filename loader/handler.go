@@ -2,9 +2,9 @@
 package loader
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -12,7 +12,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"wasabi/audit"
 	"wasabi/db"
+	"wasabi/jobs"
 	"wasabi/model"
 
 	"golang.org/x/text/encoding/japanese"
@@ -25,160 +27,187 @@ type UpdatedProductView struct {
 	Status      string `json:"status"` // "UPDATED", "ORPHANED", "NEW"
 }
 
+// MasterUpdateResult はJCSHMSマスター更新の結果です。
+type MasterUpdateResult struct {
+	UpdatedProducts    []UpdatedProductView `json:"updatedProducts"`
+	OrphanedProducts   []UpdatedProductView `json:"orphanedProducts"`
+	NewlyAddedProducts []UpdatedProductView `json:"newlyAddedProducts"`
+}
+
+// CreateMasterUpdateHandler はJCSHMSマスター更新をジョブとして開始します。
+// 進捗は /api/jobs/events で受信できます。
 func CreateMasterUpdateHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("新しい要件に基づくJCSHMSマスター更新処理を開始します...")
+		user := audit.RequestUser(r)
+		jobs.ServeSubmit(w, "master_update", "JCSHMSマスター更新", func(ctx context.Context, rep jobs.Reporter) (interface{}, error) {
+			result, err := UpdateMasters(ctx, conn, user, rep)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"message":            "指定の要件で製品マスターの更新が完了しました。",
+				"updatedProducts":    result.UpdatedProducts,
+				"orphanedProducts":   result.OrphanedProducts,
+				"newlyAddedProducts": result.NewlyAddedProducts,
+			}, nil
+		})
+	}
+}
 
-		// === ステップ1: 必要なデータを全てメモリにロード ===
-		newJcshmsData, err := loadCSVToMap("SOU/JCSHMS.CSV", false, 0)
-		if err != nil {
-			http.Error(w, "JCSHMS.CSVの読み込みに失敗しました: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		newJancodeData, err := loadCSVToMap("SOU/JANCODE.CSV", true, 1)
-		if err != nil {
-			http.Error(w, "JANCODE.CSVの読み込みに失敗しました: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		existingMasters, err := db.GetAllProductMasters(conn)
-		if err != nil {
-			http.Error(w, "既存の製品マスターの取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+// UpdateMasters はSOUフォルダのJCSHMS.CSVとJANCODE.CSVで既存の製品マスターを更新します。
+// JCSHMS由来のマスターがCSVから消えた場合はPROVISIONALに変更します。
+// 更新は1つのトランザクションで行うため、途中で中止した場合は何も更新されません。
+// user は監査ログに記録する操作者です (定期実行では処理の名前)。
+func UpdateMasters(ctx context.Context, conn *sql.DB, user string, rep jobs.Reporter) (*MasterUpdateResult, error) {
+	log.Println("新しい要件に基づくJCSHMSマスター更新処理を開始します...")
+	rep.Report("CSV読込", 0, 0)
 
-		tx, err := conn.Begin()
-		if err != nil {
-			http.Error(w, "トランザクションの開始に失敗しました", http.StatusInternalServerError)
-			return
-		}
-		defer tx.Rollback()
+	// === ステップ1: 必要なデータを全てメモリにロード ===
+	newJcshmsData, err := loadCSVToMap("SOU/JCSHMS.CSV", false, 0)
+	if err != nil {
+		return nil, fmt.Errorf("JCSHMS.CSVの読み込みに失敗しました: %w", err)
+	}
+	newJancodeData, err := loadCSVToMap("SOU/JANCODE.CSV", true, 1)
+	if err != nil {
+		return nil, fmt.Errorf("JANCODE.CSVの読み込みに失敗しました: %w", err)
+	}
+	existingMasters, err := db.GetAllProductMasters(conn)
+	if err != nil {
+		return nil, fmt.Errorf("既存の製品マスターの取得に失敗しました: %w", err)
+	}
 
-		var updatedProducts, orphanedProducts, newlyAddedProducts []UpdatedProductView
+	tx, err := db.BeginAuditTx(conn, user)
+	if err != nil {
+		return nil, fmt.Errorf("トランザクションの開始に失敗しました: %w", err)
+	}
+	defer tx.Rollback()
 
-		existingMastersMap := make(map[string]*model.ProductMaster)
-		for _, m := range existingMasters {
-			existingMastersMap[m.ProductCode] = m
-		}
+	var updatedProducts, orphanedProducts, newlyAddedProducts []UpdatedProductView
 
-		// --- 既存マスターの更新と孤立化処理 ---
-		for _, master := range existingMasters {
-			jcshmsRow, matchFound := newJcshmsData[master.ProductCode]
-			if matchFound {
-				jancodeRow := newJancodeData[master.ProductCode]
-				// ProductMasterInputへの変換ロジックを createInputFromCSV に集約
-				input := createInputFromCSV(jcshmsRow, jancodeRow)
+	existingMastersMap := make(map[string]*model.ProductMaster)
+	for _, m := range existingMasters {
+		existingMastersMap[m.ProductCode] = m
+	}
+
+	// --- 既存マスターの更新と孤立化処理 ---
+	rep.Report("マスター更新", 0, len(existingMasters))
+	for i, master := range existingMasters {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i%100 == 0 {
+			rep.Report("マスター更新", i, len(existingMasters))
+		}
+		jcshmsRow, matchFound := newJcshmsData[master.ProductCode]
+		if matchFound {
+			jancodeRow := newJancodeData[master.ProductCode]
+			// ProductMasterInputへの変換ロジックを createInputFromCSV に集約
+			input := createInputFromCSV(jcshmsRow, jancodeRow)
 
-				// ▼▼▼【ここから修正】▼▼▼
-				if input.YjCode == "" {
-					// JCSHMSにYJコードがない場合
-					if master.YjCode != "" {
-						// DBに既にYJコードがあれば（手入力されたものなど）、それを維持する
-						input.YjCode = master.YjCode
-					} else {
-						// DBにもYJコードがなければ、新規に採番する
-						newYjCode, err := db.NextSequenceInTx(tx, "MA2Y", "MA2Y", 8)
-						if err != nil {
-							http.Error(w, fmt.Sprintf("YJコードの採番に失敗 (JAN: %s): %v", master.ProductCode, err), http.StatusInternalServerError)
-							return
-						}
-						input.YjCode = newYjCode
+			// ▼▼▼【ここから修正】▼▼▼
+			if input.YjCode == "" {
+				// JCSHMSにYJコードがない場合
+				if master.YjCode != "" {
+					// DBに既にYJコードがあれば（手入力されたものなど）、それを維持する
+					input.YjCode = master.YjCode
+				} else {
+					// DBにもYJコードがなければ、新規に採番する
+					newYjCode, err := db.NextSequenceInTx(tx, "MA2Y", "MA2Y", 8)
+					if err != nil {
+						return nil, fmt.Errorf("YJコードの採番に失敗 (JAN: %s): %w", master.ProductCode, err)
 					}
+					input.YjCode = newYjCode
 				}
-				// ▲▲▲【修正ここまで】▲▲▲
+			}
+			// ▲▲▲【修正ここまで】▲▲▲
 
-				// 既存のユーザー設定項目を維持する
-				input.PurchasePrice = master.PurchasePrice
-				input.SupplierWholesale = master.SupplierWholesale
-				input.GroupCode = master.GroupCode
-				input.ShelfNumber = master.ShelfNumber
-				input.Category = master.Category
-				input.UserNotes = master.UserNotes
-				input.IsOrderStopped = master.IsOrderStopped // 発注可否設定を引き継ぐ
+			// 既存のユーザー設定項目を維持する
+			input.PurchasePrice = master.PurchasePrice
+			input.SupplierWholesale = master.SupplierWholesale
+			input.GroupCode = master.GroupCode
+			input.ShelfNumber = master.ShelfNumber
+			input.Category = master.Category
+			input.UserNotes = master.UserNotes
+			input.IsOrderStopped = master.IsOrderStopped // 発注可否設定を引き継ぐ
 
-				if err := db.UpsertProductMasterInTx(tx, input); err != nil {
-					http.Error(w, fmt.Sprintf("マスターの上書き更新に失敗 (JAN: %s): %v", master.ProductCode, err), http.StatusInternalServerError)
-					return
-				}
-				updatedProducts = append(updatedProducts, UpdatedProductView{ProductCode: master.ProductCode, ProductName: input.ProductName, Status: "UPDATED"})
-			} else if master.Origin == "JCSHMS" {
-				// JCSHMS由来のマスターがCSVから消えた場合、PROVISIONAL化する
-				newProductName := master.ProductName
-				if !strings.HasPrefix(master.ProductName, "◆") {
-					newProductName = "◆" + newProductName
-				}
-				master.Origin = "PROVISIONAL"
-				master.ProductName = newProductName
-				// 更新用のInputを作成
-				input := model.ProductMasterInput{
-					ProductCode:         master.ProductCode,
-					YjCode:              master.YjCode,
-					Gs1Code:             master.Gs1Code,
-					ProductName:         master.ProductName,
-					KanaName:            master.KanaName,
-					MakerName:           master.MakerName,
-					Specification:       master.Specification,
-					UsageClassification: master.UsageClassification,
-					PackageForm:         master.PackageForm,
-					YjUnitName:          master.YjUnitName,
-					YjPackUnitQty:       master.YjPackUnitQty,
-					JanPackInnerQty:     master.JanPackInnerQty,
-					JanUnitCode:         master.JanUnitCode,
-					JanPackUnitQty:      master.JanPackUnitQty,
-					Origin:              master.Origin,
-					NhiPrice:            master.NhiPrice,
-					PurchasePrice:       master.PurchasePrice,
-					FlagPoison:          master.FlagPoison,
-					FlagDeleterious:     master.FlagDeleterious,
-					FlagNarcotic:        master.FlagNarcotic,
-					FlagPsychotropic:    master.FlagPsychotropic,
-					FlagStimulant:       master.FlagStimulant,
-					FlagStimulantRaw:    master.FlagStimulantRaw,
-					IsOrderStopped:      master.IsOrderStopped,
-					SupplierWholesale:   master.SupplierWholesale,
-					GroupCode:           master.GroupCode,
-					ShelfNumber:         master.ShelfNumber,
-					Category:            master.Category,
-					UserNotes:           master.UserNotes,
-				}
-				if err := db.UpsertProductMasterInTx(tx, input); err != nil {
-					http.Error(w, fmt.Sprintf("マスターのPROVISIONAL化に失敗 (JAN: %s): %v", master.ProductCode, err), http.StatusInternalServerError)
-					return
-				}
-				orphanedProducts = append(orphanedProducts, UpdatedProductView{ProductCode: master.ProductCode, ProductName: newProductName, Status: "ORPHANED"})
+			if err := db.UpsertProductMasterInTx(tx, input); err != nil {
+				return nil, fmt.Errorf("マスターの上書き更新に失敗 (JAN: %s): %w", master.ProductCode, err)
+			}
+			updatedProducts = append(updatedProducts, UpdatedProductView{ProductCode: master.ProductCode, ProductName: input.ProductName, Status: "UPDATED"})
+		} else if master.Origin == "JCSHMS" {
+			// JCSHMS由来のマスターがCSVから消えた場合、PROVISIONAL化する
+			newProductName := master.ProductName
+			if !strings.HasPrefix(master.ProductName, "◆") {
+				newProductName = "◆" + newProductName
+			}
+			master.Origin = "PROVISIONAL"
+			master.ProductName = newProductName
+			// 更新用のInputを作成
+			input := model.ProductMasterInput{
+				ProductCode:         master.ProductCode,
+				YjCode:              master.YjCode,
+				Gs1Code:             master.Gs1Code,
+				ProductName:         master.ProductName,
+				KanaName:            master.KanaName,
+				MakerName:           master.MakerName,
+				Specification:       master.Specification,
+				UsageClassification: master.UsageClassification,
+				PackageForm:         master.PackageForm,
+				YjUnitName:          master.YjUnitName,
+				YjPackUnitQty:       master.YjPackUnitQty,
+				JanPackInnerQty:     master.JanPackInnerQty,
+				JanUnitCode:         master.JanUnitCode,
+				JanPackUnitQty:      master.JanPackUnitQty,
+				Origin:              master.Origin,
+				NhiPrice:            master.NhiPrice,
+				PurchasePrice:       master.PurchasePrice,
+				FlagPoison:          master.FlagPoison,
+				FlagDeleterious:     master.FlagDeleterious,
+				FlagNarcotic:        master.FlagNarcotic,
+				FlagPsychotropic:    master.FlagPsychotropic,
+				FlagStimulant:       master.FlagStimulant,
+				FlagStimulantRaw:    master.FlagStimulantRaw,
+				IsOrderStopped:      master.IsOrderStopped,
+				SupplierWholesale:   master.SupplierWholesale,
+				GroupCode:           master.GroupCode,
+				ShelfNumber:         master.ShelfNumber,
+				Category:            master.Category,
+				UserNotes:           master.UserNotes,
 			}
+			if err := db.UpsertProductMasterInTx(tx, input); err != nil {
+				return nil, fmt.Errorf("マスターのPROVISIONAL化に失敗 (JAN: %s): %w", master.ProductCode, err)
+			}
+			orphanedProducts = append(orphanedProducts, UpdatedProductView{ProductCode: master.ProductCode, ProductName: newProductName, Status: "ORPHANED"})
 		}
+	}
 
-		// --- 新規マスターの追加処理 ---
-		// ▼▼▼【ご指示により、JCSHMSマスターにしか存在しない新規品目を自動で追加する機能を削除】▼▼▼
-		/*
-			for productCode, jcshmsRow := range newJcshmsData {
-				if _, exists := existingMastersMap[productCode]; !exists {
-					jancodeRow := newJancodeData[productCode]
-					input := createInputFromCSV(jcshmsRow, jancodeRow)
-					if err := db.UpsertProductMasterInTx(tx, input); err != nil {
-						http.Error(w, fmt.Sprintf("新規マスターの追加に失敗 (JAN: %s): %v", productCode, err), http.StatusInternalServerError)
-						return
-					}
-					newlyAddedProducts = append(newlyAddedProducts, UpdatedProductView{ProductCode: productCode, ProductName: input.ProductName, Status: "NEW"})
+	// --- 新規マスターの追加処理 ---
+	// ▼▼▼【ご指示により、JCSHMSマスターにしか存在しない新規品目を自動で追加する機能を削除】▼▼▼
+	/*
+		for productCode, jcshmsRow := range newJcshmsData {
+			if _, exists := existingMastersMap[productCode]; !exists {
+				jancodeRow := newJancodeData[productCode]
+				input := createInputFromCSV(jcshmsRow, jancodeRow)
+				if err := db.UpsertProductMasterInTx(tx, input); err != nil {
+					return nil, fmt.Errorf("新規マスターの追加に失敗 (JAN: %s): %w", productCode, err)
 				}
+				newlyAddedProducts = append(newlyAddedProducts, UpdatedProductView{ProductCode: productCode, ProductName: input.ProductName, Status: "NEW"})
 			}
-		*/
-		// ▲▲▲【削除ここまで】▲▲▲
-
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "トランザクションのコミットに失敗しました", http.StatusInternalServerError)
-			return
 		}
+	*/
+	// ▲▲▲【削除ここまで】▲▲▲
 
-		log.Println("新しい要件に基づくJCSHMSマスター更新処理が正常に完了しました。")
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message":            "指定の要件で製品マスターの更新が完了しました。",
-			"updatedProducts":    updatedProducts,
-			"orphanedProducts":   orphanedProducts,
-			"newlyAddedProducts": newlyAddedProducts,
-		})
+	rep.Report("マスター更新", len(existingMasters), len(existingMasters))
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("トランザクションのコミットに失敗しました: %w", err)
 	}
+
+	log.Println("新しい要件に基づくJCSHMSマスター更新処理が正常に完了しました。")
+	return &MasterUpdateResult{
+		UpdatedProducts:    updatedProducts,
+		OrphanedProducts:   orphanedProducts,
+		NewlyAddedProducts: newlyAddedProducts,
+	}, nil
 }
 
 func loadCSVToMap(filepath string, skipHeader bool, keyIndex int) (map[string][]string, error) {
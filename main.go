@@ -8,30 +8,39 @@ import (
 	"os/exec"
 	"runtime"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"wasabi/aggregation"
+	"wasabi/analysis"
+	"wasabi/audit"
 	"wasabi/backorder"
 	"wasabi/backup"
 	"wasabi/cleanup"
 	"wasabi/client"
 	"wasabi/config"
+	"wasabi/controlled"
+	"wasabi/cyclecount"
 	"wasabi/dat"
 	"wasabi/db"
 	"wasabi/deadstock"
 	"wasabi/edge"
+	"wasabi/expiry"
 	"wasabi/guidedinventory"
+	"wasabi/importbatch"
+	"wasabi/ingest"
 	"wasabi/inout"
 	"wasabi/inventory"
+	"wasabi/jobs"
 	"wasabi/loader"
 	"wasabi/masteredit"
 	"wasabi/medrec"
 	"wasabi/orders"
+	"wasabi/periodclose"
 	"wasabi/precomp"
 	"wasabi/pricing"
 	"wasabi/product"
+	"wasabi/replenishment"
 	"wasabi/reprocess"
 	"wasabi/returns"
+	"wasabi/scheduler"
 	"wasabi/search"
 	"wasabi/sequence"
 	"wasabi/settings"
@@ -40,10 +49,12 @@ import (
 	"wasabi/units"
 	"wasabi/usage"
 	"wasabi/valuation"
+	"wasabi/variance"
 )
 
 func main() {
-	conn, err := sql.Open("sqlite3", "./wasabi.db")
+	// 監査ログのトリガーが使用する関数を登録したドライバで開く
+	conn, err := sql.Open(db.DriverName, "./wasabi.db")
 	if err != nil {
 		log.Fatalf("db open error: %v", err)
 	}
@@ -70,6 +81,11 @@ func main() {
 	}
 	log.Println("Master data loaded successfully.")
 
+	ingest.Start(conn)
+	if err := scheduler.Start(conn); err != nil {
+		log.Fatalf("job scheduler start failed: %v", err)
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/masters/cleanup/candidates", cleanup.GetCandidatesHandler(conn))
@@ -78,14 +94,57 @@ func main() {
 	mux.HandleFunc("/api/products/search", search.SearchJcshmsByNameHandler(conn))
 	mux.HandleFunc("/api/masters/search_all", search.SearchAllMastersHandler(conn))
 	mux.HandleFunc("/api/product/by_gs1", search.GetProductByGS1Handler(conn))
+	mux.HandleFunc("/api/product/scan_gs1", search.ScanGS1Handler(conn))
 	mux.HandleFunc("/api/masters/by_yj_code", search.GetMastersByYjCodeHandler(conn))
 	mux.HandleFunc("/api/valuation", valuation.GetValuationHandler(conn))
 	mux.HandleFunc("/api/valuation/export", valuation.ExportValuationHandler(conn))
 	// ▼▼▼ この行を追加 ▼▼▼
 	mux.HandleFunc("/api/valuation/export_pdf", valuation.ExportValuationPDFHandler(conn))
+	mux.HandleFunc("/api/variance", variance.GetVarianceReportHandler(conn))
+	mux.HandleFunc("/api/variance/export", variance.ExportVarianceReportHandler(conn))
+	mux.HandleFunc("/api/cyclecount", cyclecount.GetListHandler(conn))
+	mux.HandleFunc("/api/cyclecount/generate", cyclecount.GenerateListHandler(conn))
+	mux.HandleFunc("/api/cyclecount/classify", cyclecount.ClassifyHandler(conn))
+	mux.HandleFunc("/api/analysis/abcxyz", analysis.GetAbcXyzHandler(conn))
+	mux.HandleFunc("/api/analysis/abcxyz/save", analysis.SaveAbcXyzHandler(conn))
+	mux.HandleFunc("/api/analysis/abcxyz/export", analysis.ExportAbcXyzHandler(conn))
 	// ▲▲▲ 追加ここまで ▲▲▲
+	mux.HandleFunc("/api/expiry/alerts", expiry.GetExpiryAlertsHandler(conn))
+	mux.HandleFunc("/api/expiry/summary", expiry.GetExpirySummaryHandler(conn))
+	mux.HandleFunc("/api/expiry/export", expiry.ExportExpiryAlertsHandler(conn))
+	mux.HandleFunc("/api/expiry/export_pdf", expiry.ExportExpiryAlertsPDFHandler(conn))
+	mux.HandleFunc("/api/periods", periodclose.ListClosedPeriodsHandler(conn))
+	mux.HandleFunc("/api/periods/close", periodclose.ClosePeriodHandler(conn))
+	mux.HandleFunc("/api/periods/reopen", periodclose.ReopenPeriodHandler(conn))
+	mux.HandleFunc("/api/periods/snapshot", periodclose.GetSnapshotHandler(conn))
+	mux.HandleFunc("/api/audit", audit.GetAuditLogsHandler(conn))
+	mux.HandleFunc("/api/audit/export", audit.ExportAuditLogsHandler(conn))
+	mux.HandleFunc("/api/controlled/register", controlled.GetRegisterHandler(conn))
+	mux.HandleFunc("/api/controlled/notes", controlled.SaveNoteHandler(conn))
+	mux.HandleFunc("/api/controlled/export_pdf", controlled.ExportRegisterPDFHandler(conn))
+	mux.HandleFunc("/api/controlled/annual", controlled.GetAnnualReportHandler(conn))
+	mux.HandleFunc("/api/controlled/annual/export", controlled.ExportAnnualReportExcelHandler(conn))
+	mux.HandleFunc("/api/controlled/annual/export_pdf", controlled.ExportAnnualReportPDFHandler(conn))
+	mux.HandleFunc("/api/controlled/annual/export_csv", controlled.ExportAnnualReportCSVHandler(conn))
 	mux.HandleFunc("/api/dat/upload", dat.UploadDatHandler(conn))
+	mux.HandleFunc("/api/dat/preview", dat.PreviewDatHandler(conn))
+	mux.HandleFunc("/api/dat/confirm", dat.ConfirmDatHandler(conn))
+	mux.HandleFunc("/api/import_batches", importbatch.ListImportBatchesHandler(conn))
+	mux.HandleFunc("/api/import_batches/rollback", importbatch.RollbackImportBatchHandler(conn))
 	mux.HandleFunc("/api/usage/upload", usage.UploadUsageHandler(conn))
+	mux.HandleFunc("/api/usage/detect_format", usage.DetectUsageFormatHandler(conn))
+	mux.HandleFunc("/api/usage/nsips/upload", usage.UploadNsipsHandler(conn))
+	mux.HandleFunc("/api/ingest/status", ingest.StatusHandler(conn))
+	mux.HandleFunc("/api/ingest/errors", ingest.ErrorsHandler(conn))
+	mux.HandleFunc("/api/ingest/run", ingest.RunNowHandler(conn))
+	mux.HandleFunc("/api/scheduler/jobs", scheduler.JobsHandler(conn))
+	mux.HandleFunc("/api/scheduler/jobs/update", scheduler.UpdateJobHandler(conn))
+	mux.HandleFunc("/api/scheduler/jobs/run", scheduler.RunJobHandler(conn))
+	mux.HandleFunc("/api/scheduler/runs", scheduler.JobRunsHandler(conn))
+	mux.HandleFunc("/api/jobs", jobs.ListHandler())
+	mux.HandleFunc("/api/jobs/status", jobs.StatusHandler())
+	mux.HandleFunc("/api/jobs/events", jobs.EventsHandler())
+	mux.HandleFunc("/api/jobs/cancel", jobs.CancelHandler())
 	mux.HandleFunc("/api/inout/save", inout.SaveInOutHandler(conn))
 
 	// 問題のある棚卸機能を無効化
@@ -118,8 +177,12 @@ func main() {
 	mux.HandleFunc("/api/deadstock/export", deadstock.ExportDeadStockHandler(conn))
 	mux.HandleFunc("/api/settings/get", settings.GetSettingsHandler(conn))
 	mux.HandleFunc("/api/settings/save", settings.SaveSettingsHandler(conn))
+	mux.HandleFunc("/api/settings/usage_formats", settings.UsageFormatsHandler(conn))
+	mux.HandleFunc("/api/settings/nsips_layout", settings.NsipsLayoutHandler(conn))
 	mux.HandleFunc("/api/settings/wholesalers", settings.WholesalersHandler(conn))
 	mux.HandleFunc("/api/settings/wholesalers/", settings.WholesalersHandler(conn))
+	mux.HandleFunc("/api/replenishment/policies", replenishment.PoliciesHandler(conn))
+	mux.HandleFunc("/api/replenishment/policies/", replenishment.PoliciesHandler(conn))
 	mux.HandleFunc("/api/transactions/clear_all", settings.ClearTransactionsHandler(conn))
 	mux.HandleFunc("/api/masters/clear_all", settings.ClearMastersHandler(conn))
 	mux.HandleFunc("/api/precomp/save", precomp.SavePrecompHandler(conn))
@@ -132,20 +195,27 @@ func main() {
 	mux.HandleFunc("/api/precomp/suspend", precomp.SuspendPrecompHandler(conn))
 	mux.HandleFunc("/api/precomp/resume", precomp.ResumePrecompHandler(conn))
 	mux.HandleFunc("/api/precomp/status", precomp.GetStatusPrecompHandler(conn))
+	mux.HandleFunc("/api/precomp/dispensing", precomp.GetDispensingPrecompHandler(conn))
 	mux.HandleFunc("/api/orders/candidates", orders.GenerateOrderCandidatesHandler(conn))
 	mux.HandleFunc("/api/orders/place", orders.PlaceOrderHandler(conn))
+	mux.HandleFunc("/api/orders/allocate", orders.AllocateOrdersHandler(conn))
+	mux.HandleFunc("/api/orders/purchase_order", orders.PurchaseOrderDocumentHandler(conn))
 	mux.HandleFunc("/api/returns/candidates", returns.GenerateReturnCandidatesHandler(conn))
 	mux.HandleFunc("/api/backorders", backorder.GetBackordersHandler(conn))
 	mux.HandleFunc("/api/backorders/delete", backorder.DeleteBackorderHandler(conn))
 	mux.HandleFunc("/api/backorders/bulk_delete", backorder.BulkDeleteBackordersHandler(conn))
+	mux.HandleFunc("/api/backorders/update", backorder.UpdateBackorderHandler(conn))
+	mux.HandleFunc("/api/backorders/aging", backorder.AgingReportHandler(conn))
 	mux.HandleFunc("/api/masters/reload_jcshms", loader.CreateMasterUpdateHandler(conn))
 	mux.HandleFunc("/api/pricing/export", pricing.GetExportDataHandler(conn))
 	mux.HandleFunc("/api/pricing/upload", pricing.UploadQuotesHandler(conn))
+	mux.HandleFunc("/api/pricing/quotes", pricing.QuoteHistoryHandler(conn))
 	mux.HandleFunc("/api/pricing/update", pricing.BulkUpdateHandler(conn))
 	mux.HandleFunc("/api/pricing/all_masters", pricing.GetAllMastersForPricingHandler(conn))
 	mux.HandleFunc("/api/pricing/direct_import", pricing.DirectImportHandler(conn))
 	mux.HandleFunc("/api/stock/current", stock.GetCurrentStockHandler(conn))
 	mux.HandleFunc("/api/stock/all_current", stock.GetAllCurrentStockHandler(conn))
+	mux.HandleFunc("/api/stock/lots", stock.GetLotStockHandler(conn))
 	mux.HandleFunc("/api/medrec/download", medrec.DownloadHandler(conn))
 	mux.HandleFunc("/api/edge/download", edge.DownloadHandler(conn))
 	mux.HandleFunc("/api/sequence/next/", sequence.GetNextSequenceHandler(conn))
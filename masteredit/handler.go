@@ -8,11 +8,18 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/mappers"
 	"wasabi/model"
 )
 
+// EditableMaster は製品マスター編集画面の1件で、ABC/XYZ分析で保存した分類を含みます。
+type EditableMaster struct {
+	*model.ProductMaster
+	AbcXyzClass string `json:"abcXyzClass"` // 例: "AX"。分類が保存されていない場合は空
+}
+
 func GetEditableMastersHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		masters, err := db.GetAllProductMasters(conn)
@@ -20,8 +27,17 @@ func GetEditableMastersHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to get editable masters", http.StatusInternalServerError)
 			return
 		}
+		classes, err := db.GetAbcXyzClassMap(conn)
+		if err != nil {
+			http.Error(w, "Failed to get ABC/XYZ classifications", http.StatusInternalServerError)
+			return
+		}
+		editable := make([]EditableMaster, 0, len(masters))
+		for _, m := range masters {
+			editable = append(editable, EditableMaster{ProductMaster: m, AbcXyzClass: classes[m.ProductCode]})
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(masters)
+		json.NewEncoder(w).Encode(editable)
 	}
 }
 func UpdateMasterHandler(conn *sql.DB) http.HandlerFunc {
@@ -39,7 +55,7 @@ func UpdateMasterHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Product Code (JAN) cannot be empty.", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -74,7 +90,7 @@ func CreateProvisionalMasterHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "gs1Code and productCode are required", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -150,7 +166,7 @@ func SetOrderStoppedHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "productCode is required", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -228,7 +244,7 @@ func BulkUpdateShelfNumbersHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -300,7 +316,7 @@ func CreateFromJcshmsHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
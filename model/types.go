@@ -198,6 +198,29 @@ type AggregationFilters struct {
 	YjCode       string
 	MovementOnly bool
 	ShelfNumber  string
+	// Forecast は発注点の計算方法です。Method が空の場合は従来どおり最大使用量×Coefficient とします。
+	Forecast ForecastParams
+}
+
+// ForecastParams は発注点の計算に使う需要予測の方法と条件です。
+type ForecastParams struct {
+	Method       string  `json:"method"`                // moving_average, exponential_smoothing, day_of_week, max_usage
+	WindowDays   int     `json:"windowDays,omitempty"`  // 移動平均・曜日別平均に使う直近の日数
+	Alpha        float64 `json:"alpha,omitempty"`       // 指数平滑の平滑化係数 (0～1)
+	LeadTimeDays int     `json:"leadTimeDays"`          // 発注から納品までの日数
+	ServiceLevel float64 `json:"serviceLevel"`          // 欠品しない確率の目標 (例: 0.95)
+	Coefficient  float64 `json:"coefficient,omitempty"` // max_usage の係数
+}
+
+// ForecastResult は包装ごとの需要予測と発注点の内訳です。
+type ForecastResult struct {
+	ForecastParams
+	HistoryDays    int     `json:"historyDays"`    // 予測に使った日数
+	DailyDemand    float64 `json:"dailyDemand"`    // 予測した1日の平均需要 (YJ単位)
+	DemandStdDev   float64 `json:"demandStdDev"`   // 1日の需要の予測誤差の標準偏差
+	SafetyFactor   float64 `json:"safetyFactor"`   // サービス率に対応する安全係数 (z)
+	LeadTimeDemand float64 `json:"leadTimeDemand"` // リードタイム中の予測需要
+	SafetyStock    float64 `json:"safetyStock"`
 }
 
 type ValuationFilters struct {
@@ -235,6 +258,7 @@ type StockLedgerPackageGroup struct {
 	BaseReorderPoint       float64             `json:"baseReorderPoint"`
 	PrecompoundedTotal     float64             `json:"precompoundedTotal"`
 	DeliveryHistory        []TransactionRecord `json:"deliveryHistory,omitempty"`
+	Forecast               *ForecastResult     `json:"forecast,omitempty"` // BaseReorderPoint の計算方法と内訳
 }
 
 type LedgerTransaction struct {
@@ -314,10 +338,20 @@ type PreCompoundingRecord struct {
 }
 
 type Wholesaler struct {
-	Code string `json:"code"`
-	Name string `json:"name"`
+	Code           string  `json:"code"`
+	Name           string  `json:"name"`
+	MinOrderAmount float64 `json:"minOrderAmount"` // 1回の発注の最低金額 (0 の場合は制限なし)
 }
 
+// 発注残の状態です。ordered と partial が未完了 (納品待ち) で、発注残の数量として集計されます。
+const (
+	BackorderStatusOrdered    = "ordered"      // 発注済み
+	BackorderStatusPartial    = "partial"      // 一部納品
+	BackorderStatusReceived   = "received"     // 納品完了
+	BackorderStatusCancelled  = "cancelled"    // 取消
+	BackorderStatusOutOfStock = "out_of_stock" // 卸の欠品
+)
+
 type Backorder struct {
 	ID                int            `json:"id"`
 	OrderDate         string         `json:"orderDate"`
@@ -332,8 +366,123 @@ type Backorder struct {
 	YjPackUnitQty     float64        `json:"yjPackUnitQty"`
 	JanPackUnitQty    float64        `json:"janPackUnitQty"`
 	JanUnitCode       int            `json:"janUnitCode"`
+	ProductCode       string         `json:"productCode"` // 発注した製品 (JAN)
+	PoNumber          string         `json:"poNumber"`    // 発注書番号
+	Status            string         `json:"status"`
+	ExpectedDate      string         `json:"expectedDate"` // 納品予定日 (YYYYMMDD)
+	StatusReason      string         `json:"statusReason"` // 取消・欠品の理由
+	ClosedDate        string         `json:"closedDate"`   // 納品完了・取消・欠品になった日 (YYYYMMDD)
 	// フロントエンドからの発注データ受け取り用フィールド
 	YjQuantity float64 `json:"yjQuantity,omitempty"`
+	// 納品データによる消し込み用フィールド (納品した取引の伝票。WholesalerCode は納品した卸です)
+	DeliveryDate          string `json:"-"`
+	DeliveryReceiptNumber string `json:"-"`
+	DeliveryLineNumber    string `json:"-"`
+}
+
+// BackorderFulfillment は発注残を消し込んだ納品データ (DATの明細) です。
+type BackorderFulfillment struct {
+	ID              int64   `json:"id"`
+	BackorderID     int     `json:"backorderId"`
+	TransactionDate string  `json:"transactionDate"`
+	ClientCode      string  `json:"clientCode"`
+	ReceiptNumber   string  `json:"receiptNumber"`
+	LineNumber      string  `json:"lineNumber"`
+	JanCode         string  `json:"janCode"`
+	Quantity        float64 `json:"quantity"` // 消し込んだ数量 (YJ単位)
+}
+
+// BackorderReconciliation は納品による発注残の消し込み1件分の明細です。
+// 取込バッチのロールバック時に発注残を復元できるよう、元の発注残の内容も保持します。
+type BackorderReconciliation struct {
+	BackorderID      int            `json:"backorderId"`
+	OrderDate        string         `json:"orderDate"`
+	YjCode           string         `json:"yjCode"`
+	ProductName      string         `json:"productName"`
+	PackageForm      string         `json:"packageForm"`
+	JanPackInnerQty  float64        `json:"janPackInnerQty"`
+	YjUnitName       string         `json:"yjUnitName"`
+	OrderQuantity    float64        `json:"orderQuantity"`
+	WholesalerCode   sql.NullString `json:"wholesalerCode"`
+	YjPackUnitQty    float64        `json:"yjPackUnitQty"`
+	JanPackUnitQty   float64        `json:"janPackUnitQty"`
+	JanUnitCode      int            `json:"janUnitCode"`
+	ProductCode      string         `json:"productCode"`
+	PoNumber         string         `json:"poNumber"`
+	RemainingBefore  float64        `json:"remainingBefore"`
+	ConsumedQuantity float64        `json:"consumedQuantity"`
+	RemainingAfter   float64        `json:"remainingAfter"`
+	FulfillmentID    int64          `json:"fulfillmentId"`
+}
+
+// ImportBatch はファイル取込1回分の台帳レコードです。
+type ImportBatch struct {
+	ID             int    `json:"id"`
+	SourceType     string `json:"sourceType"` // "DAT", "USAGE", "INVENTORY"
+	FileName       string `json:"fileName"`
+	FileHash       string `json:"fileHash"`
+	ImportedAt     string `json:"importedAt"`
+	RecordCount    int    `json:"recordCount"`
+	DisplacedCount int    `json:"displacedCount"`
+	BackorderCount int    `json:"backorderCount"`
+	Status         string `json:"status"` // "ACTIVE", "ROLLED_BACK"
+	RolledBackAt   string `json:"rolledBackAt,omitempty"`
+}
+
+// UsageColumn はUSAGEファイルの1項目がどの列にあるかを示します。
+// ヘッダー行がある形式では Header を優先し、見つからない場合は Index (1始まりの列番号) を使用します。
+type UsageColumn struct {
+	Index  int    `json:"index,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+// UsageColumnMap はUSAGEファイルの各項目の列の割り当てです。
+type UsageColumnMap struct {
+	Date        UsageColumn `json:"date"`
+	YjCode      UsageColumn `json:"yjCode"`
+	JanCode     UsageColumn `json:"janCode"`
+	ProductName UsageColumn `json:"productName"`
+	Quantity    UsageColumn `json:"quantity"`
+	UnitName    UsageColumn `json:"unitName"`
+}
+
+// UsageFormat はレセコンごとに異なるUSAGEファイル(処方データ)の形式定義です。
+type UsageFormat struct {
+	Name         string         `json:"name"`
+	Encoding     string         `json:"encoding"`     // "shift_jis" または "utf-8"
+	Delimiter    string         `json:"delimiter"`    // 空の場合はカンマ。"\t" でタブ区切り
+	HasHeader    bool           `json:"hasHeader"`    // 先頭(SkipRows 行の後)がヘッダー行か
+	DateFormat   string         `json:"dateFormat"`   // YYYY, YY, MM, DD の組合せ (例: "YYYY/MM/DD")。空の場合は YYYYMMDD
+	SkipRows     int            `json:"skipRows"`     // ファイル先頭で読み飛ばす行数
+	SkipPrefixes []string       `json:"skipPrefixes"` // 先頭の列がこの文字列で始まる行は読み飛ばす (例: "#", "合計")
+	Columns      UsageColumnMap `json:"columns"`
+	BuiltIn      bool           `json:"builtIn,omitempty"`
+}
+
+// NsipsLayout はNSIPS処方データ(CSV)のレコード識別子と項目の列位置の定義です。
+// 列番号は UsageColumn と同じく1始まりです。レセコンのNSIPS出力仕様書に合わせて設定します。
+type NsipsLayout struct {
+	SpecVersion        string   `json:"specVersion"`        // このレイアウトが対応するNSIPS出力仕様の版 (記録・表示用)
+	DateFormat         string   `json:"dateFormat"`         // 調剤年月日の書式。空の場合は YYYYMMDD
+	RecordTypeColumn   int      `json:"recordTypeColumn"`   // レコード識別子の列
+	PatientRecord      string   `json:"patientRecord"`      // 患者情報のレコード識別子
+	PrescriptionRecord string   `json:"prescriptionRecord"` // 処方箋情報のレコード識別子
+	RpRecord           string   `json:"rpRecord"`           // RP(剤)情報のレコード識別子
+	DrugRecord         string   `json:"drugRecord"`         // 薬品情報のレコード識別子
+	PatientNumber      int      `json:"patientNumber"`      // 患者情報: 患者番号
+	PrescriptionNumber int      `json:"prescriptionNumber"` // 処方箋情報: 処方箋番号(受付番号)
+	DispenseDate       int      `json:"dispenseDate"`       // 処方箋情報: 調剤年月日
+	RpNumber           int      `json:"rpNumber"`           // RP情報: RP番号
+	RpDosage           int      `json:"rpDosage"`           // RP情報: 剤型区分
+	RpQuantity         int      `json:"rpQuantity"`         // RP情報: 調剤数量 (内服:日数, 頓服:回数)
+	DrugRpNumber       int      `json:"drugRpNumber"`       // 薬品情報: RP番号
+	DrugSeq            int      `json:"drugSeq"`            // 薬品情報: 薬品連番
+	DrugCode           int      `json:"drugCode"`           // 薬品情報: 薬品コード (YJコード または JANコード13桁)
+	DrugName           int      `json:"drugName"`           // 薬品情報: 薬品名
+	DrugDose           int      `json:"drugDose"`           // 薬品情報: 用量 (内服:1日量, 頓服:1回量, 外用:総量)
+	DrugUnit           int      `json:"drugUnit"`           // 薬品情報: 単位名
+	MultipliedDosages  []string `json:"multipliedDosages"`  // 用量に日数・回数を掛けて総量を求める剤型区分
+	BuiltIn            bool     `json:"builtIn,omitempty"`
 }
 
 type PriceUpdate struct {
@@ -360,3 +509,472 @@ type ValuationDetailRow struct {
 	TotalPurchaseValue   float64 `json:"totalPurchaseValue"`
 	ShowAlert            bool    `json:"showAlert"`
 }
+
+// ScheduledJob は定期実行ジョブ1件の設定と状態です。
+type ScheduledJob struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CronExpr    string `json:"cronExpr"`
+	Enabled     bool   `json:"enabled"`
+	NextRunAt   string `json:"nextRunAt,omitempty"`
+	LastRunAt   string `json:"lastRunAt,omitempty"`
+	LastStatus  string `json:"lastStatus,omitempty"` // 直近の実行結果 ("RUNNING", "SUCCESS", "FAILED")
+	Running     bool   `json:"running"`
+}
+
+// JobRun は定期実行ジョブの実行1回分の履歴です。
+type JobRun struct {
+	ID          int64  `json:"id"`
+	JobName     string `json:"jobName"`
+	TriggerType string `json:"triggerType"` // "SCHEDULE", "MANUAL"
+	StartedAt   string `json:"startedAt"`
+	FinishedAt  string `json:"finishedAt,omitempty"`
+	Status      string `json:"status"` // "RUNNING", "SUCCESS", "FAILED"
+	Message     string `json:"message,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// StockBalance は製品ごとの在庫スナップショットです。現在庫は BaseQuantity + NetChange です。
+type StockBalance struct {
+	ProductCode       string  `json:"productCode"`
+	LastInventoryDate string  `json:"lastInventoryDate"`
+	BaseQuantity      float64 `json:"baseQuantity"`
+	NetChange         float64 `json:"netChange"`
+	UpdatedAt         string  `json:"updatedAt,omitempty"`
+}
+
+// StockBalanceDrift は在庫スナップショットと取引データからの再計算結果の食い違いです。
+type StockBalanceDrift struct {
+	ProductCode string        `json:"productCode"`
+	Stored      *StockBalance `json:"stored"`   // スナップショットに無い場合は nil
+	Expected    *StockBalance `json:"expected"` // 取引データが無い場合は nil
+}
+
+// LotBalance は製品のロット・有効期限ごとの現在庫です。
+// LotNumber と ExpiryDate が空の行は、ロット不明の在庫 (引き当てられなかった出庫がある場合はマイナス) です。
+type LotBalance struct {
+	ProductCode string  `json:"productCode"`
+	LotNumber   string  `json:"lotNumber"`
+	ExpiryDate  string  `json:"expiryDate"` // YYYY-MM-DD (日が無い期限は月末日)
+	Quantity    float64 `json:"quantity"`   // YJ単位
+}
+
+// ExpiryAlertItem は有効期限切迫レポートの1行 (製品・ロットごとの在庫) です。
+type ExpiryAlertItem struct {
+	ProductCode   string  `json:"productCode"`
+	YjCode        string  `json:"yjCode"`
+	ProductName   string  `json:"productName"`
+	PackageSpec   string  `json:"packageSpec"`
+	ShelfNumber   string  `json:"shelfNumber"`
+	LotNumber     string  `json:"lotNumber"`
+	ExpiryDate    string  `json:"expiryDate"` // YYYY-MM-DD
+	DaysLeft      int     `json:"daysLeft"`   // 期限切れの場合はマイナス
+	Quantity      float64 `json:"quantity"`   // YJ単位
+	YjUnitName    string  `json:"yjUnitName"`
+	NhiValue      float64 `json:"nhiValue"`
+	PurchaseValue float64 `json:"purchaseValue"`
+	LastUsageDate string  `json:"lastUsageDate"` // 最終処方日 (YYYYMMDD)
+	Source        string  `json:"source"`        // "lot": ロット別在庫, "deadstock": デッドストックリスト
+}
+
+// ExpiryAlertWindow は有効期限切迫の期間ごとの件数と金額です。
+type ExpiryAlertWindow struct {
+	Days          int     `json:"days"` // 0 は期限切れ
+	ItemCount     int     `json:"itemCount"`
+	NhiValue      float64 `json:"nhiValue"`
+	PurchaseValue float64 `json:"purchaseValue"`
+}
+
+// ClosedPeriod は締め済みの会計期間 (月単位) です。
+type ClosedPeriod struct {
+	ID                 int     `json:"id"`
+	Period             string  `json:"period"`    // YYYYMM
+	StartDate          string  `json:"startDate"` // YYYYMMDD
+	EndDate            string  `json:"endDate"`   // YYYYMMDD
+	ClosedAt           string  `json:"closedAt"`
+	ClosedBy           string  `json:"closedBy"`
+	TotalNhiValue      float64 `json:"totalNhiValue"`
+	TotalPurchaseValue float64 `json:"totalPurchaseValue"`
+	ReopenedAt         string  `json:"reopenedAt"` // 空の場合は締め済み
+	ReopenedBy         string  `json:"reopenedBy"`
+	ReopenReason       string  `json:"reopenReason"`
+}
+
+// AuditLogEntry は監査ログ (audit_log) の1件です。
+type AuditLogEntry struct {
+	ID         int64  `json:"id"`
+	CreatedAt  string `json:"createdAt"`
+	Entity     string `json:"entity"`    // テーブル名
+	EntityKey  string `json:"entityKey"` // 主キー (product_code や id)
+	Action     string `json:"action"`    // INSERT / UPDATE / DELETE
+	BeforeJSON string `json:"beforeJson"`
+	AfterJSON  string `json:"afterJson"`
+	UserName   string `json:"userName"`
+}
+
+// AuditLogFilters は監査ログの検索条件です。
+type AuditLogFilters struct {
+	Entity    string
+	EntityKey string
+	Action    string
+	UserName  string
+	Keyword   string // 変更前後のJSONに含まれる文字列
+	DateFrom  string // YYYY-MM-DD
+	DateTo    string // YYYY-MM-DD
+	Limit     int
+}
+
+// ControlledRegisterFilters は麻薬・向精神薬帳簿の抽出条件です。
+type ControlledRegisterFilters struct {
+	DrugTypes   []string // AggregationFilters.DrugTypes と同じ区分 (narcotic, psychotropic1 など)
+	ProductCode string
+	StartDate   string // YYYYMMDD
+	EndDate     string // YYYYMMDD
+	ByLot       bool   // ロット (製造番号) ごとに帳簿を分ける
+}
+
+// ControlledRegisterEntry は麻薬・向精神薬帳簿の1行です。
+// 棚卸の行は、その日の帳簿残高 (BookBalance) と実在庫 (Counted) を比較し、差異があれば Discrepancy に記録します。
+type ControlledRegisterEntry struct {
+	TransactionID int     `json:"transactionId"`
+	Date          string  `json:"date"` // YYYYMMDD
+	Flag          int     `json:"flag"`
+	Category      string  `json:"category"` // receipt / dispense / transfer / disposal / adjust / inventory
+	CategoryLabel string  `json:"categoryLabel"`
+	ClientCode    string  `json:"clientCode"`
+	ClientName    string  `json:"clientName"`
+	ReceiptNumber string  `json:"receiptNumber"`
+	LotNumber     string  `json:"lotNumber"`
+	ExpiryDate    string  `json:"expiryDate"`
+	Received      float64 `json:"received"`
+	Issued        float64 `json:"issued"`
+	Balance       float64 `json:"balance"`
+	BookBalance   float64 `json:"bookBalance"`
+	Counted       float64 `json:"counted"`
+	Discrepancy   float64 `json:"discrepancy"`
+	Witness       string  `json:"witness"`
+	Note          string  `json:"note"`
+	Closed        bool    `json:"closed"` // 締め済みの期間の行 (変更不可)
+}
+
+// ControlledRegister は製品 (ByLot の場合は製品とロット) ごとの麻薬・向精神薬帳簿です。
+type ControlledRegister struct {
+	ProductCode      string                    `json:"productCode"`
+	YjCode           string                    `json:"yjCode"`
+	ProductName      string                    `json:"productName"`
+	PackageSpec      string                    `json:"packageSpec"`
+	YjUnitName       string                    `json:"yjUnitName"`
+	DrugClass        string                    `json:"drugClass"`
+	LotNumber        string                    `json:"lotNumber"`
+	ExpiryDate       string                    `json:"expiryDate"`
+	OpeningBalance   float64                   `json:"openingBalance"`
+	TotalReceived    float64                   `json:"totalReceived"`
+	TotalIssued      float64                   `json:"totalIssued"`
+	ClosingBalance   float64                   `json:"closingBalance"`
+	DiscrepancyCount int                       `json:"discrepancyCount"`
+	Entries          []ControlledRegisterEntry `json:"entries"`
+}
+
+// ControlledRegisterNote は帳簿の行に付ける区分・立会人・備考です。
+type ControlledRegisterNote struct {
+	TransactionID int    `json:"transactionId"`
+	Category      string `json:"category"`
+	Witness       string `json:"witness"`
+	Note          string `json:"note"`
+}
+
+// UnresolvedRegisterNote は伝票の削除や別の製品への置き換えにより、取引に対応しなくなった帳簿の記載です。
+type UnresolvedRegisterNote struct {
+	TransactionDate string `json:"transactionDate"`
+	ClientCode      string `json:"clientCode"`
+	ReceiptNumber   string `json:"receiptNumber"`
+	LineNumber      string `json:"lineNumber"`
+	JanCode         string `json:"janCode"`
+	Category        string `json:"category"`
+	Witness         string `json:"witness"`
+	Note            string `json:"note"`
+}
+
+// NarcoticAnnualReportItem は麻薬年間届の1品目です (数量はYJ単位)。
+// 年初在庫 + 受入 - 施用・交付 - 譲渡 - 廃棄 + 調整 = 年末在庫 となります。調整には棚卸の差異を含みます。
+type NarcoticAnnualReportItem struct {
+	ProductCode string  `json:"productCode"`
+	YjCode      string  `json:"yjCode"`
+	ProductName string  `json:"productName"`
+	PackageSpec string  `json:"packageSpec"`
+	YjUnitName  string  `json:"yjUnitName"`
+	Opening     float64 `json:"opening"`
+	Received    float64 `json:"received"`
+	Dispensed   float64 `json:"dispensed"`
+	Transferred float64 `json:"transferred"`
+	Disposed    float64 `json:"disposed"`
+	Adjusted    float64 `json:"adjusted"`
+	Closing     float64 `json:"closing"`
+}
+
+// NarcoticAnnualReport は麻薬年間届 (10月1日～翌年9月30日) の集計です。
+type NarcoticAnnualReport struct {
+	Year      int                        `json:"year"`      // 期間が終わる年 (2026 の場合は 2025-10-01 ～ 2026-09-30)
+	StartDate string                     `json:"startDate"` // YYYYMMDD
+	EndDate   string                     `json:"endDate"`   // YYYYMMDD
+	Items     []NarcoticAnnualReportItem `json:"items"`
+	// 期間内の帳簿の記載のうち取引に対応しないもの。集計に反映されていないため、届出前に確認が必要です。
+	UnresolvedNotes []UnresolvedRegisterNote `json:"unresolvedNotes"`
+}
+
+// InventoryVarianceFilters は棚卸差異レポートの抽出条件です。
+type InventoryVarianceFilters struct {
+	StartDate       string // 棚卸日 YYYYMMDD
+	EndDate         string // 棚卸日 YYYYMMDD
+	GroupBy         string // "shelf": 棚番, "form": 剤型, "drugType": 薬品区分
+	DifferencesOnly bool   // 差異のある品目のみ
+}
+
+// InventoryVarianceItem は棚卸1回・1品目の理論在庫と実棚数の差異です (数量はYJ単位)。
+type InventoryVarianceItem struct {
+	CountDate           string  `json:"countDate"`
+	ProductCode         string  `json:"productCode"`
+	YjCode              string  `json:"yjCode"`
+	ProductName         string  `json:"productName"`
+	ShelfNumber         string  `json:"shelfNumber"`
+	UsageClassification string  `json:"usageClassification"`
+	DrugType            string  `json:"drugType"`
+	YjUnitName          string  `json:"yjUnitName"`
+	TheoreticalQuantity float64 `json:"theoreticalQuantity"`
+	CountedQuantity     float64 `json:"countedQuantity"`
+	Difference          float64 `json:"difference"` // 実棚数 - 理論在庫 (マイナスは不足)
+	NhiValue            float64 `json:"nhiValue"`
+	PurchaseValue       float64 `json:"purchaseValue"`
+	Source              string  `json:"source"`
+}
+
+// InventoryVarianceSummary は棚卸日・集計区分 (棚・剤型・薬品区分) ごとの差異の集計です。
+type InventoryVarianceSummary struct {
+	CountDate             string  `json:"countDate"`
+	GroupKey              string  `json:"groupKey"`
+	ItemCount             int     `json:"itemCount"`
+	DiscrepancyCount      int     `json:"discrepancyCount"`
+	ShortageNhiValue      float64 `json:"shortageNhiValue"` // 不足分の薬価金額 (マイナス)
+	SurplusNhiValue       float64 `json:"surplusNhiValue"`
+	NetNhiValue           float64 `json:"netNhiValue"`
+	ShortagePurchaseValue float64 `json:"shortagePurchaseValue"`
+	SurplusPurchaseValue  float64 `json:"surplusPurchaseValue"`
+	NetPurchaseValue      float64 `json:"netPurchaseValue"`
+}
+
+// InventoryVarianceReport は棚卸差異レポートです。
+type InventoryVarianceReport struct {
+	GroupBy   string                     `json:"groupBy"`
+	Summaries []InventoryVarianceSummary `json:"summaries"`
+	Items     []InventoryVarianceItem    `json:"items"`
+}
+
+// CycleCountPolicy は循環棚卸の計画の条件です。
+type CycleCountPolicy struct {
+	IntervalDays    map[string]int `json:"intervalDays"`    // ABC分類ごとの棚卸間隔 (日)
+	DailyLimit      int            `json:"dailyLimit"`      // 1日に棚卸する品目 (YJ) の上限。0 の場合は上限なし
+	UsagePeriodDays int            `json:"usagePeriodDays"` // ABC分類に使う処方の集計日数
+}
+
+// CycleCountItem は循環棚卸リストの1品目 (YJコード) です。
+type CycleCountItem struct {
+	CountDate     string `json:"countDate"` // YYYYMMDD
+	YjCode        string `json:"yjCode"`
+	ProductName   string `json:"productName"`
+	ShelfNumber   string `json:"shelfNumber"`
+	AbcClass      string `json:"abcClass"`
+	LastCountDate string `json:"lastCountDate"` // 未実施の場合は空
+	DueDate       string `json:"dueDate"`       // 未実施の場合は空
+	Status        string `json:"status"`        // "pending" または "done"
+	CompletedAt   string `json:"completedAt"`
+}
+
+// CycleCountShelf は棚番ごとの循環棚卸リストです。
+type CycleCountShelf struct {
+	ShelfNumber string           `json:"shelfNumber"`
+	Items       []CycleCountItem `json:"items"`
+}
+
+// CycleCountClassSummary はABC分類ごとの棚卸の実施状況です。
+type CycleCountClassSummary struct {
+	AbcClass     string `json:"abcClass"`
+	IntervalDays int    `json:"intervalDays"`
+	GroupCount   int    `json:"groupCount"`   // 品目 (YJ) 数
+	OverdueCount int    `json:"overdueCount"` // 棚卸期限を過ぎた品目数 (未実施を含む)
+	NeverCounted int    `json:"neverCounted"`
+}
+
+// AbcXyzFilters はABC/XYZ分析の条件です。
+type AbcXyzFilters struct {
+	StartDate string // YYYYMMDD
+	EndDate   string // YYYYMMDD
+}
+
+// AbcXyzItem はABC/XYZ分析の1品目 (YJコードまたは包装) です。
+type AbcXyzItem struct {
+	Key             string   `json:"key"` // YJコード、または包装キー (YJコード|包装形態|内包装数量|YJ単位)
+	YjCode          string   `json:"yjCode"`
+	ProductName     string   `json:"productName"`
+	PackageSpec     string   `json:"packageSpec"` // 包装の場合のみ
+	YjUnitName      string   `json:"yjUnitName"`
+	ProductCodes    []string `json:"productCodes"`
+	UsageQuantity   float64  `json:"usageQuantity"` // 期間の処方数量 (YJ単位)
+	UsageValue      float64  `json:"usageValue"`    // 期間の処方金額 (薬価)
+	CumulativeShare float64  `json:"cumulativeShare"`
+	AbcClass        string   `json:"abcClass"`
+	WeeklyMean      float64  `json:"weeklyMean"`
+	WeeklyStdDev    float64  `json:"weeklyStdDev"`
+	CV              float64  `json:"cv"` // 変動係数 (処方が無い場合は0)
+	XyzClass        string   `json:"xyzClass"`
+}
+
+// AbcXyzCell はABC/XYZの組み合わせごとの品目数と処方金額です。
+type AbcXyzCell struct {
+	AbcClass   string  `json:"abcClass"`
+	XyzClass   string  `json:"xyzClass"`
+	Count      int     `json:"count"`
+	UsageValue float64 `json:"usageValue"`
+	ValueShare float64 `json:"valueShare"`
+}
+
+// AbcXyzResult は集計単位 (YJコードまたは包装) ごとの分析結果です。
+type AbcXyzResult struct {
+	Matrix     []AbcXyzCell `json:"matrix"` // A-X, A-Y, A-Z, B-X ... C-Z の順
+	TotalValue float64      `json:"totalValue"`
+	Items      []AbcXyzItem `json:"items"` // 処方金額の多い順
+}
+
+// AbcXyzAnalysis はABC/XYZ分析の結果です。
+type AbcXyzAnalysis struct {
+	StartDate string       `json:"startDate"`
+	EndDate   string       `json:"endDate"`
+	Weeks     int          `json:"weeks"`
+	YjGroups  AbcXyzResult `json:"yjGroups"`
+	Packages  AbcXyzResult `json:"packages"`
+}
+
+// ReplenishmentPolicy は製品・包装・YJコードごとの補充条件です。数量はYJ単位、最小発注数は包装数です。
+type ReplenishmentPolicy struct {
+	PolicyKey        string  `json:"policyKey"` // 製品コード、包装キー (YJコード|包装形態|内包装数量|YJ単位)、YJコード、ABC/XYZ分類 (AX～CZ)
+	Scope            string  `json:"scope"`     // product / package / yj / class
+	TargetName       string  `json:"targetName"`
+	MinQuantity      float64 `json:"minQuantity"`  // 0 の場合は需要予測の発注点
+	MaxQuantity      float64 `json:"maxQuantity"`  // 0 の場合は発注点まで補充する
+	SafetyDays       float64 `json:"safetyDays"`   // 0 の場合はサービス率による安全在庫
+	LeadTimeDays     int     `json:"leadTimeDays"` // 0 の場合は設定のリードタイム
+	MinOrderQuantity float64 `json:"minOrderQuantity"`
+	RoundToPackage   bool    `json:"roundToPackage"`
+	Notes            string  `json:"notes"`
+	UpdatedAt        string  `json:"updatedAt"`
+}
+
+// OrderSuggestion は製品ごとの発注数の提案です。
+type OrderSuggestion struct {
+	PolicyKey         string  `json:"policyKey"` // 適用した補充条件 (無い場合は空)
+	Scope             string  `json:"scope"`
+	ReorderPoint      float64 `json:"reorderPoint"`      // 予製引当数を含む (YJ単位)
+	OrderUpTo         float64 `json:"orderUpTo"`         // 補充後の在庫の目標 (YJ単位)
+	AvailableQuantity float64 `json:"availableQuantity"` // 在庫 + 発注残 (YJ単位)
+	ShortfallQuantity float64 `json:"shortfallQuantity"` // 目標までの不足数 (YJ単位)
+	ReorderNeeded     bool    `json:"reorderNeeded"`
+	Packages          float64 `json:"packages"`    // 提案する発注数 (包装数。バラ発注の場合は端数あり)
+	JanQuantity       float64 `json:"janQuantity"` // 提案する発注数 (JAN単位)
+	YjQuantity        float64 `json:"yjQuantity"`  // 提案する発注数 (YJ単位)
+}
+
+// WholesalerQuote は卸の見積価格 (包装単位の納入価) の履歴です。
+type WholesalerQuote struct {
+	ID             int64   `json:"id"`
+	ProductCode    string  `json:"productCode"`
+	WholesalerCode string  `json:"wholesalerCode"` // 卸業者管理に無い卸は空
+	WholesalerName string  `json:"wholesalerName"`
+	Price          float64 `json:"price"`
+	ValidFrom      string  `json:"validFrom"` // YYYYMMDD
+	ValidTo        string  `json:"validTo"`   // YYYYMMDD (空は期限なし)
+	QuotedAt       string  `json:"quotedAt"`
+}
+
+// OrderAllocationRequestLine は卸への振り分けを行う発注候補の1行です。
+type OrderAllocationRequestLine struct {
+	ProductCode string  `json:"productCode"`
+	Quantity    float64 `json:"quantity"` // 包装数
+}
+
+// OrderAllocationLine は振り分けた発注の1行です。金額は包装単位の納入価 × 包装数です。
+type OrderAllocationLine struct {
+	ProductCode           string  `json:"productCode"`
+	ProductName           string  `json:"productName"`
+	Quantity              float64 `json:"quantity"`
+	WholesalerCode        string  `json:"wholesalerCode"`
+	UnitPrice             float64 `json:"unitPrice"`
+	Amount                float64 `json:"amount"`
+	QuoteValidTo          string  `json:"quoteValidTo"` // 採用した見積の有効期限 (見積が無い場合・期限なしは空)
+	CurrentWholesalerCode string  `json:"currentWholesalerCode"`
+	CurrentUnitPrice      float64 `json:"currentUnitPrice"`
+	Savings               float64 `json:"savings"` // 現在の採用卸・納入価との差額 (安くなる場合は正)
+	Reason                string  `json:"reason"`  // 採用卸のままにした理由、または除外した理由
+}
+
+// OrderAllocationGroup は卸ごとの振り分け結果です。
+type OrderAllocationGroup struct {
+	WholesalerCode string                `json:"wholesalerCode"`
+	WholesalerName string                `json:"wholesalerName"`
+	MinOrderAmount float64               `json:"minOrderAmount"`
+	TotalAmount    float64               `json:"totalAmount"`
+	BelowMinimum   bool                  `json:"belowMinimum"`
+	Lines          []OrderAllocationLine `json:"lines"`
+}
+
+// OrderAllocationResult は発注候補を最安の有効な見積で卸ごとに振り分けた結果です。
+type OrderAllocationResult struct {
+	Date               string                 `json:"date"` // 見積の有効期間の判定日 (YYYYMMDD)
+	Groups             []OrderAllocationGroup `json:"groups"`
+	Excluded           []OrderAllocationLine  `json:"excluded"` // 発注不可・卸が決まらない行
+	TotalAmount        float64                `json:"totalAmount"`
+	CurrentTotalAmount float64                `json:"currentTotalAmount"`
+	TotalSavings       float64                `json:"totalSavings"`
+}
+
+// PurchaseOrder は卸ごとの発注書です。発注時に卸ごとに発注書番号を採番し、発注残に保存します。
+type PurchaseOrder struct {
+	PoNumber       string              `json:"poNumber"`
+	OrderDate      string              `json:"orderDate"` // YYYYMMDD
+	WholesalerCode string              `json:"wholesalerCode"`
+	WholesalerName string              `json:"wholesalerName"`
+	Lines          []PurchaseOrderLine `json:"lines"`
+}
+
+// PurchaseOrderLine は発注書の1行です。
+type PurchaseOrderLine struct {
+	ProductCode string  `json:"productCode"` // JAN
+	ProductName string  `json:"productName"`
+	PackageSpec string  `json:"packageSpec"`
+	Quantity    float64 `json:"quantity"`   // 包装数
+	YjQuantity  float64 `json:"yjQuantity"` // YJ単位の数量
+	YjUnitName  string  `json:"yjUnitName"`
+}
+
+// BackorderAgingItem は経過日数レポートの発注残です。
+type BackorderAgingItem struct {
+	Backorder
+	DaysOpen int  `json:"daysOpen"` // 発注日からの経過日数
+	Overdue  bool `json:"overdue"`  // 納品予定日を過ぎている
+}
+
+// BackorderAgingGroup は卸ごとの、発注から一定日数を過ぎた未完了の発注残です。
+type BackorderAgingGroup struct {
+	WholesalerCode  string               `json:"wholesalerCode"`
+	WholesalerName  string               `json:"wholesalerName"`
+	Count           int                  `json:"count"`
+	OverdueCount    int                  `json:"overdueCount"`
+	OldestOrderDate string               `json:"oldestOrderDate"`
+	MaxDaysOpen     int                  `json:"maxDaysOpen"`
+	Items           []BackorderAgingItem `json:"items"`
+}
+
+// BackorderAgingReport は発注残の経過日数レポートです。
+type BackorderAgingReport struct {
+	Date   string                `json:"date"` // 基準日 (YYYYMMDD)
+	Days   int                   `json:"days"` // この日数を超えて未完了の発注残を対象とします
+	Groups []BackorderAgingGroup `json:"groups"`
+}
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"wasabi/audit"
 	"wasabi/config"
 	"wasabi/db"
+	"wasabi/forecast"
 	"wasabi/model"
 	"wasabi/units"
 )
@@ -26,8 +29,15 @@ type OrderCandidateYJGroup struct {
 
 type OrderCandidatePackageGroup struct {
 	model.StockLedgerPackageGroup
-	Masters            []model.ProductMasterView `json:"masters"`
-	ExistingBackorders []model.Backorder         `json:"existingBackorders"`
+	Masters            []OrderCandidateMaster `json:"masters"`
+	ExistingBackorders []model.Backorder      `json:"existingBackorders"`
+}
+
+// OrderCandidateMaster は発注候補の製品と、補充条件による発注数の提案です。
+type OrderCandidateMaster struct {
+	model.ProductMasterView
+	Policy     *model.ReplenishmentPolicy `json:"policy,omitempty"`
+	Suggestion model.OrderSuggestion      `json:"suggestion"`
 }
 
 func GenerateOrderCandidatesHandler(conn *sql.DB) http.HandlerFunc {
@@ -58,6 +68,7 @@ func GenerateOrderCandidatesHandler(conn *sql.DB) http.HandlerFunc {
 			DosageForm:  dosageForm,
 			ShelfNumber: shelfNumber,
 			Coefficient: coefficient,
+			Forecast:    forecast.ParamsFromConfig(cfg, coefficient),
 		}
 
 		yjGroups, err := db.GetStockLedger(conn, filters)
@@ -77,38 +88,61 @@ func GenerateOrderCandidatesHandler(conn *sql.DB) http.HandlerFunc {
 			backordersByPackageKey[key] = append(backordersByPackageKey[key], bo)
 		}
 
+		policies, err := db.GetReplenishmentPolicyMap(conn)
+		if err != nil {
+			http.Error(w, "Failed to get replenishment policies for candidates", http.StatusInternalServerError)
+			return
+		}
+		abcXyzClasses, err := db.GetAbcXyzClassMap(conn)
+		if err != nil {
+			http.Error(w, "Failed to get ABC/XYZ classifications for candidates", http.StatusInternalServerError)
+			return
+		}
+
+		// 補充条件による発注数の提案があるYJコードを発注候補とする
 		var candidates []OrderCandidateYJGroup
 		for _, group := range yjGroups {
-			if group.IsReorderNeeded {
-				newYjGroup := OrderCandidateYJGroup{
-					StockLedgerYJGroup: group,
-					PackageLedgers:     []OrderCandidatePackageGroup{},
+			newYjGroup := OrderCandidateYJGroup{
+				StockLedgerYJGroup: group,
+				PackageLedgers:     []OrderCandidatePackageGroup{},
+			}
+			isReorderNeeded := false
+
+			for _, pkg := range group.PackageLedgers {
+				newPkgGroup := OrderCandidatePackageGroup{
+					StockLedgerPackageGroup: pkg,
+					Masters:                 []OrderCandidateMaster{},
+					ExistingBackorders:      backordersByPackageKey[pkg.PackageKey],
 				}
+				for _, master := range pkg.Masters {
+					tempJcshms := model.JCShms{
+						JC037: master.PackageForm,
+						JC039: master.YjUnitName,
+						JC044: master.YjPackUnitQty,
+						JA006: sql.NullFloat64{Float64: master.JanPackInnerQty, Valid: true},
+						JA008: sql.NullFloat64{Float64: master.JanPackUnitQty, Valid: true},
+						JA007: sql.NullString{String: fmt.Sprintf("%d", master.JanUnitCode), Valid: true},
+					}
+					formattedSpec := units.FormatPackageSpec(&tempJcshms)
 
-				for _, pkg := range group.PackageLedgers {
-					newPkgGroup := OrderCandidatePackageGroup{
-						StockLedgerPackageGroup: pkg,
-						Masters:                 []model.ProductMasterView{},
-						ExistingBackorders:      backordersByPackageKey[pkg.PackageKey],
+					policy := db.ResolveReplenishmentPolicy(policies, master, abcXyzClasses[master.ProductCode])
+					suggestion := db.SuggestOrder(&pkg, master, policy)
+					if suggestion.ReorderNeeded {
+						isReorderNeeded = true
 					}
-					for _, master := range pkg.Masters {
-						tempJcshms := model.JCShms{
-							JC037: master.PackageForm,
-							JC039: master.YjUnitName,
-							JC044: master.YjPackUnitQty,
-							JA006: sql.NullFloat64{Float64: master.JanPackInnerQty, Valid: true},
-							JA008: sql.NullFloat64{Float64: master.JanPackUnitQty, Valid: true},
-							JA007: sql.NullString{String: fmt.Sprintf("%d", master.JanUnitCode), Valid: true},
-						}
-						formattedSpec := units.FormatPackageSpec(&tempJcshms)
-
-						newPkgGroup.Masters = append(newPkgGroup.Masters, model.ProductMasterView{
+					newPkgGroup.Masters = append(newPkgGroup.Masters, OrderCandidateMaster{
+						ProductMasterView: model.ProductMasterView{
 							ProductMaster:        *master,
 							FormattedPackageSpec: formattedSpec,
-						})
-					}
-					newYjGroup.PackageLedgers = append(newYjGroup.PackageLedgers, newPkgGroup)
+						},
+						Policy:     policy,
+						Suggestion: suggestion,
+					})
 				}
+				newYjGroup.PackageLedgers = append(newYjGroup.PackageLedgers, newPkgGroup)
+			}
+			if isReorderNeeded {
+				newYjGroup.IsReorderNeeded = true
 				candidates = append(candidates, newYjGroup)
 			}
 		}
@@ -137,7 +171,7 @@ func PlaceOrderHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -145,16 +179,29 @@ func PlaceOrderHandler(conn *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		today := time.Now().Format("20060102")
+		// 納品予定日の既定値は、発注日に発注点の計算と同じリードタイムを足した日とする
+		leadTimeDays := forecast.ParamsFromConfig(config.GetConfig(), 0).LeadTimeDays
 		for i := range payload {
 			if payload[i].OrderDate == "" {
 				payload[i].OrderDate = today
 			}
+			if payload[i].ExpectedDate == "" {
+				if orderDate, err := time.Parse("20060102", payload[i].OrderDate); err == nil {
+					payload[i].ExpectedDate = orderDate.AddDate(0, 0, leadTimeDays).Format("20060102")
+				}
+			}
 			// ▼▼▼【ここから修正】▼▼▼
 			payload[i].OrderQuantity = payload[i].YjQuantity
 			payload[i].RemainingQuantity = payload[i].YjQuantity
 			// ▲▲▲【修正ここまで】▲▲▲
 		}
 
+		poNumbers, err := db.AssignPurchaseOrderNumbersInTx(tx, payload)
+		if err != nil {
+			http.Error(w, "Failed to assign purchase order numbers: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := db.InsertBackordersInTx(tx, payload); err != nil {
 			http.Error(w, "Failed to save backorders", http.StatusInternalServerError)
 			return
@@ -165,7 +212,59 @@ func PlaceOrderHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		purchaseOrders := make([]model.PurchaseOrder, 0, len(poNumbers))
+		for _, poNumber := range poNumbers {
+			po, err := db.GetPurchaseOrder(conn, poNumber)
+			if err != nil {
+				http.Error(w, "Failed to get purchase order: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if po != nil {
+				purchaseOrders = append(purchaseOrders, *po)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":        fmt.Sprintf("発注内容を発注残として登録しました (発注書 %d件)。", len(purchaseOrders)),
+			"purchaseOrders": purchaseOrders,
+		})
+	}
+}
+
+// AllocateOrdersRequest は発注候補の卸への振り分けのリクエストです。
+type AllocateOrdersRequest struct {
+	Date  string                             `json:"date"` // 見積の有効期間の判定日 (YYYY-MM-DD または YYYYMMDD、省略時は今日)
+	Lines []model.OrderAllocationRequestLine `json:"lines"`
+}
+
+// AllocateOrdersHandler は発注候補を、有効な見積の最も安い卸に振り分けます。発注残の登録は行いません。
+func AllocateOrdersHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req AllocateOrdersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		date := strings.ReplaceAll(req.Date, "-", "")
+		if date == "" {
+			date = time.Now().Format("20060102")
+		}
+		if _, err := time.Parse("20060102", date); err != nil {
+			http.Error(w, "日付が不正です: "+req.Date, http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.AllocateOrders(conn, date, req.Lines)
+		if err != nil {
+			http.Error(w, "卸への振り分けに失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "発注内容を発注残として登録しました。"})
+		json.NewEncoder(w).Encode(result)
 	}
 }
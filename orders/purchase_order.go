@@ -0,0 +1,220 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\orders\purchase_order.go
+
+package orders
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// PurchaseOrderDocumentHandler は発注書を ?format= (pdf, xlsx, csv) の形式で返します (?poNumber= で発注書番号を指定)。
+// CSVは卸の発注サイトに取り込むための、ヘッダーの無い JAN,包装数 の形式です。
+func PurchaseOrderDocumentHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		poNumber := r.URL.Query().Get("poNumber")
+		if poNumber == "" {
+			http.Error(w, "poNumber is required", http.StatusBadRequest)
+			return
+		}
+		po, err := db.GetPurchaseOrder(conn, poNumber)
+		if err != nil {
+			http.Error(w, "発注書の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if po == nil {
+			http.Error(w, "発注書が見つかりません: "+poNumber, http.StatusNotFound)
+			return
+		}
+
+		baseName := fmt.Sprintf("発注書_%s_%s", po.PoNumber, wholesalerLabel(po))
+		switch r.URL.Query().Get("format") {
+		case "pdf":
+			writePurchaseOrderPDF(w, po, baseName+".pdf")
+		case "xlsx":
+			writePurchaseOrderExcel(w, po, baseName+".xlsx")
+		case "csv":
+			writePurchaseOrderCSV(w, po, baseName+".csv")
+		default:
+			http.Error(w, "format は pdf, xlsx, csv のいずれかを指定してください", http.StatusBadRequest)
+		}
+	}
+}
+
+func wholesalerLabel(po *model.PurchaseOrder) string {
+	if po.WholesalerName != "" {
+		return po.WholesalerName
+	}
+	if po.WholesalerCode != "" {
+		return po.WholesalerCode
+	}
+	return "卸未指定"
+}
+
+// formatDate は YYYYMMDD 形式の日付を YYYY-MM-DD 形式に変換します。
+func formatDate(s string) string {
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:6] + "-" + s[6:]
+}
+
+// formatQuantity は数量を、整数なら小数点無しで、端数があれば小数第3位までで表示します。
+func formatQuantity(q float64) string {
+	return strconv.FormatFloat(math.Round(q*1000)/1000, 'f', -1, 64)
+}
+
+func writePurchaseOrderPDF(w http.ResponseWriter, po *model.PurchaseOrder, fileName string) {
+	const (
+		pageHeight   = 297.0
+		bottomMargin = 15.0
+		rowHeight    = 7.0
+	)
+	columns := []struct {
+		title string
+		width float64
+		align string
+	}{
+		{"No", 10, "R"},
+		{"JANコード", 30, "L"},
+		{"製品名", 70, "L"},
+		{"包装", 40, "L"},
+		{"数量", 20, "R"},
+		{"備考", 20, "L"},
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(10, 15, 10)
+	pdf.SetAutoPageBreak(false, bottomMargin)
+	pdf.AddUTF8Font("ipaexg", "", "SOU/ipaexg.ttf")
+	pdf.AddPage()
+
+	pdf.SetFont("ipaexg", "", 18)
+	pdf.CellFormat(0, 12, "発 注 書", "", 1, "C", false, 0, "")
+	pdf.SetFont("ipaexg", "", 12)
+	pdf.Cell(0, 8, wholesalerLabel(po)+" 御中")
+	pdf.Ln(10)
+	pdf.SetFont("ipaexg", "", 10)
+	pdf.Cell(0, 6, "発注書番号: "+po.PoNumber)
+	pdf.Ln(6)
+	pdf.Cell(0, 6, "発注日: "+formatDate(po.OrderDate))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, "下記の通り発注いたします。")
+	pdf.Ln(10)
+
+	drawHeader := func() {
+		pdf.SetFont("ipaexg", "", 9)
+		pdf.SetFillColor(240, 240, 240)
+		for _, c := range columns {
+			pdf.CellFormat(c.width, rowHeight, c.title, "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(rowHeight)
+	}
+	drawHeader()
+
+	// セル幅に収まらない文字列は末尾を切り詰める
+	fit := func(s string, width float64) string {
+		for s != "" && pdf.GetStringWidth(s) > width-2 {
+			runes := []rune(s)
+			s = string(runes[:len(runes)-1])
+		}
+		return s
+	}
+
+	for i, line := range po.Lines {
+		if pdf.GetY()+rowHeight > pageHeight-bottomMargin {
+			pdf.AddPage()
+			drawHeader()
+		}
+		values := []string{
+			strconv.Itoa(i + 1),
+			line.ProductCode,
+			line.ProductName,
+			line.PackageSpec,
+			formatQuantity(line.Quantity),
+			"",
+		}
+		for j, c := range columns {
+			pdf.CellFormat(c.width, rowHeight, fit(values[j], c.width), "1", 0, c.align, false, 0, "")
+		}
+		pdf.Ln(rowHeight)
+	}
+	pdf.Ln(2)
+	pdf.Cell(0, 6, fmt.Sprintf("合計 %d品目", len(po.Lines)))
+
+	var buffer bytes.Buffer
+	if err := pdf.Output(&buffer); err != nil {
+		http.Error(w, "PDFの生成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fileName))
+	w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	if _, err := buffer.WriteTo(w); err != nil {
+		http.Error(w, "PDFの送信に失敗しました: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writePurchaseOrderExcel(w http.ResponseWriter, po *model.PurchaseOrder, fileName string) {
+	f := excelize.NewFile()
+	headerStyle, _ := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+
+	sheet := "発注書"
+	index, _ := f.NewSheet(sheet)
+	f.SetActiveSheet(index)
+	f.DeleteSheet("Sheet1")
+
+	f.SetCellValue(sheet, "A1", "発注書番号")
+	f.SetCellValue(sheet, "B1", po.PoNumber)
+	f.SetCellValue(sheet, "A2", "発注日")
+	f.SetCellValue(sheet, "B2", formatDate(po.OrderDate))
+	f.SetCellValue(sheet, "A3", "卸")
+	f.SetCellValue(sheet, "B3", wholesalerLabel(po))
+
+	const headerRow = 5
+	headers := []string{"No", "JANコード", "製品名", "包装", "数量", "YJ単位数量", "YJ単位"}
+	for i, h := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, headerRow)
+		f.SetCellValue(sheet, cell, h)
+		f.SetCellStyle(sheet, cell, cell, headerStyle)
+	}
+	for i, line := range po.Lines {
+		values := []interface{}{i + 1, line.ProductCode, line.ProductName, line.PackageSpec, line.Quantity, line.YjQuantity, line.YjUnitName}
+		cell, _ := excelize.CoordinatesToCellName(1, headerRow+1+i)
+		f.SetSheetRow(sheet, cell, &values)
+	}
+	f.SetColWidth(sheet, "A", "A", 12)
+	f.SetColWidth(sheet, "B", "B", 16)
+	f.SetColWidth(sheet, "C", "C", 40)
+	f.SetColWidth(sheet, "D", "D", 24)
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	if err := f.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writePurchaseOrderCSV(w http.ResponseWriter, po *model.PurchaseOrder, fileName string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	csvWriter := csv.NewWriter(w)
+	csvWriter.UseCRLF = true
+	defer csvWriter.Flush()
+	for _, line := range po.Lines {
+		csvWriter.Write([]string{line.ProductCode, formatQuantity(line.Quantity)})
+	}
+}
@@ -3,63 +3,238 @@ package parsers
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 	"wasabi/model"
 
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
 )
 
+const (
+	// DiagnosticError はその行のデータが正しく取り込めないことを示します。
+	DiagnosticError = "ERROR"
+	// DiagnosticWarning は取込は可能だが確認が必要なことを示します。
+	DiagnosticWarning = "WARNING"
+)
+
+// datRecordLength はD/Sレコードの固定長（改行を除く）です。
+const datRecordLength = 128
+
+// DatDiagnostic はDATファイルの解析で見つかった問題1件分の情報です。
+type DatDiagnostic struct {
+	Line     int    `json:"line"`     // 1始まりの行番号
+	Field    string `json:"field"`    // 問題のあったフィールド名
+	Raw      string `json:"raw"`      // フィールドの生データ (16進表記)
+	Reason   string `json:"reason"`   // 問題の内容
+	Severity string `json:"severity"` // "ERROR" または "WARNING"
+}
+
+// HasDatErrors は診断結果にERRORが含まれるかを返します。
+func HasDatErrors(diags []DatDiagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == DiagnosticError {
+			return true
+		}
+	}
+	return false
+}
+
 // ParseDatは、固定長のDATファイルからレコードを抽出し、UnifiedInputRecordのスライスを返します。
 func ParseDat(r io.Reader) ([]model.UnifiedInputRecord, error) {
+	records, _, err := ParseDatStrict(r)
+	return records, err
+}
+
+// ParseDatStrict は ParseDat と同じレコードを返すと共に、各行の検証結果を診断情報として返します。
+// 数値・日付・JANチェックデジット・Shift-JIS品名・Sレコード（ヘッダー）を検証します。
+// 返されるレコードは ParseDat と同一で、問題のある行を除外するかどうかは呼び出し側が判断します。
+func ParseDatStrict(r io.Reader) ([]model.UnifiedInputRecord, []DatDiagnostic, error) {
 	scanner := bufio.NewScanner(r)
 	var records []model.UnifiedInputRecord
+	var diags []DatDiagnostic
 	var currentWholesale string
+	headerSeen := false
+	lineNo := 0
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
 		if len(line) == 0 {
 			continue
 		}
 
 		switch line[0:1] {
 		case "S":
+			headerSeen = true
+			diags = append(diags, validateDatHeader(lineNo, line)...)
 			if len(line) >= 13 {
 				currentWholesale = strings.TrimSpace(line[3:13])
 			}
 		case "D":
-			if len(line) < 121 {
-				line += strings.Repeat(" ", 121-len(line))
-			}
-
-			// 型変換をここで行う
-			productNameSJIS := line[38:78]
-			utf8Bytes, _, _ := transform.Bytes(japanese.ShiftJIS.NewDecoder(), []byte(productNameSJIS))
-
-			flag, _ := strconv.Atoi(strings.TrimSpace(line[3:4]))
-			datqty, _ := strconv.ParseFloat(strings.TrimSpace(line[78:83]), 64)
-			unitprice, _ := strconv.ParseFloat(strings.TrimSpace(line[83:92]), 64)
-			subtotal, _ := strconv.ParseFloat(strings.TrimSpace(line[92:101]), 64)
-
-			// UnifiedInputRecordを直接構築
-			rec := model.UnifiedInputRecord{
-				ClientCode:    currentWholesale,
-				Flag:          flag,
-				Date:          strings.TrimSpace(line[4:12]),
-				ReceiptNumber: strings.TrimSpace(line[12:22]),
-				LineNumber:    strings.TrimSpace(line[22:24]),
-				JanCode:       strings.TrimSpace(line[25:38]),
-				ProductName:   strings.TrimSpace(string(utf8Bytes)),
-				DatQuantity:   datqty,
-				UnitPrice:     unitprice,
-				Subtotal:      subtotal,
-				ExpiryDate:    strings.TrimSpace(line[109:115]), // 文字列として直接格納
-				LotNumber:     strings.TrimSpace(line[115:121]),
+			if !headerSeen {
+				diags = append(diags, newDatDiagnostic(lineNo, "record", line[0:1], "Sレコード(ヘッダー)より前にDレコードがあります", DiagnosticError))
 			}
+			rec, lineDiags := parseDatDetail(lineNo, line, currentWholesale)
+			diags = append(diags, lineDiags...)
 			records = append(records, rec)
+		case "E":
+			// 終端レコード。内容は使用しない
+		default:
+			diags = append(diags, newDatDiagnostic(lineNo, "record", line[0:1], "不明なレコード種別です", DiagnosticWarning))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return records, diags, err
+	}
+	if !headerSeen && len(records) > 0 {
+		diags = append(diags, newDatDiagnostic(0, "record", "", "Sレコード(ヘッダー)がありません", DiagnosticError))
+	}
+	return records, diags, nil
+}
+
+// parseDatDetail はDレコード1行を解析します。
+func parseDatDetail(lineNo int, line, wholesale string) (model.UnifiedInputRecord, []DatDiagnostic) {
+	var diags []DatDiagnostic
+	if len(line) < 121 {
+		diags = append(diags, newDatDiagnostic(lineNo, "record", line, fmt.Sprintf("行の長さが不足しています (%dバイト)", len(line)), DiagnosticWarning))
+		line += strings.Repeat(" ", 121-len(line))
+	}
+
+	productNameSJIS := line[38:78]
+	utf8Bytes, _, nameErr := transform.Bytes(japanese.ShiftJIS.NewDecoder(), []byte(productNameSJIS))
+	productName := strings.TrimSpace(string(utf8Bytes))
+	if nameErr != nil || strings.ContainsRune(productName, utf8.RuneError) {
+		diags = append(diags, newDatDiagnostic(lineNo, "productName", productNameSJIS, "品名がShift-JISとして正しくありません", DiagnosticError))
+	}
+	if productName == "" {
+		diags = append(diags, newDatDiagnostic(lineNo, "productName", productNameSJIS, "品名が空です", DiagnosticWarning))
+	}
+
+	flagRaw := line[3:4]
+	flag, err := strconv.Atoi(strings.TrimSpace(flagRaw))
+	if err != nil {
+		diags = append(diags, newDatDiagnostic(lineNo, "flag", flagRaw, "伝票区分が数値ではありません", DiagnosticError))
+	} else if flag != 1 && flag != 2 {
+		diags = append(diags, newDatDiagnostic(lineNo, "flag", flagRaw, "伝票区分が想定外の値です (1:納品, 2:返品)", DiagnosticWarning))
+	}
+
+	dateRaw := line[4:12]
+	date := strings.TrimSpace(dateRaw)
+	if _, err := time.Parse("20060102", date); err != nil {
+		diags = append(diags, newDatDiagnostic(lineNo, "date", dateRaw, "日付がYYYYMMDD形式の正しい日付ではありません", DiagnosticError))
+	}
+
+	receiptRaw := line[12:22]
+	if strings.TrimSpace(receiptRaw) == "" {
+		diags = append(diags, newDatDiagnostic(lineNo, "receiptNumber", receiptRaw, "伝票番号が空です", DiagnosticWarning))
+	}
+
+	janRaw := line[25:38]
+	jan := strings.TrimSpace(janRaw)
+	switch {
+	case jan == "" || jan == "0000000000000":
+		diags = append(diags, newDatDiagnostic(lineNo, "janCode", janRaw, "JANコードがありません (品名で仮マスターを作成します)", DiagnosticWarning))
+	case len(jan) != 13 || !isDigits(jan):
+		diags = append(diags, newDatDiagnostic(lineNo, "janCode", janRaw, "JANコードが13桁の数字ではありません", DiagnosticError))
+	case !IsValidGS1CheckDigit(jan):
+		diags = append(diags, newDatDiagnostic(lineNo, "janCode", janRaw, "JANコードのチェックデジットが一致しません", DiagnosticError))
+	}
+
+	datqty, qtyDiag := parseDatNumber(lineNo, "quantity", line[78:83])
+	unitprice, priceDiag := parseDatNumber(lineNo, "unitPrice", line[83:92])
+	subtotal, subtotalDiag := parseDatNumber(lineNo, "subtotal", line[92:101])
+	for _, d := range []*DatDiagnostic{qtyDiag, priceDiag, subtotalDiag} {
+		if d != nil {
+			diags = append(diags, *d)
 		}
 	}
-	return records, scanner.Err()
-}
\ No newline at end of file
+
+	expiryRaw := line[109:115]
+	expiry := strings.TrimSpace(expiryRaw)
+	if expiry != "" && !isValidDatExpiry(expiry) {
+		diags = append(diags, newDatDiagnostic(lineNo, "expiryDate", expiryRaw, "有効期限がYYMM, YYMMDD, YYYYMMのいずれの形式でもありません", DiagnosticWarning))
+	}
+
+	rec := model.UnifiedInputRecord{
+		ClientCode:    wholesale,
+		Flag:          flag,
+		Date:          date,
+		ReceiptNumber: strings.TrimSpace(receiptRaw),
+		LineNumber:    strings.TrimSpace(line[22:24]),
+		JanCode:       jan,
+		ProductName:   productName,
+		DatQuantity:   datqty,
+		UnitPrice:     unitprice,
+		Subtotal:      subtotal,
+		ExpiryDate:    expiry, // 文字列として直接格納
+		LotNumber:     strings.TrimSpace(line[115:121]),
+	}
+	return rec, diags
+}
+
+// validateDatHeader はSレコード（ヘッダー）を検証します。
+// 卸コード(4-13桁目)と、データ作成日時(28-39桁目, YYMMDDhhmmss)を確認します。
+func validateDatHeader(lineNo int, line string) []DatDiagnostic {
+	var diags []DatDiagnostic
+	if len(line) < 39 {
+		return append(diags, newDatDiagnostic(lineNo, "header", line, fmt.Sprintf("Sレコードの長さが不足しています (%dバイト)", len(line)), DiagnosticError))
+	}
+	if len(line) != datRecordLength {
+		diags = append(diags, newDatDiagnostic(lineNo, "header", line, fmt.Sprintf("Sレコードの長さが%dバイトではありません (%dバイト)", datRecordLength, len(line)), DiagnosticWarning))
+	}
+	if strings.TrimSpace(line[3:13]) == "" {
+		diags = append(diags, newDatDiagnostic(lineNo, "wholesaler", line[3:13], "卸コードが空です", DiagnosticError))
+	}
+	if _, err := time.Parse("060102150405", line[27:39]); err != nil {
+		diags = append(diags, newDatDiagnostic(lineNo, "timestamp", line[27:39], "データ作成日時がYYMMDDhhmmss形式ではありません", DiagnosticError))
+	}
+	return diags
+}
+
+// parseDatNumber は固定長の数値フィールドを解析します。空欄は0として扱います。
+func parseDatNumber(lineNo int, field, raw string) (float64, *DatDiagnostic) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		d := newDatDiagnostic(lineNo, field, raw, "数値として解釈できません", DiagnosticError)
+		return 0, &d
+	}
+	return v, nil
+}
+
+// isValidDatExpiry は有効期限が YYMM, YYMMDD, YYYYMM のいずれかの形式かを判定します。
+func isValidDatExpiry(s string) bool {
+	if !isDigits(s) {
+		return false
+	}
+	switch len(s) {
+	case 4:
+		_, err := time.Parse("0601", s)
+		return err == nil
+	case 6:
+		if _, err := time.Parse("060102", s); err == nil {
+			return true
+		}
+		_, err := time.Parse("200601", s)
+		return err == nil
+	}
+	return false
+}
+
+func newDatDiagnostic(lineNo int, field, raw, reason, severity string) DatDiagnostic {
+	return DatDiagnostic{
+		Line:     lineNo,
+		Field:    field,
+		Raw:      fmt.Sprintf("% X", raw),
+		Reason:   reason,
+		Severity: severity,
+	}
+}
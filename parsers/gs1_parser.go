@@ -0,0 +1,244 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\parsers\gs1_parser.go
+package parsers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gs1GroupSeparator はFNC1（可変長AIの区切り）として送出されるGS文字です。
+const gs1GroupSeparator = "\x1d"
+
+// GS1Data はGS1-128 / GS1 DataMatrix のバーコードから読み取った内容を保持します。
+type GS1Data struct {
+	Gtin         string            `json:"gtin"`         // AI(01) 調剤包装単位コード (14桁)
+	ExpiryDate   string            `json:"expiryDate"`   // AI(17) 有効期限 (YYYYMM形式)
+	ExpiryRaw    string            `json:"expiryRaw"`    // AI(17) の元データ (YYMMDD)
+	LotNumber    string            `json:"lotNumber"`    // AI(10) 製造番号・ロット番号
+	SerialNumber string            `json:"serialNumber"` // AI(21) シリアル番号
+	Quantity     string            `json:"quantity"`     // AI(30) 数量
+	Elements     map[string]string `json:"elements"`     // 読み取った全てのAIと値
+}
+
+// gs1FixedLengths は、AIの先頭2桁ごとに定められたデータ部の固定長です (GS1総合仕様書の事前定義長)。
+// ここに含まれないAIは可変長として扱い、GS文字または末尾までを値とします。
+var gs1FixedLengths = map[string]int{
+	"00": 18, "01": 14, "02": 14, "03": 14, "04": 16,
+	"11": 6, "12": 6, "13": 6, "14": 6, "15": 6, "16": 6, "17": 6, "18": 6, "19": 6,
+	"20": 2,
+	"31": 6, "32": 6, "33": 6, "34": 6, "35": 6, "36": 6,
+	"41": 13,
+}
+
+// ParseGS1 は、GS1アプリケーション識別子(AI)形式のバーコード文字列を解析します。
+// 以下の入力形式に対応します。
+//   - FNC1をGS文字(0x1D)で区切った生データ (スキャナーからの入力)
+//   - シンボル識別子付きのデータ ("]d2", "]C1" など)
+//   - 括弧付きの目視可能文字 ("(01)04987...(17)270331(10)ABC123")
+func ParseGS1(code string) (*GS1Data, error) {
+	s := strings.TrimSpace(code)
+	s = strings.ReplaceAll(s, "<GS>", gs1GroupSeparator)
+	if len(s) >= 3 && s[0] == ']' {
+		s = s[3:]
+	}
+	s = strings.TrimPrefix(s, gs1GroupSeparator)
+	if s == "" {
+		return nil, fmt.Errorf("empty GS1 code")
+	}
+
+	var elements map[string]string
+	var order []string
+	var err error
+	if strings.HasPrefix(s, "(") {
+		elements, order, err = parseGS1Parenthesized(s)
+	} else {
+		elements, order, err = parseGS1Raw(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no application identifiers found in GS1 code")
+	}
+
+	data := &GS1Data{Elements: elements}
+	data.Gtin = elements["01"]
+	data.LotNumber = elements["10"]
+	data.SerialNumber = elements["21"]
+	data.Quantity = elements["30"]
+
+	if data.Gtin != "" && !IsValidGS1CheckDigit(data.Gtin) {
+		return nil, fmt.Errorf("invalid GTIN check digit: %s", data.Gtin)
+	}
+
+	if raw, ok := elements["17"]; ok {
+		expiry, err := gs1DateToYearMonth(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry date (AI 17): %w", err)
+		}
+		data.ExpiryRaw = raw
+		data.ExpiryDate = expiry
+	}
+
+	return data, nil
+}
+
+// parseGS1Raw はGS文字区切りの生データを先頭から順にAIごとに分解します。
+func parseGS1Raw(s string) (map[string]string, []string, error) {
+	elements := make(map[string]string)
+	var order []string
+
+	pos := 0
+	for pos < len(s) {
+		if strings.HasPrefix(s[pos:], gs1GroupSeparator) {
+			pos++
+			continue
+		}
+
+		aiLen := gs1AILength(s[pos:])
+		if aiLen == 0 || pos+aiLen > len(s) {
+			return nil, nil, fmt.Errorf("unknown application identifier at position %d: %q", pos, s[pos:])
+		}
+		ai := s[pos : pos+aiLen]
+		pos += aiLen
+
+		var value string
+		if fixed, ok := gs1FixedLengths[ai[:2]]; ok {
+			if pos+fixed > len(s) {
+				return nil, nil, fmt.Errorf("AI %s requires %d characters but only %d remain", ai, fixed, len(s)-pos)
+			}
+			value = s[pos : pos+fixed]
+			pos += fixed
+		} else {
+			end := strings.Index(s[pos:], gs1GroupSeparator)
+			if end == -1 {
+				value = s[pos:]
+				pos = len(s)
+			} else {
+				value = s[pos : pos+end]
+				pos += end + 1
+			}
+		}
+
+		if _, exists := elements[ai]; !exists {
+			order = append(order, ai)
+		}
+		elements[ai] = value
+	}
+	return elements, order, nil
+}
+
+// parseGS1Parenthesized は "(AI)値" 形式の目視可能文字を分解します。
+func parseGS1Parenthesized(s string) (map[string]string, []string, error) {
+	elements := make(map[string]string)
+	var order []string
+
+	rest := s
+	for rest != "" {
+		if rest[0] != '(' {
+			return nil, nil, fmt.Errorf("malformed GS1 text near %q", rest)
+		}
+		closeIdx := strings.Index(rest, ")")
+		if closeIdx == -1 {
+			return nil, nil, fmt.Errorf("unterminated application identifier near %q", rest)
+		}
+		ai := rest[1:closeIdx]
+		if _, err := strconv.Atoi(ai); err != nil || len(ai) < 2 || len(ai) > 4 {
+			return nil, nil, fmt.Errorf("invalid application identifier %q", ai)
+		}
+		rest = rest[closeIdx+1:]
+
+		next := strings.Index(rest, "(")
+		var value string
+		if next == -1 {
+			value = rest
+			rest = ""
+		} else {
+			value = rest[:next]
+			rest = rest[next:]
+		}
+		value = strings.TrimSuffix(value, gs1GroupSeparator)
+
+		if fixed, ok := gs1FixedLengths[ai[:2]]; ok && len(value) != fixed {
+			return nil, nil, fmt.Errorf("AI %s requires %d characters, got %d", ai, fixed, len(value))
+		}
+		if _, exists := elements[ai]; !exists {
+			order = append(order, ai)
+		}
+		elements[ai] = value
+	}
+	return elements, order, nil
+}
+
+// gs1AILength は、文字列の先頭にあるAIの桁数を返します。判定できない場合は0を返します。
+func gs1AILength(s string) int {
+	if len(s) < 2 || !isDigits(s[:2]) {
+		return 0
+	}
+	prefix := s[:2]
+	switch {
+	case prefix >= "00" && prefix <= "22":
+		return 2
+	case prefix == "30" || prefix == "37" || (prefix >= "90" && prefix <= "99"):
+		return 2
+	case prefix >= "23" && prefix <= "25", prefix >= "40" && prefix <= "42":
+		if len(s) >= 3 && isDigits(s[:3]) {
+			return 3
+		}
+	case prefix >= "31" && prefix <= "36", prefix == "39", prefix == "70", prefix >= "80" && prefix <= "82":
+		if len(s) >= 4 && isDigits(s[:4]) {
+			return 4
+		}
+	case prefix == "71":
+		if len(s) >= 3 && isDigits(s[:3]) {
+			return 3
+		}
+	}
+	return 0
+}
+
+// gs1DateToYearMonth はGS1の日付(YYMMDD)をアプリ内の期限表記(YYYYMM)に変換します。
+// 日が "00" の場合は月末を意味しますが、月単位で扱うため日付部分は使用しません。
+func gs1DateToYearMonth(raw string) (string, error) {
+	if len(raw) != 6 || !isDigits(raw) {
+		return "", fmt.Errorf("expected YYMMDD, got %q", raw)
+	}
+	month, _ := strconv.Atoi(raw[2:4])
+	day, _ := strconv.Atoi(raw[4:6])
+	if month < 1 || month > 12 || day > 31 {
+		return "", fmt.Errorf("out of range date %q", raw)
+	}
+	return "20" + raw[0:4], nil
+}
+
+// IsValidGS1CheckDigit は、JAN(GTIN-13)やGTIN-14などのGS1コードのチェックデジットを検証します。
+func IsValidGS1CheckDigit(code string) bool {
+	if len(code) < 2 || !isDigits(code) {
+		return false
+	}
+	sum := 0
+	body := code[:len(code)-1]
+	for i := len(body) - 1; i >= 0; i-- {
+		n := int(body[i] - '0')
+		// チェックデジットの直前の桁から数えて奇数番目に3を掛ける
+		if (len(body)-1-i)%2 == 0 {
+			n *= 3
+		}
+		sum += n
+	}
+	check := (10 - sum%10) % 10
+	return check == int(code[len(code)-1]-'0')
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
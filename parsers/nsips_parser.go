@@ -0,0 +1,236 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wasabi/model"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// NsipsFormatName はUSAGE取込で形式名として指定した場合にNSIPS処方データとして扱う名前です。
+const NsipsFormatName = "nsips"
+
+// DefaultNsipsSpecVersion は標準のNSIPSレイアウトの版名です。
+// 標準のレイアウトは、日本薬剤師会のNSIPS仕様書の特定の版をそのまま実装したものではなく、
+// 1列目をレコード識別子(1:患者, 2:処方箋, 3:RP, 4:薬品)とする簡易形式です。
+// レセコンの出力がこれと異なる場合は、そのレセコンのNSIPS出力仕様書の版に合わせたレイアウトを設定(nsipsLayout)に登録します。
+const DefaultNsipsSpecVersion = "WASABI簡易形式 1.0"
+
+// DefaultNsipsLayout は標準のNSIPSレイアウト (DefaultNsipsSpecVersion) を返します。
+func DefaultNsipsLayout() model.NsipsLayout {
+	return model.NsipsLayout{
+		SpecVersion:        DefaultNsipsSpecVersion,
+		RecordTypeColumn:   1,
+		PatientRecord:      "1",
+		PrescriptionRecord: "2",
+		RpRecord:           "3",
+		DrugRecord:         "4",
+		PatientNumber:      2,
+		PrescriptionNumber: 2,
+		DispenseDate:       3,
+		RpNumber:           2,
+		RpDosage:           3, // 1:内服, 2:頓服, 3:外用, ...
+		RpQuantity:         4,
+		DrugRpNumber:       2,
+		DrugSeq:            3,
+		DrugCode:           4,
+		DrugName:           5,
+		DrugDose:           6,
+		DrugUnit:           7,
+		MultipliedDosages:  []string{"1", "2"}, // 内服, 頓服
+		BuiltIn:            true,
+	}
+}
+
+// ResolveNsipsLayout は設定に登録されたレイアウトを返します。未登録の場合は標準のレイアウトを返します。
+func ResolveNsipsLayout(custom *model.NsipsLayout) model.NsipsLayout {
+	if custom == nil {
+		return DefaultNsipsLayout()
+	}
+	layout := *custom
+	layout.BuiltIn = false
+	return layout
+}
+
+// ValidateNsipsLayout は設定に保存するNSIPSレイアウトを検証します。
+func ValidateNsipsLayout(layout model.NsipsLayout) error {
+	records := map[string]string{
+		"患者情報": layout.PatientRecord, "処方箋情報": layout.PrescriptionRecord,
+		"RP情報": layout.RpRecord, "薬品情報": layout.DrugRecord,
+	}
+	seen := make(map[string]string)
+	for name, id := range records {
+		if strings.TrimSpace(id) == "" {
+			return fmt.Errorf("%sのレコード識別子がありません", name)
+		}
+		if other, dup := seen[id]; dup {
+			return fmt.Errorf("%sと%sのレコード識別子 %q が重複しています", other, name, id)
+		}
+		seen[id] = name
+	}
+	columns := map[string]int{
+		"レコード識別子": layout.RecordTypeColumn, "患者番号": layout.PatientNumber,
+		"処方箋番号": layout.PrescriptionNumber, "調剤年月日": layout.DispenseDate,
+		"RP番号(RP情報)": layout.RpNumber, "剤型区分": layout.RpDosage, "調剤数量": layout.RpQuantity,
+		"RP番号(薬品情報)": layout.DrugRpNumber, "薬品連番": layout.DrugSeq, "薬品コード": layout.DrugCode,
+		"薬品名": layout.DrugName, "用量": layout.DrugDose, "単位名": layout.DrugUnit,
+	}
+	for name, col := range columns {
+		if col < 1 {
+			return fmt.Errorf("%sの列番号は1以上で指定してください", name)
+		}
+	}
+	if layout.DateFormat != "" {
+		if !strings.Contains(layout.DateFormat, "YY") || !strings.Contains(layout.DateFormat, "M") || !strings.Contains(layout.DateFormat, "D") {
+			return fmt.Errorf("日付書式 %q には年(YYYY/YY)・月(MM)・日(DD)が必要です", layout.DateFormat)
+		}
+	}
+	return nil
+}
+
+// nsipsRp は解析中のRP(剤)情報です。
+type nsipsRp struct {
+	dosage   string
+	quantity float64
+}
+
+// IsNsips はファイルの内容がレイアウトに沿ったNSIPS処方データかを判定します。
+// 全ての行のレコード識別子の列が識別子のいずれかで、処方箋情報と薬品情報のレコードを含む場合にNSIPSとみなします。
+func IsNsips(content []byte, layout model.NsipsLayout) bool {
+	reader := newNsipsCSVReader(content)
+	hasPrescription, hasDrug := false, false
+	for i := 0; i < 50; i++ {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(rec) == 0 {
+			return false
+		}
+		switch nsipsField(rec, layout.RecordTypeColumn) {
+		case layout.PatientRecord:
+		case layout.PrescriptionRecord:
+			hasPrescription = true
+		case layout.RpRecord:
+		case layout.DrugRecord:
+			hasDrug = true
+		default:
+			return false
+		}
+	}
+	return hasPrescription && hasDrug
+}
+
+// ParseNsips はNSIPS処方データをレイアウトに従って解析し、薬品1行ごとに処方(flag=3)のUnifiedInputRecordを返します。
+// 患者番号は ClientCode、処方箋番号は ReceiptNumber、「RP番号-薬品連番」は LineNumber に格納します。
+// 薬品コードは13桁ならJANコード、それ以外はYJコードとして格納し、マスターの特定は呼び出し側で行います。
+func ParseNsips(r io.Reader, layout model.NsipsLayout) ([]model.UnifiedInputRecord, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("nsips read error: %w", err)
+	}
+	reader := newNsipsCSVReader(content)
+	dateFormat := model.UsageFormat{DateFormat: layout.DateFormat}
+	multiplied := make(map[string]bool, len(layout.MultipliedDosages))
+	for _, d := range layout.MultipliedDosages {
+		multiplied[d] = true
+	}
+
+	var records []model.UnifiedInputRecord
+	var patientNumber, prescriptionNumber, dispenseDate string
+	rps := make(map[string]nsipsRp)
+	lineNo := 0
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv read error: %w", err)
+		}
+		lineNo++
+		if len(rec) == 0 {
+			continue
+		}
+
+		switch nsipsField(rec, layout.RecordTypeColumn) {
+		case layout.PatientRecord:
+			patientNumber = nsipsField(rec, layout.PatientNumber)
+		case layout.PrescriptionRecord:
+			prescriptionNumber = nsipsField(rec, layout.PrescriptionNumber)
+			dispenseDate, err = normalizeUsageDate(dateFormat, nsipsField(rec, layout.DispenseDate))
+			if err != nil {
+				return nil, fmt.Errorf("%d行目: 調剤年月日: %w", lineNo, err)
+			}
+			if _, err := time.Parse("20060102", dispenseDate); err != nil {
+				return nil, fmt.Errorf("%d行目: 調剤年月日 %q がYYYYMMDD形式ではありません", lineNo, dispenseDate)
+			}
+			// 処方箋が変わればRP番号も振り直される
+			rps = make(map[string]nsipsRp)
+		case layout.RpRecord:
+			qty, _ := strconv.ParseFloat(nsipsField(rec, layout.RpQuantity), 64)
+			rps[nsipsField(rec, layout.RpNumber)] = nsipsRp{dosage: nsipsField(rec, layout.RpDosage), quantity: qty}
+		case layout.DrugRecord:
+			if dispenseDate == "" {
+				return nil, fmt.Errorf("%d行目: 処方箋情報より前に薬品情報があります", lineNo)
+			}
+			rpNumber := nsipsField(rec, layout.DrugRpNumber)
+			dose, err := strconv.ParseFloat(nsipsField(rec, layout.DrugDose), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%d行目: 用量 %q が数値ではありません", lineNo, nsipsField(rec, layout.DrugDose))
+			}
+			quantity := dose
+			if rp, ok := rps[rpNumber]; ok && multiplied[rp.dosage] && rp.quantity > 0 {
+				quantity = dose * rp.quantity
+			}
+
+			unified := model.UnifiedInputRecord{
+				Date:          dispenseDate,
+				ProductName:   nsipsField(rec, layout.DrugName),
+				YjQuantity:    quantity,
+				YjUnitName:    nsipsField(rec, layout.DrugUnit),
+				ClientCode:    patientNumber,
+				ReceiptNumber: prescriptionNumber,
+				LineNumber:    fmt.Sprintf("%s-%s", rpNumber, nsipsField(rec, layout.DrugSeq)),
+				Flag:          3,
+			}
+			code := nsipsField(rec, layout.DrugCode)
+			if len(code) == 13 && isDigits(code) {
+				unified.JanCode = code
+			} else {
+				unified.YjCode = code
+			}
+			records = append(records, unified)
+		}
+	}
+	return records, nil
+}
+
+// newNsipsCSVReader はUTF-8として正しい内容はUTF-8、それ以外はShift-JISとして読み込むCSVリーダーを作成します。
+func newNsipsCSVReader(content []byte) *csv.Reader {
+	var r io.Reader
+	if utf8.Valid(content) {
+		r = bytes.NewReader(bytes.TrimPrefix(content, utf8BOM))
+	} else {
+		r = transform.NewReader(bytes.NewReader(content), japanese.ShiftJIS.NewDecoder())
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// nsipsField は1始まりの列番号で項目を取り出します。
+func nsipsField(rec []string, col int) string {
+	if col < 1 || col > len(rec) {
+		return ""
+	}
+	return strings.TrimSpace(rec[col-1])
+}
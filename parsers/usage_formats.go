@@ -0,0 +1,261 @@
+package parsers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wasabi/model"
+)
+
+// DefaultUsageFormatName は標準のUSAGE形式の名前です。設定で同じ名前の形式は登録できません。
+const DefaultUsageFormatName = "default"
+
+// usageSniffRows は形式の自動判別で確認するデータ行数です。
+const usageSniffRows = 5
+
+// usageSniffMaxLines は形式の自動判別で読む最大行数です。列数の足りない行ばかりの場合に全体を読まないための上限です。
+const usageSniffMaxLines = 50
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// DefaultUsageFormat は従来から対応している標準形式
+// （Shift-JIS・ヘッダーなし・日付,YJ,JAN,品名,数量,単位の6列）を返します。
+func DefaultUsageFormat() model.UsageFormat {
+	return model.UsageFormat{
+		Name:     DefaultUsageFormatName,
+		Encoding: "shift_jis",
+		Columns: model.UsageColumnMap{
+			Date:        model.UsageColumn{Index: 1},
+			YjCode:      model.UsageColumn{Index: 2},
+			JanCode:     model.UsageColumn{Index: 3},
+			ProductName: model.UsageColumn{Index: 4},
+			Quantity:    model.UsageColumn{Index: 5},
+			UnitName:    model.UsageColumn{Index: 6},
+		},
+		BuiltIn: true,
+	}
+}
+
+// UsageFormats は設定で登録された形式の後ろに標準形式を加えた一覧を返します。
+// 自動判別で同じ評価の形式が複数ある場合は、この順序で先にあるものが選ばれます。
+func UsageFormats(custom []model.UsageFormat) []model.UsageFormat {
+	formats := make([]model.UsageFormat, 0, len(custom)+1)
+	for _, f := range custom {
+		if f.Name == DefaultUsageFormatName {
+			continue
+		}
+		f.BuiltIn = false
+		formats = append(formats, f)
+	}
+	return append(formats, DefaultUsageFormat())
+}
+
+// FindUsageFormat は名前で形式を検索します。
+func FindUsageFormat(name string, custom []model.UsageFormat) (model.UsageFormat, bool) {
+	for _, f := range UsageFormats(custom) {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return model.UsageFormat{}, false
+}
+
+// ValidateUsageFormats は設定に保存する形式定義の一覧を検証します。
+func ValidateUsageFormats(formats []model.UsageFormat) error {
+	names := make(map[string]struct{})
+	for i, f := range formats {
+		if strings.TrimSpace(f.Name) == "" {
+			return fmt.Errorf("%d番目の形式に名前がありません", i+1)
+		}
+		if f.Name == DefaultUsageFormatName || f.Name == NsipsFormatName {
+			return fmt.Errorf("形式名 %q は組み込みの形式のため使用できません", f.Name)
+		}
+		if _, dup := names[f.Name]; dup {
+			return fmt.Errorf("形式名 %q が重複しています", f.Name)
+		}
+		names[f.Name] = struct{}{}
+
+		if !isUTF8Encoding(f.Encoding) && !isShiftJISEncoding(f.Encoding) {
+			return fmt.Errorf("形式 %s: 文字コード %q には対応していません (shift_jis または utf-8)", f.Name, f.Encoding)
+		}
+		if f.SkipRows < 0 {
+			return fmt.Errorf("形式 %s: 読み飛ばす行数が負の値です", f.Name)
+		}
+		if f.DateFormat != "" {
+			if !strings.Contains(f.DateFormat, "YY") || !strings.Contains(f.DateFormat, "M") || !strings.Contains(f.DateFormat, "D") {
+				return fmt.Errorf("形式 %s: 日付書式 %q には年(YYYY/YY)・月(MM)・日(DD)が必要です", f.Name, f.DateFormat)
+			}
+		}
+		for _, col := range []model.UsageColumn{f.Columns.Date, f.Columns.YjCode, f.Columns.JanCode, f.Columns.ProductName, f.Columns.Quantity, f.Columns.UnitName} {
+			if col.Index < 0 {
+				return fmt.Errorf("形式 %s: 列番号は1以上で指定してください", f.Name)
+			}
+			if col.Header != "" && !f.HasHeader && col.Index == 0 {
+				return fmt.Errorf("形式 %s: ヘッダー行の無い形式では列番号で指定してください", f.Name)
+			}
+		}
+		// 列の指定が揃っているかはヘッダー行が無くても判定できる
+		if _, err := resolveUsageColumns(f, headerNamesOf(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DetectUsageFormat はファイル先頭を読み、登録された形式の中から最も合致するものを選びます。
+// ヘッダー名が全て一致する形式を優先し、次に先頭のデータ行の過半数が日付・数量として解釈できる形式を選びます。
+func DetectUsageFormat(content []byte, custom []model.UsageFormat) (model.UsageFormat, error) {
+	var best model.UsageFormat
+	bestScore := 0
+	for _, f := range UsageFormats(custom) {
+		if score := sniffUsageFormat(content, f); score > bestScore {
+			best, bestScore = f, score
+		}
+	}
+	if bestScore == 0 {
+		return model.UsageFormat{}, fmt.Errorf("USAGEファイルの形式を判別できませんでした。設定のUSAGE形式を確認してください")
+	}
+	return best, nil
+}
+
+// sniffUsageFormat はファイルが形式に合致する度合いを返します。
+// 0: 合致しない, 1: データ行の過半数が解釈できる, 2: ヘッダー名も一致する
+// 列数の足りない行は ParseUsage と同じく読み飛ばし、合計行などの一部の行が解釈できなくても判別できるようにします。
+func sniffUsageFormat(content []byte, format model.UsageFormat) int {
+	if !usageEncodingMatches(content, format.Encoding) {
+		return 0
+	}
+	reader := newUsageCSVReader(content, format)
+
+	rowNo := 0
+	var header []string
+	var cols usageColumnIndexes
+	if !format.HasHeader {
+		var err error
+		if cols, err = resolveUsageColumns(format, nil); err != nil {
+			return 0
+		}
+	}
+	valid, invalid := 0, 0
+	for valid+invalid < usageSniffRows && rowNo < usageSniffMaxLines {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0
+		}
+		rowNo++
+		if rowNo <= format.SkipRows {
+			continue
+		}
+		if format.HasHeader && header == nil {
+			header = rec
+			if cols, err = resolveUsageColumns(format, header); err != nil {
+				return 0
+			}
+			continue
+		}
+		if shouldSkipUsageRow(format, rec) || len(rec) <= cols.maxRequired {
+			continue
+		}
+		if usageRowLooksValid(format, cols, rec) {
+			valid++
+		} else {
+			invalid++
+		}
+	}
+
+	if format.HasHeader {
+		if header == nil {
+			return 0
+		}
+		if names := headerNamesOf(format); len(names) > 0 && containsAllHeaders(header, names) {
+			return 2
+		}
+	}
+	if valid == 0 || valid <= invalid {
+		return 0
+	}
+	return 1
+}
+
+// usageRowLooksValid はデータ行の日付と数量が形式どおりに解釈できるかを判定します。
+func usageRowLooksValid(format model.UsageFormat, cols usageColumnIndexes, rec []string) bool {
+	dateRaw := cols.cell(rec, cols.date)
+	if format.DateFormat == "" {
+		if _, err := time.Parse("20060102", dateRaw); err != nil {
+			return false
+		}
+	} else if _, err := normalizeUsageDate(format, dateRaw); err != nil {
+		return false
+	}
+	_, err := strconv.ParseFloat(strings.ReplaceAll(cols.cell(rec, cols.quantity), ",", ""), 64)
+	return err == nil
+}
+
+// usageEncodingMatches はファイルの内容が形式の文字コードと矛盾しないかを判定します。
+// 日本語を含むShift-JISのファイルがUTF-8として正しいことはまず無いため、UTF-8として正しく
+// 非ASCII文字を含むファイルはShift-JIS形式の候補から外します。
+func usageEncodingMatches(content []byte, encoding string) bool {
+	validUTF8 := utf8.Valid(content)
+	if isUTF8Encoding(encoding) {
+		return validUTF8
+	}
+	if !validUTF8 {
+		return true
+	}
+	for _, b := range content {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return !bytes.HasPrefix(content, utf8BOM)
+}
+
+// headerNamesOf は形式定義で指定されたヘッダー名から、判定用の仮のヘッダー行を作ります。
+func headerNamesOf(format model.UsageFormat) []string {
+	if !format.HasHeader {
+		return nil
+	}
+	var names []string
+	for _, col := range []model.UsageColumn{format.Columns.Date, format.Columns.YjCode, format.Columns.JanCode, format.Columns.ProductName, format.Columns.Quantity, format.Columns.UnitName} {
+		if col.Header != "" {
+			names = append(names, col.Header)
+		}
+	}
+	return names
+}
+
+func containsAllHeaders(header, names []string) bool {
+	present := make(map[string]struct{}, len(header))
+	for _, h := range header {
+		present[normalizeUsageHeader(h)] = struct{}{}
+	}
+	for _, name := range names {
+		if _, ok := present[normalizeUsageHeader(name)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func isUTF8Encoding(encoding string) bool {
+	switch strings.ToLower(strings.ReplaceAll(encoding, "-", "")) {
+	case "utf8", "utf8bom":
+		return true
+	}
+	return false
+}
+
+func isShiftJISEncoding(encoding string) bool {
+	switch strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(encoding, "-", ""), "_", "")) {
+	case "", "shiftjis", "sjis", "cp932", "windows31j":
+		return true
+	}
+	return false
+}
@@ -1,10 +1,13 @@
 package parsers
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"time"
 	"wasabi/model"
 
 	"golang.org/x/text/encoding/japanese"
@@ -12,10 +15,23 @@ import (
 )
 
 // ParseUsageはUSAGE CSVを解析し、UnifiedInputRecordのスライスを返します。
+// 標準形式（Shift-JIS・ヘッダーなし・日付,YJ,JAN,品名,数量,単位の6列）として解析します。
 func ParseUsage(r io.Reader) ([]model.UnifiedInputRecord, error) {
-	reader := csv.NewReader(transform.NewReader(r, japanese.ShiftJIS.NewDecoder()))
-	reader.FieldsPerRecord = -1
+	return ParseUsageWithFormat(r, DefaultUsageFormat())
+}
+
+// ParseUsageWithFormat は指定された形式定義に従ってUSAGEファイルを解析します。
+func ParseUsageWithFormat(r io.Reader, format model.UsageFormat) ([]model.UnifiedInputRecord, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("usage read error: %w", err)
+	}
+	reader := newUsageCSVReader(content, format)
 
+	rowNo := 0
+	var header []string
+	var cols usageColumnIndexes
+	columnsResolved := false
 	var records []model.UnifiedInputRecord
 	for {
 		rec, err := reader.Read()
@@ -25,22 +41,156 @@ func ParseUsage(r io.Reader) ([]model.UnifiedInputRecord, error) {
 		if err != nil {
 			return nil, fmt.Errorf("csv read error: %w", err)
 		}
-
-		if len(rec) < 6 {
+		rowNo++
+		if rowNo <= format.SkipRows {
+			continue
+		}
+		if format.HasHeader && header == nil {
+			header = rec
+			continue
+		}
+		if !columnsResolved {
+			cols, err = resolveUsageColumns(format, header)
+			if err != nil {
+				return nil, err
+			}
+			columnsResolved = true
+		}
+		if shouldSkipUsageRow(format, rec) || len(rec) <= cols.maxRequired {
 			continue // skip incomplete rows
 		}
 
-		yjQty, _ := strconv.ParseFloat(rec[4], 64)
+		date, err := normalizeUsageDate(format, cols.cell(rec, cols.date))
+		if err != nil {
+			return nil, fmt.Errorf("%d行目: %w", rowNo, err)
+		}
+		yjQty, _ := strconv.ParseFloat(strings.ReplaceAll(cols.cell(rec, cols.quantity), ",", ""), 64)
 
 		unifiedRec := model.UnifiedInputRecord{
-			Date:        rec[0],
-			YjCode:      rec[1],
-			JanCode:     rec[2],
-			ProductName: rec[3],
+			Date:        date,
+			YjCode:      cols.cell(rec, cols.yjCode),
+			JanCode:     cols.cell(rec, cols.janCode),
+			ProductName: cols.cell(rec, cols.productName),
 			YjQuantity:  yjQty,
-			YjUnitName:  rec[5],
+			YjUnitName:  cols.cell(rec, cols.unitName),
 		}
 		records = append(records, unifiedRec)
 	}
 	return records, nil
-}
\ No newline at end of file
+}
+
+// usageColumnIndexes は各項目の0始まりの列位置です。-1 は列が無いことを示します。
+type usageColumnIndexes struct {
+	date, yjCode, janCode, productName, quantity, unitName int
+	maxRequired                                            int
+}
+
+func (c usageColumnIndexes) cell(rec []string, idx int) string {
+	if idx < 0 || idx >= len(rec) {
+		return ""
+	}
+	return strings.TrimSpace(rec[idx])
+}
+
+// resolveUsageColumns は形式定義とヘッダー行から各項目の列位置を求めます。
+func resolveUsageColumns(format model.UsageFormat, header []string) (usageColumnIndexes, error) {
+	var cols usageColumnIndexes
+	var missing []string
+	resolve := func(label string, col model.UsageColumn) int {
+		if format.HasHeader && col.Header != "" {
+			for i, h := range header {
+				if normalizeUsageHeader(h) == normalizeUsageHeader(col.Header) {
+					return i
+				}
+			}
+			if col.Index <= 0 {
+				missing = append(missing, fmt.Sprintf("%s(%s)", label, col.Header))
+				return -1
+			}
+		}
+		return col.Index - 1
+	}
+	cols.date = resolve("日付", format.Columns.Date)
+	cols.yjCode = resolve("YJコード", format.Columns.YjCode)
+	cols.janCode = resolve("JANコード", format.Columns.JanCode)
+	cols.productName = resolve("品名", format.Columns.ProductName)
+	cols.quantity = resolve("数量", format.Columns.Quantity)
+	cols.unitName = resolve("単位", format.Columns.UnitName)
+	if len(missing) > 0 {
+		return cols, fmt.Errorf("USAGE形式 %s: ヘッダーに列が見つかりません: %s", format.Name, strings.Join(missing, ", "))
+	}
+	if cols.date < 0 || cols.quantity < 0 || (cols.yjCode < 0 && cols.janCode < 0 && cols.productName < 0) {
+		return cols, fmt.Errorf("USAGE形式 %s: 日付・数量・製品を特定する列(YJ/JAN/品名)の指定が必要です", format.Name)
+	}
+
+	// 標準形式の「6列未満の行は読み飛ばす」と同じく、指定された列が揃わない行は取り込まない
+	cols.maxRequired = -1
+	for _, idx := range []int{cols.date, cols.yjCode, cols.janCode, cols.productName, cols.quantity, cols.unitName} {
+		if idx > cols.maxRequired {
+			cols.maxRequired = idx
+		}
+	}
+	return cols, nil
+}
+
+// newUsageCSVReader は形式定義の文字コード・区切り文字でCSVリーダーを作成します。
+func newUsageCSVReader(content []byte, format model.UsageFormat) *csv.Reader {
+	var r io.Reader
+	if isUTF8Encoding(format.Encoding) {
+		r = bytes.NewReader(bytes.TrimPrefix(content, utf8BOM))
+	} else {
+		r = transform.NewReader(bytes.NewReader(content), japanese.ShiftJIS.NewDecoder())
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if d := usageDelimiter(format.Delimiter); d != 0 {
+		reader.Comma = d
+	}
+	return reader
+}
+
+// shouldSkipUsageRow は読み飛ばし規則に該当する行かを判定します。
+func shouldSkipUsageRow(format model.UsageFormat, rec []string) bool {
+	if len(rec) == 0 || (len(rec) == 1 && strings.TrimSpace(rec[0]) == "") {
+		return true
+	}
+	first := strings.TrimSpace(rec[0])
+	for _, prefix := range format.SkipPrefixes {
+		if prefix != "" && strings.HasPrefix(first, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeUsageDate は形式定義の日付書式で解釈し、YYYYMMDD形式に揃えます。
+// 日付書式が未指定の場合は、従来どおり値をそのまま使用します。
+func normalizeUsageDate(format model.UsageFormat, raw string) (string, error) {
+	if format.DateFormat == "" {
+		return raw, nil
+	}
+	t, err := time.Parse(usageDateLayout(format.DateFormat), raw)
+	if err != nil {
+		return "", fmt.Errorf("日付 %q が書式 %s と一致しません", raw, format.DateFormat)
+	}
+	return t.Format("20060102"), nil
+}
+
+// usageDateLayout は YYYY/YY/MM/DD (ゼロ埋め無しは M/D) で書かれた日付書式をGoのレイアウト文字列に変換します。
+func usageDateLayout(dateFormat string) string {
+	return strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "M", "1", "DD", "02", "D", "2").Replace(dateFormat)
+}
+
+func usageDelimiter(delimiter string) rune {
+	switch delimiter {
+	case "":
+		return 0
+	case `\t`, "\t", "tab", "TAB":
+		return '\t'
+	}
+	return []rune(delimiter)[0]
+}
+
+func normalizeUsageHeader(h string) string {
+	return strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF"))
+}
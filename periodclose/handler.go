@@ -0,0 +1,123 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\periodclose\handler.go
+
+package periodclose
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"wasabi/db"
+)
+
+// ClosePeriodRequest は月次締めのリクエストです。
+type ClosePeriodRequest struct {
+	Period   string `json:"period"` // YYYYMM または YYYY-MM
+	ClosedBy string `json:"closedBy"`
+}
+
+// ReopenPeriodRequest は締め済みの期間を再開するリクエストです。
+type ReopenPeriodRequest struct {
+	ID         int    `json:"id"`
+	ReopenedBy string `json:"reopenedBy"`
+	Reason     string `json:"reason"`
+}
+
+// ListClosedPeriodsHandler は締め・再開の履歴を返します。
+func ListClosedPeriodsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		periods, err := db.GetClosedPeriods(conn)
+		if err != nil {
+			http.Error(w, "締め履歴の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(periods)
+	}
+}
+
+// ClosePeriodHandler は指定された月を締め、月末時点の在庫評価を保存します。
+func ClosePeriodHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ClosePeriodRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		period := strings.ReplaceAll(strings.TrimSpace(req.Period), "-", "")
+		closed, err := db.ClosePeriod(conn, period, strings.TrimSpace(req.ClosedBy))
+		if err != nil {
+			http.Error(w, err.Error(), periodErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": closed.Period[:4] + "年" + closed.Period[4:] + "月を締めました。",
+			"period":  closed,
+		})
+	}
+}
+
+// ReopenPeriodHandler は締め済みの期間を、管理者名と理由を記録して再開します。
+func ReopenPeriodHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ReopenPeriodRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := db.ReopenPeriod(conn, req.ID, req.ReopenedBy, req.Reason); err != nil {
+			http.Error(w, err.Error(), periodErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "期間を再開しました。"})
+	}
+}
+
+// periodErrorStatus は締め・再開のエラーに対応するHTTPステータスを返します。
+func periodErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrInvalidPeriodRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrPeriodAlreadyClosed), errors.Is(err, db.ErrPeriodNotClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetSnapshotHandler は締め時点に保存した在庫評価を返します。
+func GetSnapshotHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "id parameter is required", http.StatusBadRequest)
+			return
+		}
+		period, groups, err := db.GetClosedPeriodSnapshot(conn, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "締め済みの期間が見つかりません。", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "在庫評価の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"period":    period,
+			"valuation": groups,
+		})
+	}
+}
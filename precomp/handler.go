@@ -1,6 +1,7 @@
 package precomp
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -10,7 +11,9 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"wasabi/audit"
 	"wasabi/db"
+	"wasabi/jobs"
 	"wasabi/model"
 
 	"golang.org/x/text/encoding/japanese"
@@ -43,7 +46,7 @@ func SavePrecompHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -104,10 +107,20 @@ func ClearPrecompHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		if err := db.DeletePreCompoundingRecordsByPatient(conn, patientNumber); err != nil {
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if err := db.DeletePreCompoundingRecordsByPatient(tx, patientNumber); err != nil {
 			http.Error(w, "Failed to clear pre-compounding records: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"message": "予製データを完全に削除しました。"})
@@ -128,7 +141,7 @@ func SuspendPrecompHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Patient number is required", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -161,7 +174,7 @@ func ResumePrecompHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Patient number is required", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -180,6 +193,24 @@ func ResumePrecompHandler(conn *sql.DB) http.HandlerFunc {
 	}
 }
 
+// GetDispensingPrecompHandler は患者の予製品目ごとに、予製登録日以降の実際の調剤数量を返します
+func GetDispensingPrecompHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patientNumber := r.URL.Query().Get("patientNumber")
+		if patientNumber == "" {
+			http.Error(w, "Patient number is required", http.StatusBadRequest)
+			return
+		}
+		matches, err := db.GetPrecompDispensingMatches(conn, patientNumber)
+		if err != nil {
+			http.Error(w, "Failed to match dispensing: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matches)
+	}
+}
+
 // GetStatusPrecompHandler は予製の現在の状態を返します
 func GetStatusPrecompHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -294,7 +325,7 @@ func ImportPrecompHandler(conn *sql.DB) http.HandlerFunc {
 			})
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -356,6 +387,7 @@ func ExportAllPrecompHandler(conn *sql.DB) http.HandlerFunc {
 	}
 }
 
+// BulkImportPrecompHandler はCSVを検証した後、予製データの登録をジョブとして開始します。
 func BulkImportPrecompHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		file, _, err := r.FormFile("file")
@@ -399,28 +431,34 @@ func BulkImportPrecompHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
-		if err != nil {
-			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-			return
-		}
-		defer tx.Rollback()
-
-		for patientNumber, precompRecords := range recordsByPatient {
-			if err := db.UpsertPreCompoundingRecordsInTx(tx, patientNumber, precompRecords); err != nil {
-				http.Error(w, fmt.Sprintf("Failed to save records for patient %s: %s", patientNumber, err.Error()), http.StatusInternalServerError)
-				return
+		patientCount := len(recordsByPatient)
+		user := audit.RequestUser(r)
+		jobs.ServeSubmit(w, "precomp_import", "予製データの一括インポート", func(ctx context.Context, rep jobs.Reporter) (interface{}, error) {
+			tx, err := db.BeginAuditTx(conn, user)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to start transaction: %w", err)
+			}
+			defer tx.Rollback()
+
+			processed := 0
+			rep.Report("インポート", 0, patientCount)
+			for patientNumber, precompRecords := range recordsByPatient {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				if err := db.UpsertPreCompoundingRecordsInTx(tx, patientNumber, precompRecords); err != nil {
+					return nil, fmt.Errorf("Failed to save records for patient %s: %w", patientNumber, err)
+				}
+				processed++
+				rep.Report("インポート", processed, patientCount)
 			}
-		}
-
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
-			return
-		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": fmt.Sprintf("%d名の患者の予製データ（計%d件）をインポートしました。", len(recordsByPatient), importedCount),
+			if err := tx.Commit(); err != nil {
+				return nil, fmt.Errorf("Failed to commit transaction: %w", err)
+			}
+			return map[string]string{
+				"message": fmt.Sprintf("%d名の患者の予製データ（計%d件）をインポートしました。", patientCount, importedCount),
+			}, nil
 		})
 	}
 }
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"wasabi/audit"
 	"wasabi/db"
 	"wasabi/model"
 	"wasabi/units"
@@ -128,7 +129,14 @@ func UploadQuotesHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		validFrom, validTo, err := parseQuoteValidity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		quotesByProduct := make(map[string]map[string]float64)
+		var quoteHistory []model.WholesalerQuote
 		wholesalerFiles := r.MultipartForm.File["files"]
 		wholesalerNames := r.MultipartForm.Value["wholesalerNames"]
 
@@ -199,9 +207,32 @@ func UploadQuotesHandler(conn *sql.DB) http.HandlerFunc {
 					quotesByProduct[productCode] = make(map[string]float64)
 				}
 				quotesByProduct[productCode][wholesalerName] = price
+				quoteHistory = append(quoteHistory, model.WholesalerQuote{
+					ProductCode:    productCode,
+					WholesalerName: wholesalerName,
+					Price:          price,
+					ValidFrom:      validFrom,
+					ValidTo:        validTo,
+				})
 			}
 		}
 
+		// 見積は採用卸を決めた後も比較・発注の振り分けに使うため、有効期間とともに履歴に残す
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+		if err != nil {
+			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := db.SaveWholesalerQuotesInTx(tx, quoteHistory); err != nil {
+			http.Error(w, "Failed to save quote history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+
 		var responseData []QuoteDataWithSpec
 		for _, master := range allMasters {
 			tempJcshms := model.JCShms{
@@ -231,6 +262,46 @@ func UploadQuotesHandler(conn *sql.DB) http.HandlerFunc {
 	}
 }
 
+// parseQuoteValidity はフォームの validFrom・validTo (YYYY-MM-DD または YYYYMMDD) から見積の有効期間を返します。
+// 開始日の省略時は今日、終了日の省略時は期限なし (空) です。
+func parseQuoteValidity(r *http.Request) (string, string, error) {
+	validFrom := strings.ReplaceAll(r.FormValue("validFrom"), "-", "")
+	validTo := strings.ReplaceAll(r.FormValue("validTo"), "-", "")
+	if validFrom == "" {
+		validFrom = time.Now().Format("20060102")
+	}
+	if _, err := time.Parse("20060102", validFrom); err != nil {
+		return "", "", fmt.Errorf("見積の有効期間の開始日が不正です: %s", r.FormValue("validFrom"))
+	}
+	if validTo != "" {
+		if _, err := time.Parse("20060102", validTo); err != nil {
+			return "", "", fmt.Errorf("見積の有効期間の終了日が不正です: %s", r.FormValue("validTo"))
+		}
+		if validTo < validFrom {
+			return "", "", fmt.Errorf("見積の有効期間の終了日が開始日より前です")
+		}
+	}
+	return validFrom, validTo, nil
+}
+
+// QuoteHistoryHandler は製品の卸ごとの見積価格の履歴を返します (?productCode=)。
+func QuoteHistoryHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		productCode := r.URL.Query().Get("productCode")
+		if productCode == "" {
+			http.Error(w, "productCode is required", http.StatusBadRequest)
+			return
+		}
+		quotes, err := db.GetWholesalerQuoteHistory(conn, productCode)
+		if err != nil {
+			http.Error(w, "Failed to get quote history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quotes)
+	}
+}
+
 func BulkUpdateHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload []model.PriceUpdate
@@ -239,7 +310,7 @@ func BulkUpdateHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -354,7 +425,7 @@ func DirectImportHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -0,0 +1,85 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\replenishment\handler.go
+
+package replenishment
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"wasabi/audit"
+	"wasabi/db"
+	"wasabi/model"
+)
+
+/**
+ * @brief 補充条件の一覧・登録・削除を処理するHTTPハンドラです。
+ * @param conn データベース接続
+ * @return http.HandlerFunc HTTPリクエストを処理するハンドラ関数
+ * @details
+ * GET は一覧、POST はJSONの補充条件の登録・更新、DELETE は /api/replenishment/policies/{policy_key} の削除です。
+ * 登録・削除は監査ログに記録されます。
+ */
+func PoliciesHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			policies, err := db.GetReplenishmentPolicies(conn)
+			if err != nil {
+				http.Error(w, "補充条件の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(policies)
+
+		case http.MethodPost:
+			var payload model.ReplenishmentPolicy
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+			if err != nil {
+				http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+			if err := db.SaveReplenishmentPolicyInTx(tx, payload); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "補充条件を保存しました。"})
+
+		case http.MethodDelete:
+			key := strings.TrimPrefix(r.URL.Path, "/api/replenishment/policies/")
+			if key == "" || key == r.URL.Path {
+				http.Error(w, "Policy key is required", http.StatusBadRequest)
+				return
+			}
+			tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
+			if err != nil {
+				http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+			if err := db.DeleteReplenishmentPolicyInTx(tx, key); err != nil {
+				http.Error(w, "補充条件の削除に失敗しました: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "補充条件を削除しました。"})
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
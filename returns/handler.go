@@ -13,6 +13,7 @@ import (
 	"time"
 	"wasabi/config"
 	"wasabi/db"
+	"wasabi/forecast"
 	"wasabi/model"
 )
 
@@ -44,6 +45,7 @@ func GenerateReturnCandidatesHandler(conn *sql.DB) http.HandlerFunc {
 			DosageForm:  q.Get("dosageForm"),
 			ShelfNumber: q.Get("shelfNumber"),
 			Coefficient: coefficient,
+			Forecast:    forecast.ParamsFromConfig(cfg, coefficient),
 		}
 
 		// ステップ1: 過去のデータから使用量を分析し、発注点を計算する
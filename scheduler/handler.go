@@ -0,0 +1,115 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\scheduler\handler.go
+
+package scheduler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"wasabi/db"
+)
+
+// JobsHandler は定期実行ジョブの一覧 (スケジュール・次回実行予定・直近の結果) を返します。
+func JobsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := getService()
+		if s == nil {
+			http.Error(w, "定期実行サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		jobs, err := s.jobs()
+		if err != nil {
+			http.Error(w, "ジョブ一覧の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(jobs)
+	}
+}
+
+// UpdateJobHandler はジョブのスケジュール (cron形式) と有効・無効を変更します。
+func UpdateJobHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := getService()
+		if s == nil {
+			http.Error(w, "定期実行サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Name     string `json:"name"`
+			CronExpr string `json:"cronExpr"`
+			Enabled  bool   `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.update(req.Name, req.CronExpr, req.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"message": "スケジュールを保存しました。"})
+	}
+}
+
+// RunJobHandler はジョブをスケジュールを待たずにバックグラウンドで実行し、実行履歴のIDを返します。
+func RunJobHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := getService()
+		if s == nil {
+			http.Error(w, "定期実行サービスが起動していません。", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		runID, err := s.run(req.Name, "MANUAL", true)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrJobRunning) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"runId":   runID,
+			"message": fmt.Sprintf("ジョブ %s を開始しました。", req.Name),
+		})
+	}
+}
+
+// JobRunsHandler はジョブの実行履歴を新しい順に返します。
+// クエリパラメータ name でジョブを絞り込み、limit で件数 (既定50件) を指定できます。
+func JobRunsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		runs, err := db.GetJobRuns(conn, r.URL.Query().Get("name"), limit)
+		if err != nil {
+			http.Error(w, "実行履歴の取得に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(runs)
+	}
+}
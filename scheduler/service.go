@@ -0,0 +1,230 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\scheduler\service.go
+
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"wasabi/db"
+	"wasabi/model"
+)
+
+const (
+	// tickInterval はスケジュールを確認する間隔です。
+	tickInterval = 30 * time.Second
+	timeLayout   = "2006-01-02 15:04:05"
+	// auditUser は定期実行による変更を監査ログに記録する際の操作者名です。
+	auditUser = "定期実行"
+)
+
+// ErrJobRunning は同じジョブが既に実行中であることを示します。
+var ErrJobRunning = errors.New("このジョブは実行中です")
+
+// service は登録されたジョブのスケジュールを確認し、実行予定日時を過ぎたものを実行します。
+type service struct {
+	conn *sql.DB
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+var (
+	svcMu sync.Mutex
+	svc   *service
+)
+
+/**
+ * @brief 定期実行サービスをバックグラウンドで開始します。
+ * @param conn データベース接続
+ * @return error ジョブの登録に失敗した場合
+ * @details
+ * 未登録のタスクは既定のスケジュールで無効状態として登録します。
+ * アプリケーションが停止していた間に実行予定日時を過ぎたジョブは、起動後に1回だけ実行します。
+ */
+func Start(conn *sql.DB) error {
+	svcMu.Lock()
+	defer svcMu.Unlock()
+	if svc != nil {
+		return nil
+	}
+	for _, t := range tasks {
+		if err := db.EnsureScheduledJob(conn, t.Name, t.DefaultCron); err != nil {
+			return err
+		}
+	}
+	if err := db.FailInterruptedJobRuns(conn); err != nil {
+		return err
+	}
+
+	svc = &service{conn: conn, running: make(map[string]bool)}
+	go svc.loop()
+	log.Println("Job scheduler started.")
+	return nil
+}
+
+func getService() *service {
+	svcMu.Lock()
+	defer svcMu.Unlock()
+	return svc
+}
+
+func (s *service) loop() {
+	for {
+		s.runDueJobs(time.Now())
+		time.Sleep(tickInterval)
+	}
+}
+
+// runDueJobs は実行予定日時を過ぎた有効なジョブを順に実行します。
+func (s *service) runDueJobs(now time.Time) {
+	jobs, err := db.GetScheduledJobs(s.conn)
+	if err != nil {
+		log.Printf("WARN: scheduler: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if !job.Enabled {
+			continue
+		}
+		schedule, err := ParseSchedule(job.CronExpr)
+		if err != nil {
+			log.Printf("WARN: scheduler: job %s: %v", job.Name, err)
+			continue
+		}
+		if job.NextRunAt == "" {
+			s.setNextRun(job.Name, schedule, now)
+			continue
+		}
+		next, err := time.ParseInLocation(timeLayout, job.NextRunAt, time.Local)
+		if err != nil || next.After(now) {
+			continue
+		}
+
+		// 次回の予定を先に進めてから実行し、失敗しても同じ予定で繰り返し実行しない
+		s.setNextRun(job.Name, schedule, now)
+		if _, err := s.run(job.Name, "SCHEDULE", false); err != nil && !errors.Is(err, ErrJobRunning) {
+			log.Printf("WARN: scheduler: job %s: %v", job.Name, err)
+		}
+	}
+}
+
+func (s *service) setNextRun(name string, schedule *Schedule, now time.Time) {
+	if err := db.SetScheduledJobNextRun(s.conn, name, formatTime(schedule.Next(now))); err != nil {
+		log.Printf("WARN: scheduler: %v", err)
+	}
+}
+
+// run はジョブを実行し、実行履歴のIDを返します。async が true の場合は履歴を作成した時点で戻ります。
+func (s *service) run(name, triggerType string, async bool) (int64, error) {
+	task, ok := findTask(name)
+	if !ok {
+		return 0, fmt.Errorf("ジョブ %s は登録されていません", name)
+	}
+
+	s.mu.Lock()
+	if s.running[name] {
+		s.mu.Unlock()
+		return 0, ErrJobRunning
+	}
+	s.running[name] = true
+	s.mu.Unlock()
+
+	runID, err := db.StartJobRun(s.conn, name, triggerType)
+	if err != nil {
+		s.finish(name)
+		return 0, err
+	}
+
+	execute := func() {
+		defer s.finish(name)
+		log.Printf("scheduler: job %s started (%s)", name, triggerType)
+		message, runErr := runTask(task, s.conn)
+		if runErr != nil {
+			log.Printf("WARN: scheduler: job %s failed: %v", name, runErr)
+		} else {
+			log.Printf("scheduler: job %s finished: %s", name, message)
+		}
+		if err := db.FinishJobRun(s.conn, runID, message, runErr); err != nil {
+			log.Printf("WARN: scheduler: %v", err)
+		}
+	}
+	if async {
+		go execute()
+	} else {
+		execute()
+	}
+	return runID, nil
+}
+
+// runTask はタスクを実行します。タスク内のpanicは失敗として記録し、サービスは停止させません。
+func runTask(task Task, conn *sql.DB) (message string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return task.Run(context.Background(), conn)
+}
+
+func (s *service) finish(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, name)
+}
+
+func (s *service) isRunning(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[name]
+}
+
+// jobs はジョブの一覧に説明と実行中かを加えて返します。
+func (s *service) jobs() ([]model.ScheduledJob, error) {
+	jobs, err := db.GetScheduledJobs(s.conn)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]model.ScheduledJob, 0, len(jobs))
+	for _, j := range jobs {
+		task, ok := findTask(j.Name)
+		if !ok {
+			continue // 削除されたタスク
+		}
+		j.Description = task.Description
+		j.Running = s.isRunning(j.Name)
+		result = append(result, j)
+	}
+	return result, nil
+}
+
+// update はジョブのスケジュールと有効・無効を変更し、次回の実行予定日時を計算し直します。
+func (s *service) update(name, cronExpr string, enabled bool) error {
+	if _, ok := findTask(name); !ok {
+		return fmt.Errorf("ジョブ %s は登録されていません", name)
+	}
+	schedule, err := ParseSchedule(cronExpr)
+	if err != nil {
+		return err
+	}
+	next := ""
+	if enabled {
+		nextTime := schedule.Next(time.Now())
+		if nextTime.IsZero() {
+			return fmt.Errorf("スケジュール %q に一致する日時がありません", cronExpr)
+		}
+		next = formatTime(nextTime)
+	}
+	return db.UpdateScheduledJob(s.conn, name, cronExpr, enabled, next)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(timeLayout)
+}
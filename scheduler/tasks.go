@@ -0,0 +1,128 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\scheduler\tasks.go
+
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"wasabi/backup"
+	"wasabi/config"
+	"wasabi/cyclecount"
+	"wasabi/db"
+	"wasabi/emednet"
+	"wasabi/ingest"
+	"wasabi/jobs"
+	"wasabi/loader"
+	"wasabi/medrec"
+)
+
+// databaseBackupGenerations は定期バックアップで保持する世代数です。
+const databaseBackupGenerations = 8
+
+// Task は定期実行できる処理です。Run は結果の概要を返します。
+type Task struct {
+	Name        string
+	Description string
+	DefaultCron string // 初回登録時のスケジュール。登録直後は無効
+	Run         func(ctx context.Context, conn *sql.DB) (string, error)
+}
+
+// tasks は定期実行できる処理の一覧です。
+var tasks = []Task{
+	{
+		Name:        "dat_download",
+		Description: "e-mednet から納品データ(DAT)を受信して取り込みます",
+		DefaultCron: "30 8 * * 1-6",
+		Run:         runDatDownload,
+	},
+	{
+		Name:        "usage_import",
+		Description: "監視フォルダのUSAGE・DATファイルを取り込みます",
+		DefaultCron: "0 22 * * *",
+		Run:         runUsageImport,
+	},
+	{
+		Name:        "jcshms_reload",
+		Description: "SOUフォルダのJCSHMS・JANCODEで製品マスターを更新します",
+		DefaultCron: "0 5 1 * *",
+		Run:         runJcshmsReload,
+	},
+	{
+		Name:        "backup",
+		Description: fmt.Sprintf("データベースをバックアップします (%d世代保持)", databaseBackupGenerations),
+		DefaultCron: "0 3 * * 0",
+		Run:         runBackup,
+	},
+	{
+		Name:        "cycle_count_plan",
+		Description: "ABC分類の棚卸間隔に従って、当日の循環棚卸リストを作成します",
+		DefaultCron: "0 7 * * *",
+		Run:         runCycleCountPlan,
+	},
+}
+
+func findTask(name string) (Task, bool) {
+	for _, t := range tasks {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Task{}, false
+}
+
+func runDatDownload(ctx context.Context, conn *sql.DB) (string, error) {
+	opts, err := medrec.DownloadOptions(config.GetConfig())
+	if err != nil {
+		return "", err
+	}
+	count, res, err := emednet.ReceiveDeliveries(ctx, conn, opts, auditUser)
+	if err != nil {
+		return "", err
+	}
+	if res.NoData {
+		return "未受信の納品データはありませんでした。", nil
+	}
+	return fmt.Sprintf("%d件の納品データをダウンロードし登録しました。", count), nil
+}
+
+func runUsageImport(ctx context.Context, conn *sql.DB) (string, error) {
+	summary, err := ingest.RunOnce()
+	if err != nil {
+		return "", err
+	}
+	message := fmt.Sprintf("取込 %d件 / 取込済みのためスキップ %d件 / エラー %d件", summary.Imported, summary.Skipped, summary.Failed)
+	if summary.Failed > 0 {
+		return message, fmt.Errorf("%d件のファイルの取込に失敗しました。詳細は自動取込のエラー履歴を確認してください", summary.Failed)
+	}
+	return message, nil
+}
+
+func runJcshmsReload(ctx context.Context, conn *sql.DB) (string, error) {
+	result, err := loader.UpdateMasters(ctx, conn, auditUser, jobs.NopReporter{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("更新 %d件 / PROVISIONAL化 %d件", len(result.UpdatedProducts), len(result.OrphanedProducts)), nil
+}
+
+func runCycleCountPlan(ctx context.Context, conn *sql.DB) (string, error) {
+	shelves, err := db.GenerateCycleCountList(conn, time.Now().Format("20060102"), cyclecount.Policy())
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	for _, shelf := range shelves {
+		count += len(shelf.Items)
+	}
+	return fmt.Sprintf("%d棚 %d品目の棚卸リストを作成しました。", len(shelves), count), nil
+}
+
+func runBackup(ctx context.Context, conn *sql.DB) (string, error) {
+	path, err := backup.BackupDatabase(conn, backup.DefaultDatabaseBackupDir, databaseBackupGenerations)
+	if err != nil {
+		return "", err
+	}
+	return path + " に保存しました。", nil
+}
@@ -9,7 +9,8 @@ CREATE TABLE IF NOT EXISTS client_master (
 -- 卸業者マスターテーブル
 CREATE TABLE IF NOT EXISTS wholesalers (
   wholesaler_code TEXT PRIMARY KEY,
-  wholesaler_name TEXT NOT NULL
+  wholesaler_name TEXT NOT NULL,
+  min_order_amount REAL NOT NULL DEFAULT 0 -- 1回の発注の最低金額 (納入価)。0 の場合は制限なし
 );
 
 -- 製品マスターテーブル (最終確定版)
@@ -93,7 +94,8 @@ CREATE TABLE IF NOT EXISTS transaction_records (
   flag_psychotropic INTEGER,
   flag_stimulant INTEGER,
   flag_stimulant_raw INTEGER,
-  process_flag_ma TEXT
+  process_flag_ma TEXT,
+  import_batch_id INTEGER -- 取込バッチ (import_batches.id)。手入力などの場合はNULL
 );
 
 -- 予製レコードテーブル
@@ -141,10 +143,31 @@ CREATE TABLE IF NOT EXISTS backorders (
   wholesaler_code TEXT,
   yj_pack_unit_qty REAL,
   jan_pack_unit_qty REAL,
-  jan_unit_code INTEGER
+  jan_unit_code INTEGER,
+  product_code TEXT NOT NULL DEFAULT '', -- 発注した製品 (JAN)
+  po_number TEXT NOT NULL DEFAULT '', -- 発注書番号
+  status TEXT NOT NULL DEFAULT 'ordered', -- ordered, partial, received, cancelled, out_of_stock
+  expected_date TEXT NOT NULL DEFAULT '', -- 納品予定日 (YYYYMMDD)
+  status_reason TEXT NOT NULL DEFAULT '', -- 取消・欠品の理由
+  closed_date TEXT NOT NULL DEFAULT '' -- 納品完了・取消・欠品になった日 (YYYYMMDD)
 );
 -- ▲▲▲【修正ここまで】▲▲▲
 
+-- 発注残を消し込んだ納品データ (DATの明細)
+CREATE TABLE IF NOT EXISTS backorder_fulfillments (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  backorder_id INTEGER NOT NULL,
+  transaction_date TEXT NOT NULL,
+  client_code TEXT NOT NULL DEFAULT '',
+  receipt_number TEXT NOT NULL DEFAULT '',
+  line_number TEXT NOT NULL DEFAULT '',
+  jan_code TEXT NOT NULL DEFAULT '',
+  quantity REAL NOT NULL, -- 消し込んだ数量 (YJ単位)
+  import_batch_id INTEGER,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_backorder_fulfillments_backorder ON backorder_fulfillments (backorder_id);
+
 -- 手入力用ロット・期限情報テーブル
 CREATE TABLE IF NOT EXISTS dead_stock_list (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -188,6 +211,110 @@ CREATE TABLE IF NOT EXISTS jancode (
   PRIMARY KEY(JA001)
 );
 
+-- ファイル取込バッチ台帳 (DAT / USAGE / 棚卸移行)
+CREATE TABLE IF NOT EXISTS import_batches (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source_type TEXT NOT NULL, -- 'DAT', 'USAGE', 'NSIPS', 'INVENTORY'
+  file_name TEXT NOT NULL,
+  file_hash TEXT NOT NULL, -- ファイル内容のSHA-256
+  imported_at TEXT NOT NULL,
+  record_count INTEGER NOT NULL DEFAULT 0,
+  displaced_count INTEGER NOT NULL DEFAULT 0,
+  backorder_count INTEGER NOT NULL DEFAULT 0,
+  status TEXT NOT NULL DEFAULT 'ACTIVE', -- 'ACTIVE', 'ROLLED_BACK'
+  rolled_back_at TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_import_batches_hash ON import_batches (source_type, file_hash, status);
+
+-- 取込によって置換・削除された既存の取引レコード (ロールバック時に復元する)
+CREATE TABLE IF NOT EXISTS import_batch_displaced_records (
+  batch_id INTEGER NOT NULL,
+  original_id INTEGER NOT NULL,
+  transaction_date TEXT,
+  client_code TEXT,
+  receipt_number TEXT,
+  line_number TEXT,
+  flag INTEGER,
+  jan_code TEXT,
+  yj_code TEXT,
+  product_name TEXT,
+  kana_name TEXT,
+  usage_classification TEXT,
+  package_form TEXT,
+  package_spec TEXT,
+  maker_name TEXT,
+  dat_quantity REAL,
+  jan_pack_inner_qty REAL,
+  jan_quantity REAL,
+  jan_pack_unit_qty REAL,
+  jan_unit_name TEXT,
+  jan_unit_code TEXT,
+  yj_quantity REAL,
+  yj_pack_unit_qty REAL,
+  yj_unit_name TEXT,
+  unit_price REAL,
+  purchase_price REAL,
+  supplier_wholesale TEXT,
+  subtotal REAL,
+  tax_amount REAL,
+  tax_rate REAL,
+  expiry_date TEXT,
+  lot_number TEXT,
+  flag_poison INTEGER,
+  flag_deleterious INTEGER,
+  flag_narcotic INTEGER,
+  flag_psychotropic INTEGER,
+  flag_stimulant INTEGER,
+  flag_stimulant_raw INTEGER,
+  process_flag_ma TEXT,
+  import_batch_id INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_displaced_records_batch ON import_batch_displaced_records (batch_id);
+
+-- 取込によって消し込まれた発注残 (ロールバック時に復元する)
+CREATE TABLE IF NOT EXISTS import_batch_backorders (
+  batch_id INTEGER NOT NULL,
+  backorder_id INTEGER NOT NULL,
+  order_date TEXT NOT NULL,
+  yj_code TEXT NOT NULL,
+  product_name TEXT,
+  package_form TEXT NOT NULL,
+  jan_pack_inner_qty REAL NOT NULL,
+  yj_unit_name TEXT NOT NULL,
+  order_quantity REAL NOT NULL,
+  wholesaler_code TEXT,
+  yj_pack_unit_qty REAL,
+  jan_pack_unit_qty REAL,
+  jan_unit_code INTEGER,
+  remaining_before REAL NOT NULL,
+  consumed_quantity REAL NOT NULL,
+  product_code TEXT NOT NULL DEFAULT '',
+  po_number TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_import_batch_backorders_batch ON import_batch_backorders (batch_id);
+
+-- 定期実行ジョブ (schedulerパッケージに登録されたタスクの実行スケジュール)
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+  name TEXT PRIMARY KEY, -- タスク名 ('dat_download', 'usage_import', 'jcshms_reload', 'backup')
+  cron_expr TEXT NOT NULL, -- cron形式 (分 時 日 月 曜日)
+  enabled INTEGER NOT NULL DEFAULT 0,
+  next_run_at TEXT, -- 次回の実行予定日時。無効の場合はNULL
+  last_run_at TEXT
+);
+
+-- 定期実行ジョブの実行履歴
+CREATE TABLE IF NOT EXISTS job_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  job_name TEXT NOT NULL,
+  trigger_type TEXT NOT NULL, -- 'SCHEDULE', 'MANUAL'
+  started_at TEXT NOT NULL,
+  finished_at TEXT,
+  status TEXT NOT NULL DEFAULT 'RUNNING', -- 'RUNNING', 'SUCCESS', 'FAILED'
+  message TEXT,
+  error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs (job_name, id);
+
 -- 自動採番用シーケンステーブル
 CREATE TABLE IF NOT EXISTS code_sequences (
   name TEXT PRIMARY KEY,
@@ -195,6 +322,7 @@ CREATE TABLE IF NOT EXISTS code_sequences (
 );
 INSERT OR IGNORE INTO code_sequences(name, last_no) VALUES ('MA2Y', 0);
 INSERT OR IGNORE INTO code_sequences(name, last_no) VALUES ('CL', 0);
+INSERT OR IGNORE INTO code_sequences(name, last_no) VALUES ('PO', 0);
 -- パフォーマンス向上のためのインデックス
 CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_unique_slip
   ON transaction_records(transaction_date, client_code, receipt_number, line_number)
@@ -207,4 +335,256 @@ CREATE INDEX IF NOT EXISTS idx_product_master_kana_name ON product_master (kana_
 CREATE INDEX IF NOT EXISTS idx_tx_jan_date ON transaction_records(jan_code, transaction_date);
 CREATE INDEX IF NOT EXISTS idx_transactions_receipt_number ON transaction_records (receipt_number);
 CREATE INDEX IF NOT EXISTS idx_transactions_process_flag_ma ON transaction_records (process_flag_ma);
-CREATE INDEX IF NOT EXISTS idx_transactions_flag_date ON transaction_records (flag, transaction_date);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_transactions_flag_date ON transaction_records (flag, transaction_date);
+
+-- 在庫スナップショット (製品ごとの最新棚卸日・棚卸数量・棚卸後の増減)
+-- 取引データを変更した処理が、コミット前に db.RefreshStockBalancesInTx で更新する
+CREATE TABLE IF NOT EXISTS stock_balances (
+  product_code TEXT PRIMARY KEY,
+  last_inventory_date TEXT NOT NULL DEFAULT '', -- 棚卸が無い場合は空文字
+  base_quantity REAL NOT NULL DEFAULT 0, -- 最新棚卸日の棚卸数量 (YJ単位)
+  net_change REAL NOT NULL DEFAULT 0, -- 最新棚卸日より後の入出庫の増減 (YJ単位)
+  updated_at TEXT NOT NULL
+);
+
+-- ロット別在庫 (製品・ロット番号・有効期限ごとの現在庫)
+-- 最新棚卸日の棚卸ロットを起点に、入庫はロットごとに積み上げ、出庫はロット指定が無ければ期限の近い順 (FEFO) に引き当てる。
+-- stock_balances と同時に更新する。ロット不明の在庫と引き当てられなかった出庫は lot_number・expiry_date が空の行に計上する
+CREATE TABLE IF NOT EXISTS lot_balances (
+  product_code TEXT NOT NULL,
+  lot_number TEXT NOT NULL DEFAULT '',
+  expiry_date TEXT NOT NULL DEFAULT '', -- YYYY-MM-DD (日が無い期限は月末日)
+  quantity REAL NOT NULL DEFAULT 0, -- YJ単位
+  PRIMARY KEY (product_code, lot_number, expiry_date)
+);
+CREATE INDEX IF NOT EXISTS idx_lot_balances_expiry ON lot_balances (expiry_date);
+
+-- 在庫スナップショットの再計算が必要な製品 (以下のトリガーで記録する)
+CREATE TABLE IF NOT EXISTS stock_balance_changes (
+  product_code TEXT PRIMARY KEY
+);
+
+-- INSERT OR REPLACE で置き換えられる行 (伝票の一意キーが一致する行) の製品も記録する
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_stock_insert
+BEFORE INSERT ON transaction_records
+BEGIN
+  INSERT OR IGNORE INTO stock_balance_changes (product_code) VALUES (COALESCE(NEW.jan_code, ''));
+  INSERT OR IGNORE INTO stock_balance_changes (product_code)
+    SELECT COALESCE(jan_code, '') FROM transaction_records
+    WHERE NEW.receipt_number != '' AND transaction_date = NEW.transaction_date AND client_code = NEW.client_code
+      AND receipt_number = NEW.receipt_number AND line_number = NEW.line_number;
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_stock_update
+AFTER UPDATE OF jan_code, flag, transaction_date, yj_quantity ON transaction_records
+BEGIN
+  INSERT OR IGNORE INTO stock_balance_changes (product_code) VALUES (COALESCE(OLD.jan_code, ''));
+  INSERT OR IGNORE INTO stock_balance_changes (product_code) VALUES (COALESCE(NEW.jan_code, ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_stock_delete
+AFTER DELETE ON transaction_records
+BEGIN
+  INSERT OR IGNORE INTO stock_balance_changes (product_code) VALUES (COALESCE(OLD.jan_code, ''));
+END;
+
+-- 締め済みの会計期間 (月単位)。reopened_at が空の期間に含まれる取引データは登録・変更・削除できない
+-- 期間を再開しても行は削除せず、再開の理由と共に履歴として残す
+CREATE TABLE IF NOT EXISTS closed_periods (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  period TEXT NOT NULL,                       -- YYYYMM
+  start_date TEXT NOT NULL,                   -- YYYYMMDD
+  end_date TEXT NOT NULL,                     -- YYYYMMDD
+  closed_at TEXT NOT NULL,
+  closed_by TEXT NOT NULL DEFAULT '',
+  total_nhi_value REAL NOT NULL DEFAULT 0,
+  total_purchase_value REAL NOT NULL DEFAULT 0,
+  valuation_snapshot TEXT NOT NULL,           -- 締め時点の在庫評価 (GetInventoryValuation の結果のJSON)
+  reopened_at TEXT NOT NULL DEFAULT '',
+  reopened_by TEXT NOT NULL DEFAULT '',
+  reopen_reason TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_closed_periods_active ON closed_periods (period) WHERE reopened_at = '';
+
+-- 締め済みの期間の取引データへの変更は、全ての書き込み処理で拒否する
+-- メッセージは db.ErrPeriodClosed と同じ文字列にすること
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_closed_insert
+BEFORE INSERT ON transaction_records
+WHEN EXISTS (SELECT 1 FROM closed_periods WHERE reopened_at = '' AND NEW.transaction_date BETWEEN start_date AND end_date)
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_closed_update
+BEFORE UPDATE ON transaction_records
+WHEN EXISTS (SELECT 1 FROM closed_periods WHERE reopened_at = ''
+  AND (OLD.transaction_date BETWEEN start_date AND end_date OR NEW.transaction_date BETWEEN start_date AND end_date))
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_transaction_records_closed_delete
+BEFORE DELETE ON transaction_records
+WHEN EXISTS (SELECT 1 FROM closed_periods WHERE reopened_at = '' AND OLD.transaction_date BETWEEN start_date AND end_date)
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+-- 監査ログ: 製品マスター・取引データ・発注残・予製・デッドストックの変更履歴
+-- 記録用のトリガーは列の追加に追従するため、起動時に db.ApplyMigrations で作成する
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S', 'now', 'localtime')),
+  entity TEXT NOT NULL,                       -- テーブル名
+  entity_key TEXT NOT NULL,                   -- 主キー (product_code や id)
+  action TEXT NOT NULL,                       -- INSERT / UPDATE / DELETE
+  before_json TEXT,                           -- 変更前の行 (INSERT の場合は NULL)
+  after_json TEXT,                            -- 変更後の行 (DELETE の場合は NULL)
+  user_name TEXT NOT NULL DEFAULT 'system'
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity, entity_key);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+-- 監査ログは追記のみとし、変更・削除は拒否する
+CREATE TRIGGER IF NOT EXISTS trg_audit_log_no_update
+BEFORE UPDATE ON audit_log
+BEGIN
+  SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_audit_log_no_delete
+BEFORE DELETE ON audit_log
+BEGIN
+  SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+-- 麻薬・向精神薬帳簿: 取引データに付ける帳簿上の区分 (廃棄など) と立会人・備考
+-- 区分が無い取引は取引種別 (flag) から区分を決める
+-- 伝票の編集や再取込では取引データが削除・再登録されIDが変わるため、伝票キーで取引に対応させる
+-- 伝票の行が別の製品に置き換わった場合は jan_code が一致せず、取引に対応しない記載として扱う
+CREATE TABLE IF NOT EXISTS controlled_register_notes (
+  transaction_date TEXT NOT NULL,
+  client_code TEXT NOT NULL,
+  receipt_number TEXT NOT NULL,
+  line_number TEXT NOT NULL,
+  jan_code TEXT NOT NULL,                     -- 記載した時点の取引の製品
+  category TEXT NOT NULL DEFAULT '',          -- receipt / dispense / transfer / disposal / adjust (空の場合は flag から判定)
+  witness TEXT NOT NULL DEFAULT '',           -- 廃棄の立会人など
+  note TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL,
+  updated_by TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (transaction_date, client_code, receipt_number, line_number)
+);
+
+-- 締め済みの期間の帳簿の記載は、取引データと同じく変更できない
+CREATE TRIGGER IF NOT EXISTS trg_controlled_register_notes_closed_insert
+BEFORE INSERT ON controlled_register_notes
+WHEN EXISTS (SELECT 1 FROM closed_periods c WHERE c.reopened_at = ''
+  AND NEW.transaction_date BETWEEN c.start_date AND c.end_date)
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_controlled_register_notes_closed_update
+BEFORE UPDATE ON controlled_register_notes
+WHEN EXISTS (SELECT 1 FROM closed_periods c WHERE c.reopened_at = ''
+  AND (OLD.transaction_date BETWEEN c.start_date AND c.end_date OR NEW.transaction_date BETWEEN c.start_date AND c.end_date))
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_controlled_register_notes_closed_delete
+BEFORE DELETE ON controlled_register_notes
+WHEN EXISTS (SELECT 1 FROM closed_periods c WHERE c.reopened_at = ''
+  AND OLD.transaction_date BETWEEN c.start_date AND c.end_date)
+BEGIN
+  SELECT RAISE(ABORT, 'transaction date is in a closed period');
+END;
+
+-- 棚卸差異: 棚卸 (flag=0) の保存時点の理論在庫と実棚数の差
+-- 棚卸は次回以降の在庫計算の起点に置き換わるため、保存時に差異を記録しておく
+-- 棚・剤型・区分・単価は棚卸時点の値を保持する (数量はYJ単位、単価はYJ単位あたり)
+CREATE TABLE IF NOT EXISTS inventory_variances (
+  count_date TEXT NOT NULL,                   -- 棚卸日 (YYYYMMDD)
+  product_code TEXT NOT NULL,
+  yj_code TEXT NOT NULL DEFAULT '',
+  product_name TEXT NOT NULL DEFAULT '',
+  shelf_number TEXT NOT NULL DEFAULT '',
+  usage_classification TEXT NOT NULL DEFAULT '',
+  drug_type TEXT NOT NULL DEFAULT '',
+  yj_unit_name TEXT NOT NULL DEFAULT '',
+  theoretical_quantity REAL NOT NULL DEFAULT 0, -- 棚卸直前の理論在庫
+  counted_quantity REAL NOT NULL DEFAULT 0,
+  nhi_price REAL NOT NULL DEFAULT 0,
+  purchase_price REAL NOT NULL DEFAULT 0,
+  source TEXT NOT NULL DEFAULT '',            -- guided: 棚卸調整, manual: 手入力棚卸
+  recorded_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S', 'now', 'localtime')),
+  PRIMARY KEY (count_date, product_code)
+);
+CREATE INDEX IF NOT EXISTS idx_inventory_variances_product_code ON inventory_variances(product_code);
+
+-- 循環棚卸の製品の分類 (ABC: 処方金額の累積構成比による重要度)
+-- 循環棚卸の頻度に使用する。ClassifyProductsABC だけが更新する
+CREATE TABLE IF NOT EXISTS product_classifications (
+  product_code TEXT PRIMARY KEY,
+  abc_class TEXT NOT NULL DEFAULT 'C',        -- A / B / C
+  usage_value REAL NOT NULL DEFAULT 0,        -- 分類期間の処方金額 (薬価)
+  classified_at TEXT NOT NULL
+);
+
+-- ABC/XYZ分析で保存した製品の分類 (XYZ: 週ごとの処方数量の変動係数による需要の安定度)
+-- 発注の方針などで参照する。分析の期間を選んで保存するため、循環棚卸の分類とは別に保持する
+CREATE TABLE IF NOT EXISTS product_abc_xyz_classifications (
+  product_code TEXT PRIMARY KEY,
+  abc_class TEXT NOT NULL,                    -- A / B / C
+  xyz_class TEXT NOT NULL,                    -- X / Y / Z
+  usage_value REAL NOT NULL DEFAULT 0,        -- 分析期間の処方金額 (薬価)
+  demand_cv REAL NOT NULL DEFAULT 0,          -- 週ごとの処方数量の変動係数
+  start_date TEXT NOT NULL,                   -- 分析期間 (YYYYMMDD)
+  end_date TEXT NOT NULL,
+  classified_at TEXT NOT NULL
+);
+
+-- 循環棚卸の棚卸リスト (棚卸日・YJコードごと)
+-- 棚卸調整で保存すると、その日までの未実施の行を実施済みにする
+CREATE TABLE IF NOT EXISTS cycle_count_items (
+  count_date TEXT NOT NULL,                   -- 棚卸予定日 (YYYYMMDD)
+  yj_code TEXT NOT NULL,
+  product_name TEXT NOT NULL DEFAULT '',
+  shelf_number TEXT NOT NULL DEFAULT '',
+  abc_class TEXT NOT NULL DEFAULT 'C',
+  last_count_date TEXT NOT NULL DEFAULT '',   -- 作成時点の前回棚卸日 (未実施は空)
+  due_date TEXT NOT NULL DEFAULT '',          -- 分類の頻度による棚卸期限 (未実施は空)
+  status TEXT NOT NULL DEFAULT 'pending',     -- pending / done
+  completed_at TEXT NOT NULL DEFAULT '',      -- 棚卸を保存した棚卸日 (YYYYMMDD)
+  PRIMARY KEY (count_date, yj_code)
+);
+CREATE INDEX IF NOT EXISTS idx_cycle_count_items_yj_code ON cycle_count_items(yj_code, status);
+
+-- 補充条件 (発注候補の計算に使用)。policy_key は scope に応じて製品コード・包装キー (YJコード|包装形態|内包装数量|YJ単位)・YJコードのいずれか
+-- 同じ製品に複数の条件がある場合は製品 > 包装 > YJコードの順に優先する
+CREATE TABLE IF NOT EXISTS replenishment_policies (
+  policy_key TEXT PRIMARY KEY,
+  scope TEXT NOT NULL,                        -- product / package / yj / class
+  min_quantity REAL NOT NULL DEFAULT 0,       -- 発注点 (YJ単位)。0 の場合は需要予測の発注点
+  max_quantity REAL NOT NULL DEFAULT 0,       -- 補充後の在庫の目標 (YJ単位)。0 の場合は発注点まで
+  safety_days REAL NOT NULL DEFAULT 0,        -- 安全在庫の日数。0 の場合はサービス率による安全在庫
+  lead_time_days INTEGER NOT NULL DEFAULT 0,  -- 卸の納品までの日数。0 の場合は設定のリードタイム
+  min_order_quantity REAL NOT NULL DEFAULT 0, -- 最小発注数 (包装数)
+  round_to_package INTEGER NOT NULL DEFAULT 1, -- 1: 包装単位で発注する 0: JAN単位 (バラ) で発注できる
+  notes TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL DEFAULT ''
+);
+
+-- 卸の見積価格の履歴 (価格見積のCSVを取り込むごとに記録)。価格は包装単位の納入価
+CREATE TABLE IF NOT EXISTS wholesaler_quotes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  product_code TEXT NOT NULL,
+  wholesaler_code TEXT NOT NULL DEFAULT '',   -- 卸業者管理に無い卸は空
+  wholesaler_name TEXT NOT NULL,
+  price REAL NOT NULL,
+  valid_from TEXT NOT NULL,                   -- 有効期間の開始日 (YYYYMMDD)
+  valid_to TEXT NOT NULL DEFAULT '',          -- 有効期間の終了日 (YYYYMMDD。空は期限なし)
+  quoted_at TEXT NOT NULL                     -- 取込日時
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wholesaler_quotes_key ON wholesaler_quotes(product_code, wholesaler_name, valid_from);
@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"wasabi/db" // ▼▼▼【ここに追加】▼▼▼
 	"wasabi/mappers"
+	"wasabi/model"
+	"wasabi/parsers"
 )
 
 /**
@@ -97,3 +100,79 @@ func GetProductByGS1Handler(conn *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(masterView)
 	}
 }
+
+// GS1ScanResult はGS1バーコードのスキャン結果と、特定された製品情報をまとめたレスポンスです。
+// Product が nil の場合、製品マスターにもJCSHMSにも該当がなかったことを示します。
+type GS1ScanResult struct {
+	parsers.GS1Data
+	Product *model.ProductMasterView `json:"product"`
+	Source  string                   `json:"source"` // "MASTER", "JCSHMS", または "" (該当なし)
+}
+
+// ScanGS1Handler はGS1-128 / GS1 DataMatrix のスキャン文字列を解析し、
+// 製品情報と共に有効期限・ロット番号・シリアル番号を返します。(/api/product/scan_gs1)
+func ScanGS1Handler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		parsed, err := parsers.ParseGS1(code)
+		if err != nil {
+			http.Error(w, "Failed to parse GS1 code: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parsed.Gtin == "" {
+			http.Error(w, "GS1 code does not contain a GTIN (AI 01)", http.StatusBadRequest)
+			return
+		}
+
+		result := GS1ScanResult{GS1Data: *parsed}
+
+		master, err := db.GetProductMasterByGS1Code(conn, parsed.Gtin)
+		if err != nil {
+			http.Error(w, "Failed to get product by gs1 code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// GTIN-14の先頭が0の場合、残りの13桁はJANコードと一致する
+		if master == nil && strings.HasPrefix(parsed.Gtin, "0") {
+			master, err = db.GetProductMasterByCode(conn, parsed.Gtin[1:])
+			if err != nil {
+				http.Error(w, "Failed to get product by jan code: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if master != nil {
+			view := mappers.ToProductMasterView(master)
+			view.IsAdopted = true
+			result.Product = &view
+			result.Source = "MASTER"
+		} else {
+			// 製品マスター未登録の場合はJCSHMSを参照する (読み取りのみで登録はしない)
+			tx, err := conn.Begin()
+			if err != nil {
+				http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+
+			jcshms, janCode, err := db.GetJcshmsRecordByGS1(tx, parsed.Gtin)
+			if err != nil && err != sql.ErrNoRows {
+				http.Error(w, "Failed to search JCSHMS master by GS1: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if jcshms != nil {
+				m := model.ProductMaster(mappers.JcshmsToProductMasterInput(jcshms, janCode))
+				view := mappers.ToProductMasterView(&m)
+				result.Product = &view
+				result.Source = "JCSHMS"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"wasabi/audit"
 	"wasabi/config"
 	"wasabi/db"
+	"wasabi/forecast"
 	"wasabi/model"
+	"wasabi/parsers"
 )
 
 // GetSettingsHandler returns the current settings.
@@ -32,6 +35,12 @@ func SaveSettingsHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		if payload.ForecastMethod != "" {
+			if err := forecast.Validate(payload.ForecastMethod); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		currentSettings, err := config.LoadConfig()
 		if err != nil {
@@ -48,6 +57,23 @@ func SaveSettingsHandler(conn *sql.DB) http.HandlerFunc {
 		// ▼▼▼【ここに追加】▼▼▼
 		currentSettings.EdgePath = payload.EdgePath // Edgeパスをマージ
 		// ▲▲▲【追加ここまで】▲▲▲
+		currentSettings.AutoIngestEnabled = payload.AutoIngestEnabled
+		currentSettings.AutoIngestIntervalSeconds = payload.AutoIngestIntervalSeconds
+		currentSettings.DatWatchFolderPath = payload.DatWatchFolderPath
+		currentSettings.EmednetBaseURL = payload.EmednetBaseURL
+		currentSettings.ChromePath = payload.ChromePath
+		currentSettings.AutomationHeadless = payload.AutomationHeadless
+		currentSettings.AutomationRetries = payload.AutomationRetries
+		currentSettings.ExpiryAlertDays = payload.ExpiryAlertDays
+		currentSettings.CycleCountDaysA = payload.CycleCountDaysA
+		currentSettings.CycleCountDaysB = payload.CycleCountDaysB
+		currentSettings.CycleCountDaysC = payload.CycleCountDaysC
+		currentSettings.CycleCountDailyLimit = payload.CycleCountDailyLimit
+		currentSettings.ForecastMethod = payload.ForecastMethod
+		currentSettings.ForecastWindowDays = payload.ForecastWindowDays
+		currentSettings.ForecastAlpha = payload.ForecastAlpha
+		currentSettings.LeadTimeDays = payload.LeadTimeDays
+		currentSettings.ServiceLevel = payload.ServiceLevel
 
 		if err := config.SaveConfig(currentSettings); err != nil {
 			http.Error(w, "Failed to save settings: "+err.Error(), http.StatusInternalServerError)
@@ -59,6 +85,95 @@ func SaveSettingsHandler(conn *sql.DB) http.HandlerFunc {
 	}
 }
 
+// UsageFormatsHandler はUSAGEファイル形式の一覧取得(GET)と保存(POST)を処理します。
+// GET は標準形式を含む一覧を返し、POST は標準形式以外の形式定義の一覧で設定を置き換えます。
+func UsageFormatsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(parsers.UsageFormats(config.GetConfig().UsageFormats))
+
+		case http.MethodPost:
+			var payload []model.UsageFormat
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			formats := make([]model.UsageFormat, 0, len(payload))
+			for _, f := range payload {
+				if f.BuiltIn {
+					continue
+				}
+				formats = append(formats, f)
+			}
+			if err := parsers.ValidateUsageFormats(formats); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			currentSettings, err := config.LoadConfig()
+			if err != nil {
+				http.Error(w, "Failed to load current settings", http.StatusInternalServerError)
+				return
+			}
+			currentSettings.UsageFormats = formats
+			if err := config.SaveConfig(currentSettings); err != nil {
+				http.Error(w, "Failed to save settings: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "USAGE形式を保存しました。"})
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// NsipsLayoutHandler はNSIPS処方データのレイアウトの取得(GET)と保存(POST)を処理します。
+// GET は設定が無い場合に標準のレイアウトを返し、POST で標準(builtIn)のレイアウトを送ると設定を削除して標準に戻します。
+func NsipsLayoutHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(parsers.ResolveNsipsLayout(config.GetConfig().NsipsLayout))
+
+		case http.MethodPost:
+			var payload model.NsipsLayout
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			var layout *model.NsipsLayout
+			if !payload.BuiltIn {
+				if err := parsers.ValidateNsipsLayout(payload); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				layout = &payload
+			}
+
+			currentSettings, err := config.LoadConfig()
+			if err != nil {
+				http.Error(w, "Failed to load current settings", http.StatusInternalServerError)
+				return
+			}
+			currentSettings.NsipsLayout = layout
+			if err := config.SaveConfig(currentSettings); err != nil {
+				http.Error(w, "Failed to save settings: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "NSIPSレイアウトを保存しました。"})
+
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
 // WholesalersHandler は卸業者に関するリクエストを処理します。
 func WholesalersHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -82,13 +197,33 @@ func WholesalersHandler(conn *sql.DB) http.HandlerFunc {
 				http.Error(w, "Code and Name are required", http.StatusBadRequest)
 				return
 			}
-			if err := db.CreateWholesaler(conn, payload.Code, payload.Name); err != nil {
+			if payload.MinOrderAmount < 0 {
+				http.Error(w, "最低発注金額は0以上で指定してください", http.StatusBadRequest)
+				return
+			}
+			if err := db.CreateWholesaler(conn, payload.Code, payload.Name, payload.MinOrderAmount); err != nil {
 				http.Error(w, "Failed to create wholesaler", http.StatusInternalServerError)
 				return
 			}
 			w.WriteHeader(http.StatusCreated)
 			json.NewEncoder(w).Encode(map[string]string{"message": "卸業者を追加しました。"})
 
+		case http.MethodPut:
+			var payload model.Wholesaler
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if payload.Code == "" || payload.MinOrderAmount < 0 {
+				http.Error(w, "卸コードと0以上の最低発注金額を指定してください", http.StatusBadRequest)
+				return
+			}
+			if err := db.UpdateWholesalerMinOrderAmount(conn, payload.Code, payload.MinOrderAmount); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"message": "最低発注金額を更新しました。"})
+
 		case http.MethodDelete:
 			code := strings.TrimPrefix(r.URL.Path, "/api/settings/wholesalers/")
 			if code == "" {
@@ -115,7 +250,11 @@ func ClearTransactionsHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		if err := db.ClearAllTransactions(conn); err != nil {
+		if err := db.ClearAllTransactions(conn, audit.RequestUser(r)); err != nil {
+			if db.IsPeriodClosedError(err) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
 			http.Error(w, "Failed to clear all transactions: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -132,7 +271,7 @@ func ClearMastersHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
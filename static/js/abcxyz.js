@@ -0,0 +1,153 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\abcxyz.js
+
+let view, outputContainer, matrixContainer, levelSelect, cellSelect;
+let lastAnalysis = null;
+const formatCurrency = (value) => new Intl.NumberFormat('ja-JP', { style: 'currency', currency: 'JPY' }).format(value || 0);
+const formatDate = (s) => (s && s.length === 8) ? `${s.slice(0, 4)}-${s.slice(4, 6)}-${s.slice(6)}` : (s || '');
+const formatQty = (v) => (Math.round((v || 0) * 100) / 100).toLocaleString('ja-JP', { maximumFractionDigits: 2 });
+const formatPercent = (v) => `${((v || 0) * 100).toFixed(1)}%`;
+const ABC_CLASSES = ['A', 'B', 'C'];
+const XYZ_CLASSES = ['X', 'Y', 'Z'];
+
+function buildParams() {
+    return new URLSearchParams({
+        startDate: document.getElementById('abcxyz-start-date').value,
+        endDate: document.getElementById('abcxyz-end-date').value,
+    });
+}
+
+function renderMatrix(result) {
+    const cellOf = (abc, xyz) => result.matrix.find(c => c.abcClass === abc && c.xyzClass === xyz) || { count: 0, usageValue: 0, valueShare: 0 };
+    const rows = ABC_CLASSES.map(abc => `
+        <tr>
+            <th>${abc}</th>
+            ${XYZ_CLASSES.map(xyz => {
+                const cell = cellOf(abc, xyz);
+                return `<td class="right abcxyz-cell" data-cell="${abc}${xyz}" style="cursor: pointer;">
+                    ${cell.count}品目<br>${formatCurrency(cell.usageValue)}<br><span style="font-size: 11px;">${formatPercent(cell.valueShare)}</span>
+                </td>`;
+            }).join('')}
+        </tr>`).join('');
+
+    matrixContainer.innerHTML = `
+        <h3 style="margin: 10px 0 4px;">${formatDate(lastAnalysis.startDate)} ～ ${formatDate(lastAnalysis.endDate)} (${lastAnalysis.weeks}週) 処方金額合計 ${formatCurrency(result.totalValue)}</h3>
+        <table class="data-table" style="width: auto;">
+            <thead>
+                <tr><th></th><th>X (安定)</th><th>Y (変動)</th><th>Z (不規則)</th></tr>
+            </thead>
+            <tbody>${rows}</tbody>
+        </table>`;
+}
+
+function renderItems(result) {
+    const cell = cellSelect.value;
+    const items = cell ? result.items.filter(item => item.abcClass + item.xyzClass === cell) : result.items;
+    if (!items.length) {
+        outputContainer.innerHTML = '<p>該当する品目はありません。</p>';
+        return;
+    }
+    const showPackage = levelSelect.value === 'packages';
+    const rows = items.map(item => `
+        <tr>
+            <td class="center">${item.abcClass}${item.xyzClass}</td>
+            <td class="yj-jump-link" data-yj-code="${item.yjCode}" style="cursor: pointer; text-decoration: underline;">${item.yjCode}</td>
+            <td class="left">${item.productName}</td>
+            ${showPackage ? `<td class="left">${item.packageSpec}</td>` : ''}
+            <td class="right">${formatQty(item.usageQuantity)} ${item.yjUnitName}</td>
+            <td class="right">${formatCurrency(item.usageValue)}</td>
+            <td class="right">${formatPercent(item.cumulativeShare)}</td>
+            <td class="right">${formatQty(item.weeklyMean)}</td>
+            <td class="right">${item.weeklyMean > 0 ? item.cv.toFixed(2) : '-'}</td>
+        </tr>`).join('');
+
+    outputContainer.innerHTML = `
+        <h3 style="margin: 16px 0 4px;">${showPackage ? '包装' : 'YJコード'}別 (${items.length}品目)</h3>
+        <table class="data-table">
+            <thead>
+                <tr><th>区分</th><th>YJコード</th><th>製品名</th>${showPackage ? '<th>包装</th>' : ''}<th>処方数量</th><th>処方金額</th><th>累積構成比</th><th>週平均</th><th>変動係数</th></tr>
+            </thead>
+            <tbody>${rows}</tbody>
+        </table>`;
+}
+
+function render() {
+    if (!lastAnalysis) return;
+    const result = lastAnalysis[levelSelect.value];
+    renderMatrix(result);
+    renderItems(result);
+}
+
+async function runAnalysis() {
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/analysis/abcxyz?${buildParams().toString()}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || 'ABC/XYZ分析に失敗しました。');
+        }
+        lastAnalysis = await res.json();
+        render();
+    } catch (err) {
+        matrixContainer.innerHTML = '';
+        outputContainer.innerHTML = `<p style="color:red;">${err.message}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+async function saveClassifications() {
+    if (!confirm('この期間の包装単位の分類を各製品に保存します。よろしいですか？')) return;
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/analysis/abcxyz/save?${buildParams().toString()}`, { method: 'POST' });
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '分類の保存に失敗しました。');
+        }
+        const result = await res.json();
+        window.showNotification(result.message, 'success');
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+    }
+}
+
+export function initAbcXyzView() {
+    view = document.getElementById('abcxyz-view');
+    if (!view) return;
+    outputContainer = document.getElementById('abcxyz-output-container');
+    matrixContainer = document.getElementById('abcxyz-matrix-container');
+    levelSelect = document.getElementById('abcxyz-level');
+    cellSelect = document.getElementById('abcxyz-cell');
+
+    ABC_CLASSES.forEach(abc => XYZ_CLASSES.forEach(xyz => {
+        cellSelect.insertAdjacentHTML('beforeend', `<option value="${abc}${xyz}">${abc}${xyz}</option>`);
+    }));
+
+    const today = new Date();
+    const from = new Date(today.getFullYear(), today.getMonth(), today.getDate() - 181);
+    const toDateString = (d) => `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
+    document.getElementById('abcxyz-start-date').value = toDateString(from);
+    document.getElementById('abcxyz-end-date').value = toDateString(today);
+
+    document.getElementById('run-abcxyz-btn').addEventListener('click', runAnalysis);
+    document.getElementById('save-abcxyz-btn').addEventListener('click', saveClassifications);
+    document.getElementById('export-abcxyz-btn').addEventListener('click', () => {
+        window.location.href = `/api/analysis/abcxyz/export?${buildParams().toString()}`;
+    });
+    levelSelect.addEventListener('change', render);
+    cellSelect.addEventListener('change', () => lastAnalysis && renderItems(lastAnalysis[levelSelect.value]));
+
+    matrixContainer.addEventListener('click', (e) => {
+        const cell = e.target.closest('.abcxyz-cell');
+        if (!cell) return;
+        cellSelect.value = cellSelect.value === cell.dataset.cell ? '' : cell.dataset.cell;
+        renderItems(lastAnalysis[levelSelect.value]);
+    });
+    outputContainer.addEventListener('click', (e) => {
+        const link = e.target.closest('.yj-jump-link');
+        if (!link) return;
+        document.dispatchEvent(new CustomEvent('navigateToInventoryAdjustment', { detail: { yjCode: link.dataset.yjCode } }));
+    });
+}
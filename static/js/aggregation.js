@@ -1,6 +1,6 @@
 // C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\aggregation.js
 
-import { hiraganaToKatakana, getLocalDateString } from './utils.js';
+import { hiraganaToKatakana, getLocalDateString, describeForecast } from './utils.js';
 import { transactionTypeMap, createUploadTableHTML, renderUploadTableRows } from './common_table.js';
 
 let view, runBtn, printBtn, outputContainer, kanaNameInput, dosageFormInput, coefficientInput, drugTypeCheckboxes, reorderNeededCheckbox, movementOnlyCheckbox;
@@ -65,6 +65,7 @@ function renderResults() {
                         発注点: ${pkgReorderPointText} |  
                         変動: ${formatBalance(pkg.netChange)}
                     </span>
+                    ${pkg.forecast ? `<span class="balance-info" style="font-size: 11px;">発注点の計算: ${describeForecast(pkg.forecast)}</span>` : ''}
                 </div>
             `;
             const tableShell = createUploadTableHTML(tableId);
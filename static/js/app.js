@@ -27,6 +27,14 @@ import { initValuationView } from './valuation.js';
 import { initPricingView } from './pricing.js';
 import { initReturnsView } from './returns.js';
 import { initEdge } from './edge.js';
+import { resumeRunningJobs } from './jobs.js';
+import { initExpiryView, loadExpiryAlertWidget } from './expiry.js';
+import { initAuditUser, initAuditView } from './audit.js';
+import { initControlledView } from './controlled.js';
+import { initVarianceView } from './variance.js';
+import { initCycleCountView } from './cyclecount.js';
+import { initAbcXyzView } from './abcxyz.js';
+import { initReplenishmentPolicies } from './replenishment.js';
 
 window.showLoading = (message = '処理中...') => {
     const overlay = document.getElementById('loading-overlay');
@@ -49,6 +57,8 @@ window.showNotification = (message, type = 'success') => {
 };
 document.addEventListener('DOMContentLoaded', async () => {
     
+    // 監査ログの操作者を記録するため、最初に全てのリクエストへ担当者名を付ける
+    initAuditUser();
     await loadMasterData();
     const allViews = document.querySelectorAll('main > div[id$="-view"]');
     const inOutBtn = document.getElementById('inOutViewBtn');
@@ -82,6 +92,12 @@ document.addEventListener('DOMContentLoaded', async () => {
     const valuationBtn = document.getElementById('valuationBtn');
     const pricingBtn = document.getElementById('pricingBtn');
     const returnsBtn = document.getElementById('returnsBtn');
+    const expiryBtn = document.getElementById('expiryBtn');
+    const auditBtn = document.getElementById('auditBtn');
+    const controlledBtn = document.getElementById('controlledBtn');
+    const varianceBtn = document.getElementById('varianceBtn');
+    const cycleCountBtn = document.getElementById('cycleCountBtn');
+    const abcXyzBtn = document.getElementById('abcXyzBtn');
     
     document.querySelectorAll('input[type="text"], input[type="password"], input[type="number"], input[type="date"]').forEach(input => {
         input.setAttribute('autocomplete', 'off');
@@ -113,6 +129,16 @@ document.addEventListener('DOMContentLoaded', async () => {
     initPricingView();
     initReturnsView();
     initEdge();
+    initExpiryView();
+    initAuditView();
+    initControlledView();
+    initVarianceView();
+    initCycleCountView();
+    initAbcXyzView();
+    initReplenishmentPolicies();
+
+    // 画面の再読み込み前に開始した処理が実行中なら、進捗の表示を再開する
+    resumeRunningJobs();
 
     function showView(viewIdToShow) {
         const notificationBox = document.getElementById('notification-box');
@@ -143,10 +169,16 @@ document.addEventListener('DOMContentLoaded', async () => {
         document.getElementById('master-edit-view').dispatchEvent(event);
     });
     // ▲▲▲【追加ここまで】▲▲▲ 
-    inOutBtn.addEventListener('click', () => { showView('in-out-view'); resetInOutView(); });
+    // 期限切迫の警告表示から一覧画面への遷移イベントを捕捉する
+    document.addEventListener('navigateToExpiry', (e) => {
+        showView('expiry-view');
+        document.getElementById('expiry-view').dispatchEvent(new CustomEvent('show', { detail: e.detail }));
+    });
+    inOutBtn.addEventListener('click', () => { showView('in-out-view'); resetInOutView(); loadExpiryAlertWidget(); });
     datBtn.addEventListener('click', () => {
         showView('upload-view');
         document.getElementById('upload-view-title').textContent = `DAT File Upload`;
+        document.getElementById('dat-upload-options').classList.remove('hidden');
         if (uploadOutputContainer) uploadOutputContainer.innerHTML = '';
         datFileInput.click();
     });
@@ -154,6 +186,7 @@ document.addEventListener('DOMContentLoaded', async () => {
     usageBtn.addEventListener('click', async () => {
         showView('upload-view');
         document.getElementById('upload-view-title').textContent = `USAGE File Import`;
+        document.getElementById('dat-upload-options').classList.add('hidden');
         
         try {
             const res = await fetch('/api/config/usage_path');
@@ -219,7 +252,23 @@ document.addEventListener('DOMContentLoaded', async () => {
         document.getElementById('pricing-view').dispatchEvent(new Event('show'));
     });
     returnsBtn.addEventListener('click', () => showView('returns-view'));
+    expiryBtn.addEventListener('click', () => {
+        showView('expiry-view');
+        document.getElementById('expiry-view').dispatchEvent(new Event('show'));
+    });
+    controlledBtn.addEventListener('click', () => showView('controlled-view'));
+    varianceBtn.addEventListener('click', () => showView('variance-view'));
+    cycleCountBtn.addEventListener('click', () => {
+        showView('cyclecount-view');
+        document.getElementById('cyclecount-view').dispatchEvent(new Event('show'));
+    });
+    abcXyzBtn.addEventListener('click', () => showView('abcxyz-view'));
+    auditBtn.addEventListener('click', () => {
+        showView('audit-view');
+        document.getElementById('audit-view').dispatchEvent(new Event('show'));
+    });
     
     showView('in-out-view');
     resetInOutView();
+    loadExpiryAlertWidget();
 });
\ No newline at end of file
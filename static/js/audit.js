@@ -0,0 +1,122 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\audit.js
+
+import { escapeHtml } from './utils.js';
+
+const OPERATOR_NAME_KEY = 'wasabi.operatorName';
+const ENTITY_LABELS = {
+    product_master: '製品マスター',
+    transaction_records: '取引データ',
+    backorders: '発注残',
+    precomp_records: '予製',
+    dead_stock_list: 'デッドストック',
+    replenishment_policies: '補充条件',
+};
+const ACTION_LABELS = { INSERT: '登録', UPDATE: '変更', DELETE: '削除' };
+
+let view, outputContainer;
+
+export function getOperatorName() {
+    return localStorage.getItem(OPERATOR_NAME_KEY) || '';
+}
+
+/**
+ * 全てのリクエストに、この端末で設定した担当者名を付けます。
+ * サーバーは監査ログの操作者としてこの名前を記録します (未設定の場合は接続元の端末)。
+ */
+export function initAuditUser() {
+    const originalFetch = window.fetch.bind(window);
+    window.fetch = (input, init = {}) => {
+        const name = getOperatorName();
+        if (!name) return originalFetch(input, init);
+        const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+        headers.set('X-Wasabi-User', encodeURIComponent(name));
+        return originalFetch(input, { ...init, headers });
+    };
+
+    const operatorInput = document.getElementById('operatorName');
+    if (operatorInput) {
+        operatorInput.value = getOperatorName();
+        operatorInput.addEventListener('change', () => {
+            localStorage.setItem(OPERATOR_NAME_KEY, operatorInput.value.trim());
+            window.showNotification('この端末の担当者名を保存しました。', 'success');
+        });
+    }
+}
+
+function describeChanges(entry) {
+    const parse = (s) => { try { return s ? JSON.parse(s) : null; } catch { return null; } };
+    const before = parse(entry.beforeJson);
+    const after = parse(entry.afterJson);
+    if (entry.action === 'UPDATE' && before && after) {
+        return Object.keys(after)
+            .filter(key => String(before[key]) !== String(after[key]))
+            .map(key => escapeHtml(`${key}: ${before[key] ?? '(なし)'} → ${after[key] ?? '(なし)'}`))
+            .join('<br>');
+    }
+    const row = after || before;
+    if (!row) return '';
+    const name = row.product_name ? ` ${row.product_name}` : '';
+    const slip = row.receipt_number ? ` 伝票:${row.receipt_number}` : '';
+    return escapeHtml(`${row.transaction_date || row.order_date || ''}${slip}${name}`.trim());
+}
+
+function buildParams() {
+    const params = new URLSearchParams();
+    const fields = { entity: 'audit-entity', key: 'audit-key', action: 'audit-action', user: 'audit-user', keyword: 'audit-keyword', from: 'audit-from', to: 'audit-to' };
+    for (const [param, id] of Object.entries(fields)) {
+        const value = document.getElementById(id).value.trim();
+        if (value) params.set(param, value);
+    }
+    return params;
+}
+
+async function runSearch() {
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/audit?${buildParams().toString()}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '監査ログの取得に失敗しました。');
+        }
+        const entries = await res.json();
+        if (entries.length === 0) {
+            outputContainer.innerHTML = '<p>該当する監査ログはありません。</p>';
+            return;
+        }
+        outputContainer.innerHTML = `<table class="data-table">
+            <thead>
+                <tr><th>日時</th><th>対象</th><th>キー</th><th>操作</th><th>操作者</th><th>内容</th></tr>
+            </thead>
+            <tbody>${entries.map(e => `
+                <tr>
+                    <td class="center">${escapeHtml(e.createdAt)}</td>
+                    <td class="left">${ENTITY_LABELS[e.entity] || escapeHtml(e.entity)}</td>
+                    <td class="left">${escapeHtml(e.entityKey)}</td>
+                    <td class="center">${ACTION_LABELS[e.action] || escapeHtml(e.action)}</td>
+                    <td class="left">${escapeHtml(e.userName)}</td>
+                    <td class="left" style="font-size: 11px;">${describeChanges(e)}</td>
+                </tr>`).join('')}
+            </tbody>
+        </table>`;
+    } catch (err) {
+        outputContainer.innerHTML = `<p style="color:red;">${escapeHtml(err.message)}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+export function initAuditView() {
+    view = document.getElementById('audit-view');
+    if (!view) return;
+    outputContainer = document.getElementById('audit-output-container');
+
+    const entitySelect = document.getElementById('audit-entity');
+    entitySelect.innerHTML = '<option value="">すべて</option>' +
+        Object.entries(ENTITY_LABELS).map(([value, label]) => `<option value="${value}">${label}</option>`).join('');
+
+    document.getElementById('run-audit-btn').addEventListener('click', runSearch);
+    document.getElementById('export-audit-btn').addEventListener('click', () => {
+        window.location.href = `/api/audit/export?${buildParams().toString()}`;
+    });
+    view.addEventListener('show', runSearch);
+}
@@ -1,6 +1,37 @@
 // C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\backorder.js
 
-let view, outputContainer;
+import { purchaseOrderLinks, getLocalDateString, escapeHtml } from './utils.js';
+
+let view, outputContainer, statusFilter, agingDaysInput, agingOutput;
+
+const STATUS_LABELS = {
+    ordered: '発注済み',
+    partial: '一部納品',
+    received: '納品完了',
+    cancelled: '取消',
+    out_of_stock: '欠品',
+};
+
+function isOpen(bo) {
+    return bo.status === 'ordered' || bo.status === 'partial';
+}
+
+function formatDate(yyyymmdd) {
+    if (!yyyymmdd || yyyymmdd.length !== 8) return yyyymmdd || '';
+    return `${yyyymmdd.slice(0, 4)}-${yyyymmdd.slice(4, 6)}-${yyyymmdd.slice(6)}`;
+}
+
+// 状態と、消し込んだ納品データ (DATの伝票) を表示する
+function statusCellHTML(bo) {
+    let html = STATUS_LABELS[bo.status] || escapeHtml(bo.status);
+    if (bo.statusReason) {
+        html += `<br><small>${escapeHtml(bo.statusReason)}</small>`;
+    }
+    (bo.fulfillments || []).forEach(f => {
+        html += `<br><small title="卸: ${escapeHtml(f.clientCode)} / JAN: ${escapeHtml(f.janCode)}">納品 ${escapeHtml(formatDate(f.transactionDate))} 伝票${escapeHtml(f.receiptNumber)}-${escapeHtml(f.lineNumber)} (${f.quantity})</small>`;
+    });
+    return html;
+}
 
 // ▼▼▼【ここから修正】▼▼▼
 function renderBackorders(data) {
@@ -11,19 +42,22 @@ function renderBackorders(data) {
 
     let html = `
         <div class="controls-grid" style="margin-bottom: 10px; display: flex; gap: 10px;">
-            <button class="btn" id="bulk-delete-backorder-btn" style="background-color: #dc3545; color: white;">選択した項目を一括削除</button>
-            <button class="btn" id="delete-all-backorder-btn">表示されている項目を全て削除</button>
+            <button class="btn" id="bulk-delete-backorder-btn" style="background-color: #dc3545; color: white;">選択した項目を一括取消</button>
+            <button class="btn" id="delete-all-backorder-btn">表示されている項目を全て取消</button>
         </div>
         <table class="data-table">
             <thead>
                 <tr>
                     <th style="width: 5%;"><input type="checkbox" id="select-all-backorders-checkbox"></th>
                     <th style="width: 10%;">発注日</th>
-                    <th style="width: 10%;">YJコード</th>
-                    <th style="width: 25%;">製品名</th>
-                    <th style="width: 20%;">包装仕様</th>
-                    <th style="width: 10%;">発注数量</th>
-                    <th style="width: 10%;">残数量</th>
+                    <th style="width: 8%;">YJコード</th>
+                    <th style="width: 17%;">製品名</th>
+                    <th style="width: 12%;">包装仕様</th>
+                    <th style="width: 6%;">発注数量</th>
+                    <th style="width: 6%;">残数量</th>
+                    <th style="width: 12%;">状態</th>
+                    <th style="width: 9%;">納品予定日</th>
+                    <th style="width: 10%;">発注書</th>
                     <th style="width: 10%;">個別操作</th>
                 </tr>
             </thead>
@@ -32,17 +66,28 @@ function renderBackorders(data) {
 
     data.forEach(bo => {
         const pkgSpec = bo.formattedPackageSpec;
+        const open = isOpen(bo);
+        const overdue = open && bo.expectedDate && bo.expectedDate < getLocalDateString().replace(/-/g, '');
+        const expectedCell = open
+            ? `<input type="date" class="backorder-expected-date" value="${formatDate(bo.expectedDate)}"${overdue ? ' style="color: red;"' : ''}>`
+            : formatDate(bo.expectedDate);
+        const actions = open
+            ? `<button class="btn delete-backorder-btn">取消</button> <button class="btn out-of-stock-backorder-btn">欠品</button>`
+            : (bo.status === 'received' ? '' : `<button class="btn reopen-backorder-btn">再開</button>`);
 
         html += `
             <tr data-id="${bo.id}">
-                <td class="center"><input type="checkbox" class="backorder-select-checkbox"></td>
+                <td class="center">${open ? '<input type="checkbox" class="backorder-select-checkbox">' : ''}</td>
                 <td>${bo.orderDate}</td>
                 <td>${bo.yjCode}</td>
                 <td class="left">${bo.productName}</td>
                 <td class="left">${pkgSpec}</td>
                 <td class="right">${bo.orderQuantity.toFixed(2)}</td>
                 <td class="right">${bo.remainingQuantity.toFixed(2)}</td>
-                <td class="center"><button class="btn delete-backorder-btn">削除</button></td>
+                <td class="left">${statusCellHTML(bo)}</td>
+                <td>${expectedCell}</td>
+                <td>${bo.poNumber ? `${escapeHtml(bo.poNumber)}<br>${purchaseOrderLinks(bo.poNumber)}` : ''}</td>
+                <td class="center">${actions}</td>
             </tr>
         `;
     });
@@ -53,7 +98,7 @@ function renderBackorders(data) {
 async function loadAndRenderBackorders() {
     outputContainer.innerHTML = '<p>読み込み中...</p>';
     try {
-        const res = await fetch('/api/backorders');
+        const res = await fetch(`/api/backorders?status=${encodeURIComponent(statusFilter.value)}`);
         if (!res.ok) throw new Error('発注残リストの読み込みに失敗しました。');
         const data = await res.json();
         renderBackorders(data);
@@ -65,14 +110,30 @@ async function loadAndRenderBackorders() {
 async function handleBackorderEvents(e) {
     const target = e.target;
 
-    // 個別削除ボタン
+    // 欠品・再開ボタン
+    if (target.classList.contains('out-of-stock-backorder-btn') || target.classList.contains('reopen-backorder-btn')) {
+        const row = target.closest('tr');
+        const payload = { id: parseInt(row.dataset.id, 10), status: 'ordered' };
+        if (target.classList.contains('out-of-stock-backorder-btn')) {
+            const reason = prompt(`「${row.cells[3].textContent}」を卸の欠品にします。理由を入力してください（出荷調整など）。`);
+            if (reason === null) return;
+            payload.status = 'out_of_stock';
+            payload.reason = reason;
+        }
+        await updateBackorder(payload);
+        return;
+    }
+
+    // 個別取消ボタン
     if (target.classList.contains('delete-backorder-btn')) {
         const row = target.closest('tr');
-        if (!confirm(`「${row.cells[3].textContent}」の発注残（発注日: ${row.cells[1].textContent}）を削除しますか？`)) {
+        const reason = prompt(`「${row.cells[3].textContent}」の発注残（発注日: ${row.cells[1].textContent}）を取り消します。取消理由を入力してください。`);
+        if (reason === null) {
             return;
         }
         const payload = {
             id: parseInt(row.dataset.id, 10),
+            reason: reason,
         };
         window.showLoading();
         try {
@@ -82,7 +143,7 @@ async function handleBackorderEvents(e) {
                 body: JSON.stringify(payload),
             });
             const resData = await res.json();
-            if (!res.ok) throw new Error(resData.message || '削除に失敗しました。');
+            if (!res.ok) throw new Error(resData.message || '取消に失敗しました。');
             window.showNotification(resData.message, 'success');
             loadAndRenderBackorders();
         } catch (err) {
@@ -98,21 +159,22 @@ async function handleBackorderEvents(e) {
         document.querySelectorAll('.backorder-select-checkbox').forEach(cb => cb.checked = isChecked);
     }
 
-    // 全件削除ボタン
+    // 全件取消ボタン
     if (target.id === 'delete-all-backorder-btn') {
         document.getElementById('select-all-backorders-checkbox').checked = true;
         document.querySelectorAll('.backorder-select-checkbox').forEach(cb => cb.checked = true);
         document.getElementById('bulk-delete-backorder-btn').click(); // 一括削除ボタンのクリックを擬似的に発火
     }
 
-    // 選択項目の一括削除ボタン
+    // 選択項目の一括取消ボタン
     if (target.id === 'bulk-delete-backorder-btn') {
         const checkedRows = document.querySelectorAll('.backorder-select-checkbox:checked');
         if (checkedRows.length === 0) {
-            window.showNotification('削除する項目が選択されていません。', 'error');
+            window.showNotification('取り消す項目が選択されていません。', 'error');
             return;
         }
-        if (!confirm(`${checkedRows.length}件の発注残を削除します。よろしいですか？`)) {
+        const reason = prompt(`${checkedRows.length}件の発注残を取り消します。取消理由を入力してください。`);
+        if (reason === null) {
             return;
         }
 
@@ -120,6 +182,7 @@ async function handleBackorderEvents(e) {
             const row = cb.closest('tr');
             return {
                 id: parseInt(row.dataset.id, 10),
+                reason: reason,
             };
         });
 
@@ -131,7 +194,7 @@ async function handleBackorderEvents(e) {
                 body: JSON.stringify(payload),
             });
             const resData = await res.json();
-            if (!res.ok) throw new Error(resData.message || '一括削除に失敗しました。');
+            if (!res.ok) throw new Error(resData.message || '一括取消に失敗しました。');
             window.showNotification(resData.message, 'success');
             loadAndRenderBackorders();
         } catch (err) {
@@ -142,12 +205,85 @@ async function handleBackorderEvents(e) {
     }
 }
 
+async function updateBackorder(payload) {
+    window.showLoading();
+    try {
+        const res = await fetch('/api/backorders/update', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload),
+        });
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '発注残の更新に失敗しました。');
+        }
+        const resData = await res.json();
+        window.showNotification(resData.message, 'success');
+        loadAndRenderBackorders();
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+    }
+}
+
+// 発注から指定日数を超えて未完了の発注残を卸ごとに表示する
+async function loadAgingReport() {
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/backorders/aging?days=${encodeURIComponent(agingDaysInput.value)}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '経過日数レポートの作成に失敗しました。');
+        }
+        const report = await res.json();
+        if (report.groups.length === 0) {
+            agingOutput.innerHTML = `<p>発注から${report.days}日を超えて未完了の発注残はありません。</p>`;
+            return;
+        }
+        let html = `<p>発注から${report.days}日を超えて未完了の発注残 (基準日: ${formatDate(report.date)})</p>
+            <table class="data-table" style="margin-bottom: 10px;">
+                <thead><tr><th>卸</th><th>件数</th><th>納品予定日超過</th><th>最も古い発注日</th><th>最大経過日数</th><th>製品</th></tr></thead>
+                <tbody>`;
+        report.groups.forEach(g => {
+            const items = g.items.map(item =>
+                `<span${item.overdue ? ' style="color: red;"' : ''}>${item.productName} (${item.daysOpen}日)</span>`).join('<br>');
+            html += `<tr>
+                <td class="left">${g.wholesalerName || g.wholesalerCode || '卸未指定'}</td>
+                <td class="right">${g.count}</td>
+                <td class="right">${g.overdueCount}</td>
+                <td>${formatDate(g.oldestOrderDate)}</td>
+                <td class="right">${g.maxDaysOpen}日</td>
+                <td class="left">${items}</td>
+            </tr>`;
+        });
+        html += `</tbody></table>`;
+        agingOutput.innerHTML = html;
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+    }
+}
+
 export function initBackorderView() {
     view = document.getElementById('backorder-view');
     if (!view) return;
     outputContainer = document.getElementById('backorder-output-container');
+    statusFilter = document.getElementById('backorder-status-filter');
+    agingDaysInput = document.getElementById('backorder-aging-days');
+    agingOutput = document.getElementById('backorder-aging-output');
     
     view.addEventListener('show', loadAndRenderBackorders);
     outputContainer.addEventListener('click', handleBackorderEvents);
+    statusFilter.addEventListener('change', loadAndRenderBackorders);
+    document.getElementById('backorder-aging-btn').addEventListener('click', loadAgingReport);
+
+    // 納品予定日の変更はその場で保存する
+    outputContainer.addEventListener('change', (e) => {
+        if (!e.target.classList.contains('backorder-expected-date')) return;
+        const row = e.target.closest('tr');
+        updateBackorder({ id: parseInt(row.dataset.id, 10), expectedDate: e.target.value });
+    });
 }
 // ▲▲▲【修正ここまで】▲▲▲
\ No newline at end of file
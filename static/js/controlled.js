@@ -0,0 +1,232 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\controlled.js
+
+import { escapeHtml } from './utils.js';
+
+let view, outputContainer;
+const formatDate = (s) => (s && s.length === 8) ? `${s.slice(0, 4)}-${s.slice(4, 6)}-${s.slice(6)}` : (s || '');
+const formatQty = (v) => (Math.round((v || 0) * 1000) / 1000).toLocaleString('ja-JP', { maximumFractionDigits: 3 });
+const NOTE_CATEGORIES = { '': '(取引種別のまま)', receipt: '受入', dispense: '施用・交付', transfer: '譲渡', disposal: '廃棄', adjust: '調整' };
+
+function buildParams() {
+    const drugTypes = [...view.querySelectorAll('input[name="controlledDrugType"]:checked')].map(cb => cb.value);
+    const params = new URLSearchParams({
+        drugTypes: drugTypes.join(','),
+        startDate: document.getElementById('controlled-start-date').value,
+        endDate: document.getElementById('controlled-end-date').value,
+        productCode: document.getElementById('controlled-product-code').value.trim(),
+        byLot: document.getElementById('controlled-by-lot').checked ? 'true' : 'false',
+    });
+    return params;
+}
+
+function renderRegisters(registers) {
+    if (!registers.length) {
+        outputContainer.innerHTML = '<p>対象期間に記載する取引はありません。</p>';
+        return;
+    }
+    outputContainer.innerHTML = registers.map(reg => {
+        const lot = reg.lotNumber || reg.expiryDate ? ` / 製造番号: ${reg.lotNumber || '(不明)'} 期限: ${reg.expiryDate || ''}` : '';
+        const warning = reg.discrepancyCount > 0 ? ` <span style="color: red; font-weight: bold;">実在庫との差異 ${reg.discrepancyCount}件</span>` : '';
+        const rows = reg.entries.map(e => {
+            let remarks = escapeHtml(e.note);
+            let style = '';
+            if (e.category === 'inventory') {
+                remarks = `帳簿 ${formatQty(e.bookBalance)}`;
+                if (e.discrepancy !== 0) {
+                    remarks += ` 差異 ${e.discrepancy > 0 ? '+' : ''}${formatQty(e.discrepancy)}`;
+                    style = ' style="color: red; font-weight: bold;"';
+                }
+            } else if (e.witness) {
+                remarks = `立会 ${escapeHtml(e.witness)} ${remarks}`;
+            }
+            // 記載は伝票キーで保存するため、伝票番号の無い取引 (USAGEの処方データ) には記載できない
+            const action = (e.category !== 'inventory' && !e.closed && e.receiptNumber)
+                ? `<button class="btn edit-controlled-note-btn" data-id="${e.transactionId}" data-category="${escapeHtml(e.category)}" data-witness="${escapeHtml(e.witness)}" data-note="${escapeHtml(e.note)}">記載</button>`
+                : (e.closed ? '締め済' : '');
+            return `<tr${style}>
+                <td class="center">${formatDate(e.date)}</td>
+                <td class="center">${e.categoryLabel}</td>
+                <td class="left">${e.clientName || e.clientCode || ''}</td>
+                <td class="left">${e.receiptNumber || ''}</td>
+                <td class="left">${e.lotNumber || ''}</td>
+                <td class="right">${e.received ? formatQty(e.received) : ''}</td>
+                <td class="right">${e.issued ? formatQty(e.issued) : ''}</td>
+                <td class="right">${formatQty(e.balance)}</td>
+                <td class="left">${remarks}</td>
+                <td class="center">${action}</td>
+            </tr>`;
+        }).join('');
+        return `<h3 style="margin: 16px 0 4px;">${reg.productName} <small>(${reg.drugClass} / ${reg.packageSpec} / 単位: ${reg.yjUnitName}${lot})</small>${warning}</h3>
+        <table class="data-table">
+            <thead>
+                <tr><th>年月日</th><th>区分</th><th>相手方・患者</th><th>伝票番号</th><th>製造番号</th><th>受入</th><th>払出</th><th>残高</th><th>備考</th><th>操作</th></tr>
+            </thead>
+            <tbody>
+                <tr><td></td><td class="center">前期繰越</td><td colspan="5"></td><td class="right">${formatQty(reg.openingBalance)}</td><td colspan="2"></td></tr>
+                ${rows}
+            </tbody>
+            <tfoot>
+                <tr>
+                    <td colspan="5" class="right" style="font-weight: bold;">合計</td>
+                    <td class="right" style="font-weight: bold;">${formatQty(reg.totalReceived)}</td>
+                    <td class="right" style="font-weight: bold;">${formatQty(reg.totalIssued)}</td>
+                    <td class="right" style="font-weight: bold;">${formatQty(reg.closingBalance)}</td>
+                    <td colspan="2"></td>
+                </tr>
+            </tfoot>
+        </table>`;
+    }).join('');
+}
+
+async function runRegister() {
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/controlled/register?${buildParams().toString()}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '帳簿の取得に失敗しました。');
+        }
+        renderRegisters(await res.json());
+    } catch (err) {
+        outputContainer.innerHTML = `<p style="color:red;">${err.message}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+async function editNote(button) {
+    const options = Object.entries(NOTE_CATEGORIES).filter(([key]) => key).map(([key, label]) => `${key}: ${label}`).join('\n');
+    const category = prompt(`区分を入力してください (空欄で取引種別のまま)\n${options}`, button.dataset.category);
+    if (category === null) return;
+    if (!(category.trim() in NOTE_CATEGORIES)) {
+        window.showNotification('区分が不正です。', 'error');
+        return;
+    }
+    const witness = prompt('立会人 (廃棄の場合は必須)', button.dataset.witness);
+    if (witness === null) return;
+    if (category.trim() === 'disposal' && !witness.trim()) {
+        window.showNotification('廃棄の場合は立会人を入力してください。', 'error');
+        return;
+    }
+    const note = prompt('備考', button.dataset.note);
+    if (note === null) return;
+
+    try {
+        const res = await fetch('/api/controlled/notes', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ transactionId: Number(button.dataset.id), category: category.trim(), witness, note }),
+        });
+        if (!res.ok) throw new Error(await res.text());
+        window.showNotification('帳簿の記載を保存しました。', 'success');
+        runRegister();
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    }
+}
+
+// 伝票の削除などで取引に対応しなくなった帳簿の記載は集計に含まれないため、届出前に確認できるよう一覧にする
+function renderUnresolvedNotes(notes) {
+    if (!notes || !notes.length) return '';
+    const rows = notes.map(n => `
+        <tr>
+            <td class="center">${formatDate(n.transactionDate)}</td>
+            <td class="left">${escapeHtml(n.clientCode)}</td>
+            <td class="left">${escapeHtml(n.receiptNumber)}</td>
+            <td class="center">${escapeHtml(n.lineNumber)}</td>
+            <td class="left">${escapeHtml(n.janCode)}</td>
+            <td class="center">${NOTE_CATEGORIES[n.category] || escapeHtml(n.category)}</td>
+            <td class="left">${escapeHtml(n.witness)}</td>
+            <td class="left">${escapeHtml(n.note)}</td>
+        </tr>`).join('');
+    return `<p style="color: red; font-weight: bold;">取引に対応しない帳簿の記載が${notes.length}件あります (集計に含まれていません)。伝票の修正・削除を確認してください。</p>
+    <table class="data-table">
+        <thead><tr><th>日付</th><th>取引先</th><th>伝票番号</th><th>行</th><th>JAN</th><th>区分</th><th>立会人</th><th>備考</th></tr></thead>
+        <tbody>${rows}</tbody>
+    </table>`;
+}
+
+function renderAnnualReport(report) {
+    const container = document.getElementById('narcotic-report-container');
+    const warning = renderUnresolvedNotes(report.unresolvedNotes);
+    if (!report.items.length) {
+        container.innerHTML = warning + '<p>期間内に記載する麻薬の取引・在庫はありません。</p>';
+        return;
+    }
+    container.innerHTML = warning + `<table class="data-table">
+        <thead>
+            <tr><th>品名</th><th>規格・包装</th><th>単位</th><th>年初在庫</th><th>受入</th><th>施用・交付</th><th>譲渡</th><th>廃棄</th><th>調整</th><th>年末在庫</th></tr>
+        </thead>
+        <tbody>${report.items.map(item => `
+            <tr>
+                <td class="left">${item.productName}</td>
+                <td class="left">${item.packageSpec}</td>
+                <td class="center">${item.yjUnitName}</td>
+                <td class="right">${formatQty(item.opening)}</td>
+                <td class="right">${formatQty(item.received)}</td>
+                <td class="right">${formatQty(item.dispensed)}</td>
+                <td class="right">${formatQty(item.transferred)}</td>
+                <td class="right">${formatQty(item.disposed)}</td>
+                <td class="right"${item.adjusted !== 0 ? ' style="color: red;"' : ''}>${formatQty(item.adjusted)}</td>
+                <td class="right">${formatQty(item.closing)}</td>
+            </tr>`).join('')}
+        </tbody>
+    </table>`;
+}
+
+async function runAnnualReport() {
+    window.showLoading();
+    try {
+        const year = document.getElementById('narcotic-report-year').value;
+        const res = await fetch(`/api/controlled/annual?year=${year}`);
+        if (!res.ok) throw new Error(await res.text() || '麻薬年間届の集計に失敗しました。');
+        renderAnnualReport(await res.json());
+    } catch (err) {
+        document.getElementById('narcotic-report-container').innerHTML = `<p style="color:red;">${err.message}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+function initAnnualReport() {
+    const yearSelect = document.getElementById('narcotic-report-year');
+    if (!yearSelect) return;
+    const today = new Date();
+    // 10月以降は今年の9月30日までの期間、それより前は前年の期間が直近の届出となる
+    const latest = today.getMonth() >= 9 ? today.getFullYear() : today.getFullYear() - 1;
+    const years = Array.from({ length: 5 }, (_, i) => latest - i);
+    yearSelect.innerHTML = years.map(y => `<option value="${y}">${y - 1}年10月1日 ～ ${y}年9月30日</option>`).join('');
+
+    document.getElementById('run-narcotic-report-btn').addEventListener('click', runAnnualReport);
+    document.getElementById('export-narcotic-report-btn').addEventListener('click', () => {
+        window.location.href = `/api/controlled/annual/export?year=${yearSelect.value}`;
+    });
+    document.getElementById('export-narcotic-report-pdf-btn').addEventListener('click', () => {
+        window.open(`/api/controlled/annual/export_pdf?year=${yearSelect.value}`, '_blank');
+    });
+    document.getElementById('export-narcotic-report-csv-btn').addEventListener('click', () => {
+        window.location.href = `/api/controlled/annual/export_csv?year=${yearSelect.value}`;
+    });
+}
+
+export function initControlledView() {
+    view = document.getElementById('controlled-view');
+    if (!view) return;
+    outputContainer = document.getElementById('controlled-output-container');
+
+    const today = new Date();
+    const first = new Date(today.getFullYear(), today.getMonth(), 1);
+    const toDateString = (d) => `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
+    document.getElementById('controlled-start-date').value = toDateString(first);
+    document.getElementById('controlled-end-date').value = toDateString(today);
+
+    document.getElementById('run-controlled-btn').addEventListener('click', runRegister);
+    document.getElementById('export-controlled-pdf-btn').addEventListener('click', () => {
+        window.open(`/api/controlled/export_pdf?${buildParams().toString()}`, '_blank');
+    });
+    outputContainer.addEventListener('click', (e) => {
+        const button = e.target.closest('.edit-controlled-note-btn');
+        if (button) editNote(button);
+    });
+    initAnnualReport();
+}
@@ -0,0 +1,108 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\cyclecount.js
+
+let view, dateInput, outputContainer, summaryContainer;
+const formatDate = (s) => (s && s.length === 8) ? `${s.slice(0, 4)}-${s.slice(4, 6)}-${s.slice(6)}` : (s || '');
+
+function renderSummary(data) {
+    summaryContainer.innerHTML = `<table class="data-table" style="width: auto; margin-bottom: 10px;">
+        <thead>
+            <tr><th>分類</th><th>棚卸間隔</th><th>品目数</th><th>期限超過</th><th>うち未実施</th></tr>
+        </thead>
+        <tbody>${data.summary.map(s => `
+            <tr>
+                <td class="center">${s.abcClass}</td>
+                <td class="right">${s.intervalDays}日</td>
+                <td class="right">${s.groupCount}</td>
+                <td class="right"${s.overdueCount > 0 ? ' style="color: red; font-weight: bold;"' : ''}>${s.overdueCount}</td>
+                <td class="right">${s.neverCounted}</td>
+            </tr>`).join('')}
+        </tbody>
+    </table>`;
+}
+
+function renderList(data) {
+    renderSummary(data);
+    if (!data.shelves.length) {
+        outputContainer.innerHTML = `<p>${formatDate(data.date)} の棚卸リストはありません。「リスト作成」で作成してください。</p>`;
+        return;
+    }
+    const total = data.shelves.reduce((sum, shelf) => sum + shelf.items.length, 0);
+    const done = data.shelves.reduce((sum, shelf) => sum + shelf.items.filter(i => i.status === 'done').length, 0);
+    outputContainer.innerHTML = `<p>${formatDate(data.date)} の棚卸リスト: ${done} / ${total} 品目 実施済み</p>` +
+        data.shelves.map(shelf => `
+        <h3 style="margin: 12px 0 4px;">棚番: ${shelf.shelfNumber || '(棚番なし)'}</h3>
+        <table class="data-table">
+            <thead>
+                <tr><th>分類</th><th>製品名</th><th>YJコード</th><th>前回棚卸日</th><th>棚卸期限</th><th>状態</th><th>操作</th></tr>
+            </thead>
+            <tbody>${shelf.items.map(item => `
+                <tr${item.status === 'done' ? ' style="color: #888;"' : ''}>
+                    <td class="center">${item.abcClass}</td>
+                    <td class="left">${item.productName}</td>
+                    <td class="center">${item.yjCode}</td>
+                    <td class="center">${formatDate(item.lastCountDate) || '未実施'}</td>
+                    <td class="center">${formatDate(item.dueDate) || '-'}</td>
+                    <td class="center">${item.status === 'done' ? `実施済 (${formatDate(item.completedAt)})` : '未実施'}</td>
+                    <td class="center"><button class="btn cycle-count-go-btn" data-yj-code="${item.yjCode}">棚卸</button></td>
+                </tr>`).join('')}
+            </tbody>
+        </table>`).join('');
+}
+
+async function request(url, options) {
+    window.showLoading();
+    try {
+        const res = await fetch(url, options);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '循環棚卸の処理に失敗しました。');
+        }
+        return await res.json();
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+        return null;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+async function loadList() {
+    const data = await request(`/api/cyclecount?date=${dateInput.value}`);
+    if (data) renderList(data);
+}
+
+async function generateList() {
+    const data = await request(`/api/cyclecount/generate?date=${dateInput.value}`, { method: 'POST' });
+    if (data) renderList(data);
+}
+
+async function classify() {
+    if (!confirm('処方金額でABC分類をやり直します。よろしいですか？')) return;
+    const result = await request('/api/cyclecount/classify', { method: 'POST' });
+    if (result) {
+        window.showNotification(result.message, 'success');
+        loadList();
+    }
+}
+
+export function initCycleCountView() {
+    view = document.getElementById('cyclecount-view');
+    if (!view) return;
+    dateInput = document.getElementById('cyclecount-date');
+    outputContainer = document.getElementById('cyclecount-output-container');
+    summaryContainer = document.getElementById('cyclecount-summary-container');
+
+    const today = new Date();
+    dateInput.value = `${today.getFullYear()}-${String(today.getMonth() + 1).padStart(2, '0')}-${String(today.getDate()).padStart(2, '0')}`;
+
+    document.getElementById('run-cyclecount-btn').addEventListener('click', loadList);
+    document.getElementById('generate-cyclecount-btn').addEventListener('click', generateList);
+    document.getElementById('classify-cyclecount-btn').addEventListener('click', classify);
+    outputContainer.addEventListener('click', (e) => {
+        const button = e.target.closest('.cycle-count-go-btn');
+        if (!button) return;
+        // 棚卸調整画面で保存すると、このリストの行が実施済みになる
+        document.dispatchEvent(new CustomEvent('navigateToInventoryAdjustment', { detail: { yjCode: button.dataset.yjCode } }));
+    });
+    view.addEventListener('show', loadList);
+}
@@ -1,9 +1,14 @@
 import { createUploadTableHTML, renderUploadTableRows } from './common_table.js';
+import { escapeHtml } from './utils.js';
 
 export function initDatUpload() {
     const datInput = document.getElementById('datFileInput');
     if (!datInput) return;
 
+    // 取込結果を見てから厳格モードで選び直せるよう、画面からもファイル選択を開けるようにする
+    const selectBtn = document.getElementById('dat-select-files-btn');
+    if (selectBtn) selectBtn.addEventListener('click', () => datInput.click());
+
     datInput.addEventListener('change', async (e) => {
         const files = e.target.files;
         if (!files.length) return;
@@ -18,6 +23,7 @@ export function initDatUpload() {
         try {
             const formData = new FormData();
             for (const file of files) formData.append('file', file);
+            if (document.getElementById('dat-strict-mode')?.checked) formData.append('strict', 'true');
             
             const res = await fetch('/api/dat/upload', { method: 'POST', body: formData });
             const data = await res.json();
@@ -31,8 +37,8 @@ export function initDatUpload() {
             // 2. 文字列を結合して完全なHTMLを作成
             const fullTableHtml = tableShell.replace('<tbody></tbody>', `<tbody>${tableBodyContent}</tbody>`);
 
-            // 3. 完成したHTMLを一度だけDOMに書き込む
-            uploadContainer.innerHTML = fullTableHtml;
+            // 3. 完成したHTMLを一度だけDOMに書き込む (ファイルごとの検証結果を先頭に表示)
+            uploadContainer.innerHTML = renderFileDiagnostics(data.files) + fullTableHtml;
 
             window.showNotification('DAT files processed successfully.', 'success');
 
@@ -49,4 +55,28 @@ export function initDatUpload() {
         }
         // ▲▲▲【修正ここまで】▲▲▲
     });
-}
\ No newline at end of file
+}
+// ファイルごとの検証結果（警告・エラー）を表示用HTMLに変換する
+function renderFileDiagnostics(files) {
+    if (!files) return '';
+    const sections = files
+        .filter(f => f.error || (f.diagnostics && f.diagnostics.length > 0))
+        .map(f => {
+            const rows = (f.diagnostics || []).map(d => `
+                <tr>
+                    <td class="center">${d.line}</td>
+                    <td>${escapeHtml(d.field)}</td>
+                    <td style="color:${d.severity === 'ERROR' ? 'red' : 'darkorange'};">${escapeHtml(d.severity)}</td>
+                    <td>${escapeHtml(d.reason)}</td>
+                    <td style="font-family:monospace;">${escapeHtml(d.raw)}</td>
+                </tr>`).join('');
+            const errorLine = f.error ? `<p style="color:red;">取込エラー: ${escapeHtml(f.error)}</p>` : '';
+            return `
+                <div class="dat-file-diagnostics">
+                    <h4>${escapeHtml(f.fileName)} (${f.recordCount}件)</h4>
+                    ${errorLine}
+                    ${rows ? `<table class="data-table"><thead><tr><th>行</th><th>項目</th><th>区分</th><th>内容</th><th>生データ</th></tr></thead><tbody>${rows}</tbody></table>` : ''}
+                </div>`;
+        });
+    return sections.join('');
+}
@@ -0,0 +1,150 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\expiry.js
+
+let view, daysSelect, runBtn, exportBtn, pdfExportBtn, outputContainer, widget;
+const formatCurrency = (value) => new Intl.NumberFormat('ja-JP', { style: 'currency', currency: 'JPY' }).format(value || 0);
+const formatDate = (s) => (s && s.length === 8) ? `${s.slice(0, 4)}-${s.slice(4, 6)}-${s.slice(6)}` : (s || '');
+
+function renderWindowOptions(windows, selected) {
+    const current = selected ?? daysSelect.value;
+    daysSelect.innerHTML = windows.map(d => `<option value="${d}">${d}日以内</option>`).join('');
+    if (current && windows.includes(Number(current))) {
+        daysSelect.value = current;
+    } else {
+        daysSelect.value = windows[windows.length - 1];
+    }
+}
+
+function renderReport(report) {
+    if (!report.items || report.items.length === 0) {
+        outputContainer.innerHTML = `<p>有効期限が${report.days}日以内の在庫はありません。</p>`;
+        return;
+    }
+
+    let totalNhi = 0;
+    let totalPurchase = 0;
+    let html = `<table class="data-table">
+        <thead>
+            <tr>
+                <th>有効期限</th><th>残日数</th><th>製品名</th><th>包装</th><th>棚番</th><th>ロット番号</th>
+                <th>数量</th><th>薬価金額</th><th>納入価金額</th><th>最終処方日</th>
+            </tr>
+        </thead>
+        <tbody>`;
+    report.items.forEach(item => {
+        totalNhi += item.nhiValue;
+        totalPurchase += item.purchaseValue;
+        const style = item.daysLeft < 0 ? ' style="color: red; font-weight: bold;"' : '';
+        const source = item.source === 'deadstock' ? ' <span title="デッドストックリストの期限">(デッド)</span>' : '';
+        html += `
+            <tr${style}>
+                <td class="center">${item.expiryDate}</td>
+                <td class="right">${item.daysLeft < 0 ? '期限切れ' : item.daysLeft + '日'}</td>
+                <td class="left">${item.productName}</td>
+                <td class="left">${item.packageSpec}</td>
+                <td class="left">${item.shelfNumber || ''}</td>
+                <td class="left">${item.lotNumber || ''}${source}</td>
+                <td class="right">${item.quantity.toFixed(2)} ${item.yjUnitName}</td>
+                <td class="right">${formatCurrency(item.nhiValue)}</td>
+                <td class="right">${formatCurrency(item.purchaseValue)}</td>
+                <td class="center">${formatDate(item.lastUsageDate)}</td>
+            </tr>`;
+    });
+    html += `</tbody>
+        <tfoot>
+            <tr>
+                <td colspan="7" class="right" style="font-weight: bold;">総合計 (${report.items.length}件)</td>
+                <td class="right" style="font-weight: bold;">${formatCurrency(totalNhi)}</td>
+                <td class="right" style="font-weight: bold;">${formatCurrency(totalPurchase)}</td>
+                <td></td>
+            </tr>
+        </tfoot>
+    </table>`;
+    outputContainer.innerHTML = html;
+}
+
+async function runReport() {
+    window.showLoading();
+    try {
+        const params = new URLSearchParams({ days: daysSelect.value });
+        const res = await fetch(`/api/expiry/alerts?${params.toString()}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '期限切迫一覧の取得に失敗しました。');
+        }
+        const report = await res.json();
+        renderWindowOptions(report.windows, String(report.days));
+        renderReport(report);
+    } catch (err) {
+        outputContainer.innerHTML = `<p style="color:red;">${err.message}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+/**
+ * ホーム画面に期限切れ・期限切迫の件数を表示します。該当する在庫が無ければ非表示にします。
+ */
+export async function loadExpiryAlertWidget() {
+    widget = widget || document.getElementById('expiry-alert-widget');
+    if (!widget) return;
+    try {
+        const res = await fetch('/api/expiry/summary');
+        if (!res.ok) throw new Error(await res.text());
+        const { windows } = await res.json();
+        if (!windows.some(w => w.itemCount > 0)) {
+            widget.classList.add('hidden');
+            return;
+        }
+        const parts = windows.map(w => {
+            const label = w.days === 0 ? '期限切れ' : `${w.days}日以内`;
+            const cls = w.days === 0 && w.itemCount > 0 ? ' class="expired"' : '';
+            return `<a href="#" data-days="${w.days}"${cls}>${label}: ${w.itemCount}件 (${formatCurrency(w.nhiValue)})</a>`;
+        });
+        widget.innerHTML = `<span class="expiry-alert-title">有効期限の警告</span>${parts.join('')}`;
+        widget.classList.remove('hidden');
+    } catch (err) {
+        console.warn('期限切迫の件数の取得に失敗しました。', err);
+        widget.classList.add('hidden');
+    }
+}
+
+export function initExpiryView() {
+    view = document.getElementById('expiry-view');
+    if (!view) return;
+
+    daysSelect = document.getElementById('expiry-days');
+    runBtn = document.getElementById('run-expiry-btn');
+    exportBtn = document.getElementById('export-expiry-btn');
+    pdfExportBtn = document.getElementById('export-expiry-pdf-btn');
+    outputContainer = document.getElementById('expiry-output-container');
+    renderWindowOptions([30, 60, 90]);
+
+    runBtn.addEventListener('click', runReport);
+    exportBtn.addEventListener('click', () => {
+        const params = new URLSearchParams({ days: daysSelect.value });
+        window.location.href = `/api/expiry/export?${params.toString()}`;
+    });
+    pdfExportBtn.addEventListener('click', () => {
+        const params = new URLSearchParams({ days: daysSelect.value });
+        window.open(`/api/expiry/export_pdf?${params.toString()}`, '_blank');
+    });
+
+    // 一覧は期限切れの在庫も含むため、警告表示の「期限切れ」(days=0) からは選択中の期間のまま表示する
+    view.addEventListener('show', (e) => {
+        const days = e.detail && e.detail.days;
+        if (days && [...daysSelect.options].some(o => o.value === String(days))) {
+            daysSelect.value = String(days);
+        }
+        runReport();
+    });
+
+    widget = document.getElementById('expiry-alert-widget');
+    if (widget) {
+        widget.addEventListener('click', (e) => {
+            const link = e.target.closest('a[data-days]');
+            if (!link) return;
+            e.preventDefault();
+            document.dispatchEvent(new CustomEvent('navigateToExpiry', { detail: { days: Number(link.dataset.days) } }));
+        });
+    }
+}
@@ -2,6 +2,7 @@
 
 import { showModal } from './inout_modal.js';
 import { transactionTypeMap, createUploadTableHTML } from './common_table.js';
+import { scanGS1 } from './utils.js';
 
 let tableBody, addRowBtn, tableContainer;
 // ▼▼▼ [修正点] 「個数」をINPUTから表示用のセルに変更 ▼▼▼
@@ -177,6 +178,64 @@ function recalculateRow(upperRow) {
 }
 // ▲▲▲ 修正ここまで ▲▲▲
 
+// 選択またはスキャンされた製品の情報を明細行に反映します
+function applyProductToRow(targetRow, selectedProduct) {
+    // 選択された製品データ(productCodeを持つ)を、
+    // 既存の取引データ(janCodeを持つ)と構造を合わせるために正規化します。
+    const productToStore = { ...selectedProduct };
+    productToStore.janCode = productToStore.productCode; // janCodeプロパティを追加
+
+    // 正規化したオブジェクトをデータとして保存します。
+    targetRow.dataset.product = JSON.stringify(productToStore);
+
+    const lowerRow = targetRow.nextElementSibling;
+
+    targetRow.querySelector('.display-yj-code').textContent = selectedProduct.yjCode;
+    targetRow.querySelector('.product-name-cell').textContent = selectedProduct.productName;
+    targetRow.querySelector('.display-yj-pack-unit-qty').textContent = selectedProduct.yjPackUnitQty || '';
+    targetRow.querySelector('.display-yj-unit-name').textContent = selectedProduct.yjUnitName || '';
+    targetRow.querySelector('.display-unit-price').textContent = (selectedProduct.nhiPrice || 0).toFixed(4);
+
+    lowerRow.querySelector('.display-jan-code').textContent = selectedProduct.productCode;
+    lowerRow.querySelector('.display-package-spec').textContent = selectedProduct.formattedPackageSpec || '';
+    lowerRow.querySelector('.display-maker-name').textContent = selectedProduct.makerName;
+    lowerRow.querySelector('.display-usage-classification').textContent = selectedProduct.usageClassification || '';
+    lowerRow.querySelector('.display-jan-pack-unit-qty').textContent = selectedProduct.janPackUnitQty || '';
+    lowerRow.querySelector('.display-jan-unit-name').textContent = selectedProduct.janUnitName || '';
+    recalculateRow(targetRow);
+}
+
+// GS1バーコードのスキャンで明細行を追加し、サーバーで解析した有効期限・ロット番号を入力します
+async function handleInoutBarcodeScan(e) {
+    e.preventDefault();
+    const barcodeInput = document.getElementById('inout-barcode-input');
+    const inputValue = barcodeInput.value.trim();
+    if (!inputValue) return;
+
+    window.showLoading('製品情報を検索中...');
+    try {
+        const scan = await scanGS1(inputValue);
+        if (!scan.product) {
+            throw new Error(`GS1コード[${scan.gtin}]に該当する製品が見つかりません。`);
+        }
+        if (tableBody.querySelector('td[colspan="14"]')) {
+            tableBody.innerHTML = '';
+        }
+        tableBody.insertAdjacentHTML('beforeend', createInoutRowsHTML());
+        const upperRows = tableBody.querySelectorAll('tr[data-row-id]');
+        const targetRow = upperRows[upperRows.length - 1];
+        applyProductToRow(targetRow, scan.product);
+        targetRow.querySelector('input[name="expiryDate"]').value = scan.expiryDate || '';
+        targetRow.nextElementSibling.querySelector('input[name="lotNumber"]').value = scan.lotNumber || '';
+        barcodeInput.value = '';
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+        barcodeInput.focus();
+    }
+}
+
 export function initDetailsTable() {
     tableContainer = document.getElementById('inout-details-container');
     addRowBtn = document.getElementById('addRowBtn');
@@ -184,6 +243,10 @@ export function initDetailsTable() {
     
     tableContainer.innerHTML = createUploadTableHTML('inout-details-table');
     tableBody = document.querySelector('#inout-details-table tbody');
+    const barcodeForm = document.getElementById('inout-barcode-form');
+    if (barcodeForm) {
+        barcodeForm.addEventListener('submit', handleInoutBarcodeScan);
+    }
     addRowBtn.addEventListener('click', () => {
         if (tableBody.querySelector('td[colspan="14"]')) {
             tableBody.innerHTML = '';
@@ -204,35 +267,11 @@ export function initDetailsTable() {
         if (e.target.classList.contains('product-name-cell')) {
             const activeRow = e.target.closest('tr');
             showModal(activeRow, (selectedProduct, targetRow) => {
-                // ▼▼▼【ここからが修正箇所です】▼▼▼
-                // 選択された製品データ(productCodeを持つ)を、
-                // 既存の取引データ(janCodeを持つ)と構造を合わせるために正規化します。
-                const productToStore = { ...selectedProduct };
-                productToStore.janCode = productToStore.productCode; // janCodeプロパティを追加
-
-                // 正規化したオブジェクトをデータとして保存します。
-                targetRow.dataset.product = JSON.stringify(productToStore);
-                // ▲▲▲【修正ここまで】▲▲▲
-         
-                const lowerRow = targetRow.nextElementSibling;
-                
-                targetRow.querySelector('.display-yj-code').textContent = selectedProduct.yjCode;
-                targetRow.querySelector('.product-name-cell').textContent = selectedProduct.productName;
-                targetRow.querySelector('.display-yj-pack-unit-qty').textContent = selectedProduct.yjPackUnitQty || '';
-                targetRow.querySelector('.display-yj-unit-name').textContent = selectedProduct.yjUnitName || '';
-                targetRow.querySelector('.display-unit-price').textContent = (selectedProduct.nhiPrice || 0).toFixed(4);
-                
-                lowerRow.querySelector('.display-jan-code').textContent = selectedProduct.productCode;
-                lowerRow.querySelector('.display-package-spec').textContent = selectedProduct.formattedPackageSpec || '';
-                lowerRow.querySelector('.display-maker-name').textContent = selectedProduct.makerName;
-                lowerRow.querySelector('.display-usage-classification').textContent = selectedProduct.usageClassification || '';
-                lowerRow.querySelector('.display-jan-pack-unit-qty').textContent = selectedProduct.janPackUnitQty || '';
-                lowerRow.querySelector('.display-jan-unit-name').textContent = selectedProduct.janUnitName || '';
-                
-                const quantityInput = lowerRow.querySelector('input[name="janQuantity"]');
+                applyProductToRow(targetRow, selectedProduct);
+
+                const quantityInput = targetRow.nextElementSibling.querySelector('input[name="janQuantity"]');
                 quantityInput.focus();
                 quantityInput.select();
-                recalculateRow(targetRow);
             });
         }
     });
@@ -1,6 +1,6 @@
 // C:/Users/wasab/OneDrive/デスクトップ/WASABI/static/js/inventory_adjustment_logic.js
 import { showModal } from './inout_modal.js';
-import { hiraganaToKatakana, getLocalDateString } from './utils.js';
+import { hiraganaToKatakana, getLocalDateString, scanGS1 } from './utils.js';
 import { generateFullHtml, createFinalInputRow, setUnitMap } from './inventory_adjustment_ui.js';
 
 let view, outputContainer;
@@ -8,75 +8,29 @@ let dosageFormFilter, kanaInitialFilter, selectProductBtn, deadStockOnlyFilter,
 let currentYjCode = null;
 let lastLoadedDataCache = null;
 
-// ▼▼▼【ここから修正】▼▼▼
-function parseGS1_128(code) {
-    let rest = code;
-    const data = {};
-
-    if (rest.startsWith('01')) {
-        if (rest.length < 16) return null;
-        data.gs1Code = rest.substring(2, 16);
-        rest = rest.substring(16);
-    } else {
-        return null;
-    }
-
-    if (rest.startsWith('17')) {
-        if (rest.length < 8) return data;
-        const yy_mm_dd = rest.substring(2, 8); // 例: "251000"
-        if (yy_mm_dd.length === 6) {
-            const yy = yy_mm_dd.substring(0, 2); // "25"
-            const mm = yy_mm_dd.substring(2, 4); // "10"
-            data.expiryDate = `20${yy}${mm}`;   // "202510" に変換
-        } else {
-            data.expiryDate = yy_mm_dd; // 予期せぬ形式の場合はそのまま
-        }
-        rest = rest.substring(8);
-    }
-
-    if (rest.startsWith('10')) {
-        const groupSeparatorIndex = rest.indexOf('\x1D'); // GS (Group Separator)
-        if (groupSeparatorIndex !== -1) {
-            data.lotNumber = rest.substring(2, groupSeparatorIndex);
-        } else {
-            data.lotNumber = rest.substring(2);
-        }
-    }
-   
-    return data;
-}
-// ▲▲▲【修正ここまで】▲▲▲
-
 async function handleAdjustmentBarcodeScan(e) {
     e.preventDefault();
     const barcodeInput = document.getElementById('adjustment-barcode-input');
     const inputValue = barcodeInput.value.trim();
     if (!inputValue) return;
 
-    const parsedData = parseGS1_128(inputValue);
-    if (!parsedData || !parsedData.gs1Code) {
-        window.showNotification('GS1-128形式のバーコードではありません。', 'error');
-        barcodeInput.value = '';
-        return;
-    }
-
     window.showLoading('製品情報を検索中...');
     try {
-        const res = await fetch(`/api/product/by_gs1?gs1_code=${parsedData.gs1Code}`);
-        
+        const parsedData = await scanGS1(inputValue);
+
         let productMaster;
-        if (!res.ok) {
-            if (res.status === 404) {
-                 if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成しますか？`)) {
-                    productMaster = await createProvisionalMaster(parsedData.gs1Code);
-                 } else {
-                    throw new Error('このGS1コードはマスターに登録されていません。');
-                 }
+        if (parsedData.source !== 'MASTER') {
+            if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成しますか？`)) {
+                await createProvisionalMaster(parsedData.gtin);
+                productMaster = (await scanGS1(parsedData.gtin)).product;
+                if (!productMaster) {
+                    throw new Error('作成されたマスター情報の取得に失敗しました。');
+                }
             } else {
-                throw new Error('製品情報の検索に失敗しました。');
+                throw new Error('このGS1コードはマスターに登録されていません。');
             }
         } else {
-            productMaster = await res.json();
+            productMaster = parsedData.product;
         }
 
         const productTbody = outputContainer.querySelector(`.final-input-tbody[data-product-code="${productMaster.productCode}"]`);
@@ -134,39 +88,17 @@ async function handleBarcodeScan(e) {
     const inputValue = barcodeInput.value.trim();
     if (!inputValue) return;
 
-    let gs1Code = '';
-    if (inputValue.startsWith('01') && inputValue.length > 16) {
-        const parsedData = parseGS1_128(inputValue);
-        if (parsedData) {
-            gs1Code = parsedData.gs1Code;
-        }
-    }
-    
-    if (!gs1Code) {
-        gs1Code = inputValue;
-    }
-
-    if (!gs1Code) {
-        window.showNotification('有効なGS1コードではありません。', 'error');
-        return;
-    }
-   
     window.showLoading('製品情報を検索中...');
     try {
-        const res = await fetch(`/api/product/by_gs1?gs1_code=${gs1Code}`);
-        if (!res.ok) {
-            if (res.status === 404) {
-                if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成しますか？`)) {
-                    await createProvisionalMaster(gs1Code);
-                } else {
-                    throw new Error('このGS1コードはマスターに登録されていません。');
-                }
+        const scan = await scanGS1(inputValue);
+        if (scan.source !== 'MASTER') {
+            if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成しますか？`)) {
+                await createProvisionalMaster(scan.gtin);
             } else {
-                throw new Error('製品情報の検索に失敗しました。');
+                throw new Error('このGS1コードはマスターに登録されていません。');
             }
         } else {
-            const productMaster = await res.json();
-            await loadAndRenderDetails(productMaster.yjCode);
+            await loadAndRenderDetails(scan.product.yjCode);
             barcodeInput.value = '';
             barcodeInput.focus();
         }
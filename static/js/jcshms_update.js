@@ -1,61 +1,67 @@
+import { submitJob, registerJobResultHandler } from './jobs.js';
+
+function showUploadView() {
+    const uploadView = document.getElementById('upload-view');
+    const activeView = document.querySelector('main > div:not(.hidden)');
+    if (uploadView && activeView) {
+        activeView.classList.add('hidden');
+        uploadView.classList.remove('hidden');
+        document.getElementById('dat-upload-options')?.classList.add('hidden');
+    }
+}
+
+// renderResult はマスター更新の結果 (更新・手動管理に移行した品目) を表示します。
+function renderResult(resData) {
+    const resultContainer = document.getElementById('upload-output-container');
+    showUploadView();
+
+    let resultHTML = `<h3>${resData.message}</h3>`;
+
+    if (resData.updatedProducts && resData.updatedProducts.length > 0) {
+        resultHTML += `<p style="margin-top: 10px;">以下の${resData.updatedProducts.length}件が更新されました。</p>
+                       <table class="data-table"><thead><tr><th>JAN</th><th>製品名</th></tr></thead><tbody>`;
+        resData.updatedProducts.forEach(p => {
+            resultHTML += `<tr><td>${p.productCode}</td><td class="left">${p.productName}</td></tr>`;
+        });
+        resultHTML += `</tbody></table>`;
+    }
+
+    if (resData.orphanedProducts && resData.orphanedProducts.length > 0) {
+        resultHTML += `<p style="margin-top: 10px;">以下の${resData.orphanedProducts.length}件がJCSHMSから削除されたため、手動管理に移行しました。</p>
+                       <table class="data-table"><thead><tr><th>JAN</th><th>製品名</th></tr></thead><tbody>`;
+        resData.orphanedProducts.forEach(p => {
+            resultHTML += `<tr><td>${p.productCode}</td><td class="left">${p.productName}</td></tr>`;
+        });
+        resultHTML += `</tbody></table>`;
+    }
+
+    resultContainer.innerHTML = resultHTML;
+    window.showNotification(resData.message, 'success');
+}
+
 export function initJcshmsUpdate() {
     const reloadBtn = document.getElementById('reloadJcshmsBtn');
     if (!reloadBtn) return;
 
+    registerJobResultHandler('master_update', renderResult);
+
     reloadBtn.addEventListener('click', async () => {
-        if (!confirm('SOUフォルダ内のJCSHMS.CSVとJANCODE.CSVをデータベースに再読み込みします。\nこれには数分かかることがあり、完了するまでアプリケーションは応答しなくなります。\nよろしいですか？')) {
+        if (!confirm('SOUフォルダ内のJCSHMS.CSVとJANCODE.CSVをデータベースに再読み込みします。\nこれには数分かかることがあり、処理中は進捗が表示されます。途中で中止した場合は何も更新されません。\nよろしいですか？')) {
             return;
         }
-        window.showLoading();
         // ▼▼▼ [修正点] 結果表示用のコンテナを取得し、処理中メッセージを表示 ▼▼▼
         const resultContainer = document.getElementById('upload-output-container');
-        const uploadView = document.getElementById('upload-view');
-        const activeView = document.querySelector('main > div:not(.hidden)');
-        
-        if (uploadView && activeView) {
-            activeView.classList.add('hidden');
-            uploadView.classList.remove('hidden');
-        }
-        resultContainer.innerHTML = `<h3>JCSHMSマスター更新処理中...</h3><p>ブラウザを閉じないでください。</p>`;
+        showUploadView();
+        resultContainer.innerHTML = `<h3>JCSHMSマスター更新処理中...</h3><p>処理はサーバーで実行されます。画面を再読み込みしても進捗の表示を再開できます。</p>`;
         // ▲▲▲ 修正ここまで ▲▲▲
 
         try {
-            const res = await fetch('/api/masters/reload_jcshms', { method: 'POST' });
-            const resData = await res.json();
-            if (!res.ok) {
-                throw new Error(resData.message || '再読み込みに失敗しました。');
-            }
-            
-            // ▼▼▼ [修正点] 結果表示ロジックを全面的に書き換え ▼▼▼
-            let resultHTML = `<h3>${resData.message}</h3>`;
-
-            if (resData.updatedProducts && resData.updatedProducts.length > 0) {
-                resultHTML += `<p style="margin-top: 10px;">以下の${resData.updatedProducts.length}件が更新されました。</p>
-                               <table class="data-table"><thead><tr><th>JAN</th><th>製品名</th></tr></thead><tbody>`;
-                resData.updatedProducts.forEach(p => {
-                    resultHTML += `<tr><td>${p.productCode}</td><td class="left">${p.productName}</td></tr>`;
-                });
-                resultHTML += `</tbody></table>`;
-            }
-
-            if (resData.orphanedProducts && resData.orphanedProducts.length > 0) {
-                resultHTML += `<p style="margin-top: 10px;">以下の${resData.orphanedProducts.length}件がJCSHMSから削除されたため、手動管理に移行しました。</p>
-                               <table class="data-table"><thead><tr><th>JAN</th><th>製品名</th></tr></thead><tbody>`;
-                resData.orphanedProducts.forEach(p => {
-                    resultHTML += `<tr><td>${p.productCode}</td><td class="left">${p.productName}</td></tr>`;
-                });
-                resultHTML += `</tbody></table>`;
-            }
-
-            resultContainer.innerHTML = resultHTML;
-            window.showNotification(resData.message, 'success');
-            // ▲▲▲ 修正ここまで ▲▲▲
+            const resData = await submitJob('/api/masters/reload_jcshms', {}, 'JCSHMSマスター更新');
+            renderResult(resData);
         } catch (err) {
             console.error(err);
             resultContainer.innerHTML = `<p style="color:red;">エラー: ${err.message}</p>`; // エラーもコンテナに表示
             window.showNotification(`エラー: ${err.message}`, 'error');
-        } finally {
-            window.hideLoading();
         }
     });
 }
\ No newline at end of file
@@ -0,0 +1,160 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\jobs.js
+
+// 時間のかかる処理 (マスター更新・再計算など) はサーバー側でジョブとして実行されます。
+// このモジュールはジョブの開始、進捗の表示 (Server-Sent Events)、中止を扱います。
+
+const resultHandlers = {};
+
+/**
+ * ジョブの種類ごとに、完了時の結果を表示する関数を登録します。
+ * 画面の再読み込み後に実行中のジョブへ再接続した場合も、この関数で結果を表示します。
+ */
+export function registerJobResultHandler(kind, handler) {
+    resultHandlers[kind] = handler;
+}
+
+function formatProgress(job) {
+    let text = job.title || '処理中';
+    if (job.phase) {
+        text += ` - ${job.phase}`;
+    }
+    if (job.total > 0) {
+        const percent = Math.floor(job.processed * 100 / job.total);
+        text += ` (${job.processed.toLocaleString()} / ${job.total.toLocaleString()}件, ${percent}%)`;
+    }
+    return text + '...';
+}
+
+async function cancelJob(jobId) {
+    const res = await fetch('/api/jobs/cancel', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ id: jobId }),
+    });
+    if (!res.ok) {
+        throw new Error(await res.text());
+    }
+}
+
+/**
+ * ジョブの進捗をローディング画面に表示し、終了を待ちます。
+ * 完了した場合は処理結果で resolve し、失敗・中止した場合は reject します。
+ */
+export function followJob(jobId, title = '処理中') {
+    window.showLoading(`${title}...`);
+    const cancelBtn = document.getElementById('loading-cancel-btn');
+    let lastJob = null;
+
+    return new Promise((resolve, reject) => {
+        const source = new EventSource(`/api/jobs/events?id=${encodeURIComponent(jobId)}`);
+
+        const finish = () => {
+            source.close();
+            if (cancelBtn) {
+                cancelBtn.classList.add('hidden');
+                cancelBtn.onclick = null;
+            }
+            window.hideLoading();
+        };
+
+        if (cancelBtn) {
+            cancelBtn.disabled = false;
+            cancelBtn.classList.remove('hidden');
+            cancelBtn.onclick = async () => {
+                if (!confirm('処理を中止しますか？\n中止するまでに確定した更新は元に戻りません。')) return;
+                cancelBtn.disabled = true;
+                try {
+                    await cancelJob(jobId);
+                    document.getElementById('loading-message').textContent = '中止しています...';
+                } catch (err) {
+                    cancelBtn.disabled = false;
+                    window.showNotification(`エラー: ${err.message}`, 'error');
+                }
+            };
+        }
+
+        source.addEventListener('progress', (e) => {
+            lastJob = JSON.parse(e.data);
+            document.getElementById('loading-message').textContent = formatProgress(lastJob);
+        });
+
+        source.addEventListener('done', (e) => {
+            const job = JSON.parse(e.data);
+            finish();
+            if (job.status === 'SUCCEEDED') {
+                resolve(job.result || {});
+            } else {
+                const err = new Error(job.error || '処理に失敗しました。');
+                err.result = job.result;
+                reject(err);
+            }
+        });
+
+        // 接続が切れた場合、EventSource は自動で再接続します。
+        // ジョブが見つからない (サーバーが再起動したなど) 場合のみ終了します。
+        source.onerror = () => {
+            if (source.readyState === EventSource.CLOSED) {
+                finish();
+                reject(new Error(lastJob ? '進捗の受信が中断されました。' : 'ジョブが見つかりません。'));
+            }
+        };
+    });
+}
+
+/**
+ * ジョブを開始するAPIを呼び出し、終了まで進捗を表示します。
+ * 同じ処理が既に実行中の場合は、そのジョブの進捗を表示します。
+ */
+export async function submitJob(url, options = {}, title = '処理中') {
+    window.showLoading(`${title}...`);
+    let res;
+    try {
+        res = await fetch(url, { method: 'POST', ...options });
+    } catch (err) {
+        window.hideLoading();
+        throw err;
+    }
+    const text = await res.text();
+    let data = null;
+    try {
+        data = JSON.parse(text);
+    } catch {
+        // http.Error はプレーンテキストを返す
+    }
+    if ((res.status === 202 || res.status === 409) && data && data.jobId) {
+        if (res.status === 409) {
+            window.showNotification(data.message, 'success');
+        }
+        return followJob(data.jobId, title);
+    }
+    window.hideLoading();
+    throw new Error((data && data.message) || text || '処理に失敗しました。');
+}
+
+/**
+ * 画面の読み込み時に実行中のジョブがあれば、進捗の表示を再開します。
+ */
+export async function resumeRunningJobs() {
+    let running;
+    try {
+        const res = await fetch('/api/jobs?status=RUNNING');
+        if (!res.ok) return;
+        running = await res.json();
+    } catch (err) {
+        console.error(err);
+        return;
+    }
+    for (const job of running) {
+        const handler = resultHandlers[job.kind];
+        try {
+            const result = await followJob(job.id, job.title);
+            if (handler) {
+                handler(result);
+            } else {
+                window.showNotification(result.message || `${job.title}が完了しました。`, 'success');
+            }
+        } catch (err) {
+            window.showNotification(`エラー: ${err.message}`, 'error');
+        }
+    }
+}
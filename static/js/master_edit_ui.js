@@ -49,7 +49,8 @@ function createMasterRowHTML(master = {}) {
             <td colspan="3"><div class="field-group"><label>6. メーカー</label><input type="text" name="makerName" value="${master.makerName || ''}" ${disabledAttr}></div></td>
             <td colspan="3"><div class="field-group"><label>7. 規格容量</label><input type="text" name="specification" value="${master.specification || ''}" ${disabledAttr}></div></td>
             <td colspan="2"><div class="field-group"><label>8. 剤型</label><input type="text" name="usageClassification" value="${master.usageClassification || ''}" ${disabledAttr}></div></td>
-            <td colspan="4"><div class="field-group"><label>9. 包装</label><input type="text" name="packageForm" value="${master.packageForm || ''}" ${disabledAttr}></div></td>
+            <td colspan="3"><div class="field-group"><label>9. 包装</label><input type="text" name="packageForm" value="${master.packageForm || ''}" ${disabledAttr}></div></td>
+            <td colspan="1"><div class="field-group"><label>ABC/XYZ</label><input type="text" value="${master.abcXyzClass || '未分析'}" title="ABC/XYZ分析で保存した分類" readonly></div></td>
         </tr>`;
     
     let janUnitOptions = '<option value="0">YJ単位と同じ</option>';
@@ -1,5 +1,5 @@
 // C:/Users/wasab/OneDrive/デスクトップ/WASABI/static/js/orders.js
-import { hiraganaToKatakana, getLocalDateString, toHalfWidth } from './utils.js';
+import { hiraganaToKatakana, getLocalDateString, toHalfWidth, describeForecast, purchaseOrderLinks, scanGS1 } from './utils.js';
 import { wholesalerMap } from './master_data.js';
 import { showModal } from './inout_modal.js';
 
@@ -132,24 +132,12 @@ async function processScanQueue() {
         const barcode = scanQueue.shift();
 
         try {
-            let gs1Code = '';
-            if (barcode.startsWith('01') && barcode.length > 16) {
-                gs1Code = barcode.substring(2, 16);
+            const scan = await scanGS1(barcode);
+            if (scan.source !== 'MASTER') {
+                const newMaster = await createAndFetchMaster(scan.gtin);
+                addOrUpdateOrderItem(newMaster);
             } else {
-                gs1Code = barcode;
-            }
-
-            const res = await fetch(`/api/product/by_gs1?gs1_code=${gs1Code}`);
-            if (!res.ok) {
-                if (res.status === 404) {
-                    const newMaster = await createAndFetchMaster(gs1Code);
-                    addOrUpdateOrderItem(newMaster);
-                } else {
-                    throw new Error(`サーバーエラー (HTTP ${res.status})`);
-                }
-            } else {
-                const productMaster = await res.json();
-                addOrUpdateOrderItem(productMaster);
+                addOrUpdateOrderItem(scan.product);
             }
         } catch (err) {
             console.error(`バーコード[${barcode}]の処理に失敗:`, err);
@@ -216,8 +204,8 @@ function renderOrderCandidates(data, container, wholesalers) {
         yjGroup.packageLedgers.forEach(pkg => {
             if (pkg.masters && pkg.masters.length > 0) {
                 pkg.masters.forEach(master => {
-                    const pkgShortfall = pkg.reorderPoint - (pkg.endingBalance || 0);
-                    if (pkgShortfall > 0) {
+                    const suggestion = master.suggestion || {};
+                    if (suggestion.reorderNeeded && suggestion.packages > 0) {
                         const isProvisional = master.productCode.startsWith('99999') && master.productCode.length > 13;
                         const isOrderStopped = master.isOrderStopped === 1;
                         const isOrderable = !isProvisional && !isOrderStopped;
@@ -225,7 +213,12 @@ function renderOrderCandidates(data, container, wholesalers) {
                         const rowClass = !isOrderable ? 'provisional-order-item' : '';
                         const disabledAttr = !isOrderable ? 'disabled' : '';
 
-                        const recommendedOrder = master.yjPackUnitQty > 0 ? Math.ceil(pkgShortfall / master.yjPackUnitQty) : 0;
+                        // 補充条件でバラ発注できる製品は、包装数の端数を入力できる
+                        const recommendedOrder = Math.round(suggestion.packages * 1000) / 1000;
+                        const quantityStep = Number.isInteger(recommendedOrder) ? '1' : 'any';
+                        const suggestionTitle = `発注点: ${formatBalance(suggestion.reorderPoint)} / 目標: ${formatBalance(suggestion.orderUpTo)}` +
+                            ` / 在庫+発注残: ${formatBalance(suggestion.availableQuantity)}` +
+                            (suggestion.policyKey ? ` / 補充条件: ${suggestion.policyKey}` : ` / 発注点の計算: ${describeForecast(pkg.forecast)}`);
                         
                         let rowWholesalerOptions = '<option value="">--- 選択 ---</option>';
                         wholesalers.forEach(w => {
@@ -260,12 +253,12 @@ function renderOrderCandidates(data, container, wholesalers) {
                                 data-yj-unit-name="${master.yjUnitName}"
                                 data-yj-pack-unit-qty="${master.yjPackUnitQty}"
                                 data-order-multiplier="${master.yjPackUnitQty}"> 
-                                <td class="left">${master.productName}</td>
+                                <td class="left" title="${suggestionTitle}">${master.productName}</td>
                                 <td class="left">${master.makerName || ''}</td>
                                 <td class="left">${master.formattedPackageSpec}</td>
                                 <td><select class="wholesaler-select" style="width: 100%;" ${disabledAttr}>${rowWholesalerOptions}</select></td>
                                 <td>1包装 (${master.yjPackUnitQty} ${master.yjUnitName})</td>
-                                <td><input type="number" value="${recommendedOrder}" class="order-quantity-input" style="width: 80px;" ${disabledAttr}></td>
+                                <td><input type="number" value="${recommendedOrder}" step="${quantityStep}" min="0" class="order-quantity-input" style="width: 80px;" ${disabledAttr}></td>
                                 ${actionCellHTML}
                             </tr>
                         `;
@@ -278,41 +271,81 @@ function renderOrderCandidates(data, container, wholesalers) {
     container.innerHTML = html;
 }
 
-async function handleOrderBarcodeScan(e) {
-    e.preventDefault();
-    const barcodeInput = document.getElementById('order-barcode-input');
-    const inputValue = barcodeInput.value.trim();
-    if (!inputValue) return;
+function formatYen(value) {
+    return `${Math.round(value).toLocaleString()}円`;
+}
 
-    let gs1Code = '';
-    if (inputValue.startsWith('01') && inputValue.length > 16) {
-        gs1Code = inputValue.substring(2, 16);
-    } else {
-        gs1Code = inputValue;
+// 卸への振り分け結果 (卸ごとの合計・最低発注金額・現在の採用卸との差額) を表示する
+function renderAllocationSummary(result, container) {
+    let html = `<div class="order-allocation-summary">
+        <p>見積の判定日: ${result.date} / 合計: ${formatYen(result.totalAmount)} (現在の採用卸: ${formatYen(result.currentTotalAmount)})
+        / 差額: <strong>${formatYen(result.totalSavings)}</strong></p>
+        <table class="data-table">
+            <thead><tr><th>卸</th><th>品目数</th><th>合計金額</th><th>最低発注金額</th><th>差額</th></tr></thead>
+            <tbody>`;
+    result.groups.forEach(group => {
+        const savings = group.lines.reduce((sum, line) => sum + line.savings, 0);
+        const minimum = group.minOrderAmount > 0 ? formatYen(group.minOrderAmount) : '-';
+        html += `<tr>
+            <td class="left">${group.wholesalerName}</td>
+            <td>${group.lines.length}</td>
+            <td class="right">${formatYen(group.totalAmount)}</td>
+            <td class="right">${minimum}${group.belowMinimum ? ' <span style="color:red;">(最低金額未満)</span>' : ''}</td>
+            <td class="right">${formatYen(savings)}</td>
+        </tr>`;
+    });
+    html += `</tbody></table>`;
+
+    const notes = result.groups.flatMap(group => group.lines.filter(line => line.reason))
+        .map(line => `${line.productName}: ${line.reason}`)
+        .concat(result.excluded.map(line => `${line.productName || line.productCode}: ${line.reason} (除外)`));
+    if (notes.length > 0) {
+        html += `<ul>${notes.map(note => `<li>${note}</li>`).join('')}</ul>`;
     }
+    html += `</div>`;
+    container.innerHTML = html;
+}
 
-    if (!gs1Code) {
-        window.showNotification('有効なGS1コードではありません。', 'error');
+// 発注時に作成した卸ごとの発注書のダウンロードリンクを表示する
+function renderPurchaseOrders(purchaseOrders, container) {
+    if (purchaseOrders.length === 0) {
+        container.innerHTML = '';
         return;
     }
+    let html = `<div class="order-purchase-orders">
+        <table class="data-table" style="width: auto;">
+            <thead><tr><th>発注書番号</th><th>卸</th><th>品目数</th><th>発注書</th></tr></thead>
+            <tbody>`;
+    purchaseOrders.forEach(po => {
+        html += `<tr>
+            <td>${po.poNumber}</td>
+            <td class="left">${po.wholesalerName || po.wholesalerCode || '卸未指定'}</td>
+            <td class="right">${po.lines.length}</td>
+            <td>${purchaseOrderLinks(po.poNumber)}</td>
+        </tr>`;
+    });
+    html += `</tbody></table></div>`;
+    container.innerHTML = html;
+}
+
+async function handleOrderBarcodeScan(e) {
+    e.preventDefault();
+    const barcodeInput = document.getElementById('order-barcode-input');
+    const inputValue = barcodeInput.value.trim();
+    if (!inputValue) return;
 
     window.showLoading('製品情報を検索中...');
     try {
-        const res = await fetch(`/api/product/by_gs1?gs1_code=${gs1Code}`);
-        if (!res.ok) {
-            if (res.status === 404) {
-                if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成して発注リストに追加しますか？`)) {
-                    const newMaster = await createAndFetchMaster(gs1Code);
-                    addOrUpdateOrderItem(newMaster);
-                } else {
-                    throw new Error('このGS1コードはマスターに登録されていません。');
-                }
+        const scan = await scanGS1(inputValue);
+        if (scan.source !== 'MASTER') {
+            if (confirm(`このGS1コードはマスターに登録されていません。\n新規マスターを作成して発注リストに追加しますか？`)) {
+                const newMaster = await createAndFetchMaster(scan.gtin);
+                addOrUpdateOrderItem(newMaster);
             } else {
-                throw new Error('製品情報の検索に失敗しました。');
+                throw new Error('このGS1コードはマスターに登録されていません。');
             }
         } else {
-            const productMaster = await res.json();
-            addOrUpdateOrderItem(productMaster);
+            addOrUpdateOrderItem(scan.product);
         }
         barcodeInput.value = '';
         barcodeInput.focus();
@@ -339,12 +372,11 @@ async function createAndFetchMaster(gs1Code) {
         }
         window.showNotification(`新規マスターを作成しました (YJ: ${createData.yjCode})`, 'success');
         window.showLoading('作成したマスター情報を取得中...');
-        const fetchRes = await fetch(`/api/product/by_gs1?gs1_code=${gs1Code}`);
-        if (!fetchRes.ok) {
+        const scan = await scanGS1(gs1Code);
+        if (!scan.product || scan.source !== 'MASTER') {
             throw new Error('作成されたマスター情報の取得に失敗しました。');
         }
-        const newMaster = await fetchRes.json();
-        return newMaster;
+        return scan.product;
 
     } catch (err) {
         throw err;
@@ -365,6 +397,8 @@ export function initOrders() {
     const barcodeForm = document.getElementById('order-barcode-form');
     const shelfNumberInput = document.getElementById('order-shelf-number');
     const addFromMasterBtn = document.getElementById('add-order-item-from-master-btn');
+    const allocateBtn = document.getElementById('allocate-order-wholesalers-btn');
+    const allocationSummary = document.getElementById('order-allocation-summary');
 
     continuousOrderModal = document.getElementById('continuous-order-modal');
     continuousOrderBtn = document.getElementById('continuous-order-btn');
@@ -458,6 +492,7 @@ export function initOrders() {
             const data = await res.json();
             
             renderOrderCandidates(data.candidates, outputContainer, data.wholesalers || []);
+            allocationSummary.innerHTML = '';
 
         } catch (err) {
             outputContainer.innerHTML = `<p style="color:red;">エラー: ${err.message}</p>`;
@@ -466,6 +501,50 @@ export function initOrders() {
         }
     });
 
+    allocateBtn.addEventListener('click', async () => {
+        const rows = Array.from(outputContainer.querySelectorAll('tbody tr'))
+            .filter(row => !row.classList.contains('provisional-order-item'));
+        const lines = rows
+            .map(row => ({
+                productCode: row.dataset.janCode,
+                quantity: parseFloat(row.querySelector('.order-quantity-input').value) || 0,
+            }))
+            .filter(line => line.quantity > 0);
+        if (lines.length === 0) {
+            window.showNotification('振り分ける品目がありません。', 'error');
+            return;
+        }
+
+        window.showLoading();
+        try {
+            const res = await fetch('/api/orders/allocate', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ lines }),
+            });
+            if (!res.ok) {
+                const errText = await res.text();
+                throw new Error(errText || '卸への振り分けに失敗しました。');
+            }
+            const result = await res.json();
+
+            const allocated = new Map();
+            result.groups.forEach(group => group.lines.forEach(line => allocated.set(line.productCode, line)));
+            rows.forEach(row => {
+                const line = allocated.get(row.dataset.janCode);
+                if (line) {
+                    row.querySelector('.wholesaler-select').value = line.wholesalerCode;
+                }
+            });
+            renderAllocationSummary(result, allocationSummary);
+            window.showNotification('発注候補を最安の卸に振り分けました。', 'success');
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        } finally {
+            window.hideLoading();
+        }
+    });
+
     createCsvBtn.addEventListener('click', async () => {
         const rows = outputContainer.querySelectorAll('tbody tr');
         if (rows.length === 0) {
@@ -483,7 +562,7 @@ export function initOrders() {
             }
             
             const quantityInput = row.querySelector('.order-quantity-input');
-            const quantity = parseInt(quantityInput.value, 10);
+            const quantity = parseFloat(quantityInput.value);
             
             if (quantity > 0) {
                 hasItemsToOrder = true;
@@ -498,6 +577,7 @@ export function initOrders() {
                 const orderMultiplier = parseFloat(row.dataset.orderMultiplier) || 0;
                 
                 backorderPayload.push({
+                    productCode: janCode,
                     yjCode: row.dataset.yjCode,
                     packageForm: row.dataset.packageForm,
                     janPackInnerQty: parseFloat(row.dataset.janPackInnerQty),
@@ -526,6 +606,7 @@ export function initOrders() {
             if (!res.ok) throw new Error(resData.message || '発注残の登録に失敗しました。');
             
             window.showNotification(resData.message, 'success');
+            renderPurchaseOrders(resData.purchaseOrders || [], allocationSummary);
             const sjisArray = Encoding.convert(csvContent, {
                 to: 'SJIS',
                 from: 'UNICODE',
@@ -1,3 +1,4 @@
+import { submitJob } from './jobs.js';
 import { getDetailsData, clearDetailsTable, populateDetailsTable } from './precomp_details_table.js';
 
 let patientNumberInput, saveBtn, loadBtn, clearBtn, exportBtn, importBtn, importInput, exportAllBtn, importAllBtn, importAllInput;
@@ -230,22 +231,14 @@ export function initHeader() {
         const formData = new FormData();
         formData.append('file', file);
 
-        window.showLoading();
         try {
-            const res = await fetch('/api/precomp/import_all', {
-                method: 'POST',
-                body: formData,
-            });
-            const resData = await res.json();
-            if (!res.ok) throw new Error(resData.message || '全件インポートに失敗しました。');
-            
+            const resData = await submitJob('/api/precomp/import_all', { body: formData }, '予製データの一括インポート');
             window.showNotification(resData.message, 'success');
             resetHeader();
             clearDetailsTable();
         } catch (err) {
             window.showNotification(`エラー: ${err.message}`, 'error');
         } finally {
-            window.hideLoading();
             e.target.value = '';
         }
     });
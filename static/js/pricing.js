@@ -173,6 +173,8 @@ async function handleUpload() {
         }
         
         wholesalerNames.forEach(name => formData.append('wholesalerNames', name));
+        formData.append('validFrom', document.getElementById('pricing-valid-from').value);
+        formData.append('validTo', document.getElementById('pricing-valid-to').value);
 
         const res = await fetch('/api/pricing/upload', {
             method: 'POST',
@@ -0,0 +1,133 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\replenishment.js
+
+const SCOPE_LABELS = { product: '製品コード', package: '包装キー', yj: 'YJコード', class: 'ABC/XYZ分類' };
+let tableBody, fields;
+let policies = [];
+
+function renderPolicies() {
+    if (!policies.length) {
+        tableBody.innerHTML = '<tr><td colspan="11">登録されている補充条件がありません。</td></tr>';
+        return;
+    }
+    const valueOrDash = (v) => v ? v : '-';
+    tableBody.innerHTML = policies.map((p, i) => `
+        <tr data-index="${i}">
+            <td class="center">${SCOPE_LABELS[p.scope] || p.scope}</td>
+            <td class="left">${p.policyKey}</td>
+            <td class="left">${p.targetName || ''}</td>
+            <td class="right">${valueOrDash(p.minQuantity)}</td>
+            <td class="right">${valueOrDash(p.maxQuantity)}</td>
+            <td class="right">${valueOrDash(p.safetyDays)}</td>
+            <td class="right">${valueOrDash(p.leadTimeDays)}</td>
+            <td class="right">${valueOrDash(p.minOrderQuantity)}</td>
+            <td class="center">${p.roundToPackage ? '包装' : 'バラ'}</td>
+            <td class="left">${p.notes || ''}</td>
+            <td class="center">
+                <button class="edit-policy-btn btn">編集</button>
+                <button class="delete-policy-btn btn">削除</button>
+            </td>
+        </tr>`).join('');
+}
+
+async function loadPolicies() {
+    try {
+        const res = await fetch('/api/replenishment/policies');
+        if (!res.ok) throw new Error('補充条件の読み込みに失敗しました。');
+        policies = await res.json();
+        renderPolicies();
+    } catch (err) {
+        tableBody.innerHTML = `<tr><td colspan="11" style="color:red;">${err.message}</td></tr>`;
+    }
+}
+
+function fillForm(p) {
+    fields.scope.value = p.scope;
+    fields.key.value = p.policyKey;
+    fields.min.value = p.minQuantity || '';
+    fields.max.value = p.maxQuantity || '';
+    fields.safetyDays.value = p.safetyDays || '';
+    fields.leadTime.value = p.leadTimeDays || '';
+    fields.minOrder.value = p.minOrderQuantity || '';
+    fields.round.checked = p.roundToPackage;
+    fields.notes.value = p.notes || '';
+}
+
+async function savePolicy() {
+    const payload = {
+        scope: fields.scope.value,
+        policyKey: fields.key.value.trim(),
+        minQuantity: parseFloat(fields.min.value) || 0,
+        maxQuantity: parseFloat(fields.max.value) || 0,
+        safetyDays: parseFloat(fields.safetyDays.value) || 0,
+        leadTimeDays: parseInt(fields.leadTime.value, 10) || 0,
+        minOrderQuantity: parseFloat(fields.minOrder.value) || 0,
+        roundToPackage: fields.round.checked,
+        notes: fields.notes.value.trim(),
+    };
+    if (!payload.policyKey) {
+        window.showNotification('補充条件のコードを入力してください。', 'error');
+        return;
+    }
+    try {
+        const res = await fetch('/api/replenishment/policies', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload),
+        });
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '補充条件の保存に失敗しました。');
+        }
+        const result = await res.json();
+        window.showNotification(result.message, 'success');
+        fillForm({ scope: payload.scope, policyKey: '', roundToPackage: true });
+        loadPolicies();
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    }
+}
+
+async function deletePolicy(p) {
+    if (!confirm(`補充条件「${p.policyKey}」を削除しますか？`)) return;
+    try {
+        const res = await fetch(`/api/replenishment/policies/${encodeURIComponent(p.policyKey)}`, { method: 'DELETE' });
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '補充条件の削除に失敗しました。');
+        }
+        const result = await res.json();
+        window.showNotification(result.message, 'success');
+        loadPolicies();
+    } catch (err) {
+        window.showNotification(err.message, 'error');
+    }
+}
+
+export function initReplenishmentPolicies() {
+    tableBody = document.querySelector('#policies-table tbody');
+    if (!tableBody) return;
+    fields = {
+        scope: document.getElementById('policyScope'),
+        key: document.getElementById('policyKey'),
+        min: document.getElementById('policyMinQuantity'),
+        max: document.getElementById('policyMaxQuantity'),
+        safetyDays: document.getElementById('policySafetyDays'),
+        leadTime: document.getElementById('policyLeadTimeDays'),
+        minOrder: document.getElementById('policyMinOrderQuantity'),
+        round: document.getElementById('policyRoundToPackage'),
+        notes: document.getElementById('policyNotes'),
+    };
+
+    document.getElementById('savePolicyBtn').addEventListener('click', savePolicy);
+    tableBody.addEventListener('click', (e) => {
+        const row = e.target.closest('tr[data-index]');
+        if (!row) return;
+        const p = policies[parseInt(row.dataset.index, 10)];
+        if (e.target.classList.contains('edit-policy-btn')) {
+            fillForm(p);
+        } else if (e.target.classList.contains('delete-policy-btn')) {
+            deletePolicy(p);
+        }
+    });
+    loadPolicies();
+}
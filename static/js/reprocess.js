@@ -1,9 +1,14 @@
 // C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\reprocess.js
+import { submitJob, registerJobResultHandler } from './jobs.js';
 
 export function initReprocessButton() {
     const reprocessBtn = document.getElementById('reprocessBtn');
     if (!reprocessBtn) return;
 
+    registerJobResultHandler('reprocess', (data) => {
+        window.showNotification(data.message, 'success');
+    });
+
     reprocessBtn.addEventListener('click', async () => {
         // ▼▼▼ [修正点] 確認メッセージをより強力なものに変更 ▼▼▼
         if (!confirm('全ての取引データを、最新のマスター情報で更新します。\nデータ量によっては数分かかる場合があります。\nこの操作は元に戻せません。よろしいですか？')) {
@@ -11,25 +16,15 @@ export function initReprocessButton() {
         }
         // ▲▲▲ 修正ここまで ▲▲▲
 
-        window.showLoading();
         try {
-            // ▼▼▼ [修正点] APIエンドポイントは /api/transactions/reprocess のまま（main.goで変更済み）▼▼▼
-            const res = await fetch('/api/transactions/reprocess', {
-                method: 'POST',
-            });
-            // ▲▲▲ 修正ここまで ▲▲▲
-   
-            const data = await res.json();
-            if (!res.ok) {
-                throw new Error(data.message || '処理に失敗しました。');
-            }
+            // 処理はサーバー側のジョブとして実行され、進捗がローディング画面に表示される
+            const data = await submitJob('/api/transactions/reprocess', {}, '取引データの再計算');
             window.showNotification(data.message, 'success');
         } catch (err) {
             console.error(err);
-     
-            window.showNotification(`エラー: ${err.message}`, 'error');
-        } finally {
-            window.hideLoading();
+            // 中止した場合は、中止までに更新した件数を表示する
+            const detail = err.result && err.result.message ? ` (${err.result.message})` : '';
+            window.showNotification(`エラー: ${err.message}${detail}`, 'error');
         }
     });
-}
\ No newline at end of file
+}
@@ -2,10 +2,109 @@
 import { refreshWholesalerMap } from './master_data.js';
 
 let view, userIDInput, passwordInput, saveBtn, usageFolderPathInput, calculationPeriodDaysInput, edgePathInput;
+let autoIngestEnabledInput, autoIngestIntervalInput, datWatchFolderPathInput;
+let emednetBaseUrlInput, chromePathInput, automationHeadlessInput, automationRetriesInput;
+let expiryAlertDaysInput;
+let cycleCountDaysInputs, cycleCountDailyLimitInput;
+let forecastMethodInput, forecastWindowDaysInput, forecastAlphaInput, leadTimeDaysInput, serviceLevelInput;
 let wholesalerCodeInput, wholesalerNameInput, addWholesalerBtn, wholesalersTableBody;
 let migrateInventoryBtn, migrateInventoryInput;
 let migrationResultContainer;
 
+const formatCurrency = (value) => new Intl.NumberFormat('ja-JP', { style: 'currency', currency: 'JPY' }).format(value || 0);
+
+async function loadClosedPeriods() {
+    const tbody = document.querySelector('#closed-periods-table tbody');
+    if (!tbody) return;
+    try {
+        const res = await fetch('/api/periods');
+        if (!res.ok) throw new Error('締め履歴の取得に失敗しました。');
+        const periods = await res.json();
+        if (periods.length === 0) {
+            tbody.innerHTML = '<tr><td colspan="7">締め済みの月はありません。</td></tr>';
+            return;
+        }
+        tbody.innerHTML = periods.map(p => {
+            const month = `${p.period.slice(0, 4)}年${p.period.slice(4)}月`;
+            const status = p.reopenedAt
+                ? `再開済み (${p.reopenedAt} ${p.reopenedBy}: ${p.reopenReason})`
+                : '<span style="font-weight: bold;">締め済み</span>';
+            const action = p.reopenedAt ? '' : `<button class="btn reopen-period-btn" data-id="${p.id}" data-month="${month}">再開</button>`;
+            return `<tr>
+                <td>${month}</td>
+                <td>${p.closedAt}</td>
+                <td>${p.closedBy}</td>
+                <td class="right">${formatCurrency(p.totalNhiValue)}</td>
+                <td class="right">${formatCurrency(p.totalPurchaseValue)}</td>
+                <td class="left">${status}</td>
+                <td>${action}</td>
+            </tr>`;
+        }).join('');
+    } catch (err) {
+        tbody.innerHTML = `<tr><td colspan="7" style="color:red;">${err.message}</td></tr>`;
+    }
+}
+
+function initPeriodCloseSection() {
+    const monthInput = document.getElementById('closePeriodMonth');
+    const closedByInput = document.getElementById('closePeriodBy');
+    const closeBtn = document.getElementById('closePeriodBtn');
+    const tbody = document.querySelector('#closed-periods-table tbody');
+    if (!closeBtn) return;
+
+    const lastMonth = new Date();
+    lastMonth.setDate(0);
+    monthInput.value = `${lastMonth.getFullYear()}-${String(lastMonth.getMonth() + 1).padStart(2, '0')}`;
+
+    closeBtn.addEventListener('click', async () => {
+        if (!monthInput.value) {
+            window.showNotification('締める月を指定してください。', 'error');
+            return;
+        }
+        if (!confirm(`${monthInput.value} を締めます。\n締めた月の取引データは修正・削除できなくなります。よろしいですか？`)) {
+            return;
+        }
+        window.showLoading('月末の在庫評価を保存中...');
+        try {
+            const res = await fetch('/api/periods/close', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ period: monthInput.value, closedBy: closedByInput.value.trim() }),
+            });
+            if (!res.ok) throw new Error(await res.text() || '締めに失敗しました。');
+            const resData = await res.json();
+            window.showNotification(resData.message, 'success');
+            loadClosedPeriods();
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        } finally {
+            window.hideLoading();
+        }
+    });
+
+    tbody.addEventListener('click', async (e) => {
+        if (!e.target.classList.contains('reopen-period-btn')) return;
+        const { id, month } = e.target.dataset;
+        const reopenedBy = prompt(`${month} を再開します。管理者名を入力してください。`);
+        if (!reopenedBy) return;
+        const reason = prompt('再開の理由を入力してください。');
+        if (!reason) return;
+        try {
+            const res = await fetch('/api/periods/reopen', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ id: Number(id), reopenedBy, reason }),
+            });
+            if (!res.ok) throw new Error(await res.text() || '再開に失敗しました。');
+            const resData = await res.json();
+            window.showNotification(resData.message, 'success');
+            loadClosedPeriods();
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        }
+    });
+}
+
 function initCleanupSection() {
     const getBtn = document.getElementById('getCleanupCandidatesBtn');
     const outputContainer = document.getElementById('cleanup-output-container');
@@ -121,6 +220,22 @@ async function loadSettings() {
         if (calculationPeriodDaysInput) {
             calculationPeriodDaysInput.value = settings.calculationPeriodDays || 90;
         }
+        if (expiryAlertDaysInput) {
+            expiryAlertDaysInput.value = (settings.expiryAlertDays || [30, 60, 90]).join(',');
+        }
+        if (cycleCountDailyLimitInput) {
+            cycleCountDaysInputs.A.value = settings.cycleCountDaysA || '';
+            cycleCountDaysInputs.B.value = settings.cycleCountDaysB || '';
+            cycleCountDaysInputs.C.value = settings.cycleCountDaysC || '';
+            cycleCountDailyLimitInput.value = settings.cycleCountDailyLimit || 0;
+        }
+        if (forecastMethodInput) {
+            forecastMethodInput.value = settings.forecastMethod || 'max_usage';
+            forecastWindowDaysInput.value = settings.forecastWindowDays || '';
+            forecastAlphaInput.value = settings.forecastAlpha || '';
+            leadTimeDaysInput.value = settings.leadTimeDays || '';
+            serviceLevelInput.value = settings.serviceLevel ? Math.round(settings.serviceLevel * 1000) / 10 : '';
+        }
         if (edgePathInput) {
              edgePathInput.value = settings.edgePath || '';
         }
@@ -128,6 +243,22 @@ async function loadSettings() {
         if(edgeBtn) {
             edgeBtn.disabled = !settings.edgePath;
         }
+        if (autoIngestEnabledInput) {
+            autoIngestEnabledInput.checked = !!settings.autoIngestEnabled;
+            autoIngestIntervalInput.value = settings.autoIngestIntervalSeconds || 60;
+            datWatchFolderPathInput.value = settings.datWatchFolderPath || '';
+        }
+        if (emednetBaseUrlInput) {
+            emednetBaseUrlInput.value = settings.emednetBaseUrl || '';
+            chromePathInput.value = settings.chromePath || '';
+            automationHeadlessInput.checked = !!settings.automationHeadless;
+            automationRetriesInput.value = settings.automationRetries || 0;
+        }
+        loadAutoIngestStatus();
+        loadUsageFormats();
+        loadNsipsLayout();
+        loadScheduledJobs();
+        loadClosedPeriods();
 
     } catch (err) {
          console.error(err);
@@ -135,6 +266,212 @@ async function loadSettings() {
     }
 }
 
+async function loadUsageFormats() {
+    const textarea = document.getElementById('usageFormatsJson');
+    if (!textarea) return;
+    try {
+        const res = await fetch('/api/settings/usage_formats');
+        if (!res.ok) throw new Error('USAGE形式の読み込みに失敗しました。');
+        const formats = await res.json();
+        const custom = formats.filter(f => !f.builtIn);
+        textarea.value = JSON.stringify(custom, null, 2);
+    } catch (err) {
+        console.error(err);
+        window.showNotification(err.message, 'error');
+    }
+}
+
+async function loadNsipsLayout() {
+    const textarea = document.getElementById('nsipsLayoutJson');
+    if (!textarea) return;
+    try {
+        const res = await fetch('/api/settings/nsips_layout');
+        if (!res.ok) throw new Error('NSIPSレイアウトの読み込みに失敗しました。');
+        textarea.value = JSON.stringify(await res.json(), null, 2);
+    } catch (err) {
+        console.error(err);
+        window.showNotification(err.message, 'error');
+    }
+}
+
+async function saveNsipsLayout() {
+    const textarea = document.getElementById('nsipsLayoutJson');
+    let layout;
+    try {
+        layout = JSON.parse(textarea.value.trim());
+    } catch (err) {
+        window.showNotification('NSIPSレイアウトのJSONが正しくありません: ' + err.message, 'error');
+        return;
+    }
+    window.showLoading();
+    try {
+        const res = await fetch('/api/settings/nsips_layout', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(layout),
+        });
+        if (!res.ok) throw new Error(await res.text());
+        const resData = await res.json();
+        window.showNotification(resData.message, 'success');
+        loadNsipsLayout();
+    } catch (err) {
+        console.error(err);
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+    }
+}
+
+async function saveUsageFormats() {
+    const textarea = document.getElementById('usageFormatsJson');
+    let formats;
+    try {
+        formats = JSON.parse(textarea.value.trim() || '[]');
+    } catch (err) {
+        window.showNotification('USAGE形式のJSONが正しくありません: ' + err.message, 'error');
+        return;
+    }
+    window.showLoading();
+    try {
+        const res = await fetch('/api/settings/usage_formats', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(formats),
+        });
+        if (!res.ok) throw new Error(await res.text());
+        const resData = await res.json();
+        window.showNotification(resData.message, 'success');
+        loadUsageFormats();
+    } catch (err) {
+        console.error(err);
+        window.showNotification(err.message, 'error');
+    } finally {
+        window.hideLoading();
+    }
+}
+
+const jobStatusLabels = { RUNNING: '実行中', SUCCESS: '成功', FAILED: '失敗' };
+
+async function loadScheduledJobs() {
+    const tbody = document.querySelector('#scheduled-jobs-table tbody');
+    if (!tbody) return;
+    try {
+        const res = await fetch('/api/scheduler/jobs');
+        if (!res.ok) throw new Error(await res.text());
+        const jobs = await res.json();
+        tbody.innerHTML = jobs.map(job => {
+            let last = job.lastRunAt || '-';
+            if (job.running) last += ' (実行中)';
+            else if (job.lastStatus) last += ` (${jobStatusLabels[job.lastStatus] || job.lastStatus})`;
+            return `
+            <tr data-job-name="${job.name}">
+                <td class="left">${job.name}<br><span style="font-size: 11px;">${job.description}</span></td>
+                <td class="center"><input type="checkbox" class="job-enabled" ${job.enabled ? 'checked' : ''}></td>
+                <td><input type="text" class="job-cron" value="${job.cronExpr}" style="width: 95%;"></td>
+                <td class="center">${job.nextRunAt || '-'}</td>
+                <td class="center">${last}</td>
+                <td class="center">
+                    <button class="btn save-job-btn">保存</button>
+                    <button class="btn run-job-btn" ${job.running ? 'disabled' : ''}>今すぐ実行</button>
+                </td>
+            </tr>`;
+        }).join('');
+        loadJobRuns();
+    } catch (err) {
+        tbody.innerHTML = `<tr><td colspan="6">定期実行ジョブを取得できませんでした: ${err.message}</td></tr>`;
+    }
+}
+
+async function loadJobRuns() {
+    const container = document.getElementById('job-runs-container');
+    if (!container) return;
+    try {
+        const res = await fetch('/api/scheduler/runs?limit=20');
+        if (!res.ok) throw new Error(await res.text());
+        const runs = await res.json();
+        if (runs.length === 0) {
+            container.innerHTML = '<p style="font-size: 12px;">実行履歴はありません。</p>';
+            return;
+        }
+        const rows = runs.map(run => `
+            <tr>
+                <td class="center">${run.startedAt}</td>
+                <td class="center">${run.finishedAt || '-'}</td>
+                <td class="left">${run.jobName}</td>
+                <td class="center">${run.triggerType === 'MANUAL' ? '手動' : '定期'}</td>
+                <td class="center">${jobStatusLabels[run.status] || run.status}</td>
+                <td class="left">${run.message || ''}${run.error ? `<br><span style="color: #dc3545;">${run.error}</span>` : ''}</td>
+            </tr>`).join('');
+        container.innerHTML = `
+            <table class="data-table">
+                <thead><tr><th>開始</th><th>終了</th><th>ジョブ</th><th>契機</th><th>結果</th><th>内容</th></tr></thead>
+                <tbody>${rows}</tbody>
+            </table>`;
+    } catch (err) {
+        container.textContent = '実行履歴を取得できませんでした: ' + err.message;
+    }
+}
+
+async function handleScheduledJobAction(e) {
+    const row = e.target.closest('tr[data-job-name]');
+    if (!row) return;
+    const name = row.dataset.jobName;
+
+    if (e.target.classList.contains('save-job-btn')) {
+        try {
+            const res = await fetch('/api/scheduler/jobs/update', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    name: name,
+                    cronExpr: row.querySelector('.job-cron').value.trim(),
+                    enabled: row.querySelector('.job-enabled').checked,
+                }),
+            });
+            if (!res.ok) throw new Error(await res.text());
+            const resData = await res.json();
+            window.showNotification(resData.message, 'success');
+            loadScheduledJobs();
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        }
+    } else if (e.target.classList.contains('run-job-btn')) {
+        if (!confirm(`ジョブ ${name} を今すぐ実行しますか？`)) return;
+        try {
+            const res = await fetch('/api/scheduler/jobs/run', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name: name }),
+            });
+            if (!res.ok) throw new Error(await res.text());
+            const resData = await res.json();
+            window.showNotification(resData.message, 'success');
+            loadScheduledJobs();
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        }
+    }
+}
+
+async function loadAutoIngestStatus() {
+    const container = document.getElementById('autoIngestStatus');
+    if (!container) return;
+    try {
+        const res = await fetch('/api/ingest/status');
+        if (!res.ok) throw new Error(await res.text());
+        const status = await res.json();
+        const lines = (status.watchers || []).filter(w => w.path).map(w => {
+            let text = `${w.sourceType}: 最終確認 ${w.lastScanAt || '-'} / 取込 ${w.importedCount}件`;
+            if (w.lastImportedAt) text += ` (最終 ${w.lastFile} ${w.lastImportedAt})`;
+            if (w.lastError) text += `<br><span style="color: #dc3545;">最終エラー ${w.lastErrorAt}: ${w.lastError}</span>`;
+            return text;
+        });
+        container.innerHTML = lines.length > 0 ? lines.join('<br>') : '';
+    } catch (err) {
+        container.textContent = '自動取込の状態を取得できませんでした: ' + err.message;
+    }
+}
+
 async function saveSettings() {
     window.showLoading();
     try {
@@ -159,6 +496,25 @@ async function saveSettings() {
             calculationPeriodDays: periodDays,
  
              edgePath: edgePath,
+            autoIngestEnabled: autoIngestEnabledInput.checked,
+            autoIngestIntervalSeconds: parseInt(autoIngestIntervalInput.value, 10) || 60,
+            datWatchFolderPath: datWatchFolderPathInput.value.trim(),
+            emednetBaseUrl: emednetBaseUrlInput.value.trim(),
+            chromePath: chromePathInput.value.trim(),
+            automationHeadless: automationHeadlessInput.checked,
+            automationRetries: parseInt(automationRetriesInput.value, 10) || 0,
+            expiryAlertDays: expiryAlertDaysInput.value.split(',')
+                .map(v => parseInt(v.trim(), 10))
+                .filter(v => v > 0),
+            cycleCountDaysA: parseInt(cycleCountDaysInputs.A.value, 10) || 0,
+            cycleCountDaysB: parseInt(cycleCountDaysInputs.B.value, 10) || 0,
+            cycleCountDaysC: parseInt(cycleCountDaysInputs.C.value, 10) || 0,
+            cycleCountDailyLimit: parseInt(cycleCountDailyLimitInput.value, 10) || 0,
+            forecastMethod: forecastMethodInput.value,
+            forecastWindowDays: parseInt(forecastWindowDaysInput.value, 10) || 0,
+            forecastAlpha: parseFloat(forecastAlphaInput.value) || 0,
+            leadTimeDays: parseInt(leadTimeDaysInput.value, 10) || 0,
+            serviceLevel: (parseFloat(serviceLevelInput.value) || 0) / 100,
         };
 
         const res = await fetch('/api/settings/save', {
@@ -187,14 +543,14 @@ async function saveSettings() {
 
 function renderWholesalers(wholesalers) {
     if (!wholesalers) {
-        wholesalersTableBody.innerHTML = '<tr><td colspan="3">登録されている卸業者がありません。</td></tr>';
+        wholesalersTableBody.innerHTML = '<tr><td colspan="4">登録されている卸業者がありません。</td></tr>';
         return;
     }
     wholesalersTableBody.innerHTML = wholesalers.map(w => `
         <tr data-code="${w.code}">
             <td>${w.code}</td>
             <td class="left">${w.name}</td>
-      
+            <td class="right"><input type="number" class="wholesaler-min-amount-input" value="${w.minOrderAmount || 0}" min="0" style="width: 100px;"></td>
              <td class="center"><button class="delete-wholesaler-btn btn">削除</button></td>
         </tr>
     `).join('');
@@ -302,6 +658,25 @@ export function initSettings() {
     usageFolderPathInput = document.getElementById('usageFolderPath');
     calculationPeriodDaysInput = document.getElementById('calculationPeriodDays');
     edgePathInput = document.getElementById('edgePath');
+    autoIngestEnabledInput = document.getElementById('autoIngestEnabled');
+    autoIngestIntervalInput = document.getElementById('autoIngestIntervalSeconds');
+    datWatchFolderPathInput = document.getElementById('datWatchFolderPath');
+    emednetBaseUrlInput = document.getElementById('emednetBaseUrl');
+    chromePathInput = document.getElementById('chromePath');
+    automationHeadlessInput = document.getElementById('automationHeadless');
+    automationRetriesInput = document.getElementById('automationRetries');
+    expiryAlertDaysInput = document.getElementById('expiryAlertDays');
+    cycleCountDaysInputs = {
+        A: document.getElementById('cycleCountDaysA'),
+        B: document.getElementById('cycleCountDaysB'),
+        C: document.getElementById('cycleCountDaysC'),
+    };
+    cycleCountDailyLimitInput = document.getElementById('cycleCountDailyLimit');
+    forecastMethodInput = document.getElementById('forecastMethod');
+    forecastWindowDaysInput = document.getElementById('forecastWindowDays');
+    forecastAlphaInput = document.getElementById('forecastAlpha');
+    leadTimeDaysInput = document.getElementById('leadTimeDays');
+    serviceLevelInput = document.getElementById('serviceLevel');
     wholesalerCodeInput = document.getElementById('wholesalerCode');
     wholesalerNameInput = document.getElementById('wholesalerName');
     addWholesalerBtn = document.getElementById('addWholesalerBtn');
@@ -314,6 +689,7 @@ export function initSettings() {
     migrationResultContainer = document.getElementById('migration-result-container');
 
     initCleanupSection();
+    initPeriodCloseSection();
     
     if (migrateInventoryBtn) {
         migrateInventoryBtn.addEventListener('click', () => {
@@ -383,6 +759,22 @@ export function initSettings() {
     });
 
     saveBtn.addEventListener('click', saveSettings);
+    const scheduledJobsTable = document.getElementById('scheduled-jobs-table');
+    if (scheduledJobsTable) {
+        scheduledJobsTable.addEventListener('click', handleScheduledJobAction);
+    }
+    const refreshJobRunsBtn = document.getElementById('refreshJobRunsBtn');
+    if (refreshJobRunsBtn) {
+        refreshJobRunsBtn.addEventListener('click', loadScheduledJobs);
+    }
+    const saveUsageFormatsBtn = document.getElementById('saveUsageFormatsBtn');
+    if (saveUsageFormatsBtn) {
+        saveUsageFormatsBtn.addEventListener('click', saveUsageFormats);
+    }
+    const saveNsipsLayoutBtn = document.getElementById('saveNsipsLayoutBtn');
+    if (saveNsipsLayoutBtn) {
+        saveNsipsLayoutBtn.addEventListener('click', saveNsipsLayout);
+    }
     addWholesalerBtn.addEventListener('click', addWholesaler);
     
     clearTransactionsBtn.addEventListener('click', async () => {
@@ -407,6 +799,25 @@ export function initSettings() {
          }
     });
 
+    wholesalersTableBody.addEventListener('change', async (e) => {
+        if (!e.target.classList.contains('wholesaler-min-amount-input')) return;
+        const code = e.target.closest('tr').dataset.code;
+        try {
+            const res = await fetch('/api/settings/wholesalers', {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ code, minOrderAmount: parseFloat(e.target.value) || 0 }),
+            });
+            if (!res.ok) {
+                const errText = await res.text();
+                throw new Error(errText || '最低発注金額の更新に失敗しました。');
+            }
+            const resData = await res.json();
+            window.showNotification(resData.message, 'success');
+        } catch (err) {
+            window.showNotification(err.message, 'error');
+        }
+    });
     wholesalersTableBody.addEventListener('click', async (e) => {
         if (e.target.classList.contains('delete-wholesaler-btn')) {
             const row = e.target.closest('tr');
@@ -36,4 +36,63 @@ export function toHalfWidth(str) {
         return String.fromCharCode(s.charCodeAt(0) - 0xFEE0);
     }).replace(/　/g, ' '); // 全角スペースを半角スペースに
 }
-// ▲▲▲【追加ここまで】▲▲▲
\ No newline at end of file
+// ▲▲▲【追加ここまで】▲▲▲
+const FORECAST_METHOD_LABELS = {
+    moving_average: '移動平均',
+    exponential_smoothing: '指数平滑',
+    day_of_week: '曜日別平均',
+    max_usage: '最大処方量×係数',
+};
+
+/**
+ * 発注点の計算方法と内訳 (StockLedgerPackageGroup.forecast) を表示用の文字列にします。
+ * @param {object} forecast 発注点の計算方法と内訳
+ * @returns {string} 表示用の文字列 (内訳が無い場合は空文字)
+ */
+export function describeForecast(forecast) {
+    if (!forecast) return '';
+    const label = FORECAST_METHOD_LABELS[forecast.method] || forecast.method;
+    if (forecast.method === 'max_usage') {
+        return `${label} (係数${forecast.coefficient || 0})`;
+    }
+    let params = '';
+    if (forecast.method === 'exponential_smoothing') {
+        params = `α${forecast.alpha}`;
+    } else {
+        params = `直近${Math.min(forecast.windowDays || forecast.historyDays, forecast.historyDays)}日`;
+    }
+    const fmt = (v) => (v || 0).toFixed(2);
+    return `${label} ${params} | 日平均${fmt(forecast.dailyDemand)} × LT${forecast.leadTimeDays}日 = ${fmt(forecast.leadTimeDemand)}` +
+        ` + 安全在庫${fmt(forecast.safetyStock)} (サービス率${Math.round(forecast.serviceLevel * 100)}%)`;
+}
+
+// HTMLに埋め込む文字列をエスケープする (ファイル名や入力された理由などの自由入力欄に使用)
+export function escapeHtml(s) {
+    return String(s ?? '').replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+}
+
+// 発注書 (PDF・Excel・卸の発注サイト取込用CSV) のダウンロードリンクを返す
+export function purchaseOrderLinks(poNumber) {
+    if (!poNumber) return '';
+    const url = (format) => `/api/orders/purchase_order?poNumber=${encodeURIComponent(poNumber)}&format=${format}`;
+    return `<a href="${url('pdf')}" target="_blank">PDF</a> <a href="${url('xlsx')}">Excel</a> <a href="${url('csv')}">CSV</a>`;
+}
+
+// バーコードをサーバーのGS1パーサー (/api/product/scan_gs1) で解析し、製品・有効期限(YYYYMM)・ロットを返す
+// AIを含まないJAN(13桁)・GTIN(14桁)のみの入力は AI(01) を補って解析する
+export async function scanGS1(code) {
+    let value = String(code ?? '').trim();
+    if (/^\d{13}$/.test(value)) {
+        value = '010' + value;
+    } else if (/^\d{14}$/.test(value)) {
+        value = '01' + value;
+    }
+    const res = await fetch(`/api/product/scan_gs1?code=${encodeURIComponent(value)}`);
+    if (!res.ok) {
+        if (res.status === 400) {
+            throw new Error('有効なGS1コードではありません。');
+        }
+        throw new Error(`製品情報の検索に失敗しました (HTTP ${res.status})`);
+    }
+    return await res.json();
+}
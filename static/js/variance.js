@@ -0,0 +1,99 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\static\js\variance.js
+
+let view, outputContainer;
+const formatCurrency = (value) => new Intl.NumberFormat('ja-JP', { style: 'currency', currency: 'JPY' }).format(value || 0);
+const formatDate = (s) => (s && s.length === 8) ? `${s.slice(0, 4)}-${s.slice(4, 6)}-${s.slice(6)}` : (s || '');
+const formatQty = (v) => (Math.round((v || 0) * 1000) / 1000).toLocaleString('ja-JP', { maximumFractionDigits: 3 });
+const GROUP_LABELS = { shelf: '棚番', form: '剤型', drugType: '薬品区分' };
+const SOURCE_LABELS = { guided: '棚卸調整', manual: '手入力' };
+
+function buildParams() {
+    return new URLSearchParams({
+        startDate: document.getElementById('variance-start-date').value,
+        endDate: document.getElementById('variance-end-date').value,
+        groupBy: document.getElementById('variance-group-by').value,
+        differencesOnly: document.getElementById('variance-differences-only').checked ? 'true' : 'false',
+    });
+}
+
+function renderReport(report) {
+    if (!report.items.length) {
+        outputContainer.innerHTML = '<p>対象期間の棚卸差異はありません。</p>';
+        return;
+    }
+    const valueCell = (v) => `<td class="right"${v < 0 ? ' style="color: red;"' : ''}>${formatCurrency(v)}</td>`;
+    const summaryRows = report.summaries.map(s => `
+        <tr>
+            <td class="center">${formatDate(s.countDate)}</td>
+            <td class="left">${s.groupKey}</td>
+            <td class="right">${s.itemCount}</td>
+            <td class="right">${s.discrepancyCount}</td>
+            ${valueCell(s.shortageNhiValue)}
+            ${valueCell(s.surplusNhiValue)}
+            ${valueCell(s.netNhiValue)}
+            ${valueCell(s.netPurchaseValue)}
+        </tr>`).join('');
+    const detailRows = report.items.map(item => `
+        <tr${item.difference < 0 ? ' style="color: red;"' : ''}>
+            <td class="center">${formatDate(item.countDate)}</td>
+            <td class="left">${item.productName}</td>
+            <td class="left">${item.shelfNumber || ''}</td>
+            <td class="center">${item.usageClassification || ''}</td>
+            <td class="center">${item.drugType}</td>
+            <td class="right">${formatQty(item.theoreticalQuantity)}</td>
+            <td class="right">${formatQty(item.countedQuantity)}</td>
+            <td class="right">${item.difference > 0 ? '+' : ''}${formatQty(item.difference)} ${item.yjUnitName}</td>
+            <td class="right">${formatCurrency(item.nhiValue)}</td>
+            <td class="right">${formatCurrency(item.purchaseValue)}</td>
+            <td class="center">${SOURCE_LABELS[item.source] || item.source}</td>
+        </tr>`).join('');
+
+    outputContainer.innerHTML = `
+        <h3 style="margin: 10px 0 4px;">棚卸日・${GROUP_LABELS[report.groupBy]}別の集計</h3>
+        <table class="data-table">
+            <thead>
+                <tr><th>棚卸日</th><th>${GROUP_LABELS[report.groupBy]}</th><th>品目数</th><th>差異品目数</th><th>不足 (薬価)</th><th>過剰 (薬価)</th><th>差引 (薬価)</th><th>差引 (納入価)</th></tr>
+            </thead>
+            <tbody>${summaryRows}</tbody>
+        </table>
+        <h3 style="margin: 16px 0 4px;">明細</h3>
+        <table class="data-table">
+            <thead>
+                <tr><th>棚卸日</th><th>製品名</th><th>棚番</th><th>剤型</th><th>薬品区分</th><th>理論在庫</th><th>実棚数</th><th>差異</th><th>薬価金額</th><th>納入価金額</th><th>入力元</th></tr>
+            </thead>
+            <tbody>${detailRows}</tbody>
+        </table>`;
+}
+
+async function runReport() {
+    window.showLoading();
+    try {
+        const res = await fetch(`/api/variance?${buildParams().toString()}`);
+        if (!res.ok) {
+            const errText = await res.text();
+            throw new Error(errText || '棚卸差異レポートの取得に失敗しました。');
+        }
+        renderReport(await res.json());
+    } catch (err) {
+        outputContainer.innerHTML = `<p style="color:red;">${err.message}</p>`;
+    } finally {
+        window.hideLoading();
+    }
+}
+
+export function initVarianceView() {
+    view = document.getElementById('variance-view');
+    if (!view) return;
+    outputContainer = document.getElementById('variance-output-container');
+
+    const today = new Date();
+    const from = new Date(today.getFullYear(), today.getMonth() - 11, 1);
+    const toDateString = (d) => `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
+    document.getElementById('variance-start-date').value = toDateString(from);
+    document.getElementById('variance-end-date').value = toDateString(today);
+
+    document.getElementById('run-variance-btn').addEventListener('click', runReport);
+    document.getElementById('export-variance-btn').addEventListener('click', () => {
+        window.location.href = `/api/variance/export?${buildParams().toString()}`;
+    });
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"wasabi/db"
+	"wasabi/model"
 )
 
 // GetCurrentStockHandler はJANコード指定で現在の理論在庫を返します。
@@ -42,3 +43,82 @@ func GetAllCurrentStockHandler(conn *sql.DB) http.HandlerFunc {
 }
 
 // ▲▲▲ 修正ここまで ▲▲▲
+
+// ProductLotStock は製品の現在庫とロット・有効期限ごとの内訳です。
+type ProductLotStock struct {
+	ProductCode     string             `json:"productCode"`
+	ProductName     string             `json:"productName"`
+	YjCode          string             `json:"yjCode"`
+	YjUnitName      string             `json:"yjUnitName"`
+	JanPackInnerQty float64            `json:"janPackInnerQty"`
+	CurrentStock    float64            `json:"currentStock"` // YJ単位
+	Lots            []model.LotBalance `json:"lots"`
+}
+
+// GetLotStockHandler は製品の現在庫をロット・有効期限ごとに返します。
+// クエリパラメータ jan_code で単一製品、yj_code で同じYJコードの全包装を指定します。
+// ロットは期限の近い順で、ロット番号・有効期限が空の行はロット不明の在庫です。
+func GetLotStockHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		janCode := r.URL.Query().Get("jan_code")
+		yjCode := r.URL.Query().Get("yj_code")
+
+		var masters []*model.ProductMaster
+		switch {
+		case janCode != "":
+			master, err := db.GetProductMasterByCode(conn, janCode)
+			if err != nil {
+				http.Error(w, "Failed to get product master: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if master == nil {
+				http.Error(w, "製品が見つかりません。", http.StatusNotFound)
+				return
+			}
+			masters = append(masters, master)
+		case yjCode != "":
+			var err error
+			masters, err = db.GetProductMastersByYjCode(conn, yjCode)
+			if err != nil {
+				http.Error(w, "Failed to get product masters: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "jan_code or yj_code parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		codes := make([]string, 0, len(masters))
+		for _, m := range masters {
+			codes = append(codes, m.ProductCode)
+		}
+		lotsMap, err := db.GetLotBalancesByProductCodes(conn, codes)
+		if err != nil {
+			http.Error(w, "Failed to get lot balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]ProductLotStock, 0, len(masters))
+		for _, m := range masters {
+			lots := lotsMap[m.ProductCode]
+			if lots == nil {
+				lots = []model.LotBalance{}
+			}
+			item := ProductLotStock{
+				ProductCode:     m.ProductCode,
+				ProductName:     m.ProductName,
+				YjCode:          m.YjCode,
+				YjUnitName:      m.YjUnitName,
+				JanPackInnerQty: m.JanPackInnerQty,
+				Lots:            lots,
+			}
+			for _, l := range lots {
+				item.CurrentStock += l.Quantity
+			}
+			result = append(result, item)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
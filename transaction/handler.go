@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv" // strconvをインポート
 	"strings"
+	"wasabi/audit"
 	"wasabi/db"
 )
 
@@ -56,7 +57,7 @@ func DeleteTransactionHandler(conn *sql.DB) http.HandlerFunc {
 			http.Error(w, "Receipt number is required", http.StatusBadRequest)
 			return
 		}
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -64,10 +65,19 @@ func DeleteTransactionHandler(conn *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		if err := db.DeleteTransactionsByReceiptNumberInTx(tx, receiptNumber); err != nil {
+			if db.IsPeriodClosedError(err) {
+				http.Error(w, "締め済みの期間の取引データは変更できません: "+err.Error(), http.StatusConflict)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
@@ -108,7 +118,7 @@ func DeleteTransactionByIDHandler(conn *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		tx, err := conn.Begin()
+		tx, err := db.BeginAuditTx(conn, audit.RequestUser(r))
 		if err != nil {
 			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
 			return
@@ -116,10 +126,19 @@ func DeleteTransactionByIDHandler(conn *sql.DB) http.HandlerFunc {
 		defer tx.Rollback()
 
 		if err := db.DeleteTransactionByIDInTx(tx, id); err != nil {
+			if db.IsPeriodClosedError(err) {
+				http.Error(w, "締め済みの期間の取引データは変更できません: "+err.Error(), http.StatusConflict)
+				return
+			}
 			http.Error(w, "Failed to delete transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if err := db.RefreshStockBalancesInTx(tx); err != nil {
+			http.Error(w, "Failed to update stock balances: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
 			return
@@ -1,15 +1,20 @@
 package usage
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"wasabi/audit"
 	"wasabi/config"
 	"wasabi/db"
 	"wasabi/mappers"
@@ -26,25 +31,99 @@ INSERT OR REPLACE INTO transaction_records (
     yj_quantity, yj_pack_unit_qty, yj_unit_name, unit_price, purchase_price, supplier_wholesale,
     subtotal, tax_amount, tax_rate, expiry_date, lot_number, flag_poison,
     flag_deleterious, flag_narcotic, flag_psychotropic, flag_stimulant,
-    flag_stimulant_raw, process_flag_ma
-) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+    flag_stimulant_raw, process_flag_ma, import_batch_id
+) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// insertPrescriptionRecordsInTx は処方(flag=3)の明細に製品マスターの情報を補い、取込バッチに紐付けて登録します。
+// マスターが無い製品は mastermanager で仮登録します。USAGE と NSIPS の取込で共通に使用します。
+func insertPrescriptionRecordsInTx(tx *sql.Tx, records []model.TransactionRecord, batchID int64) ([]model.TransactionRecord, error) {
+	var keyList, janList []string
+	keySet, janSet := make(map[string]struct{}), make(map[string]struct{})
+	for _, rec := range records {
+		if rec.JanCode != "" && rec.JanCode != "0000000000000" {
+			if _, seen := janSet[rec.JanCode]; !seen {
+				janSet[rec.JanCode] = struct{}{}
+				janList = append(janList, rec.JanCode)
+			}
+		}
+		key := rec.JanCode
+		if key == "" || key == "0000000000000" {
+			key = fmt.Sprintf("9999999999999%s", rec.ProductName)
+		}
+		if _, seen := keySet[key]; !seen {
+			keySet[key] = struct{}{}
+			keyList = append(keyList, key)
+		}
+	}
+
+	mastersMap, err := db.GetProductMastersByCodesMap(tx, keyList)
+	if err != nil {
+		return nil, err
+	}
+	jcshmsMap, err := db.GetJcshmsByCodesMap(tx, janList)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(insertTransactionQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var finalRecords []model.TransactionRecord
+	for _, ar := range records {
+		master, err := mastermanager.FindOrCreate(tx, ar.JanCode, ar.ProductName, mastersMap, jcshmsMap)
+		if err != nil {
+			return nil, err
+		}
+
+		mappers.MapProductMasterToTransaction(&ar, master)
+		if master.Origin == "JCSHMS" {
+			ar.ProcessFlagMA = "COMPLETE"
+		} else {
+			ar.ProcessFlagMA = "PROVISIONAL"
+		}
+
+		_, err = stmt.Exec(
+			ar.TransactionDate, ar.ClientCode, ar.ReceiptNumber, ar.LineNumber, ar.Flag,
+			ar.JanCode, ar.YjCode, ar.ProductName, ar.KanaName, ar.UsageClassification, ar.PackageForm, ar.PackageSpec, ar.MakerName,
+			ar.DatQuantity, ar.JanPackInnerQty, ar.JanQuantity, ar.JanPackUnitQty, ar.JanUnitName, ar.JanUnitCode,
+			ar.YjQuantity, ar.YjPackUnitQty, ar.YjUnitName, ar.UnitPrice, ar.PurchasePrice, ar.SupplierWholesale,
+			ar.Subtotal, ar.TaxAmount, ar.TaxRate, ar.ExpiryDate, ar.LotNumber, ar.FlagPoison,
+			ar.FlagDeleterious, ar.FlagNarcotic, ar.FlagPsychotropic, ar.FlagStimulant,
+			ar.FlagStimulantRaw, ar.ProcessFlagMA, batchID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert record for JAN %s: %w", ar.JanCode, err)
+		}
+
+		finalRecords = append(finalRecords, ar)
+	}
+	return finalRecords, nil
+}
 
 // UploadUsageHandler は自動または手動でのUSAGEファイルアップロードを処理します。
 func UploadUsageHandler(conn *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var file io.Reader
+		var fileName string
+		var formatName string
 		var err error
 
 		if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
 			log.Println("Processing manual USAGE file upload...")
 			var f multipart.File
-			f, _, err = r.FormFile("file")
+			var header *multipart.FileHeader
+			f, header, err = r.FormFile("file")
 			if err != nil {
 				http.Error(w, "ファイルの取得に失敗しました: "+err.Error(), http.StatusBadRequest)
 				return
 			}
 			defer f.Close()
 			file = f
+			fileName = header.Filename
+			formatName = r.FormValue("format")
 		} else {
 			log.Println("Processing automatic USAGE file import...")
 			cfg, cfgErr := config.LoadConfig()
@@ -73,11 +152,16 @@ func UploadUsageHandler(conn *sql.DB) http.HandlerFunc {
 			}
 			defer f.Close()
 			file = f
+			fileName = filepath.Base(filePath)
 		}
 
-		processedRecords, procErr := processUsageFile(conn, file)
+		processedRecords, procErr := ProcessUsageFileWithFormat(conn, file, fileName, formatName, audit.RequestUser(r))
 		if procErr != nil {
-			http.Error(w, procErr.Error(), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if errors.Is(procErr, db.ErrDuplicateImport) || errors.Is(procErr, db.ErrUsageSourceConflict) || db.IsPeriodClosedError(procErr) {
+				status = http.StatusConflict
+			}
+			http.Error(w, procErr.Error(), status)
 			return
 		}
 
@@ -88,9 +172,86 @@ func UploadUsageHandler(conn *sql.DB) http.HandlerFunc {
 	}
 }
 
-// processUsageFile はファイルストリームから処方データを解析しDBに登録する共通関数です。
-func processUsageFile(conn *sql.DB, file io.Reader) ([]model.TransactionRecord, error) {
-	parsed, err := parsers.ParseUsage(file)
+// DetectUsageFormatHandler はアップロードされたUSAGEファイルの形式を判別し、選ばれた形式と先頭数件の解析結果を返します。
+// ファイルはデータベースに登録しません。
+func DetectUsageFormatHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "ファイルの取得に失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		content, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "USAGEファイルの読み込みに失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if layout := nsipsLayout(); parsers.IsNsips(content, layout) {
+			parsed, err := parsers.ParseNsips(bytes.NewReader(content), layout)
+			if err != nil {
+				http.Error(w, "NSIPSファイルの解析に失敗しました ("+layout.SpecVersion+"): "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			writeDetectResult(w, model.UsageFormat{Name: parsers.NsipsFormatName, BuiltIn: true}, parsed)
+			return
+		}
+
+		format, err := resolveUsageFormat(content, r.FormValue("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		parsed, err := parsers.ParseUsageWithFormat(bytes.NewReader(content), format)
+		if err != nil {
+			http.Error(w, "USAGEファイルの解析に失敗しました: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeDetectResult(w, format, parsed)
+	}
+}
+
+func writeDetectResult(w http.ResponseWriter, format model.UsageFormat, parsed []model.UnifiedInputRecord) {
+	sample := parsed
+	if len(sample) > 10 {
+		sample = sample[:10]
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"format":      format,
+		"recordCount": len(parsed),
+		"sample":      sample,
+	})
+}
+
+// ProcessUsageFile はファイルストリームから処方データを解析しDBに登録する共通関数です。
+// ファイルの形式は設定に登録されたUSAGE形式と標準形式の中から自動判別します。
+// 登録したレコードは取込バッチに紐付けられ、同一内容のファイルは再登録できません。
+// user は監査ログに記録する操作者です (自動取込では処理の名前)。
+func ProcessUsageFile(conn *sql.DB, file io.Reader, fileName string, user string) ([]model.TransactionRecord, error) {
+	return ProcessUsageFileWithFormat(conn, file, fileName, "", user)
+}
+
+// ProcessUsageFileWithFormat は ProcessUsageFile と同じ処理を、形式名を指定して行います。
+// formatName が空の場合は自動判別し、NSIPS処方データであれば ProcessNsipsFile で取り込みます。
+func ProcessUsageFileWithFormat(conn *sql.DB, file io.Reader, fileName, formatName, user string) ([]model.TransactionRecord, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("USAGEファイルの読み込みに失敗しました: %w", err)
+	}
+
+	if formatName == parsers.NsipsFormatName || (formatName == "" && parsers.IsNsips(content, nsipsLayout())) {
+		return ProcessNsipsFile(conn, content, fileName, user)
+	}
+
+	format, err := resolveUsageFormat(content, formatName)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Parsing USAGE file %s as format %q", fileName, format.Name)
+
+	parsed, err := parsers.ParseUsageWithFormat(bytes.NewReader(content), format)
 	if err != nil {
 		return nil, fmt.Errorf("USAGEファイルの解析に失敗しました: %w", err)
 	}
@@ -109,13 +270,15 @@ func processUsageFile(conn *sql.DB, file io.Reader) ([]model.TransactionRecord,
 		return []model.TransactionRecord{}, nil
 	}
 
-	tx, err := conn.Begin()
+	tx, err := db.BeginAuditTx(conn, user)
 	if err != nil {
 		return nil, fmt.Errorf("トランザクションの開始に失敗: %w", err)
 	}
 	defer tx.Rollback()
 
 	minDate, maxDate := "99999999", "00000000"
+	var dates []string
+	dateSet := make(map[string]struct{})
 	for _, rec := range filtered {
 		if rec.Date < minDate {
 			minDate = rec.Date
@@ -123,79 +286,49 @@ func processUsageFile(conn *sql.DB, file io.Reader) ([]model.TransactionRecord,
 		if rec.Date > maxDate {
 			maxDate = rec.Date
 		}
-	}
-
-	if err := db.DeleteUsageTransactionsInDateRange(tx, minDate, maxDate); err != nil {
-		return nil, fmt.Errorf("既存の処方データ削除に失敗: %w", err)
-	}
-
-	var keyList, janList []string
-	keySet, janSet := make(map[string]struct{}), make(map[string]struct{})
-	for _, rec := range filtered {
-		if rec.JanCode != "" && rec.JanCode != "0000000000000" {
-			if _, seen := janSet[rec.JanCode]; !seen {
-				janSet[rec.JanCode] = struct{}{}
-				janList = append(janList, rec.JanCode)
-			}
-		}
-		key := rec.JanCode
-		if key == "" || key == "0000000000000" {
-			key = fmt.Sprintf("9999999999999%s", rec.ProductName)
-		}
-		if _, seen := keySet[key]; !seen {
-			keySet[key] = struct{}{}
-			keyList = append(keyList, key)
+		if _, seen := dateSet[rec.Date]; !seen {
+			dateSet[rec.Date] = struct{}{}
+			dates = append(dates, rec.Date)
 		}
 	}
 
-	mastersMap, err := db.GetProductMastersByCodesMap(tx, keyList)
-	if err != nil {
+	if err := db.CheckUsageSourceInTx(tx, "USAGE", dates); err != nil {
 		return nil, err
 	}
-	jcshmsMap, err := db.GetJcshmsByCodesMap(tx, janList)
+
+	batchID, err := db.CreateImportBatchInTx(tx, "USAGE", fileName, fmt.Sprintf("%x", sha256.Sum256(content)))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("取込バッチの登録に失敗: %w", err)
 	}
 
-	stmt, err := tx.Prepare(insertTransactionQuery)
-	if err != nil {
-		return nil, err
+	// 期間内の既存の処方データ (USAGE 取込分) は置き換えられるため、ロールバックに備えて退避する
+	if _, err := db.SnapshotDisplacedRecordsInTx(tx, batchID, db.UsageRecordCondition+" AND transaction_date BETWEEN ? AND ?", minDate, maxDate); err != nil {
+		return nil, fmt.Errorf("既存の処方データの退避に失敗: %w", err)
 	}
-	defer stmt.Close()
 
-	var finalRecords []model.TransactionRecord
+	if err := db.DeleteUsageTransactionsInDateRange(tx, minDate, maxDate); err != nil {
+		return nil, fmt.Errorf("既存の処方データ削除に失敗: %w", err)
+	}
+
+	records := make([]model.TransactionRecord, 0, len(filtered))
 	for _, rec := range filtered {
-		ar := model.TransactionRecord{
+		records = append(records, model.TransactionRecord{
 			TransactionDate: rec.Date, Flag: 3, JanCode: rec.JanCode,
 			YjCode: rec.YjCode, ProductName: rec.ProductName,
 			YjQuantity: rec.YjQuantity, YjUnitName: rec.YjUnitName,
-		}
-		master, err := mastermanager.FindOrCreate(tx, rec.JanCode, rec.ProductName, mastersMap, jcshmsMap)
-		if err != nil {
-			return nil, err
-		}
-
-		mappers.MapProductMasterToTransaction(&ar, master)
-		if master.Origin == "JCSHMS" {
-			ar.ProcessFlagMA = "COMPLETE"
-		} else {
-			ar.ProcessFlagMA = "PROVISIONAL"
-		}
+		})
+	}
+	finalRecords, err := insertPrescriptionRecordsInTx(tx, records, batchID)
+	if err != nil {
+		return nil, err
+	}
 
-		_, err = stmt.Exec(
-			ar.TransactionDate, ar.ClientCode, ar.ReceiptNumber, ar.LineNumber, ar.Flag,
-			ar.JanCode, ar.YjCode, ar.ProductName, ar.KanaName, ar.UsageClassification, ar.PackageForm, ar.PackageSpec, ar.MakerName,
-			ar.DatQuantity, ar.JanPackInnerQty, ar.JanQuantity, ar.JanPackUnitQty, ar.JanUnitName, ar.JanUnitCode,
-			ar.YjQuantity, ar.YjPackUnitQty, ar.YjUnitName, ar.UnitPrice, ar.PurchasePrice, ar.SupplierWholesale,
-			ar.Subtotal, ar.TaxAmount, ar.TaxRate, ar.ExpiryDate, ar.LotNumber, ar.FlagPoison,
-			ar.FlagDeleterious, ar.FlagNarcotic, ar.FlagPsychotropic, ar.FlagStimulant,
-			ar.FlagStimulantRaw, ar.ProcessFlagMA,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to insert record for JAN %s: %w", ar.JanCode, err)
-		}
+	if err := db.RefreshStockBalancesInTx(tx); err != nil {
+		return nil, err
+	}
 
-		finalRecords = append(finalRecords, ar)
+	if err := db.FinalizeImportBatchInTx(tx, batchID); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -204,6 +337,19 @@ func processUsageFile(conn *sql.DB, file io.Reader) ([]model.TransactionRecord,
 	return finalRecords, nil
 }
 
+// resolveUsageFormat は指定された名前の形式、または自動判別した形式を返します。
+func resolveUsageFormat(content []byte, formatName string) (model.UsageFormat, error) {
+	custom := config.GetConfig().UsageFormats
+	if formatName != "" {
+		format, ok := parsers.FindUsageFormat(formatName, custom)
+		if !ok {
+			return model.UsageFormat{}, fmt.Errorf("USAGE形式 %q は登録されていません", formatName)
+		}
+		return format, nil
+	}
+	return parsers.DetectUsageFormat(content, custom)
+}
+
 func removeUsageDuplicates(records []model.UnifiedInputRecord) []model.UnifiedInputRecord {
 	seen := make(map[string]struct{})
 	var result []model.UnifiedInputRecord
@@ -0,0 +1,164 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\usage\nsips.go
+
+package usage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"wasabi/audit"
+	"wasabi/config"
+	"wasabi/db"
+	"wasabi/model"
+	"wasabi/parsers"
+)
+
+// UploadNsipsHandler はNSIPS処方データのアップロードを処理します。
+func UploadNsipsHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "ファイルの取得に失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "NSIPSファイルの読み込みに失敗しました: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		processedRecords, procErr := ProcessNsipsFile(conn, content, header.Filename, audit.RequestUser(r))
+		if procErr != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(procErr, db.ErrDuplicateImport) || errors.Is(procErr, db.ErrUsageSourceConflict) || db.IsPeriodClosedError(procErr) {
+				status = http.StatusConflict
+			}
+			http.Error(w, procErr.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"records": processedRecords,
+		})
+	}
+}
+
+// nsipsLayout は設定に登録されたNSIPSレイアウトを返します。未登録の場合は標準のレイアウトです。
+func nsipsLayout() model.NsipsLayout {
+	return parsers.ResolveNsipsLayout(config.GetConfig().NsipsLayout)
+}
+
+// ProcessNsipsFile はNSIPS処方データを解析し、処方(flag=3)の取引データとして登録します。
+// 患者番号は client_code に格納され、予製(precomp_records)と患者単位で突き合わせできます。
+// 同じ処方箋(調剤日・患者番号・処方箋番号)の既存の処方データは置き換えられ、取込バッチのロールバックで復元できます。
+// USAGE の処方データが登録済みの日付は二重計上になるため取り込みません。
+// user は監査ログに記録する操作者です。
+func ProcessNsipsFile(conn *sql.DB, content []byte, fileName string, user string) ([]model.TransactionRecord, error) {
+	layout := nsipsLayout()
+	parsed, err := parsers.ParseNsips(bytes.NewReader(content), layout)
+	if err != nil {
+		return nil, fmt.Errorf("NSIPSファイルの解析に失敗しました (%s): %w", layout.SpecVersion, err)
+	}
+	if len(parsed) == 0 {
+		return []model.TransactionRecord{}, nil
+	}
+
+	tx, err := db.BeginAuditTx(conn, user)
+	if err != nil {
+		return nil, fmt.Errorf("トランザクションの開始に失敗: %w", err)
+	}
+	defer tx.Rollback()
+
+	batchID, err := db.CreateImportBatchInTx(tx, "NSIPS", fileName, fmt.Sprintf("%x", sha256.Sum256(content)))
+	if err != nil {
+		return nil, fmt.Errorf("取込バッチの登録に失敗: %w", err)
+	}
+
+	// YJコードのみの明細は、代表JANコードを求めて mastermanager で扱えるようにする
+	janByYj := make(map[string]string)
+	for i := range parsed {
+		rec := &parsed[i]
+		if rec.JanCode != "" || rec.YjCode == "" {
+			continue
+		}
+		jan, ok := janByYj[rec.YjCode]
+		if !ok {
+			jan, err = db.GetRepresentativeJanByYjCode(tx, rec.YjCode)
+			if err != nil {
+				return nil, err
+			}
+			janByYj[rec.YjCode] = jan
+		}
+		rec.JanCode = jan
+	}
+
+	var dates []string
+	dateSet := make(map[string]struct{})
+	for _, rec := range parsed {
+		if _, seen := dateSet[rec.Date]; !seen {
+			dateSet[rec.Date] = struct{}{}
+			dates = append(dates, rec.Date)
+		}
+	}
+	if err := db.CheckUsageSourceInTx(tx, "NSIPS", dates); err != nil {
+		return nil, err
+	}
+
+	// 取り込む処方箋ごとに既存の処方データ (NSIPS 取込分) を退避してから削除する
+	type prescriptionKey struct{ date, patient, receipt string }
+	replaced := make(map[prescriptionKey]struct{})
+	for _, rec := range parsed {
+		key := prescriptionKey{rec.Date, rec.ClientCode, rec.ReceiptNumber}
+		if rec.ReceiptNumber == "" {
+			continue
+		}
+		if _, done := replaced[key]; done {
+			continue
+		}
+		replaced[key] = struct{}{}
+		const where = db.NsipsRecordCondition + " AND transaction_date = ? AND client_code = ? AND receipt_number = ?"
+		if _, err := db.SnapshotDisplacedRecordsInTx(tx, batchID, where, key.date, key.patient, key.receipt); err != nil {
+			return nil, fmt.Errorf("既存の処方データの退避に失敗: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM transaction_records WHERE "+where, key.date, key.patient, key.receipt); err != nil {
+			return nil, fmt.Errorf("既存の処方データ削除に失敗: %w", err)
+		}
+	}
+
+	records := make([]model.TransactionRecord, 0, len(parsed))
+	for _, rec := range parsed {
+		records = append(records, model.TransactionRecord{
+			TransactionDate: rec.Date, Flag: 3, JanCode: rec.JanCode,
+			YjCode: rec.YjCode, ProductName: rec.ProductName,
+			YjQuantity: rec.YjQuantity, YjUnitName: rec.YjUnitName,
+			ClientCode: rec.ClientCode, ReceiptNumber: rec.ReceiptNumber, LineNumber: rec.LineNumber,
+		})
+	}
+	finalRecords, err := insertPrescriptionRecordsInTx(tx, records, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.RefreshStockBalancesInTx(tx); err != nil {
+		return nil, err
+	}
+
+	if err := db.FinalizeImportBatchInTx(tx, batchID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("トランザクションのコミットに失敗: %w", err)
+	}
+	log.Printf("Imported %d NSIPS prescription lines from %s", len(finalRecords), fileName)
+	return finalRecords, nil
+}
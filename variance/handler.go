@@ -0,0 +1,139 @@
+// C:\Users\wasab\OneDrive\デスクトップ\WASABI\variance\handler.go
+
+package variance
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"wasabi/db"
+	"wasabi/model"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// groupByLabels は集計区分の表示名です。
+var groupByLabels = map[string]string{"shelf": "棚番", "form": "剤型", "drugType": "薬品区分"}
+
+// sourceLabels は棚卸の入力元の表示名です。
+var sourceLabels = map[string]string{"guided": "棚卸調整", "manual": "手入力"}
+
+// parseFilters はクエリパラメータから棚卸差異レポートの抽出条件を作成します。日付は YYYY-MM-DD と YYYYMMDD のどちらも受け付けます。
+func parseFilters(r *http.Request) model.InventoryVarianceFilters {
+	q := r.URL.Query()
+	return model.InventoryVarianceFilters{
+		StartDate:       strings.ReplaceAll(q.Get("startDate"), "-", ""),
+		EndDate:         strings.ReplaceAll(q.Get("endDate"), "-", ""),
+		GroupBy:         q.Get("groupBy"),
+		DifferencesOnly: q.Get("differencesOnly") == "true" || q.Get("differencesOnly") == "1",
+	}
+}
+
+// GetVarianceReportHandler は棚卸差異レポートを返します。
+func GetVarianceReportHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := db.GetInventoryVarianceReport(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "棚卸差異レポートの作成に失敗しました: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// ExportVarianceReportHandler は棚卸差異レポートを、集計と明細の2シートのExcelファイルとしてエクスポートします。
+func ExportVarianceReportHandler(conn *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := db.GetInventoryVarianceReport(conn, parseFilters(r))
+		if err != nil {
+			http.Error(w, "Failed to get inventory variance report for export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		f := excelize.NewFile()
+		headerStyle, _ := f.NewStyle(&excelize.Style{
+			Font:      &excelize.Font{Bold: true},
+			Fill:      excelize.Fill{Type: "pattern", Color: []string{"#F0F0F0"}, Pattern: 1},
+			Alignment: &excelize.Alignment{Horizontal: "center"},
+		})
+		currencyStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 2})
+		writeHeaders := func(sheet string, headers []string) {
+			for i, h := range headers {
+				cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+				f.SetCellValue(sheet, cell, h)
+				f.SetCellStyle(sheet, cell, cell, headerStyle)
+			}
+		}
+
+		summarySheet := "集計"
+		index, _ := f.NewSheet(summarySheet)
+		f.SetActiveSheet(index)
+		f.DeleteSheet("Sheet1")
+		writeHeaders(summarySheet, []string{
+			"棚卸日", groupByLabels[report.GroupBy], "品目数", "差異品目数",
+			"不足 薬価金額", "過剰 薬価金額", "差引 薬価金額", "不足 納入価金額", "過剰 納入価金額", "差引 納入価金額",
+		})
+		for i, s := range report.Summaries {
+			row := i + 2
+			values := []interface{}{
+				formatDate(s.CountDate), s.GroupKey, s.ItemCount, s.DiscrepancyCount,
+				s.ShortageNhiValue, s.SurplusNhiValue, s.NetNhiValue, s.ShortagePurchaseValue, s.SurplusPurchaseValue, s.NetPurchaseValue,
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, row)
+			f.SetSheetRow(summarySheet, cell, &values)
+			from, _ := excelize.CoordinatesToCellName(5, row)
+			to, _ := excelize.CoordinatesToCellName(10, row)
+			f.SetCellStyle(summarySheet, from, to, currencyStyle)
+		}
+		f.SetColWidth(summarySheet, "A", "B", 14)
+		f.SetColWidth(summarySheet, "E", "J", 16)
+
+		detailSheet := "明細"
+		f.NewSheet(detailSheet)
+		writeHeaders(detailSheet, []string{
+			"棚卸日", "製品コード", "製品名", "棚番", "剤型", "薬品区分", "理論在庫", "実棚数", "差異", "YJ単位", "薬価金額", "納入価金額", "入力元",
+		})
+		for i, item := range report.Items {
+			row := i + 2
+			values := []interface{}{
+				formatDate(item.CountDate), item.ProductCode, item.ProductName, item.ShelfNumber, item.UsageClassification, item.DrugType,
+				item.TheoreticalQuantity, item.CountedQuantity, item.Difference, item.YjUnitName, item.NhiValue, item.PurchaseValue,
+				valueOr(sourceLabels[item.Source], item.Source),
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, row)
+			f.SetSheetRow(detailSheet, cell, &values)
+			from, _ := excelize.CoordinatesToCellName(11, row)
+			to, _ := excelize.CoordinatesToCellName(12, row)
+			f.SetCellStyle(detailSheet, from, to, currencyStyle)
+		}
+		f.SetColWidth(detailSheet, "A", "B", 14)
+		f.SetColWidth(detailSheet, "C", "C", 40)
+		f.SetColWidth(detailSheet, "K", "L", 14)
+
+		fileName := fmt.Sprintf("棚卸差異_%s.xlsx", time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+		if err := f.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// formatDate は YYYYMMDD 形式の日付を YYYY-MM-DD 形式に変換します。
+func formatDate(s string) string {
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:6] + "-" + s[6:]
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}